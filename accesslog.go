@@ -0,0 +1,255 @@
+package skoap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zalando/skipper/filters"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat selects the serialization of the access log entries.
+type AccessLogFormat string
+
+const (
+	// AccessLogCombined writes the entries in the Apache Combined Log
+	// Format, with the authenticated user in the user field. This is the
+	// default.
+	AccessLogCombined AccessLogFormat = "combined"
+
+	// AccessLogJSON encodes the entries as JSON objects.
+	AccessLogJSON AccessLogFormat = "json"
+)
+
+// AccessEntry is an entry of the access log.
+type AccessEntry struct {
+	Time       time.Time `json:"time"`
+	RemoteAddr string    `json:"remote-addr"`
+	User       string    `json:"user,omitempty"`
+	Method     string    `json:"method"`
+	Uri        string    `json:"uri"`
+	Proto      string    `json:"proto"`
+	Host       string    `json:"host"`
+	Status     int       `json:"status"`
+	Size       int64     `json:"size"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user-agent,omitempty"`
+	DurationMs int64     `json:"duration-ms"`
+}
+
+type (
+	accessRecordKey struct{}
+
+	// accessRecord is placed in the context of the incoming requests, and
+	// receives the user set by the auth filters in the state bag, and the
+	// query parameters carrying the tokens
+	accessRecord struct {
+		mu          sync.Mutex
+		name        string
+		tokenParams []string
+	}
+
+	accessLog struct {
+		next        http.Handler
+		format      AccessLogFormat
+		tokenParams []string
+		mu          sync.Mutex
+		out         io.Writer
+	}
+
+	accessResponseWriter struct {
+		http.ResponseWriter
+		status int
+		size   int64
+	}
+)
+
+// Parses the name of an access log format. Empty means the combined
+// format.
+func ParseAccessLogFormat(s string) (AccessLogFormat, error) {
+	switch f := AccessLogFormat(strings.ToLower(s)); f {
+	case "":
+		return AccessLogCombined, nil
+	case AccessLogCombined, AccessLogJSON:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid access log format: %s", s)
+	}
+}
+
+// NewAccessLog wraps a handler, typically the Skipper proxy, and writes
+// an access log entry for every request. The user of the entry is the
+// one stored by the auth filters of the route in the state bag, under
+// StateBagUserKey, either the authenticated or the rejected one. The
+// query parameters read as tokens by the auth filters, and the ones
+// listed in tokenParams, are removed from the logged uri.
+func NewAccessLog(next http.Handler, out io.Writer, format AccessLogFormat, tokenParams ...string) http.Handler {
+	return &accessLog{next: next, format: format, out: out, tokenParams: tokenParams}
+}
+
+// marks a query parameter of the request as a token, to be removed from
+// the access log
+func maskAccessLogQuery(r *http.Request, name string) {
+	if u, ok := r.Context().Value(accessRecordKey{}).(*accessRecord); ok {
+		u.mu.Lock()
+		u.tokenParams = append(u.tokenParams, name)
+		u.mu.Unlock()
+	}
+}
+
+// the path and the query of the request, without the token parameters
+func sanitizedUri(u *url.URL, tokenParams []string) string {
+	uri := u.EscapedPath()
+	if u.RawQuery == "" {
+		return uri
+	}
+
+	q := u.Query()
+	for _, p := range tokenParams {
+		q.Del(p)
+	}
+
+	if len(q) > 0 {
+		uri += "?" + q.Encode()
+	}
+
+	return uri
+}
+
+// stores the user in the state bag, and makes it available for the
+// access log
+func setAuthUser(ctx filters.FilterContext, name string) {
+	ctx.StateBag()[authUserKey] = name
+	r := ctx.Request()
+	if r == nil {
+		return
+	}
+
+	if u, ok := r.Context().Value(accessRecordKey{}).(*accessRecord); ok {
+		u.mu.Lock()
+		u.name = name
+		u.mu.Unlock()
+	}
+}
+
+func (w *accessResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// the streamed responses are flushed by the proxy
+func (w *accessResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// the connection upgrades hijack the connection
+func (w *accessResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection cannot be hijacked")
+	}
+
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+
+	return h.Hijack()
+}
+
+func formatCombined(e *AccessEntry) string {
+	return fmt.Sprintf(
+		`%s - %s [%s] "%s %s %s" %d %d "%s" "%s" %d`,
+		clfValue(e.RemoteAddr),
+		clfValue(strings.Replace(e.User, " ", "_", -1)),
+		e.Time.Format(clfTimeFormat),
+		e.Method,
+		strings.Replace(e.Uri, `"`, `\"`, -1),
+		e.Proto,
+		e.Status,
+		e.Size,
+		strings.Replace(e.Referer, `"`, `\"`, -1),
+		strings.Replace(e.UserAgent, `"`, `\"`, -1),
+		e.DurationMs)
+}
+
+func (l *accessLog) write(e *AccessEntry) {
+	var b []byte
+	if l.format == AccessLogJSON {
+		var err error
+		if b, err = json.Marshal(e); err != nil {
+			log.Println(err)
+			return
+		}
+	} else {
+		b = []byte(formatCombined(e))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(append(b, '\n')); err != nil {
+		log.Println(err)
+	}
+}
+
+func (l *accessLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	// the filters may change the url of the request
+	reqUrl := *r.URL
+	u := &accessRecord{}
+	aw := &accessResponseWriter{ResponseWriter: w}
+	l.next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), accessRecordKey{}, u)))
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	u.mu.Lock()
+	user := u.name
+	tokenParams := append(u.tokenParams, l.tokenParams...)
+	u.mu.Unlock()
+
+	status := aw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	l.write(&AccessEntry{
+		Time:       start,
+		RemoteAddr: host,
+		User:       user,
+		Method:     r.Method,
+		Uri:        sanitizedUri(&reqUrl, tokenParams),
+		Proto:      r.Proto,
+		Host:       r.Host,
+		Status:     status,
+		Size:       aw.size,
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+		DurationMs: int64(time.Since(start) / time.Millisecond)})
+}
@@ -0,0 +1,143 @@
+package skoap
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/zalando/skipper/filters/filtertest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseAccessLogFormat(t *testing.T) {
+	for _, ti := range []struct {
+		msg    string
+		format string
+		expect AccessLogFormat
+		fail   bool
+	}{{
+		msg:    "default",
+		expect: AccessLogCombined,
+	}, {
+		msg:    "json",
+		format: "JSON",
+		expect: AccessLogJSON,
+	}, {
+		msg:    "invalid",
+		format: "xml",
+		fail:   true,
+	}} {
+		f, err := ParseAccessLogFormat(ti.format)
+		if ti.fail != (err != nil) {
+			t.Error(ti.msg, "unexpected error result", err)
+			continue
+		}
+
+		if f != ti.expect {
+			t.Error(ti.msg, "invalid format", f)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	// the handler acts as the proxy, executing an auth filter with the
+	// incoming request
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/authorized" {
+			f := &filter{tokenQuery: "access_token"}
+			f.removeQueryToken(r)
+			authorized(&filtertest.Context{FRequest: r, FStateBag: make(map[string]interface{})}, &authDoc{Uid: testUid})
+		}
+
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello"))
+	})
+
+	for _, ti := range []struct {
+		msg    string
+		path   string
+		query  string
+		uri    string
+		format AccessLogFormat
+		user   string
+	}{{
+		msg:    "combined, anonymous",
+		path:   "/anonymous",
+		format: AccessLogCombined,
+	}, {
+		msg:    "combined, authorized",
+		path:   "/authorized",
+		format: AccessLogCombined,
+		user:   testUid,
+	}, {
+		msg:    "json, authorized",
+		path:   "/authorized",
+		format: AccessLogJSON,
+		user:   testUid,
+	}, {
+		msg:    "token in the query",
+		path:   "/authorized",
+		query:  "?access_token=" + testToken + "&page=2",
+		uri:    "/authorized?page=2",
+		format: AccessLogCombined,
+		user:   testUid,
+	}, {
+		msg:    "token in the configured query parameter",
+		path:   "/anonymous",
+		query:  "?token=" + testToken,
+		uri:    "/anonymous",
+		format: AccessLogJSON,
+	}} {
+		var out bytes.Buffer
+		s := httptest.NewServer(NewAccessLog(h, &out, ti.format, "token"))
+		req, err := http.NewRequest("GET", s.URL+ti.path+ti.query, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set("User-Agent", "test-agent")
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		s.Close()
+
+		line := out.String()
+		if strings.Contains(line, testToken) {
+			t.Error(ti.msg, "token in the access log", line)
+		}
+
+		uri := ti.uri
+		if uri == "" {
+			uri = ti.path
+		}
+
+		if ti.format == AccessLogJSON {
+			var e AccessEntry
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Error(ti.msg, err)
+				continue
+			}
+
+			if e.User != ti.user || e.Status != http.StatusTeapot || e.Size != 5 || e.Uri != uri ||
+				e.UserAgent != "test-agent" {
+				t.Error(ti.msg, "invalid entry", line)
+			}
+
+			continue
+		}
+
+		user := ti.user
+		if user == "" {
+			user = "-"
+		}
+
+		if !strings.HasPrefix(line, "127.0.0.1 - "+user+" [") ||
+			!strings.Contains(line, `"GET `+uri+` HTTP/1.1" 418 5 "" "test-agent"`) {
+			t.Error(ti.msg, "invalid entry", line)
+		}
+	}
+}
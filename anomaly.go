@@ -0,0 +1,167 @@
+package skoap
+
+import (
+	"crypto/sha256"
+	"github.com/zalando/skipper/filters"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type anomaly string
+
+const (
+	duplicateAuthHeader anomaly = "duplicate-authorization-header"
+	tokenReuse          anomaly = "token-reuse"
+)
+
+const (
+	anomalyMetricsPrefix  = "skoap.anomaly."
+	defaultTokenReuseTime = time.Minute
+
+	// when tracking more tokens than this, the expired entries are
+	// cleaned up on the next insert, and when not enough of them
+	// expired, arbitrary tokens are dropped
+	maxTrackedTokens = 1 << 16
+
+	// the tracked tokens are split into lock-striped shards by their
+	// hash
+	reuseShards = 32
+)
+
+type (
+	tokenIPs map[string]time.Time
+
+	reuseShard struct {
+		mu     sync.Mutex
+		tokens map[[sha256.Size]byte]tokenIPs
+	}
+
+	// tracks the client addresses that a token was used from in the
+	// last time window
+	reuseDetector struct {
+		maxIPs         int
+		window         time.Duration
+		trustedProxies []*net.IPNet
+		forwardedDepth int
+		shards         [reuseShards]reuseShard
+	}
+)
+
+func newReuseDetector(maxIPs int, window time.Duration, trusted []*net.IPNet, depth int) *reuseDetector {
+	if maxIPs <= 0 {
+		return nil
+	}
+
+	if window <= 0 {
+		window = defaultTokenReuseTime
+	}
+
+	d := &reuseDetector{
+		maxIPs:         maxIPs,
+		window:         window,
+		trustedProxies: trusted,
+		forwardedDepth: depth}
+	for i := range d.shards {
+		d.shards[i].tokens = make(map[[sha256.Size]byte]tokenIPs)
+	}
+
+	return d
+}
+
+// the address of the connection
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// the address of the client, as seen by the ipAllow and ipDeny filters
+func (d *reuseDetector) clientAddr(r *http.Request) string {
+	return clientAddr(r, d.trustedProxies, d.forwardedDepth)
+}
+
+func (ips tokenIPs) expire(before time.Time) {
+	for ip, t := range ips {
+		if t.Before(before) {
+			delete(ips, ip)
+		}
+	}
+}
+
+// drops the least recently seen address
+func (ips tokenIPs) dropOldest() {
+	var (
+		oldest string
+		at     time.Time
+	)
+
+	for ip, t := range ips {
+		if oldest == "" || t.Before(at) {
+			oldest, at = ip, t
+		}
+	}
+
+	delete(ips, oldest)
+}
+
+func (s *reuseShard) cleanup(before time.Time) {
+	for k, ips := range s.tokens {
+		ips.expire(before)
+		if len(ips) == 0 {
+			delete(s.tokens, k)
+		}
+	}
+
+	// when too few expired, arbitrary tokens are dropped, down to three
+	// quarters of the limit, so that the cleanup doesn't run on every
+	// insert
+	for k := range s.tokens {
+		if len(s.tokens)*4 < maxTrackedTokens/reuseShards*3 {
+			return
+		}
+
+		delete(s.tokens, k)
+	}
+}
+
+// records the usage of a token from an address, and tells if the
+// token was used from too many different addresses. Beyond the limit,
+// only the most recent addresses are kept, so that a token cannot grow
+// its entry without bounds.
+func (d *reuseDetector) record(token, ip string, now time.Time) bool {
+	key := sha256.Sum256([]byte(token))
+	before := now.Add(-d.window)
+
+	s := &d.shards[int(key[0])%reuseShards]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ips, ok := s.tokens[key]
+	if !ok {
+		if len(s.tokens) >= maxTrackedTokens/reuseShards {
+			s.cleanup(before)
+		}
+
+		ips = make(tokenIPs)
+		s.tokens[key] = ips
+	}
+
+	ips.expire(before)
+	if _, known := ips[ip]; !known && len(ips) > d.maxIPs {
+		ips.dropOldest()
+	}
+
+	ips[ip] = now
+	return len(ips) > d.maxIPs
+}
+
+func reportAnomaly(ctx filters.FilterContext, a anomaly) {
+	ctx.Metrics().IncCounter(anomalyMetricsPrefix + string(a))
+	anomalies, _ := ctx.StateBag()[authAnomaliesKey].([]string)
+	ctx.StateBag()[authAnomaliesKey] = append(anomalies, string(a))
+}
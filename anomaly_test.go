@@ -0,0 +1,124 @@
+package skoap
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestReuseDetectorDisabled(t *testing.T) {
+	if newReuseDetector(0, time.Minute, nil, 0) != nil {
+		t.Error("failed to disable reuse detection")
+	}
+}
+
+func TestReuseDetector(t *testing.T) {
+	d := newReuseDetector(2, time.Minute, nil, 0)
+	now := time.Now()
+
+	if d.record(testToken, "10.0.0.1", now) || d.record(testToken, "10.0.0.2", now) {
+		t.Error("unexpected anomaly")
+	}
+
+	if d.record(testToken, "10.0.0.1", now) {
+		t.Error("unexpected anomaly for known address")
+	}
+
+	if d.record("other-token", "10.0.0.3", now) {
+		t.Error("unexpected anomaly for other token")
+	}
+
+	if !d.record(testToken, "10.0.0.3", now) {
+		t.Error("failed to detect token reuse")
+	}
+
+	if d.record(testToken, "10.0.0.4", now.Add(2*time.Minute)) {
+		t.Error("failed to expire addresses")
+	}
+}
+
+func TestReuseDetectorLimits(t *testing.T) {
+	d := newReuseDetector(2, time.Minute, nil, 0)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		d.record(testToken, fmt.Sprintf("10.0.0.%d", i), now.Add(time.Duration(i)*time.Second))
+	}
+
+	s := &d.shards[int(sha256.Sum256([]byte(testToken))[0])%reuseShards]
+	ips := s.tokens[sha256.Sum256([]byte(testToken))]
+	if len(ips) != 3 {
+		t.Error("failed to limit the tracked addresses", len(ips))
+	}
+
+	if _, ok := ips["10.0.0.9"]; !ok {
+		t.Error("failed to keep the most recent address")
+	}
+
+	if !d.record(testToken, "10.0.0.10", now.Add(10*time.Second)) {
+		t.Error("failed to detect token reuse beyond the limit")
+	}
+
+	for i := 0; i < 2*maxTrackedTokens; i++ {
+		d.record(fmt.Sprintf("token-%d", i), "10.0.0.1", now)
+	}
+
+	var tracked int
+	for i := range d.shards {
+		tracked += len(d.shards[i].tokens)
+	}
+
+	if tracked > maxTrackedTokens {
+		t.Error("failed to limit the tracked tokens", tracked)
+	}
+}
+
+func TestReuseDetectorClientAddr(t *testing.T) {
+	trusted, err := ParseNetworks([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ti := range []struct {
+		msg     string
+		trusted []*net.IPNet
+		depth   int
+		addr    string
+	}{{
+		msg:  "connection",
+		addr: "10.0.0.1",
+	}, {
+		msg:     "trusted proxies",
+		trusted: trusted,
+		addr:    "192.168.0.2",
+	}, {
+		msg:   "forwarded depth",
+		depth: 3,
+		addr:  "192.168.0.1",
+	}} {
+		d := newReuseDetector(1, time.Minute, ti.trusted, ti.depth)
+		r := &http.Request{
+			RemoteAddr: "10.0.0.1:54321",
+			Header:     http.Header{"X-Forwarded-For": []string{"192.168.0.1, 192.168.0.2, 10.0.0.2"}}}
+		if addr := d.clientAddr(r); addr != ti.addr {
+			t.Error(ti.msg, "invalid client address", addr)
+		}
+	}
+}
+
+func BenchmarkReuseDetectorParallel(b *testing.B) {
+	d := newReuseDetector(3, time.Minute, nil, 0)
+	tokens := make([]string, 1<<12)
+	for i := range tokens {
+		tokens[i] = fmt.Sprintf("token-%d", i)
+	}
+
+	now := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			d.record(tokens[i%len(tokens)], "10.0.0.1", now)
+		}
+	})
+}
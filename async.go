@@ -0,0 +1,155 @@
+package skoap
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultAuditBufferSize = 1024
+
+// asyncSink forwards the audit entries to a sink in a background
+// goroutine, through a bounded buffer. When the buffer is full, the
+// oldest entry is dropped, so a slow sink never delays the responses.
+type asyncSink struct {
+	sink    AuditSink
+	buffer  chan *AuditDoc
+	pending sync.WaitGroup
+	dropped uint64
+	bytes   int64
+	mu      sync.Mutex
+
+	// the time of the last dropped entry, in unix nanoseconds
+	lastDrop int64
+}
+
+// Creates an audit sink forwarding the entries to s asynchronously,
+// through a buffer of the given size. When the buffer is full, the
+// oldest entries are dropped. Defaults to 1024 entries.
+func NewAsyncSink(s AuditSink, size int) AuditSink {
+	if size <= 0 {
+		size = defaultAuditBufferSize
+	}
+
+	as := &asyncSink{sink: s, buffer: make(chan *AuditDoc, size)}
+	registerMemoryUser(as)
+	registerDegradable(as)
+	registerAuditFlusher(as)
+	go as.run()
+	return as
+}
+
+func (s *asyncSink) run() {
+	for d := range s.buffer {
+		s.release(d)
+		if err := s.sink.Log(d); err != nil {
+			log.Println(err)
+		}
+
+		s.pending.Done()
+	}
+}
+
+func (s *asyncSink) Log(d *AuditDoc) error {
+	s.pending.Add(1)
+	size := docSize(d)
+
+	// the mutex ensures that dropping the oldest entry and adding the
+	// new one happen together
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		select {
+		case s.buffer <- d:
+			atomic.AddInt64(&s.bytes, size)
+			addMemory(size)
+			return nil
+		default:
+		}
+
+		select {
+		case oldest := <-s.buffer:
+			s.release(oldest)
+			dropped := atomic.AddUint64(&s.dropped, 1)
+			atomic.StoreInt64(&s.lastDrop, time.Now().UnixNano())
+			if dropped == 1 || dropped%1000 == 0 {
+				log.Printf("audit log buffer full, %d entries dropped", dropped)
+			}
+
+			s.pending.Done()
+		default:
+		}
+	}
+}
+
+func (s *asyncSink) release(d *AuditDoc) {
+	size := docSize(d)
+	atomic.AddInt64(&s.bytes, -size)
+	addMemory(-size)
+}
+
+func (s *asyncSink) memoryStats() MemoryStats {
+	return MemoryStats{
+		Name:    "audit-buffer",
+		Entries: len(s.buffer),
+		Bytes:   atomic.LoadInt64(&s.bytes)}
+}
+
+func (s *asyncSink) degradation() DegradationStats {
+	queued := len(s.buffer)
+	return DegradationStats{
+		Name:     "audit-buffer",
+		Degraded: spooling(queued, cap(s.buffer)) || droppedRecently(&s.lastDrop),
+		Queued:   queued,
+		Dropped:  atomic.LoadUint64(&s.dropped)}
+}
+
+// waits until the buffered entries are forwarded
+func (s *asyncSink) flush() {
+	s.pending.Wait()
+}
+
+// auditFlusher is implemented by the sinks sending the entries in the
+// background.
+type auditFlusher interface {
+	flush()
+}
+
+var auditFlushers struct {
+	mu  sync.Mutex
+	all []auditFlusher
+}
+
+func registerAuditFlusher(f auditFlusher) {
+	auditFlushers.mu.Lock()
+	defer auditFlushers.mu.Unlock()
+	auditFlushers.all = append(auditFlushers.all, f)
+}
+
+// FlushAuditSinks waits until the audit entries buffered or queued by
+// the background sinks are sent, or the timeout expires, e.g. before
+// the process exits. It returns false on timeout. The sinks are flushed
+// in the reverse order of their creation, so that the wrapping sinks
+// are flushed before the sinks they forward to.
+func FlushAuditSinks(timeout time.Duration) bool {
+	auditFlushers.mu.Lock()
+	all := auditFlushers.all
+	auditFlushers.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		for i := len(all) - 1; i >= 0; i-- {
+			all[i].flush()
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,235 @@
+package skoap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const webhookTimeout = 5 * time.Second
+
+type (
+	// AuthStatusDoc contains the result of the authentication in the
+	// audit log entries.
+	AuthStatusDoc struct {
+		User         string            `json:"user,omitempty"`
+		Rejected     bool              `json:"rejected"`
+		Reason       string            `json:"reason,omitempty"`
+		DryRunReason string            `json:"dryRunReason,omitempty"`
+		Anomalies    []string          `json:"anomalies,omitempty"`
+		Claims       map[string]string `json:"claims,omitempty"`
+	}
+
+	// TLSDoc contains the details of the TLS connection of the client
+	// in the audit log entries.
+	TLSDoc struct {
+		Version       string `json:"version"`
+		CipherSuite   string `json:"cipherSuite"`
+		ServerName    string `json:"serverName,omitempty"`
+		Resumed       bool   `json:"resumed"`
+		ClientSubject string `json:"clientSubject,omitempty"`
+	}
+
+	// BackendDoc contains the details of the backend connection in the
+	// audit log entries.
+	BackendDoc struct {
+		Address     string `json:"address,omitempty"`
+		Reused      bool   `json:"reused"`
+		TLSVerified *bool  `json:"tlsVerified,omitempty"`
+		TLSError    string `json:"tlsError,omitempty"`
+		Retries     int    `json:"retries"`
+		Error       string `json:"error,omitempty"`
+	}
+
+	// AuditDoc is an entry of the audit log.
+	AuditDoc struct {
+		Method           string            `json:"method"`
+		Path             string            `json:"path"`
+		Query            string            `json:"query,omitempty"`
+		Proto            string            `json:"proto,omitempty"`
+		Status           int               `json:"status"`
+		RemoteAddr       string            `json:"remoteAddr,omitempty"`
+		UserAgent        string            `json:"userAgent,omitempty"`
+		Category         string            `json:"category,omitempty"`
+		Owner            string            `json:"owner,omitempty"`
+		AuthStatus       *AuthStatusDoc    `json:"authStatus,omitempty"`
+		TokenFingerprint string            `json:"tokenFingerprint,omitempty"`
+		TLS              *TLSDoc           `json:"tls,omitempty"`
+		Backend          *BackendDoc       `json:"backend,omitempty"`
+		KillSwitch       *KillSwitchDoc    `json:"killSwitch,omitempty"`
+		RequestHeaders   map[string]string `json:"requestHeaders,omitempty"`
+		ResponseHeaders  map[string]string `json:"responseHeaders,omitempty"`
+		RequestBody      string            `json:"requestBody,omitempty"`
+		SampleRate       float64           `json:"sampleRate,omitempty"`
+
+		// Static fields, e.g. the environment or the datacenter,
+		// merged into the JSON document. The fields with the same
+		// name as the other fields of the document are ignored.
+		Fields map[string]string `json:"-"`
+	}
+)
+
+// the names of the JSON fields of the audit documents, that the static
+// fields cannot override
+var auditDocFields = make(map[string]bool)
+
+func init() {
+	t := reflect.TypeOf(AuditDoc{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			auditDocFields[name] = true
+		}
+	}
+}
+
+// Encodes the audit document as JSON, merging the static fields into
+// it.
+func (d AuditDoc) MarshalJSON() ([]byte, error) {
+	type doc AuditDoc
+	b, err := json.Marshal(doc(d))
+	if err != nil || len(d.Fields) == 0 {
+		return b, err
+	}
+
+	fields := make(map[string]string)
+	for k, v := range d.Fields {
+		if !auditDocFields[k] {
+			fields[k] = v
+		}
+	}
+
+	if len(fields) == 0 {
+		return b, nil
+	}
+
+	fb, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	// both are JSON objects, and the document has at least one field
+	return append(append(b[:len(b)-1], ','), fb[1:]...), nil
+}
+
+// Decodes an audit document, collecting the unknown string fields as
+// static fields.
+func (d *AuditDoc) UnmarshalJSON(b []byte) error {
+	type doc AuditDoc
+	if err := json.Unmarshal(b, (*doc)(d)); err != nil {
+		return err
+	}
+
+	var all map[string]interface{}
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+
+	for k, v := range all {
+		if s, ok := v.(string); ok && !auditDocFields[k] {
+			if d.Fields == nil {
+				d.Fields = make(map[string]string)
+			}
+
+			d.Fields[k] = s
+		}
+	}
+
+	return nil
+}
+
+// AuditSink receives the entries of the auditLog filter.
+// Implementations must be safe for concurrent use.
+type AuditSink interface {
+	Log(*AuditDoc) error
+}
+
+// AuditSinkFunc implements the AuditSink interface with a function.
+type AuditSinkFunc func(*AuditDoc) error
+
+// Calls the function.
+func (f AuditSinkFunc) Log(d *AuditDoc) error { return f(d) }
+
+type (
+	writerSink struct {
+		mu     sync.Mutex
+		writer io.Writer
+		format AuditFormat
+	}
+
+	webhookSink struct {
+		url    string
+		client *http.Client
+	}
+)
+
+// Creates an audit sink writing the entries to w, as JSON, one entry
+// per line.
+func NewWriterSink(w io.Writer) AuditSink {
+	return NewWriterSinkWithFormat(w, AuditFormatJSON)
+}
+
+// Creates an audit sink writing the entries to w, in the given format,
+// one entry per line.
+func NewWriterSinkWithFormat(w io.Writer, f AuditFormat) AuditSink {
+	return &writerSink{writer: w, format: f}
+}
+
+// Creates an audit sink appending the entries to a file, as JSON, one
+// entry per line.
+func NewFileSink(path string) (AuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWriterSink(f), nil
+}
+
+// Creates an audit sink posting the entries as JSON to an HTTP
+// endpoint, one request per entry.
+func NewWebhookSink(url string) AuditSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (s *writerSink) Log(d *AuditDoc) error {
+	b, err := s.format.Encode(d)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.writer.Write(append(b, '\n'))
+	return err
+}
+
+func (s *webhookSink) Log(d *AuditDoc) error {
+	if KillSwitchOn(KillSwitchWebhookSinks) {
+		return nil
+	}
+
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	rsp, err := s.client.Post(s.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	rsp.Body.Close()
+	if rsp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("failed to send audit log entry: %s", rsp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,486 @@
+package skoap
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAuditLog(t *testing.T, spec filters.Spec, args []interface{}, body string) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	fr := make(filters.Registry)
+	fr.Register(spec)
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{Name: AuditLogName, Args: args}},
+		Backend: backend.URL})
+
+	rsp, err := http.Post(proxy.URL+"/foo", "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+	flushAuditLog(spec)
+}
+
+func flushAuditLog(spec filters.Spec) {
+	spec.(*auditLog).sink.(*asyncSink).flush()
+}
+
+func TestAuditLogArgs(t *testing.T) {
+	for _, ti := range []struct {
+		msg  string
+		args []interface{}
+		fail bool
+	}{{
+		msg: "no args",
+	}, {
+		msg:  "body limit",
+		args: []interface{}{float64(1024)},
+	}, {
+		msg:  "category",
+		args: []interface{}{float64(1024), "category=payments-api"},
+	}, {
+		msg:  "category only",
+		args: []interface{}{"category=payments-api"},
+	}, {
+		msg:  "tls",
+		args: []interface{}{"category=payments-api", "tls=true"},
+	}, {
+		msg:  "tls as a number",
+		args: []interface{}{"tls=1"},
+	}, {
+		msg:  "invalid tls",
+		args: []interface{}{"tls=yes"},
+		fail: true,
+	}, {
+		msg:  "backend",
+		args: []interface{}{"tls=true", "backend=true"},
+	}, {
+		msg:  "invalid backend",
+		args: []interface{}{"backend=on"},
+		fail: true,
+	}, {
+		msg:  "query",
+		args: []interface{}{"query=true"},
+	}, {
+		msg:  "invalid query",
+		args: []interface{}{"query=yes"},
+		fail: true,
+	}, {
+		msg:  "headers",
+		args: []interface{}{float64(1024), "X-Request-Id", "category=payments-api", "Content-Type"},
+	}, {
+		msg:  "empty header name",
+		args: []interface{}{""},
+		fail: true,
+	}, {
+		msg:  "body limit not first",
+		args: []interface{}{"category=payments-api", float64(1024)},
+		fail: true,
+	}, {
+		msg:  "unknown named arg",
+		args: []interface{}{"foo=bar"},
+		fail: true,
+	}} {
+		_, err := NewAuditLog(nil).CreateFilter(ti.args)
+		if ti.fail != (err != nil) {
+			t.Error(ti.msg, "unexpected result", err)
+		}
+	}
+}
+
+func TestAuditLogCategory(t *testing.T) {
+	var out bytes.Buffer
+	testAuditLog(t, NewAuditLog(&out), []interface{}{float64(3), "category=payments-api"}, "hello")
+
+	var d AuditDoc
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Category != "payments-api" || d.Path != "/foo" || d.Method != "POST" || d.RequestBody != "hel" {
+		t.Error("invalid audit document", d)
+	}
+
+	if d.RemoteAddr != "127.0.0.1" || d.UserAgent == "" {
+		t.Error("invalid client details", d.RemoteAddr, d.UserAgent)
+	}
+}
+
+func TestAuditLogHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Backend", "b1")
+		w.Header().Set("Set-Cookie", "session="+testToken)
+	}))
+	defer backend.Close()
+
+	var out bytes.Buffer
+	al := NewAuditLog(&out)
+	fr := make(filters.Registry)
+	fr.Register(al)
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{
+			Name: AuditLogName,
+			Args: []interface{}{"x-request-id", "X-Backend", "Authorization", "Cookie", "Set-Cookie", "X-Api-Key",
+				"X-Auth-Token", "X-Missing"}}},
+		Backend: backend.URL})
+
+	req, err := http.NewRequest("GET", proxy.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("X-Request-Id", "42")
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set("Cookie", "session="+testToken)
+	req.Header.Set("X-Api-Key", testToken)
+	req.Header.Set("X-Auth-Token", testToken)
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+	flushAuditLog(al)
+
+	var d AuditDoc
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.RequestHeaders) != 1 || d.RequestHeaders["X-Request-Id"] != "42" {
+		t.Error("invalid request headers", d.RequestHeaders)
+	}
+
+	if len(d.ResponseHeaders) != 1 || d.ResponseHeaders["X-Backend"] != "b1" {
+		t.Error("invalid response headers", d.ResponseHeaders)
+	}
+
+	if strings.Contains(out.String(), testToken) {
+		t.Error("the credentials were captured")
+	}
+}
+
+func TestAuditLogTLSWithoutTLS(t *testing.T) {
+	var out bytes.Buffer
+	testAuditLog(t, NewAuditLog(&out), []interface{}{"tls=true"}, "")
+
+	var d AuditDoc
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.TLS != nil {
+		t.Error("unexpected TLS details for a plain connection", d.TLS)
+	}
+}
+
+func TestAuditSinkFunc(t *testing.T) {
+	var docs []*AuditDoc
+	testAuditLog(t, NewAuditLogWithSink(AuditSinkFunc(func(d *AuditDoc) error {
+		docs = append(docs, d)
+		return nil
+	})), []interface{}{"category=payments-api"}, "")
+
+	if len(docs) != 1 || docs[0].Category != "payments-api" || docs[0].Status != http.StatusOK {
+		t.Error("invalid audit documents", docs)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var d AuditDoc
+	webhook := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer webhook.Close()
+
+	testAuditLog(t, NewAuditLogWithSink(NewWebhookSink(webhook.URL)), nil, "")
+	if d.Method != "POST" || d.Path != "/foo" {
+		t.Error("invalid audit document", d)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	if err := NewWebhookSink(failing.URL).Log(&AuditDoc{}); err == nil {
+		t.Error("failed to fail")
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	f, err := ioutil.TempFile("", "skoap-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+	defer os.Remove(f.Name())
+
+	s, err := NewFileSink(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testAuditLog(t, NewAuditLogWithSink(s), nil, "")
+	testAuditLog(t, NewAuditLogWithSink(s), nil, "")
+
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(string(b)), "\n"); len(lines) != 2 {
+		t.Error("invalid number of entries", len(lines))
+	}
+}
+
+func TestAsyncSinkDropsOldest(t *testing.T) {
+	release := make(chan struct{})
+	var paths []string
+	s := NewAsyncSink(AuditSinkFunc(func(d *AuditDoc) error {
+		<-release
+		paths = append(paths, d.Path)
+		return nil
+	}), 2).(*asyncSink)
+
+	// the first entry is taken by the background goroutine, blocking
+	// on the release channel, then the buffer overflows
+	s.Log(&AuditDoc{Path: "/1"})
+	for len(s.buffer) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, p := range []string{"/2", "/3", "/4"} {
+		s.Log(&AuditDoc{Path: p})
+	}
+
+	close(release)
+	s.flush()
+	if strings.Join(paths, ",") != "/1,/3,/4" {
+		t.Error("invalid entries", paths)
+	}
+}
+
+func TestAuditLogDefaultMaxBody(t *testing.T) {
+	for _, ti := range []struct {
+		msg  string
+		args []interface{}
+		body string
+	}{{
+		msg:  "default",
+		body: "hel",
+	}, {
+		msg:  "with options",
+		args: []interface{}{"category=payments-api"},
+		body: "hel",
+	}, {
+		msg:  "override",
+		args: []interface{}{float64(4)},
+		body: "hell",
+	}, {
+		msg:  "override to disable",
+		args: []interface{}{float64(0)},
+	}} {
+		var out bytes.Buffer
+		spec := NewAuditLogWithOptions(AuditLogOptions{Sink: NewWriterSink(&out), MaxBody: 3})
+		testAuditLog(t, spec, ti.args, "hello")
+
+		var d AuditDoc
+		if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if d.RequestBody != ti.body {
+			t.Error(ti.msg, "invalid body", d.RequestBody)
+		}
+	}
+}
+
+func TestAuditLogTokenFingerprint(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	var out bytes.Buffer
+	al := NewAuditLogWithOptions(AuditLogOptions{Sink: NewWriterSink(&out), TokenFingerprintSalt: []byte("salt")})
+	fr := make(filters.Registry)
+	fr.Register(al)
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{Name: AuditLogName}},
+		Backend: backend.URL})
+
+	for _, token := range []string{testToken, testToken, "other-token", ""} {
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token != "" {
+			req.Header.Set(authHeaderName, "Bearer "+token)
+		}
+
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp.Body.Close()
+	}
+
+	flushAuditLog(al)
+	if strings.Contains(out.String(), testToken) {
+		t.Error("the token was logged")
+	}
+
+	var fingerprints []string
+	d := json.NewDecoder(&out)
+	for {
+		var doc AuditDoc
+		if err := d.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		fingerprints = append(fingerprints, doc.TokenFingerprint)
+	}
+
+	m := hmac.New(sha256.New, []byte("salt"))
+	m.Write([]byte(testToken))
+	expected := hex.EncodeToString(m.Sum(nil))
+	if len(fingerprints) != 4 || fingerprints[0] != expected || fingerprints[1] != expected ||
+		fingerprints[2] == expected || fingerprints[2] == "" || fingerprints[3] != "" {
+		t.Error("invalid fingerprints", fingerprints)
+	}
+}
+
+func TestAuditLogStaticFields(t *testing.T) {
+	var out bytes.Buffer
+	spec := NewAuditLogWithOptions(AuditLogOptions{
+		Sink:   NewWriterSink(&out),
+		Fields: map[string]string{"environment": "production", "datacenter": "eu-1", "status": "overridden"}})
+	testAuditLog(t, spec, nil, "")
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m["environment"] != "production" || m["datacenter"] != "eu-1" || m["status"] != float64(http.StatusOK) {
+		t.Error("invalid audit document", m)
+	}
+
+	var d AuditDoc
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.Fields) != 2 || d.Fields["environment"] != "production" || d.Status != http.StatusOK {
+		t.Error("failed to decode the static fields", d)
+	}
+}
+
+func TestAuditLogMethodSampling(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	for _, ti := range []struct {
+		msg    string
+		rates  map[string]float64
+		args   []interface{}
+		state  map[string]interface{}
+		logged bool
+	}{{
+		msg:    "no sampling",
+		logged: true,
+	}, {
+		msg:    "other method excluded",
+		rates:  map[string]float64{"HEAD": 0},
+		logged: true,
+	}, {
+		msg:   "excluded",
+		rates: map[string]float64{"POST": 0},
+	}, {
+		msg:    "always sampled",
+		rates:  map[string]float64{"POST": 1},
+		logged: true,
+	}, {
+		msg:   "excluded by the route",
+		rates: map[string]float64{"HEAD": 0},
+		args:  []interface{}{"sample=post:0"},
+	}, {
+		msg:    "included by the route",
+		rates:  map[string]float64{"POST": 0},
+		args:   []interface{}{"sample=POST:1"},
+		logged: true,
+	}, {
+		msg:    "rejected",
+		rates:  map[string]float64{"POST": 0},
+		state:  map[string]interface{}{authRejectReasonKey: string(invalidToken)},
+		logged: true,
+	}, {
+		msg:    "anomaly",
+		rates:  map[string]float64{"POST": 0},
+		state:  map[string]interface{}{authAnomaliesKey: []string{"token-reuse"}},
+		logged: true,
+	}} {
+		var out bytes.Buffer
+		spec := NewAuditLogWithOptions(AuditLogOptions{Sink: NewWriterSink(&out), MethodSampleRates: ti.rates})
+		fr := make(filters.Registry)
+		fr.Register(spec)
+		fr.Register(stateBagFilter(ti.state))
+		proxy := proxytest.New(fr, &eskip.Route{
+			Filters: []*eskip.Filter{{Name: AuditLogName, Args: ti.args}, {Name: "testStateBag"}},
+			Backend: backend.URL})
+
+		rsp, err := http.Post(proxy.URL, "text/plain", nil)
+		proxy.Close()
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		flushAuditLog(spec)
+		if logged := out.Len() > 0; logged != ti.logged {
+			t.Error(ti.msg, "unexpected result", logged)
+		}
+	}
+}
+
+func TestAuditLogInvalidSampleRate(t *testing.T) {
+	for _, a := range []string{"sample=HEAD", "sample=HEAD:2", "sample=:0.1", "sample=HEAD:x"} {
+		if _, err := NewAuditLog(nil).CreateFilter([]interface{}{a}); err == nil {
+			t.Error("failed to fail", a)
+		}
+	}
+}
+
+func TestParseMethodSampleRates(t *testing.T) {
+	rates, err := ParseMethodSampleRates([]string{"head:0", "OPTIONS:0.01"})
+	if err != nil || len(rates) != 2 || rates["HEAD"] != 0 || rates["OPTIONS"] != 0.01 {
+		t.Error("failed to parse the sample rates", rates, err)
+	}
+
+	if _, err := ParseMethodSampleRates([]string{"HEAD:2"}); err == nil {
+		t.Error("failed to fail")
+	}
+}
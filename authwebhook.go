@@ -0,0 +1,155 @@
+package skoap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/zalando/skipper/filters"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const authWebhookTimeout = 2 * time.Second
+
+const (
+	policyDenied        rejectReason = "policy-denied"
+	policyServiceAccess rejectReason = "policy-service-access"
+)
+
+type (
+	// the request posted to the policy engine, in the format of the
+	// Open Policy Agent data API
+	policyInputDoc struct {
+		Input *policyInput `json:"input"`
+	}
+
+	policyInput struct {
+		Method string            `json:"method"`
+		Path   string            `json:"path"`
+		Uid    string            `json:"uid,omitempty"`
+		Realm  string            `json:"realm,omitempty"`
+		Scopes []string          `json:"scopes,omitempty"`
+		Teams  []string          `json:"teams,omitempty"`
+		Groups []string          `json:"groups,omitempty"`
+		Claims map[string]string `json:"claims,omitempty"`
+	}
+
+	// the result is either a boolean, or an object with the decision
+	// and an optional reason
+	policyResultDoc struct {
+		Result json.RawMessage `json:"result"`
+	}
+
+	policyDecisionDoc struct {
+		Allow  bool   `json:"allow"`
+		Reason string `json:"reason"`
+	}
+
+	authWebhookSpec struct {
+		client     *http.Client
+		jsonErrors bool
+	}
+
+	authWebhook struct {
+		url        string
+		client     *http.Client
+		jsonErrors bool
+	}
+)
+
+// Creates an authWebhook filter specification. The filter posts the
+// request method and path, and the identity of the user authenticated
+// by a preceding auth filter, to an external policy engine, e.g. Open
+// Policy Agent, at the url set as its argument, and rejects the request
+// with 401 unless the engine allows it. The failures of the engine
+// reject the requests, too.
+func NewAuthWebhook(jsonErrors bool) filters.Spec {
+	return &authWebhookSpec{
+		client:     &http.Client{Timeout: authWebhookTimeout},
+		jsonErrors: jsonErrors}
+}
+
+func (s *authWebhookSpec) Name() string { return AuthWebhookName }
+
+func (s *authWebhookSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	if len(args) != 1 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	u, ok := args[0].(string)
+	if !ok {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	if _, err := url.ParseRequestURI(u); err != nil {
+		return nil, err
+	}
+
+	return &authWebhook{url: u, client: s.client, jsonErrors: s.jsonErrors}, nil
+}
+
+// returns whether the policy allows the request, and the reason, when
+// not
+func (f *authWebhook) decide(in *policyInput) (bool, string, error) {
+	b, err := json.Marshal(&policyInputDoc{Input: in})
+	if err != nil {
+		return false, "", err
+	}
+
+	rsp, err := f.client.Post(f.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return false, "", err
+	}
+
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("failed to call policy engine: %s", rsp.Status)
+	}
+
+	var r policyResultDoc
+	if err := json.NewDecoder(rsp.Body).Decode(&r); err != nil {
+		return false, "", err
+	}
+
+	var allow bool
+	if err := json.Unmarshal(r.Result, &allow); err == nil {
+		return allow, "", nil
+	}
+
+	var d policyDecisionDoc
+	if err := json.Unmarshal(r.Result, &d); err != nil {
+		return false, "", fmt.Errorf("invalid policy engine result: %s", r.Result)
+	}
+
+	return d.Allow, d.Reason, nil
+}
+
+func (f *authWebhook) Request(ctx filters.FilterContext) {
+	r := ctx.Request()
+	in := &policyInput{Method: r.Method, Path: r.URL.Path}
+	if a := authContext(ctx); a != nil {
+		in.Uid = a.User
+		in.Realm = a.Realm
+		in.Scopes = a.Scopes
+		in.Teams = a.Teams
+		in.Groups = a.Groups
+		in.Claims = a.Claims
+	}
+
+	allow, reason, err := f.decide(in)
+	switch {
+	case err != nil:
+		log.Println(err)
+		unauthorized(ctx, in.Uid, policyServiceAccess, f.jsonErrors)
+	case !allow:
+		if reason == "" {
+			reason = string(policyDenied)
+		}
+
+		unauthorized(ctx, in.Uid, rejectReason(reason), f.jsonErrors)
+	}
+}
+
+func (f *authWebhook) Response(_ filters.FilterContext) {}
@@ -0,0 +1,110 @@
+package skoap
+
+import (
+	"encoding/json"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthWebhook(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	var input policyInput
+	policy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var d policyInputDoc
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			t.Error(err)
+			return
+		}
+
+		input = *d.Input
+		switch input.Path {
+		case "/bool":
+			w.Write([]byte(`{"result": true}`))
+		case "/object":
+			w.Write([]byte(`{"result": {"allow": true}}`))
+		case "/denied":
+			w.Write([]byte(`{"result": {"allow": false, "reason": "outside-business-hours"}}`))
+		case "/invalid":
+			w.Write([]byte(`{"result": "yes"}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer policy.Close()
+
+	if _, err := NewAuthWebhook(false).CreateFilter([]interface{}{"not a url"}); err == nil {
+		t.Error("failed to fail on invalid url")
+	}
+
+	for _, ti := range []struct {
+		msg        string
+		path       string
+		statusCode int
+		reason     string
+	}{{
+		msg:        "boolean result",
+		path:       "/bool",
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "object result",
+		path:       "/object",
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "denied",
+		path:       "/denied",
+		statusCode: http.StatusUnauthorized,
+		reason:     "outside-business-hours",
+	}, {
+		msg:        "invalid result",
+		path:       "/invalid",
+		statusCode: http.StatusUnauthorized,
+		reason:     string(policyServiceAccess),
+	}, {
+		msg:        "policy engine failure",
+		path:       "/failure",
+		statusCode: http.StatusUnauthorized,
+		reason:     string(policyServiceAccess),
+	}} {
+		state := stateBagFilter{
+			authDocKey:   &authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}},
+			authTeamsKey: []string{testTeam}}
+		fr := make(filters.Registry)
+		fr.Register(state)
+		fr.Register(NewAuthWebhook(true))
+		proxy := proxytest.New(fr, &eskip.Route{
+			Filters: []*eskip.Filter{
+				{Name: state.Name()},
+				{Name: AuthWebhookName, Args: []interface{}{policy.URL}}},
+			Backend: backend.URL})
+
+		rsp, err := http.Get(proxy.URL + ti.path)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		var e errorDoc
+		if rsp.StatusCode != http.StatusOK {
+			if err := json.NewDecoder(rsp.Body).Decode(&e); err != nil {
+				t.Error(ti.msg, err)
+			}
+		}
+
+		rsp.Body.Close()
+		proxy.Close()
+
+		if rsp.StatusCode != ti.statusCode || e.Error != ti.reason {
+			t.Error(ti.msg, "invalid response", rsp.StatusCode, e.Error)
+		}
+
+		if input.Method != "GET" || input.Uid != testUid || input.Realm != testRealm ||
+			len(input.Scopes) != 1 || len(input.Teams) != 1 {
+			t.Error(ti.msg, "invalid policy input", input)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package skoap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	defaultQueueSize     = 10000
+	defaultMaxRetries    = 3
+	batchRetryBase       = 100 * time.Millisecond
+)
+
+var (
+	errAuditQueueFull  = errors.New("audit queue full, entry dropped")
+	errAuditSinkClosed = errors.New("audit sink closed, entry dropped")
+)
+
+// BatchOptions contains the settings of the batching webhook audit sink.
+type BatchOptions struct {
+
+	// The url where the batches are posted, as JSON arrays.
+	Url string
+
+	// The max number of entries in a batch. Defaults to 100.
+	BatchSize int
+
+	// The max time an entry waits for the batch to be filled. Defaults
+	// to one second.
+	FlushInterval time.Duration
+
+	// The max number of entries waiting to be sent. When the queue is
+	// full, the new entries are dropped. Defaults to 10000.
+	QueueSize int
+
+	// The number of times a failed batch is retried, with exponential
+	// backoff, before it is dropped. Defaults to 3, negative values
+	// disable the retries.
+	MaxRetries int
+}
+
+// the entries are collected into batches by the run loop, and the
+// batches are posted by the send loop, so the backoff of the retries
+// doesn't stop collecting the new entries
+type batchSink struct {
+	options BatchOptions
+	client  *http.Client
+	queue   chan *AuditDoc
+	batches chan []*AuditDoc
+	flushes chan chan struct{}
+	closing chan struct{}
+	stopped chan struct{}
+
+	// the batches handed over to the send loop and not sent yet
+	pending sync.WaitGroup
+
+	// Log doesn't queue new entries once the sink is closed
+	mu     sync.RWMutex
+	closed bool
+
+	// the entries queued or being sent, and their estimated size
+	entries int64
+	bytes   int64
+
+	// the dropped entries, and the time of the last drop in unix
+	// nanoseconds
+	dropped  uint64
+	lastDrop int64
+}
+
+// Creates an audit sink posting the entries to an HTTP endpoint in
+// batches. The entries are queued in a bounded queue, and sent in the
+// background, so a slow endpoint doesn't block the requests. The
+// returned sink implements io.Closer: Close sends the queued entries,
+// and stops the background goroutines.
+func NewBatchingWebhookSink(o BatchOptions) AuditSink {
+	if o.BatchSize <= 0 {
+		o.BatchSize = defaultBatchSize
+	}
+
+	if o.FlushInterval <= 0 {
+		o.FlushInterval = defaultFlushInterval
+	}
+
+	if o.QueueSize <= 0 {
+		o.QueueSize = defaultQueueSize
+	}
+
+	if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	} else if o.MaxRetries == 0 {
+		o.MaxRetries = defaultMaxRetries
+	}
+
+	// the batches waiting for the send loop are limited by the queue
+	// size, too
+	maxBatches := o.QueueSize / o.BatchSize
+	if maxBatches < 1 {
+		maxBatches = 1
+	}
+
+	s := &batchSink{
+		options: o,
+		client:  &http.Client{Timeout: webhookTimeout},
+		queue:   make(chan *AuditDoc, o.QueueSize),
+		batches: make(chan []*AuditDoc, maxBatches),
+		flushes: make(chan chan struct{}),
+		closing: make(chan struct{}),
+		stopped: make(chan struct{})}
+	registerMemoryUser(s)
+	registerDegradable(s)
+	registerAuditFlusher(s)
+	go s.run()
+	go s.sendLoop()
+	return s
+}
+
+func (s *batchSink) Log(d *AuditDoc) error {
+	if KillSwitchOn(KillSwitchWebhookSinks) {
+		return nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return errAuditSinkClosed
+	}
+
+	size := docSize(d)
+	atomic.AddInt64(&s.entries, 1)
+	atomic.AddInt64(&s.bytes, size)
+	addMemory(size)
+
+	select {
+	case s.queue <- d:
+		return nil
+	default:
+		s.release([]*AuditDoc{d})
+		s.drop(1)
+		return errAuditQueueFull
+	}
+}
+
+func (s *batchSink) post(b []byte) error {
+	rsp, err := s.client.Post(s.options.Url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	rsp.Body.Close()
+	if rsp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("failed to send audit log batch: %s", rsp.Status)
+	}
+
+	return nil
+}
+
+func (s *batchSink) send(batch []*AuditDoc) {
+	b, err := json.Marshal(batch)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	wait := batchRetryBase
+	for i := 0; ; i++ {
+		err = s.post(b)
+		if err == nil {
+			return
+		}
+
+		if i == s.options.MaxRetries {
+			log.Printf("%v, dropping %d entries", err, len(batch))
+			s.drop(len(batch))
+			return
+		}
+
+		// only the send loop waits, the entries are still collected
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+func (s *batchSink) sendLoop() {
+	for batch := range s.batches {
+		s.send(batch)
+		s.release(batch)
+		s.pending.Done()
+	}
+
+	close(s.stopped)
+}
+
+// hands over a batch to the send loop. When the send loop is behind,
+// e.g. retrying the failed batches, and the waiting batches reach the
+// limit, the batch is dropped, unless wait is set.
+func (s *batchSink) enqueue(batch []*AuditDoc, wait bool) {
+	s.pending.Add(1)
+	if wait {
+		s.batches <- batch
+		return
+	}
+
+	select {
+	case s.batches <- batch:
+	default:
+		s.pending.Done()
+		log.Printf("%v, dropping %d entries", errAuditQueueFull, len(batch))
+		s.release(batch)
+		s.drop(len(batch))
+	}
+}
+
+func (s *batchSink) release(docs []*AuditDoc) {
+	var size int64
+	for _, d := range docs {
+		size += docSize(d)
+	}
+
+	atomic.AddInt64(&s.entries, -int64(len(docs)))
+	atomic.AddInt64(&s.bytes, -size)
+	addMemory(-size)
+}
+
+func (s *batchSink) drop(n int) {
+	atomic.AddUint64(&s.dropped, uint64(n))
+	atomic.StoreInt64(&s.lastDrop, time.Now().UnixNano())
+}
+
+func (s *batchSink) degradation() DegradationStats {
+	queued := len(s.queue)
+	return DegradationStats{
+		Name:     "audit-batch-queue",
+		Degraded: spooling(queued, cap(s.queue)) || droppedRecently(&s.lastDrop),
+		Queued:   queued,
+		Dropped:  atomic.LoadUint64(&s.dropped)}
+}
+
+func (s *batchSink) memoryStats() MemoryStats {
+	return MemoryStats{
+		Name:    "audit-batch-queue",
+		Entries: int(atomic.LoadInt64(&s.entries)),
+		Bytes:   atomic.LoadInt64(&s.bytes)}
+}
+
+// sends the queued entries, and waits until they are sent
+func (s *batchSink) flush() {
+	done := make(chan struct{})
+	select {
+	case s.flushes <- done:
+		<-done
+	case <-s.stopped:
+	}
+}
+
+// Close sends the queued entries, and stops the background goroutines.
+// The entries logged after Close are dropped.
+func (s *batchSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.closing)
+	<-s.stopped
+	return nil
+}
+
+// hands over the current batch and all the queued entries to the send
+// loop, in batches of the max size, and waits until they are sent
+func (s *batchSink) sendAll(batch []*AuditDoc) {
+	// only the run loop receives from the queue
+	for len(s.queue) > 0 {
+		batch = append(batch, <-s.queue)
+	}
+
+	for len(batch) > 0 {
+		n := s.options.BatchSize
+		if n > len(batch) {
+			n = len(batch)
+		}
+
+		s.enqueue(batch[:n], true)
+		batch = batch[n:]
+	}
+
+	s.pending.Wait()
+}
+
+func (s *batchSink) run() {
+	var batch []*AuditDoc
+	ticker := time.NewTicker(s.options.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case d := <-s.queue:
+			batch = append(batch, d)
+			if len(batch) < s.options.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case done := <-s.flushes:
+			s.sendAll(batch)
+			batch = nil
+			close(done)
+			continue
+		case <-s.closing:
+			s.sendAll(batch)
+			close(s.batches)
+			return
+		}
+
+		s.enqueue(batch, false)
+		batch = nil
+	}
+}
@@ -0,0 +1,189 @@
+package skoap
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchingWebhookSink(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		batches  [][]AuditDoc
+		failures int
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// fail the first request to test the retry
+		if failures == 0 {
+			failures++
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var b []AuditDoc
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			t.Error(err)
+		}
+
+		batches = append(batches, b)
+	}))
+	defer server.Close()
+
+	s := NewBatchingWebhookSink(BatchOptions{Url: server.URL, BatchSize: 2, FlushInterval: 20 * time.Millisecond})
+	for _, p := range []string{"/foo", "/bar", "/baz"} {
+		if err := s.Log(&AuditDoc{Path: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	timeout := time.After(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(batches)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+
+		select {
+		case <-timeout:
+			t.Fatal("timeout", n)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if len(batches[0]) != 2 || batches[0][0].Path != "/foo" || len(batches[1]) != 1 || batches[1][0].Path != "/baz" {
+		t.Error("invalid batches", batches)
+	}
+}
+
+func TestBatchingWebhookSinkQueueFull(t *testing.T) {
+	s := &batchSink{queue: make(chan *AuditDoc, 1)}
+	if err := s.Log(&AuditDoc{}); err != nil {
+		t.Error(err)
+	}
+
+	if err := s.Log(&AuditDoc{}); err != errAuditQueueFull {
+		t.Error("failed to drop entry", err)
+	}
+}
+
+func TestBatchingWebhookSinkFlush(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]AuditDoc
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b []AuditDoc
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, b)
+	}))
+	defer server.Close()
+
+	s := NewBatchingWebhookSink(BatchOptions{Url: server.URL, BatchSize: 2, FlushInterval: time.Hour})
+	for _, p := range []string{"/foo", "/bar", "/baz"} {
+		if err := s.Log(&AuditDoc{Path: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.(*batchSink).flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 || batches[1][0].Path != "/baz" {
+		t.Error("failed to flush the queued entries", batches)
+	}
+}
+
+func TestBatchingWebhookSinkBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	s := NewBatchingWebhookSink(BatchOptions{
+		Url:           server.URL,
+		BatchSize:     1,
+		QueueSize:     4,
+		FlushInterval: time.Hour,
+		MaxRetries:    1}).(*batchSink)
+	defer s.Close()
+
+	for _, p := range []string{"/foo", "/bar", "/baz"} {
+		if err := s.Log(&AuditDoc{Path: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// while the first batch waits for the retry, the queue is drained
+	time.Sleep(30 * time.Millisecond)
+	if n := len(s.queue); n != 0 {
+		t.Error("queue blocked by the backoff", n)
+	}
+}
+
+func TestBatchingWebhookSinkClose(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		paths []string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var b []AuditDoc
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			t.Error(err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, d := range b {
+			paths = append(paths, d.Path)
+		}
+	}))
+	defer server.Close()
+
+	s := NewBatchingWebhookSink(BatchOptions{Url: server.URL, BatchSize: 2, FlushInterval: time.Hour})
+	for _, p := range []string{"/foo", "/bar", "/baz"} {
+		if err := s.Log(&AuditDoc{Path: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Log(&AuditDoc{Path: "/qux"}); err != errAuditSinkClosed {
+		t.Error("failed to reject entry after close", err)
+	}
+
+	// flushing a closed sink doesn't block
+	s.(*batchSink).flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paths) != 3 {
+		t.Error("failed to send the queued entries", paths)
+	}
+
+	// the sink sends in the background itself, and is not wrapped by the
+	// audit log filter
+	if _, ok := NewAuditLogWithSink(s).(*auditLog).sink.(*batchSink); !ok {
+		t.Error("batching sink wrapped")
+	}
+}
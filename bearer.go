@@ -0,0 +1,206 @@
+package skoap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zalando/skipper/filters"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	serviceTokenRetry     = 10 * time.Second
+	serviceTokenMinExpiry = time.Minute
+)
+
+const serviceTokenUnavailable rejectReason = "service-token-unavailable"
+
+var (
+	errMissingTokenUrl         = errors.New("missing token url for the service tokens")
+	errServiceTokenUnavailable = errors.New("service token not available")
+)
+
+type (
+	// ServiceTokenOptions contains the settings of the bearerToken filter
+	// specification.
+	ServiceTokenOptions struct {
+
+		// The url of the OAuth2 token endpoint.
+		TokenUrl string
+
+		// The client id used in the client credentials flow.
+		ClientId string
+
+		// The client secret used in the client credentials flow.
+		ClientSecret string
+	}
+
+	serviceTokenDoc struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+
+	serviceToken struct {
+		options ServiceTokenOptions
+		scopes  []string
+		mu      sync.RWMutex
+		token   string
+
+		// the last refresh failed
+		failing bool
+	}
+
+	bearerTokenSpec struct {
+		options ServiceTokenOptions
+		mu      sync.Mutex
+		tokens  map[string]*serviceToken
+	}
+)
+
+// Creates a bearerToken filter specification. The filter replaces the
+// Authorization header of the outgoing request with a service token,
+// obtained with the OAuth2 client credentials flow. The filter arguments
+// are the requested scopes. The tokens are refreshed in the background,
+// before they expire.
+func NewBearerToken(o ServiceTokenOptions) filters.Spec {
+	return &bearerTokenSpec{options: o, tokens: make(map[string]*serviceToken)}
+}
+
+// creates a service token, and starts refreshing it in the background
+func newServiceToken(o ServiceTokenOptions, scopes []string) *serviceToken {
+	st := &serviceToken{options: o, scopes: scopes}
+	registerDegradable(st)
+	go st.refresh()
+	return st
+}
+
+func (st *serviceToken) fetch() (time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(st.scopes) > 0 {
+		form.Set("scope", strings.Join(st.scopes, " "))
+	}
+
+	req, err := http.NewRequest("POST", st.options.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(st.options.ClientId, st.options.ClientSecret)
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to obtain service token: %s", rsp.Status)
+	}
+
+	var d serviceTokenDoc
+	if err := json.NewDecoder(rsp.Body).Decode(&d); err != nil {
+		return 0, err
+	}
+
+	st.mu.Lock()
+	st.token = d.AccessToken
+	st.mu.Unlock()
+
+	return time.Duration(d.ExpiresIn) * time.Second, nil
+}
+
+// refreshes the token when 80% of its lifetime has passed, or retries
+// after a short delay in case of failure
+func (st *serviceToken) refresh() {
+	for {
+		expires, err := st.fetch()
+		st.mu.Lock()
+		st.failing = err != nil
+		st.mu.Unlock()
+
+		next := expires * 4 / 5
+		if err != nil {
+			log.Println(err)
+			next = serviceTokenRetry
+		} else if next < serviceTokenMinExpiry {
+			next = serviceTokenMinExpiry
+		}
+
+		time.Sleep(next)
+	}
+}
+
+func (st *serviceToken) get() string {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.token
+}
+
+func (st *serviceToken) degradation() DegradationStats {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	ds := DegradationStats{Name: strings.TrimSpace("service-token " + strings.Join(st.scopes, " "))}
+	switch {
+	case st.token == "":
+		ds.Degraded = true
+		ds.Detail = "no service token available"
+	case st.failing:
+		ds.Degraded = true
+		ds.Detail = "refresh failing, using the previous token"
+	}
+
+	return ds
+}
+
+func (s *bearerTokenSpec) Name() string { return BearerTokenName }
+
+func (s *bearerTokenSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	if s.options.TokenUrl == "" {
+		return nil, errMissingTokenUrl
+	}
+
+	var scopes []string
+	for _, a := range args {
+		sa, ok := a.(string)
+		if !ok {
+			return nil, filters.ErrInvalidFilterParameters
+		}
+
+		scopes = append(scopes, sa)
+	}
+
+	sort.Strings(scopes)
+	key := strings.Join(scopes, " ")
+
+	// the tokens are shared between the routes requesting the same
+	// scopes, and survive the route updates
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.tokens[key]; ok {
+		return st, nil
+	}
+
+	st := newServiceToken(s.options, scopes)
+	s.tokens[key] = st
+	return st, nil
+}
+
+func (st *serviceToken) Request(ctx filters.FilterContext) {
+	t := st.get()
+	if t == "" {
+		ctx.StateBag()[authRejectReasonKey] = string(serviceTokenUnavailable)
+		ctx.Serve(&http.Response{StatusCode: http.StatusServiceUnavailable})
+		return
+	}
+
+	ctx.Request().Header.Set(authHeaderName, "Bearer "+t)
+}
+
+func (st *serviceToken) Response(_ filters.FilterContext) {}
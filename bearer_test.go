@@ -0,0 +1,143 @@
+package skoap
+
+import (
+	"context"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBearerToken(t *testing.T) {
+	tokenService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "skoap" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read-orders write-orders" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Write([]byte(`{"access_token": "service-token", "expires_in": 3600}`))
+	}))
+	defer tokenService.Close()
+
+	var auth string
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get(authHeaderName)
+	}))
+	defer backend.Close()
+
+	spec := NewBearerToken(ServiceTokenOptions{TokenUrl: tokenService.URL, ClientId: "skoap", ClientSecret: "secret"})
+	f, err := spec.CreateFilter([]interface{}{"write-orders", "read-orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(time.Second)
+	for f.(*serviceToken).get() == "" {
+		select {
+		case <-timeout:
+			t.Fatal("failed to obtain service token")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	fr := make(filters.Registry)
+	fr.Register(spec)
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{Name: BearerTokenName, Args: []interface{}{"read-orders", "write-orders"}}},
+		Backend: backend.URL})
+	defer proxy.Close()
+
+	req, err := http.NewRequest("GET", proxy.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set(authHeaderName, "Bearer "+testToken)
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK || auth != "Bearer service-token" {
+		t.Error("failed to set the service token", rsp.StatusCode, auth)
+	}
+}
+
+func TestBearerTokenMissingUrl(t *testing.T) {
+	if _, err := NewBearerToken(ServiceTokenOptions{}).CreateFilter(nil); err == nil {
+		t.Error("failed to fail without token url")
+	}
+}
+
+func TestTeamServiceToken(t *testing.T) {
+	tokenService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token": "service-token", "expires_in": 3600}`))
+	}))
+	defer tokenService.Close()
+
+	teamService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(authHeaderName) != "Bearer service-token" || r.URL.Query().Get("uid") != testUid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`[{"id": "test-team"}]`))
+	}))
+	defer teamService.Close()
+
+	tc := &teamClient{
+		urlBase:      teamService.URL + "?uid=",
+		serviceToken: newServiceToken(ServiceTokenOptions{TokenUrl: tokenService.URL}, nil)}
+
+	timeout := time.After(time.Second)
+	for tc.serviceToken.get() == "" {
+		select {
+		case <-timeout:
+			t.Fatal("failed to obtain service token")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	teams, err := tc.Teams(context.Background(), testUid, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(teams) != 1 || teams[0] != testTeam {
+		t.Error("invalid teams", teams)
+	}
+}
+
+func TestTeamServiceSourceShared(t *testing.T) {
+	var tokenRequests int32
+	tokenService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&tokenRequests, 1)
+		w.Write([]byte(`{"access_token": "service-token", "expires_in": 3600}`))
+	}))
+	defer tokenService.Close()
+
+	o := Options{
+		AuthUrlBase:      "https://auth.example.org",
+		TeamUrlBase:      "https://teams.example.org?uid=",
+		TeamServiceToken: ServiceTokenOptions{TokenUrl: tokenService.URL}}
+	o.TeamSource = NewTeamServiceSource(o)
+	NewAuthTeamWithOptions(o)
+	NewAuthScopeOrTeamWithOptions(o)
+	NewAuthPredicates(o)
+
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&tokenRequests); n != 1 {
+		t.Error("service token not shared", n)
+	}
+}
@@ -0,0 +1,283 @@
+package skoap
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheSize = 4096
+
+	// the caches are split into lock-striped shards, to avoid a single
+	// mutex becoming a hot spot on many cores. Small caches use fewer
+	// shards, so that each shard holds at least minShardSize entries.
+	defaultCacheShards = 32
+	minShardSize       = 64
+)
+
+type (
+	cacheEntry struct {
+		key     string
+		value   interface{}
+		size    int64
+		expires time.Time
+	}
+
+	cacheShard struct {
+		mu        sync.Mutex
+		maxSize   int
+		entries   map[string]*list.Element
+		lru       *list.List
+		bytes     int64
+		evictions uint64
+		hits      uint64
+		misses    uint64
+	}
+
+	// ttlCache stores values for a fixed time, or until the set
+	// expiration. The keys are distributed by their hash across
+	// shards, and when a shard reaches its max size, or the global
+	// memory budget is exceeded, the least recently used entries of
+	// the shards are evicted.
+	ttlCache struct {
+		name   string
+		ttl    time.Duration
+		shards []*cacheShard
+	}
+)
+
+// CacheStats contains the footprint and the hit rate of a cache.
+type CacheStats struct {
+	MemoryStats
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+var caches struct {
+	mu  sync.Mutex
+	all []*ttlCache
+}
+
+func newTTLCache(name string, ttl time.Duration, maxSize int) *ttlCache {
+	if maxSize <= 0 {
+		maxSize = defaultCacheSize
+	}
+
+	shards := defaultCacheShards
+	for shards > 1 && maxSize/shards < minShardSize {
+		shards /= 2
+	}
+
+	c := newShardedCache(name, ttl, maxSize, shards)
+	registerMemoryUser(c)
+
+	caches.mu.Lock()
+	defer caches.mu.Unlock()
+	caches.all = append(caches.all, c)
+	return c
+}
+
+// Returns the statistics of all the caches.
+func Caches() []CacheStats {
+	caches.mu.Lock()
+	all := caches.all
+	caches.mu.Unlock()
+
+	var s []CacheStats
+	for _, c := range all {
+		s = append(s, c.cacheStats())
+	}
+
+	return s
+}
+
+func newShardedCache(name string, ttl time.Duration, maxSize, shards int) *ttlCache {
+	c := &ttlCache{name: name, ttl: ttl, shards: make([]*cacheShard, shards)}
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{
+			maxSize: (maxSize + shards - 1) / shards,
+			entries: make(map[string]*list.Element),
+			lru:     list.New()}
+	}
+
+	return c
+}
+
+func shardIndex(key string, shards int) int {
+	if shards == 1 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shards))
+}
+
+func (s *cacheShard) remove(e *list.Element) {
+	ce := s.lru.Remove(e).(*cacheEntry)
+	delete(s.entries, ce.key)
+	s.bytes -= ce.size
+	addMemory(-ce.size)
+}
+
+func (s *cacheShard) evict() bool {
+	if s.lru.Len() == 0 {
+		return false
+	}
+
+	s.remove(s.lru.Back())
+	s.evictions++
+	return true
+}
+
+func (s *cacheShard) get(key string, now time.Time) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		s.misses++
+		return nil, false
+	}
+
+	ce := e.Value.(*cacheEntry)
+	if !now.Before(ce.expires) {
+		s.remove(e)
+		s.misses++
+		return nil, false
+	}
+
+	s.lru.MoveToFront(e)
+	s.hits++
+	return ce.value, true
+}
+
+// returns the keys of the live entries whose value matches
+func (s *cacheShard) keysWhere(match func(interface{}) bool, now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for k, e := range s.entries {
+		ce := e.Value.(*cacheEntry)
+		if now.Before(ce.expires) && match(ce.value) {
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+func (s *cacheShard) delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if ok {
+		s.remove(e)
+	}
+
+	return ok
+}
+
+func (s *cacheShard) set(ce *cacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[ce.key]; ok {
+		s.remove(e)
+	}
+
+	s.entries[ce.key] = s.lru.PushFront(ce)
+	s.bytes += ce.size
+	addMemory(ce.size)
+
+	for s.lru.Len() > s.maxSize {
+		s.evict()
+	}
+}
+
+func (c *ttlCache) shard(key string) *cacheShard {
+	return c.shards[shardIndex(key, len(c.shards))]
+}
+
+func (c *ttlCache) get(key string, now time.Time) (interface{}, bool) {
+	if KillSwitchOn(KillSwitchCaching) {
+		return nil, false
+	}
+
+	return c.shard(key).get(key, now)
+}
+
+func (c *ttlCache) set(key string, value interface{}, now time.Time) {
+	c.setUntil(key, value, now.Add(c.ttl))
+}
+
+func (c *ttlCache) setUntil(key string, value interface{}, expires time.Time) {
+	if KillSwitchOn(KillSwitchCaching) {
+		return
+	}
+
+	i := shardIndex(key, len(c.shards))
+	c.shards[i].set(&cacheEntry{
+		key:     key,
+		value:   value,
+		size:    entryOverhead + int64(len(key)) + sizeOf(value),
+		expires: expires})
+
+	// when the memory budget is exceeded, evict starting from the
+	// current shard, and continue with the next ones when it's empty
+	for empty := 0; empty < len(c.shards) && overMemoryBudget(); {
+		s := c.shards[i]
+		s.mu.Lock()
+		evicted := s.evict()
+		s.mu.Unlock()
+
+		if evicted {
+			empty = 0
+		} else {
+			empty++
+			i = (i + 1) % len(c.shards)
+		}
+	}
+}
+
+func (c *ttlCache) delete(key string) bool {
+	return c.shard(key).delete(key)
+}
+
+func (c *ttlCache) keysWhere(match func(interface{}) bool, now time.Time) []string {
+	var keys []string
+	for _, s := range c.shards {
+		keys = append(keys, s.keysWhere(match, now)...)
+	}
+
+	return keys
+}
+
+func (c *ttlCache) memoryStats() MemoryStats {
+	ms := MemoryStats{Name: c.name}
+	for _, s := range c.shards {
+		s.mu.Lock()
+		ms.Entries += s.lru.Len()
+		ms.Bytes += s.bytes
+		ms.Evictions += s.evictions
+		s.mu.Unlock()
+	}
+
+	return ms
+}
+
+func (c *ttlCache) cacheStats() CacheStats {
+	cs := CacheStats{MemoryStats: c.memoryStats()}
+	for _, s := range c.shards {
+		s.mu.Lock()
+		cs.Hits += s.hits
+		cs.Misses += s.misses
+		s.mu.Unlock()
+	}
+
+	return cs
+}
@@ -0,0 +1,113 @@
+package skoap
+
+import (
+	"fmt"
+	"github.com/zalando/skipper/filters"
+	"net/http"
+)
+
+const checkDenied rejectReason = "check-denied"
+
+// AuthContext contains the identity of the user authenticated by a
+// preceding auth filter.
+type AuthContext struct {
+	User   string
+	Realm  string
+	Scopes []string
+	Teams  []string
+	Groups []string
+	Claims map[string]string
+}
+
+// Check is a custom authorization check. It receives the identity of
+// the authenticated user, nil when there is none, the incoming request
+// and the arguments of the filter, and returns whether the request is
+// allowed, and when not, the reason that is printed in the audit log.
+// Implementations must be safe for concurrent use.
+type Check interface {
+	Check(a *AuthContext, r *http.Request, args []string) (bool, string)
+}
+
+// CheckFunc implements the Check interface with a function.
+type CheckFunc func(a *AuthContext, r *http.Request, args []string) (bool, string)
+
+// Calls the function.
+func (f CheckFunc) Check(a *AuthContext, r *http.Request, args []string) (bool, string) {
+	return f(a, r, args)
+}
+
+type (
+	checkSpec struct {
+		checks     map[string]Check
+		jsonErrors bool
+	}
+
+	checkFilter struct {
+		check      Check
+		args       []string
+		jsonErrors bool
+	}
+)
+
+// Creates a check filter specification with the custom checks, e.g.
+// loaded from plugins. The first argument of the filter is the name of
+// the check, the rest is passed to the check. When the check denies the
+// request, it is rejected with 401.
+func NewCheck(checks map[string]Check, jsonErrors bool) filters.Spec {
+	return &checkSpec{checks: checks, jsonErrors: jsonErrors}
+}
+
+func (s *checkSpec) Name() string { return CheckName }
+
+func (s *checkSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	sargs, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sargs) == 0 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	c, ok := s.checks[sargs[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown check: %s", sargs[0])
+	}
+
+	return &checkFilter{check: c, args: sargs[1:], jsonErrors: s.jsonErrors}, nil
+}
+
+func authContext(ctx filters.FilterContext) *AuthContext {
+	sb := ctx.StateBag()
+	a, ok := sb[authDocKey].(*authDoc)
+	if !ok {
+		return nil
+	}
+
+	ac := &AuthContext{User: a.Uid, Realm: a.Realm, Scopes: a.Scopes}
+	ac.Teams, _ = sb[authTeamsKey].([]string)
+	ac.Groups, _ = sb[authGroupsKey].([]string)
+	ac.Claims, _ = sb[authClaimsKey].(map[string]string)
+	return ac
+}
+
+func (f *checkFilter) Request(ctx filters.FilterContext) {
+	a := authContext(ctx)
+	allowed, reason := f.check.Check(a, ctx.Request(), f.args)
+	if allowed {
+		return
+	}
+
+	var uname string
+	if a != nil {
+		uname = a.User
+	}
+
+	if reason == "" {
+		reason = string(checkDenied)
+	}
+
+	unauthorized(ctx, uname, rejectReason(reason), f.jsonErrors)
+}
+
+func (f *checkFilter) Response(_ filters.FilterContext) {}
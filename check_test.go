@@ -0,0 +1,73 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	checks := map[string]Check{
+		"teamRead": CheckFunc(func(a *AuthContext, r *http.Request, args []string) (bool, string) {
+			if a == nil || len(args) != 1 {
+				return false, ""
+			}
+
+			for _, t := range a.Teams {
+				if t == args[0] && r.Method == "GET" {
+					return true, ""
+				}
+			}
+
+			return false, "not-in-team"
+		}),
+	}
+
+	if _, err := NewCheck(checks, false).CreateFilter([]interface{}{"unknown"}); err == nil {
+		t.Error("failed to fail on unknown check")
+	}
+
+	for _, ti := range []struct {
+		msg        string
+		state      stateBagFilter
+		statusCode int
+	}{{
+		msg:        "not authenticated",
+		state:      stateBagFilter{},
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "denied",
+		state:      stateBagFilter{authDocKey: &authDoc{Uid: testUid}, authTeamsKey: []string{"other-team"}},
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "allowed",
+		state:      stateBagFilter{authDocKey: &authDoc{Uid: testUid}, authTeamsKey: []string{testTeam}},
+		statusCode: http.StatusOK,
+	}} {
+		fr := make(filters.Registry)
+		fr.Register(ti.state)
+		fr.Register(NewCheck(checks, false))
+		proxy := proxytest.New(fr, &eskip.Route{
+			Filters: []*eskip.Filter{
+				{Name: "testStateBag"},
+				{Name: CheckName, Args: []interface{}{"teamRead", testTeam}}},
+			Backend: backend.URL})
+
+		rsp, err := http.Get(proxy.URL)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		proxy.Close()
+		if rsp.StatusCode != ti.statusCode {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode, ti.statusCode)
+		}
+	}
+}
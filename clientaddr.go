@@ -0,0 +1,104 @@
+package skoap
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const forwardedForHeader = "X-Forwarded-For"
+
+// Parses a list of networks in CIDR notation, or single IP addresses.
+func ParseNetworks(l []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, s := range l {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns the address of the client. When the connection comes from a
+// trusted proxy, the X-Forwarded-For header is followed from right to
+// left, skipping the trusted proxies, and the first untrusted address
+// is returned.
+func remoteAddr(r *http.Request, trusted []*net.IPNet) string {
+	addr := clientIP(r)
+	if !containsIP(trusted, addr) {
+		return addr
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header[forwardedForHeader], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		a := strings.TrimSpace(forwarded[i])
+		if a == "" {
+			continue
+		}
+
+		addr = a
+		if !containsIP(trusted, addr) {
+			break
+		}
+	}
+
+	return addr
+}
+
+// returns the address of the client, set by the proxies in front of
+// skoap in the X-Forwarded-For header, at the given depth from the
+// right. When the header contains fewer addresses, the address of the
+// connection is returned.
+func forwardedAddr(r *http.Request, depth int) string {
+	var forwarded []string
+	for _, a := range strings.Split(strings.Join(r.Header[forwardedForHeader], ","), ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			forwarded = append(forwarded, a)
+		}
+	}
+
+	if len(forwarded) < depth {
+		return clientIP(r)
+	}
+
+	return forwarded[len(forwarded)-depth]
+}
+
+// returns the address of the client, from the X-Forwarded-For header at
+// the given depth, when greater than zero, or else following the header
+// through the trusted proxies
+func clientAddr(r *http.Request, trusted []*net.IPNet, depth int) string {
+	if depth > 0 {
+		return forwardedAddr(r, depth)
+	}
+
+	return remoteAddr(r, trusted)
+}
@@ -0,0 +1,100 @@
+package skoap
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRemoteAddr(t *testing.T) {
+	trusted, err := ParseNetworks([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ti := range []struct {
+		msg        string
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{{
+		msg:        "no header",
+		remoteAddr: "203.0.113.1:4242",
+		expected:   "203.0.113.1",
+	}, {
+		msg:        "untrusted peer",
+		remoteAddr: "203.0.113.1:4242",
+		forwarded:  []string{"198.51.100.1"},
+		expected:   "203.0.113.1",
+	}, {
+		msg:        "trusted peer",
+		remoteAddr: "10.0.0.1:4242",
+		forwarded:  []string{"198.51.100.1"},
+		expected:   "198.51.100.1",
+	}, {
+		msg:        "trusted peer, no header",
+		remoteAddr: "10.0.0.1:4242",
+		expected:   "10.0.0.1",
+	}, {
+		msg:        "chain of trusted proxies",
+		remoteAddr: "[::1]:4242",
+		forwarded:  []string{"1.2.3.4, 198.51.100.1", "192.168.1.1, 10.1.1.1"},
+		expected:   "198.51.100.1",
+	}, {
+		msg:        "spoofed header behind an untrusted proxy",
+		remoteAddr: "10.0.0.1:4242",
+		forwarded:  []string{"10.2.2.2, 203.0.113.7"},
+		expected:   "203.0.113.7",
+	}} {
+		r := &http.Request{RemoteAddr: ti.remoteAddr, Header: http.Header{}}
+		if len(ti.forwarded) > 0 {
+			r.Header[forwardedForHeader] = ti.forwarded
+		}
+
+		if a := remoteAddr(r, trusted); a != ti.expected {
+			t.Error(ti.msg, "invalid address", a, ti.expected)
+		}
+	}
+}
+
+func TestParseNetworksInvalid(t *testing.T) {
+	if _, err := ParseNetworks([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("failed to fail")
+	}
+}
+
+func TestForwardedAddr(t *testing.T) {
+	for _, ti := range []struct {
+		msg       string
+		forwarded []string
+		depth     int
+		expected  string
+	}{{
+		msg:      "no header",
+		depth:    1,
+		expected: "203.0.113.1",
+	}, {
+		msg:       "single proxy",
+		forwarded: []string{"1.2.3.4, 198.51.100.1"},
+		depth:     1,
+		expected:  "198.51.100.1",
+	}, {
+		msg:       "two proxies, multiple headers",
+		forwarded: []string{"1.2.3.4, 198.51.100.1", "10.0.0.1"},
+		depth:     2,
+		expected:  "198.51.100.1",
+	}, {
+		msg:       "fewer addresses than the depth",
+		forwarded: []string{"198.51.100.1"},
+		depth:     2,
+		expected:  "203.0.113.1",
+	}} {
+		r := &http.Request{RemoteAddr: "203.0.113.1:4242", Header: http.Header{}}
+		if len(ti.forwarded) > 0 {
+			r.Header[forwardedForHeader] = ti.forwarded
+		}
+
+		if a := forwardedAddr(r, ti.depth); a != ti.expected {
+			t.Error(ti.msg, "invalid address", a, ti.expected)
+		}
+	}
+}
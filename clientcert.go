@@ -0,0 +1,156 @@
+package skoap
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"github.com/zalando/skipper/filters"
+	"strings"
+)
+
+const (
+	missingClientCert rejectReason = "missing-client-certificate"
+	invalidClientCert rejectReason = "invalid-client-certificate"
+)
+
+type (
+	clientCertSpec struct {
+		jsonErrors bool
+	}
+
+	clientCert struct {
+		commonNames []string
+		dnsNames    []string
+		issuers     []string
+		jsonErrors  bool
+	}
+)
+
+// Creates a clientCert filter specification. The filter authorizes the
+// requests based on the TLS client certificate verified by the
+// listener. The arguments are named: cn=<pattern> and san=<pattern>
+// allow the certificates with a matching subject common name or DNS
+// subject alternative name, and issuer=<fingerprint> allows only the
+// certificates issued by a CA whose certificate or public key has the
+// given SHA-256 fingerprint, in hex, optionally separated by colons.
+// Without cn and san arguments, any certificate of the allowed issuers
+// is accepted. The name patterns can contain '*' wildcards. The common
+// name of the certificate is stored as the user.
+func NewClientCert(jsonErrors bool) filters.Spec { return clientCertSpec{jsonErrors: jsonErrors} }
+
+func (s clientCertSpec) Name() string { return ClientCertName }
+
+func (s clientCertSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	sargs, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &clientCert{jsonErrors: s.jsonErrors}
+	for _, a := range sargs {
+		name, value, ok := namedArg(a)
+		if !ok || value == "" {
+			return nil, filters.ErrInvalidFilterParameters
+		}
+
+		switch name {
+		case "cn":
+			f.commonNames = append(f.commonNames, value)
+		case "san":
+			f.dnsNames = append(f.dnsNames, value)
+		case "issuer":
+			fp, ok := parseFingerprint(value)
+			if !ok {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+
+			f.issuers = append(f.issuers, fp)
+		default:
+			return nil, filters.ErrInvalidFilterParameters
+		}
+	}
+
+	return f, nil
+}
+
+// normalizes a hex SHA-256 fingerprint, with or without colons
+func parseFingerprint(s string) (string, bool) {
+	s = strings.ToLower(strings.Replace(s, ":", "", -1))
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", false
+	}
+
+	return s, true
+}
+
+func fingerprint(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if match(p, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *clientCert) allowedName(c *x509.Certificate) bool {
+	if len(f.commonNames) == 0 && len(f.dnsNames) == 0 ||
+		matchAny(f.commonNames, c.Subject.CommonName) {
+		return true
+	}
+
+	for _, dns := range c.DNSNames {
+		if matchAny(f.dnsNames, dns) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checks whether any of the verified chains contains an allowed issuer,
+// identified by the fingerprint of its certificate or public key, because
+// the names of the CAs are not unique
+func (f *clientCert) allowedIssuer(chains [][]*x509.Certificate) bool {
+	if len(f.issuers) == 0 {
+		return true
+	}
+
+	for _, chain := range chains {
+		for _, c := range chain[1:] {
+			cert, key := fingerprint(c.Raw), fingerprint(c.RawSubjectPublicKeyInfo)
+			for _, i := range f.issuers {
+				if i == cert || i == key {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func (f *clientCert) Request(ctx filters.FilterContext) {
+	r := ctx.Request()
+
+	// only the certificates verified by the listener are accepted
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		unauthorized(ctx, "", missingClientCert, f.jsonErrors)
+		return
+	}
+
+	c := r.TLS.VerifiedChains[0][0]
+	if !f.allowedName(c) || !f.allowedIssuer(r.TLS.VerifiedChains) {
+		unauthorized(ctx, c.Subject.CommonName, invalidClientCert, f.jsonErrors)
+		return
+	}
+
+	setAuthUser(ctx, c.Subject.CommonName)
+}
+
+func (f *clientCert) Response(_ filters.FilterContext) {}
@@ -0,0 +1,119 @@
+package skoap
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/zalando/skipper/filters/filtertest"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestClientCert(t *testing.T) {
+	for _, args := range [][]interface{}{{"cn"}, {"ou=foo"}, {"cn="}, {"issuer=Internal CA"}, {"issuer=abcd"}, {42}} {
+		if _, err := NewClientCert(false).CreateFilter(args); err == nil {
+			t.Error("failed to fail", args)
+		}
+	}
+
+	ca := &x509.Certificate{
+		Subject:                 pkix.Name{CommonName: "Internal CA"},
+		Raw:                     []byte("internal ca certificate"),
+		RawSubjectPublicKeyInfo: []byte("internal ca key")}
+
+	// a different CA with the same name
+	fakeCa := &x509.Certificate{
+		Subject:                 pkix.Name{CommonName: "Internal CA"},
+		Raw:                     []byte("fake ca certificate"),
+		RawSubjectPublicKeyInfo: []byte("fake ca key")}
+
+	caFingerprint := fingerprint(ca.Raw)
+	keyFingerprint := fingerprint(ca.RawSubjectPublicKeyInfo)
+
+	// colon separated, upper case
+	var colonFingerprint []string
+	for i := 0; i < len(caFingerprint); i += 2 {
+		colonFingerprint = append(colonFingerprint, strings.ToUpper(caFingerprint[i:i+2]))
+	}
+
+	leaf := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "orders-service"},
+		DNSNames: []string{"orders.internal.example.org"}}
+
+	for _, ti := range []struct {
+		msg    string
+		args   []interface{}
+		tls    *tls.ConnectionState
+		reason rejectReason
+	}{{
+		msg:    "no tls",
+		reason: missingClientCert,
+	}, {
+		msg:    "no verified certificate",
+		tls:    &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}},
+		reason: missingClientCert,
+	}, {
+		msg: "any verified certificate",
+		tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}},
+	}, {
+		msg:  "matching common name",
+		args: []interface{}{"cn=orders-*"},
+		tls:  &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}},
+	}, {
+		msg:  "matching alternative name",
+		args: []interface{}{"cn=payments-service", "san=*.internal.example.org"},
+		tls:  &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}},
+	}, {
+		msg:    "no matching name",
+		args:   []interface{}{"cn=payments-service", "san=*.example.com"},
+		tls:    &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}},
+		reason: invalidClientCert,
+	}, {
+		msg:    "common name pattern not matched against the alternative names",
+		args:   []interface{}{"cn=*.internal.example.org"},
+		tls:    &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}},
+		reason: invalidClientCert,
+	}, {
+		msg:  "allowed issuer by certificate fingerprint",
+		args: []interface{}{"cn=orders-service", "issuer=" + caFingerprint},
+		tls:  &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}},
+	}, {
+		msg:  "allowed issuer by key fingerprint",
+		args: []interface{}{"issuer=" + keyFingerprint},
+		tls:  &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}},
+	}, {
+		msg:  "allowed issuer, colon separated fingerprint",
+		args: []interface{}{"issuer=" + strings.Join(colonFingerprint, ":")},
+		tls:  &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, ca}}},
+	}, {
+		msg:    "issuer with the same name not allowed",
+		args:   []interface{}{"issuer=" + caFingerprint},
+		tls:    &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{leaf, fakeCa}}},
+		reason: invalidClientCert,
+	}} {
+		f, err := NewClientCert(false).CreateFilter(ti.args)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest("GET", "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.TLS = ti.tls
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		f.Request(ctx)
+
+		reason, _ := ctx.FStateBag[authRejectReasonKey].(string)
+		if reason != string(ti.reason) || ctx.FServedWithResponse != (ti.reason != "") {
+			t.Error(ti.msg, "invalid reject reason", reason, ti.reason)
+			continue
+		}
+
+		if ti.reason == "" && ctx.FStateBag[authUserKey] != "orders-service" {
+			t.Error(ti.msg, "invalid user", ctx.FStateBag[authUserKey])
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/zalando-incubator/skoap"
+)
+
+// environment variable containing a comma separated list of the kill
+// switches turned on at startup
+const killSwitchesEnv = "SKOAP_KILL_SWITCHES"
+
+// turns on the kill switches listed in the environment
+func applyKillSwitchEnv(sink skoap.AuditSink) error {
+	for _, name := range splitList(os.Getenv(killSwitchesEnv)) {
+		if err := skoap.SetKillSwitch(name, true); err != nil {
+			return err
+		}
+
+		skoap.AuditKillSwitch(sink, &skoap.AuditDoc{
+			KillSwitch: &skoap.KillSwitchDoc{
+				Name:   name,
+				On:     true,
+				Source: killSwitchesEnv}})
+	}
+
+	return nil
+}
+
+// tells whether the host of a listener address is the local host,
+// either as localhost or as a loopback IP. The other host names are not
+// resolved.
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requires the shared secret of the admin API as a bearer token
+func requireAdminToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serves the admin API on a separate listener, that should be
+// reachable only from the local host or the operators. When the token
+// is set, the clients need to send it as a bearer token. Listening
+// fails synchronously, serving in the background.
+func serveAdmin(address, token string, sink skoap.AuditSink, limits serverLimits) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return bindError{err}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/kill-switches/", skoap.NewKillSwitchHandler(sink))
+	mux.Handle("/memory", skoap.NewMemoryHandler())
+	mux.Handle("/degradation", skoap.NewDegradationHandler())
+	mux.Handle("/caches/", skoap.NewCacheHandler())
+	mux.Handle("/rejects", skoap.NewRejectCountsHandler())
+	var h http.Handler = mux
+	if token != "" {
+		h = requireAdminToken(token, mux)
+	}
+
+	go func() {
+		fatal(exitRuntime, limits.server(h).Serve(l))
+	}()
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/zalando-incubator/skoap"
+)
+
+func TestIsLoopbackAddress(t *testing.T) {
+	for _, ti := range []struct {
+		address  string
+		loopback bool
+	}{
+		{"localhost:9911", true},
+		{"127.0.0.1:9911", true},
+		{"[::1]:9911", true},
+		{":9911", false},
+		{"0.0.0.0:9911", false},
+		{"10.0.0.1:9911", false},
+		{"admin.example.org:9911", false},
+		{"localhost", false},
+	} {
+		if isLoopbackAddress(ti.address) != ti.loopback {
+			t.Error(ti.address, "invalid result")
+		}
+	}
+}
+
+func TestRequireAdminToken(t *testing.T) {
+	h := requireAdminToken("secret", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	for _, ti := range []struct {
+		msg    string
+		header string
+		status int
+	}{{
+		msg:    "missing",
+		status: http.StatusUnauthorized,
+	}, {
+		msg:    "invalid",
+		header: "Bearer other",
+		status: http.StatusUnauthorized,
+	}, {
+		msg:    "valid",
+		header: "Bearer secret",
+		status: http.StatusOK,
+	}} {
+		req := httptest.NewRequest("GET", "/kill-switches/", nil)
+		if ti.header != "" {
+			req.Header.Set("Authorization", ti.header)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != ti.status {
+			t.Error(ti.msg, "invalid status", w.Code)
+		}
+	}
+}
+
+func TestKillSwitchEnv(t *testing.T) {
+	defer os.Unsetenv(killSwitchesEnv)
+	defer skoap.SetKillSwitch(skoap.KillSwitchCaching, false)
+	defer skoap.SetKillSwitch(skoap.KillSwitchDryRun, false)
+
+	var audited []*skoap.AuditDoc
+	sink := skoap.AuditSinkFunc(func(d *skoap.AuditDoc) error {
+		audited = append(audited, d)
+		return nil
+	})
+
+	os.Setenv(killSwitchesEnv, "caching,dry-run")
+	if err := applyKillSwitchEnv(sink); err != nil {
+		t.Fatal(err)
+	}
+
+	if !skoap.KillSwitchOn(skoap.KillSwitchCaching) || !skoap.KillSwitchOn(skoap.KillSwitchDryRun) {
+		t.Error("failed to turn on the kill switches")
+	}
+
+	if len(audited) != 2 || audited[0].KillSwitch.Source != killSwitchesEnv {
+		t.Error("failed to audit the kill switches", audited)
+	}
+
+	os.Setenv(killSwitchesEnv, "unknown")
+	if err := applyKillSwitchEnv(sink); err == nil {
+		t.Error("failed to fail on unknown kill switch")
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/zalando-incubator/skoap"
+	"github.com/zalando/skipper/eskip"
+)
+
+const diffCommand = "diff"
+
+func loadRoutesFile(path string) ([]*eskip.Route, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return eskip.Parse(string(b))
+}
+
+// prints the security relevant changes between two routes files:
+//
+//	skoap diff old.eskip new.eskip
+func runDiff(args []string) {
+	if len(args) != 2 {
+		logUsage("the diff command expects two routes files: skoap diff old.eskip new.eskip")
+	}
+
+	oldRoutes, err := loadRoutesFile(args[0])
+	if err != nil {
+		logUsage(fmt.Sprintf("failed to load %s: %v", args[0], err))
+	}
+
+	newRoutes, err := loadRoutesFile(args[1])
+	if err != nil {
+		logUsage(fmt.Sprintf("failed to load %s: %v", args[1], err))
+	}
+
+	for _, c := range skoap.DiffAuth(oldRoutes, newRoutes) {
+		fmt.Fprintln(os.Stdout, c)
+	}
+}
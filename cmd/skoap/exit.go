@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log"
+	"os"
+)
+
+// exit codes, telling the supervisors and the scripts the category of
+// the failure
+const (
+	// fatal error while serving the requests
+	exitRuntime = 1
+
+	// invalid flags, or missing or invalid configuration files
+	exitConfig = 2
+
+	// failed to listen on one of the configured addresses
+	exitBind = 3
+
+	// failed to reach an upstream service required at startup, e.g. the
+	// syslog server
+	exitUpstream = 4
+)
+
+// bindError marks the failures of listening on an address
+type bindError struct {
+	err error
+}
+
+func (e bindError) Error() string { return e.err.Error() }
+
+// logs the error and exits with the code of its category
+func fatal(code int, err error) {
+	log.Println(err)
+	os.Exit(code)
+}
+
+// exits with the code of a run error, telling apart the bind errors
+func fatalRun(err error) {
+	if _, ok := err.(bindError); ok {
+		fatal(exitBind, err)
+	}
+
+	fatal(exitRuntime, err)
+}
@@ -8,16 +8,23 @@ For the list of command line options, run:
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/zalando-incubator/skoap"
 	"github.com/Sirupsen/logrus"
-	"github.com/zalando/skipper"
 	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/etcd"
 	"github.com/zalando/skipper/filters"
 	"github.com/zalando/skipper/filters/builtin"
 	"github.com/zalando/skipper/proxy"
@@ -28,23 +35,147 @@ const (
 	addressFlag        = "address"
 	targetAddressFlag  = "target-address"
 	preserveHeaderFlag = "preserve-header"
+	forwardAuthFlag    = "forward-auth"
 	realmFlag          = "realm"
 	scopesFlag         = "scopes"
 	teamsFlag          = "teams"
+	groupsFlag         = "groups"
 	auditFlag          = "audit-log"
 	auditBodyFlag      = "audit-log-limit"
 	routesFileFlag     = "routes-file"
+	routesDirFlag      = "routes-dir"
 	insecureFlag       = "insecure"
+	requireAuthFlag    = "require-auth"
+	publicRoutesFlag   = "public-routes"
+	ownersFileFlag     = "owners-file"
+	etcdUrlsFlag       = "etcd-urls"
+	etcdPrefixFlag     = "etcd-prefix"
+	inlineRoutesFlag   = "inline-routes"
+	kubernetesFlag     = "kubernetes"
+	kubeUrlFlag        = "kubernetes-url"
+	kubeClassFlag      = "kubernetes-ingress-class"
+	kubeNamespaceFlag  = "kubernetes-namespace"
+
+	auditFileFlag       = "audit-log-file"
+	auditMaxSizeFlag    = "audit-log-max-size"
+	auditMaxAgeFlag     = "audit-log-max-age"
+	auditMaxBackupsFlag = "audit-log-max-backups"
+	auditFormatFlag     = "audit-log-format"
+	auditSyslogFlag     = "audit-log-syslog"
+	auditUrlFlag        = "audit-log-url"
+	auditMaxBodyFlag    = "audit-max-body"
+	trustedProxiesFlag  = "trusted-proxies"
+	forwardedDepthFlag  = "forwarded-depth"
+	auditRedactFlag     = "audit-redact"
+	auditSaltFileFlag   = "audit-fingerprint-salt-file"
+	auditFieldsFlag     = "audit-fields"
+	auditSamplingFlag   = "audit-method-sampling"
 
 	defaultAddress     = ":9090"
 	authUrlBaseFlag    = "auth-url"
 	defaultAuthUrlBase = "http://[::1]:9081"
+	authFallbacksFlag  = "auth-url-fallbacks"
 
 	teamUrlBaseFlag    = "team-url"
 	defaultTeamUrlBase = "http://[::1]:9082/?uid="
 
-	tlsCertFlag = "tls-cert"
-	tlsKeyFlag  = "tls-key"
+	groupUrlBaseFlag    = "group-url"
+	defaultGroupUrlBase = "http://[::1]:9083/?uid="
+	groupIdFieldFlag    = "group-id-field"
+	teamIdFieldFlag     = "team-id-field"
+	githubTeamsFlag     = "github-teams-url"
+	uidFieldFlag        = "uid-field"
+	realmFieldFlag      = "realm-field"
+	scopeFieldFlag      = "scope-field"
+
+	tlsCertFlag    = "tls-cert"
+	tlsKeyFlag     = "tls-key"
+	tlsCertDirFlag = "tls-cert-dir"
+
+	tlsMinVersionFlag   = "tls-min-version"
+	tlsCipherSuitesFlag = "tls-cipher-suites"
+	tlsCurvesFlag       = "tls-curves"
+	ocspStaplingFlag    = "ocsp-stapling"
+	tlsClientCAFlag     = "tls-client-ca"
+	tlsRequireCertFlag  = "tls-require-client-cert"
+
+	acmeDomainsFlag     = "acme-domains"
+	acmeCacheDirFlag    = "acme-cache-dir"
+	acmeEmailFlag       = "acme-email"
+	acmeHTTPAddressFlag = "acme-http-address"
+	acmeDirectoryFlag   = "acme-directory-url"
+
+	readTimeoutFlag       = "read-timeout"
+	readHeaderTimeoutFlag = "read-header-timeout"
+	writeTimeoutFlag      = "write-timeout"
+	idleTimeoutFlag       = "idle-timeout"
+	maxHeaderBytesFlag    = "max-header-bytes"
+	maxConnectionsFlag    = "max-connections"
+
+	accessLogFlag       = "access-log"
+	accessLogFormatFlag = "access-log-format"
+
+	jsonErrorsFlag   = "json-errors"
+	tokenCookieFlag  = "token-cookie"
+	tokenQueryFlag   = "token-query-param"
+	dryRunFlag       = "dry-run"
+	preflightFlag    = "allow-preflight"
+	sessionKeyFlag   = "session-key-file"
+	sessionTTLFlag   = "session-ttl"
+	secureCookieFlag = "session-cookie-secure"
+	expiryLeewayFlag = "token-expiry-leeway"
+	tokenLengthFlag  = "max-token-length"
+	tokenFormatFlag  = "token-format"
+
+	claimsMappingFlag    = "claims-mapping"
+	pluginsFlag          = "plugins"
+	userInfoUrlFlag      = "userinfo-url"
+	userInfoClaimsFlag   = "userinfo-claims"
+	userInfoCacheTTLFlag = "userinfo-cache-ttl"
+
+	serviceTokenUrlFlag  = "service-token-url"
+	clientIdFlag         = "client-id"
+	clientSecretFileFlag = "client-secret-file"
+
+	teamServiceTokenFlag       = "team-service-token"
+	teamServiceTokenScopesFlag = "team-service-token-scopes"
+
+	enableFiltersFlag  = "enable-filters"
+	disableFiltersFlag = "disable-filters"
+
+	tokenReuseIPsFlag    = "token-reuse-ips"
+	tokenReuseWindowFlag = "token-reuse-window"
+
+	teamQuotasFlag       = "team-quotas"
+	teamQuotaPeriodFlag  = "team-quota-period"
+	teamQuotaReloadFlag  = "team-quota-reload"
+	teamQuotaWeightsFlag = "team-quota-method-weights"
+
+	oidcAuthorizationUrlFlag = "oidc-authorization-url"
+	oidcTokenUrlFlag         = "oidc-token-url"
+	oidcIssuerFlag           = "oidc-issuer"
+	oidcClientIdFlag         = "oidc-client-id"
+	oidcClientSecretFileFlag = "oidc-client-secret-file"
+	oidcRedirectUrlFlag      = "oidc-redirect-url"
+	oidcScopesFlag           = "oidc-scopes"
+	oidcSessionKeyFileFlag   = "oidc-session-key-file"
+
+	adminAddressFlag   = "admin-address"
+	adminTokenFileFlag = "admin-token-file"
+	memoryBudgetFlag   = "memory-budget"
+
+	supportListenerFlag = "support-listener"
+	enableProfilingFlag = "enable-profiling"
+	readinessWindowFlag = "readiness-window"
+
+	serviceRetriesFlag  = "service-retries"
+	serviceBackoffFlag  = "service-retry-backoff"
+	serviceDeadlineFlag = "service-deadline"
+	authDeadlineFlag    = "auth-deadline"
+	failurePolicyFlag   = "service-failure-policy"
+	retryAfterFlag      = "retry-after"
+
+	profileFlag = "profile"
 
 	verboseFlag = "v"
 
@@ -68,17 +199,26 @@ The command supports two modes:
 When used with eskip configuration files, it is possible to apply detailed augmentation of the requests and
 responses using Skipper rules.
 
+To review the security relevant changes between two versions of a routes file, e.g. routes gaining or losing
+auth filters, or changing the required scopes or teams, use the diff command:
+
+	skoap diff old.eskip new.eskip
+
 https://github.com/zalando/skipper
 
 `
 
-	addressUsage = `network address that skoap should listen on`
+	addressUsage = `network address that skoap should listen on. Unix domain sockets are set as unix:/path, or
+unix:@name in the abstract namespace`
 
 	targetAddressUsage = `when authenticating to a single network endpoint, set its address (without path) as
-the -target-address`
+the -target-address. Unix domain sockets are set as unix:/path, or unix:@name in the abstract namespace`
 
 	preserveHeaderUsage = `when forwarding requests, preserve the Authorization header in the outgoing request`
 
+	forwardAuthUsage = `when forwarding requests, set the X-Auth-User, X-Auth-Realm and X-Auth-Scopes headers of the
+outgoing request based on the validated token`
+
 	realmUsage = `when target address is used to specify the target endpoint, and the requests need to be
 authenticated against an OAuth2 realm, set the value of the realm with this flag. Note, that in case of a routes
 file is used, the realm can be set for each auth filter reference individually`
@@ -89,53 +229,464 @@ and the realm check`
 	teamsUsage = `a comma separated list of the teams to be checked in addition to the token validation and the
 realm check`
 
-	auditUsage = `enable audit log in single route mode`
+	groupsUsage = `a comma separated list of the groups to be checked in addition to the token validation and the
+realm check`
+
+	auditUsage = `enable audit log in single route mode. Setting the audit-log-file, audit-log-syslog or audit-log-url
+flags enables it, too`
 
 	auditBodyUsage = `set the limit of the audit log body`
 
+	auditFileUsage = `path of the file where the audit log is written. When not set, it is written to stderr`
+
+	auditMaxSizeUsage = `when greater than zero, the audit log file is rotated before it exceeds this size in megabytes`
+
+	auditMaxAgeUsage = `when greater than zero, the audit log file is rotated when it is older than this`
+
+	auditMaxBackupsUsage = `when greater than zero, the number of rotated audit log files kept`
+
+	auditFormatUsage = `format of the audit log entries written to stderr or to the audit log file: json, clf or logfmt`
+
+	auditMaxBodyUsage = `default byte limit of the request body captured by the auditLog filters of all routes, and in
+single route mode. The filters with an explicit limit argument override it. Negative values mean no limit`
+
+	trustedProxiesUsage = `a comma separated list of the networks or addresses of the proxies trusted to set the
+X-Forwarded-For header, e.g. 10.0.0.0/8. The remote address of the requests coming from them is taken from the header`
+
+	forwardedDepthUsage = `when greater than zero, the ipAllow and ipDeny filters and the token reuse detection take the
+client address from the X-Forwarded-For header at this depth from the right, e.g. 1 behind a single load balancer,
+instead of skipping the trusted proxies`
+
+	auditRedactUsage = `a comma separated list of regular expressions matching the names of the JSON fields or form
+parameters, whose values are replaced with [REDACTED] in the captured request bodies, headers and query, e.g.
+password,ssn,card.*`
+
+	auditSaltFileUsage = `path of a file containing a secret salt. When set, the audit log contains a fingerprint of the
+presented token, its HMAC-SHA256 keyed with the salt, to correlate the requests made with the same token without
+logging the token itself`
+
+	auditFieldsUsage = `a comma separated list of static fields added to every audit log entry, in the form of
+name=value, e.g. environment=production,datacenter=eu-1`
+
+	auditSamplingUsage = `a comma separated list of sample rates of the audit log entries by HTTP method, between 0 and
+1, e.g. HEAD:0,OPTIONS:0.01. Zero excludes the method. The methods not listed are always logged. Only the allowed
+requests are sampled, the rejected ones, and the ones with anomalies or a dry-run reject reason, are always logged`
+
+	auditUrlUsage = `url of an HTTP endpoint where the audit log is posted in batches, as JSON arrays`
+
+	auditSyslogUsage = `address of a syslog server where the audit log is sent in the RFC5424 format, e.g.
+tcp://syslog.example.org:514, udp://syslog.example.org:514 or unixgram:///dev/log. Set to local for the local syslog
+daemon`
+
 	routesFileUsage = `alternatively to the target address, it is possible to use a full eskip route
-configuration, and specify the auth() and authTeam() filters for the routes individually. See also:
-https://godoc.org/github.com/zalando/skipper/eskip`
+configuration, and specify the auth() and authTeam() filters for the routes individually. It can be set multiple
+times, and the routes of the files are merged. See also: https://godoc.org/github.com/zalando/skipper/eskip`
+
+	routesDirUsage = `a directory, where all the *.eskip files are loaded and merged, e.g. the route fragments owned by
+different teams. The route ids need to be unique across the files`
+
+	etcdUrlsUsage = `alternatively to the target address and the routes file, a comma separated list of etcd
+endpoints, where the eskip routes are stored and managed centrally, e.g. http://etcd-1:2379,http://etcd-2:2379`
+
+	etcdPrefixUsage = `the path prefix of the routes in etcd`
+
+	inlineRoutesUsage = `alternatively to the target address and the routes file, an eskip document with the routes,
+e.g. for test deployments and container entrypoints without a mounted routes file`
+
+	kubernetesUsage = `generate the routes from the Kubernetes Ingress objects, using the in-cluster API server and
+service account. The auth filters of the routes are set by the skoap.zalando.org/realm, skoap.zalando.org/scopes,
+skoap.zalando.org/teams and skoap.zalando.org/public annotations`
+
+	kubeUrlUsage = `the url of the Kubernetes API server, e.g. http://localhost:8001 with kubectl proxy. Implies the
+kubernetes flag, without the in-cluster settings`
+
+	kubeClassUsage = `when set, only the Kubernetes ingresses of this class are used`
+
+	kubeNamespaceUsage = `when set, only the Kubernetes ingresses of this namespace are used`
 
 	insecureUsage = `when this flag set, skipper will skip TLS verification`
 
+	requireAuthUsage = `when this flag is set, the routes file is rejected if it contains routes without an auth
+filter (auth, authAll, authTeam, authTeamAll, authScopeOrTeam or authGroup), except for the routes listed
+as public`
+
+	publicRoutesUsage = `a comma separated list of route ids that are allowed without an auth filter when the
+require-auth flag is set`
+
+	ownersFileUsage = `path of a JSON file containing an object, whose keys are route ids and values are the owners of
+the routes. The owners are printed in the audit log and used as metrics keys`
+
 	authUrlBaseUsage = `URL base of the authentication service. The authentication token found
 in the incoming requests will be validated agains this service. It will be passed as the Authorization Bearer
 header`
 
+	authFallbacksUsage = `comma separated list of additional URLs of the authentication service, e.g. in other regions.
+They are tried in order, when the previous one cannot be reached or responds with a server error`
+
 	teamUrlBaseUsage = `URL base of the team service. The user id received from the authentication service will
-be appended to this url, and the list of teams that the user is a member of will be requested`
+be appended to this url, or replace the {uid} placeholder, and the list of teams that the user is a member of will be
+requested`
+
+	groupUrlBaseUsage = `URL base of the group service. The user id received from the authentication service will
+be appended to this url, or replace the {uid} placeholder, and the list of groups that the user is a member of will
+be requested`
+
+	groupIdFieldUsage = `name of the field containing the group id in the items returned by the group service`
+
+	teamIdFieldUsage = `name of the field containing the team id in the items returned by the team service`
+
+	githubTeamsUsage = `URL of the GitHub API, e.g. https://api.github.com. When set, the teams of the users are resolved
+from GitHub instead of the team service, in the form of organization/team. It requires GitHub tokens with the read:org
+scope`
+
+	uidFieldUsage = `name of the field in the response of the authentication service that contains the user id, used
+for the team and group lookups and in the audit log, e.g. sub. With a custom field, the tokens without a user id are
+rejected`
+
+	realmFieldUsage = `name of the field in the response of the authentication service that contains the realm`
+
+	scopeFieldUsage = `name of the field in the response of the authentication service that contains the scopes, either
+as a JSON array or as a space separated string`
+
+	certPathTLSUsage = `path of the certificate file. Multiple certificates can be set as a comma separated list, and
+the certificate matching the SNI hostname of the client is served. The first one is the default`
+
+	keyPathTLSUsage = `path of the key. When multiple certificates are set, a comma separated list of the keys in
+the same order`
+
+	certDirTLSUsage = `directory containing certificate and key pairs, named as <name>.crt and <name>.key. The
+certificate matching the SNI hostname of the client is served`
+
+	tlsMinVersionUsage = `minimum TLS version accepted by the listener: 1.0, 1.1, 1.2 or 1.3`
+
+	tlsCipherSuitesUsage = `a comma separated list of the accepted cipher suites, e.g.
+TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Doesn't affect TLS 1.3`
+
+	tlsCurvesUsage = `a comma separated list of the elliptic curves in the order of preference: X25519, P256,
+P384 and P521`
+
+	ocspStaplingUsage = `when set, the OCSP responses of the listener certificates are fetched from the OCSP servers
+of the issuers and stapled to the TLS handshakes`
+
+	tlsClientCAUsage = `path of a PEM file with the CA certificates verifying the TLS client certificates. When set, the
+listener requests a client certificate, and the clientCert filter can authorize the requests based on it`
+
+	tlsRequireCertUsage = `when set, the listener rejects the TLS connections without a client certificate verified
+against the tls-client-ca certificates (mutual TLS)`
+
+	acmeDomainsUsage = `a comma separated list of domain names. When set, skoap obtains and renews the certificates
+for these domains automatically from an ACME provider (Let's Encrypt)`
+
+	acmeCacheDirUsage = `directory where the certificates obtained from the ACME provider are stored`
+
+	acmeEmailUsage = `contact email address used when registering at the ACME provider`
+
+	acmeHTTPAddressUsage = `network address to answer the ACME HTTP-01 challenges, e.g. :80. Other requests to this address
+are redirected to HTTPS. When not set, only the TLS-ALPN-01 challenges are answered on the main address. Requires the
+acme-domains flag`
+
+	acmeDirectoryUsage = `directory url of the ACME provider, e.g. the Let's Encrypt staging environment for testing.
+When empty, the Let's Encrypt production environment is used`
+
+	readTimeoutUsage = `the maximum duration of reading an incoming request, including the body. Zero means no limit`
+
+	readHeaderTimeoutUsage = `the maximum duration of reading the headers of an incoming request. Zero means no limit`
+
+	writeTimeoutUsage = `the maximum duration of writing the response, measured from the end of the request headers.
+Zero means no limit`
+
+	idleTimeoutUsage = `the maximum duration to wait for the next request on a keep-alive connection. Zero means that
+the read timeout is used`
+
+	maxHeaderBytesUsage = `the maximum size of the request headers, including the request line, in bytes`
+
+	maxConnectionsUsage = `when greater than zero, the maximum number of concurrent connections accepted by the listener.
+Further connections wait until one of the open ones is closed`
+
+	accessLogUsage = `when set, an access log entry is written to stderr for every request, containing the user
+authenticated or rejected by the auth filters of the route`
+
+	accessLogFormatUsage = `format of the access log entries: combined (Apache Combined Log Format) or json`
+
+	jsonErrorsUsage = `when set, the rejected requests are responded with a JSON body containing the reject reason`
+
+	dryRunUsage = `when set, the auth filters never reject the requests, and the would-be reject reasons are printed in
+the audit log. Individual filters can override it with the dryRun option`
+
+	preflightUsage = `when set, the auth filters let the CORS preflight requests through without token validation`
+
+	sessionKeyUsage = `path of a file containing a secret. When set, the auth filters issue an encrypted session cookie
+after a successful token validation, and accept it instead of validating the token again, until it expires`
+
+	sessionTTLUsage = `the lifetime of the session cookies issued by the auth filters`
+
+	secureCookieUsage = `when set, the session cookies of the auth filters are issued with the Secure attribute. Set it
+to false only for local development over plain HTTP`
+
+	expiryLeewayUsage = `the tolerated clock skew, when checking the expiry of the tokens returned by the token validation
+service in the exp or expires_in fields`
+
+	tokenLengthUsage = `the maximum length of the bearer tokens. The requests with longer tokens, and the ones with
+malformed or multiple Authorization headers, are rejected with 400`
+
+	tokenFormatUsage = `when set, the tokens are checked against this format before calling the token validation service,
+and the ones not matching it are rejected. It can be jwt, uuid, or a regular expression matching the whole token`
+
+	tokenCookieUsage = `name of a cookie that the token is taken from, when present, before falling back to the
+Authorization header`
+
+	tokenQueryUsage = `name of a query parameter that the token is taken from, when present, before falling back to the
+Authorization header. The parameter is removed from the forwarded request`
+
+	claimsMappingUsage = `a claims mapping in the form of target=expression, applied to every route with an auth filter,
+e.g. X-Email=lower(email). Can be set multiple times. See the mapClaims filter`
+
+	pluginsUsage = `a comma separated list of Go plugin files providing custom checks for the check filter. The
+plugins need to export a function: func Checks() map[string]skoap.Check`
+
+	userInfoUrlUsage = `url of an OIDC userinfo endpoint. When set, the selected claims of the authenticated users are
+forwarded in the X-Auth-Claim-<name> headers by the forwardAuth filter, and printed in the audit log`
+
+	userInfoClaimsUsage = `a comma separated list of the claims taken from the userinfo endpoint`
+
+	userInfoCacheTTLUsage = `the time while the claims of a token are cached`
+
+	serviceTokenUrlUsage = `url of the OAuth2 token endpoint, where the bearerToken filter obtains the service tokens
+with the client credentials flow, and the exchangeToken filter exchanges the incoming tokens`
+
+	clientIdUsage = `client id used to obtain the service tokens`
+
+	clientSecretFileUsage = `path of a file containing the client secret used to obtain the service tokens`
 
-	// TODO
-	certPathTLSUsage = "path of the certificate file"
-	keyPathTLSUsage  = "path of the key"
+	teamServiceTokenUsage = `when set, the team service is called with a service token obtained from the
+service-token-url with the client credentials flow, instead of the token of the user`
+
+	teamServiceTokenScopesUsage = `a comma separated list of the scopes requested for the team service token`
+
+	enableFiltersUsage = `a comma separated list of the skoap filters to register. When set, only the listed filters
+can be used in the routes`
+
+	disableFiltersUsage = `a comma separated list of the skoap filters that should not be registered, e.g. basicAuth`
+
+	tokenReuseIPsUsage = `when greater than zero, the use of the same token from more different client addresses than this
+value within the token reuse window is reported as an anomaly. The client addresses are taken from the
+X-Forwarded-For header the same way as by the ipAllow and ipDeny filters`
+
+	tokenReuseWindowUsage = `time window of the token reuse detection`
+
+	teamQuotasUsage = `path of a JSON file, or an http or https URL returning a JSON object, whose keys are team names and
+values are the max number of requests of the teams per quota period, enforced by the teamQuota filter. The limit of
+"*" applies to the teams without their own limit`
+
+	teamQuotaPeriodUsage = `the length of the quota periods of the teamQuota filter`
+
+	teamQuotaReloadUsage = `the interval of reloading the team quotas from the file or URL. When zero, the quotas are loaded
+only at startup, and changing them requires a restart. When the reload fails, the previous quotas are kept`
+
+	teamQuotaWeightsUsage = `a comma separated list of the weights of the requests counted against the team quotas by
+HTTP method, between 0 and 1, e.g. HEAD:0.1,OPTIONS:0. Zero excludes the method. The methods not listed count as 1`
+
+	oidcAuthorizationUrlUsage = `authorization endpoint of the OIDC identity provider, where the oidc filter redirects
+the browsers to log in. When set, the oidc-token-url, oidc-client-id, oidc-redirect-url and oidc-session-key-file
+flags are required, too`
+
+	oidcTokenUrlUsage = `token endpoint of the OIDC identity provider, where the oidc filter exchanges the
+authorization codes for access tokens`
+
+	oidcIssuerUsage = `issuer of the OIDC identity provider. When set, the iss claim of the ID tokens is checked`
+
+	oidcClientIdUsage = `client id of skoap registered at the OIDC identity provider`
+
+	oidcClientSecretFileUsage = `path of a file containing the client secret of skoap registered at the OIDC identity
+provider`
+
+	oidcRedirectUrlUsage = `absolute url of the OIDC callback, registered at the identity provider, and handled by the
+oidc filter, e.g. https://www.example.org/.skoap/callback`
+
+	oidcScopesUsage = `a comma separated list of the scopes requested by the oidc filter`
+
+	oidcSessionKeyFileUsage = `path of a file containing the secret used to encrypt the session cookies of the oidc
+filter`
+
+	adminAddressUsage = `network address of the admin API, e.g. localhost:9911. When set, the kill switches can be
+inspected with GET /kill-switches/ and changed with PUT /kill-switches/<name>?on=true|false. The kill switches turned
+on at startup can be listed in the SKOAP_KILL_SWITCHES environment variable. The footprint of the caches and
+buffers is returned by GET /memory, the cache statistics by GET /caches/, and the reject counters by GET /rejects. The
+tokens, or the cached tokens of a user, can be purged from the caches with POST /caches/purge and the token or uid
+form parameters, and also revoked with revoke=true. Only loopback addresses are accepted, unless the admin-token-file
+is set`
+
+	adminTokenFileUsage = `path of a file containing a shared secret, that the clients of the admin API need to send as
+a bearer token in the Authorization header. Required when the admin-address is not a loopback address`
+
+	supportListenerUsage = `network address of the health, readiness, metrics and profiling endpoints, e.g. :9912, to keep
+them off the proxy address. GET /healthz responds with 200 while the process is running, and GET /readyz when the
+routes are loaded and the token validation service was reached within the readiness window. The expvar metrics are
+served on /debug/vars`
+
+	enableProfilingUsage = `when set, the pprof profiling endpoints are served on the support listener, on /debug/pprof/`
+
+	readinessWindowUsage = `when the token validation service was not reached by the requests within this window, the
+readiness endpoint probes it`
+
+	memoryBudgetUsage = `when greater than zero, the memory budget of the caches and buffers in megabytes. When it is
+exceeded, the least recently used cache entries are evicted`
+
+	serviceRetriesUsage = `number of retries of the calls to the token validation, team and group services, failed with
+a network error or a server error`
+
+	serviceBackoffUsage = `the wait before the first retry of a failed service call, doubled for every subsequent one`
+
+	serviceDeadlineUsage = `when greater than zero, the overall deadline of a service call, including the retries`
+
+	authDeadlineUsage = `when greater than zero, the overall deadline of the service calls made by an auth filter for a
+request, the token validation and the team or group lookups`
+
+	failurePolicyUsage = `how the auth filters handle the requests when the authentication, team or group service cannot be
+reached: unavailable (reject with 503, the Retry-After header and the reject reason in the X-Auth-Error header),
+closed (reject with 401) or open-read-only (let the GET, HEAD and OPTIONS requests through without an identity, when
+the authentication service is down: after 5 failures in a row, or when it failed and didn't respond for 30s)`
+
+	retryAfterUsage = `the value of the Retry-After header, when the unavailable service failure policy is used`
+
+	profileUsage = `tuning profile presetting the cache, audit log, TLS and error response flags for a common deployment:
+edge-high-traffic, internal-low-latency or strict-compliance. The flags set on the command line override the
+values of the profile`
 
 	verboseUsage = `log level: Debug`
 
 	experimentalUpgradeUsage = "enable experimental feature to handle upgrade protocol requests"
 )
 
-type singleRouteClient eskip.Route
+type (
+	singleRouteClient  eskip.Route
+	inlineRoutesClient []*eskip.Route
+)
 
 var fs *flag.FlagSet
 
 var (
-	address             string
-	targetAddress       string
-	preserveHeader      bool
-	realm               string
-	scopes              string
-	teams               string
-	audit               bool
-	auditBody           int
-	routesFile          string
-	insecure            bool
-	authUrlBase         string
-	teamUrlBase         string
-	certPathTLS         string
-	keyPathTLS          string
-	verbose             bool
-	experimentalUpgrade bool
+	address              string
+	targetAddress        string
+	preserveHeader       bool
+	forwardAuth          bool
+	realm                string
+	scopes               string
+	teams                string
+	groups               string
+	audit                bool
+	auditBody            int
+	auditFile            string
+	auditMaxSize         int
+	auditMaxAge          time.Duration
+	auditMaxBackups      int
+	auditFormat          string
+	auditSyslog          string
+	auditUrl             string
+	auditMaxBody         int
+	trustedProxies       string
+	forwardedDepth       int
+	auditRedact          string
+	auditSaltFile        string
+	auditFields          string
+	auditSampling        string
+	routesFiles          stringList
+	routesDir            string
+	insecure             bool
+	requireAuth          bool
+	publicRoutes         string
+	ownersFile           string
+	etcdUrls             string
+	etcdPrefix           string
+	inlineRoutes         string
+	kubernetes           bool
+	kubeUrl              string
+	kubeClass            string
+	kubeNamespace        string
+	authUrlBase          string
+	authFallbacks        string
+	teamUrlBase          string
+	groupUrlBase         string
+	groupIdField         string
+	teamIdField          string
+	githubTeamsUrl       string
+	uidField             string
+	realmField           string
+	scopeField           string
+	certPathTLS          string
+	keyPathTLS           string
+	certDirTLS           string
+	tlsMinVersion        string
+	tlsCipherSuites      string
+	tlsCurves            string
+	tlsClientCA          string
+	tlsRequireCert       bool
+	ocspStapling         bool
+	acmeDomains          string
+	acmeCacheDir         string
+	acmeEmail            string
+	acmeHTTPAddress      string
+	acmeDirectory        string
+	readTimeout          time.Duration
+	readHeaderTimeout    time.Duration
+	writeTimeout         time.Duration
+	idleTimeout          time.Duration
+	maxHeaderBytes       int
+	maxConnections       int
+	accessLog            bool
+	accessLogFormat      string
+	jsonErrors           bool
+	dryRun               bool
+	allowPreflight       bool
+	sessionKeyFile       string
+	sessionTTL           time.Duration
+	secureCookie         bool
+	expiryLeeway         time.Duration
+	maxTokenLength       int
+	tokenFormat          string
+	tokenCookie          string
+	tokenQuery           string
+	claimsMapping        stringList
+	plugins              string
+	userInfoUrl          string
+	userInfoClaims       string
+	userInfoCacheTTL     time.Duration
+	serviceTokenUrl      string
+	clientId             string
+	clientSecretFile     string
+	teamServiceToken     bool
+	teamServiceScopes    string
+	enableFilters        string
+	disableFilters       string
+	tokenReuseIPs        int
+	tokenReuseWindow     time.Duration
+	adminAddress         string
+	adminTokenFile       string
+	supportListener      string
+	enableProfiling      bool
+	readinessWindow      time.Duration
+	memoryBudget         int
+	serviceRetries       int
+	serviceBackoff       time.Duration
+	serviceDeadline      time.Duration
+	authDeadline         time.Duration
+	failurePolicy        string
+	retryAfter           time.Duration
+	profile              string
+	teamQuotas           string
+	teamQuotaPeriod      time.Duration
+	teamQuotaReload      time.Duration
+	teamQuotaWeights     string
+	oidcAuthorizationUrl string
+	oidcTokenUrl         string
+	oidcIssuer           string
+	oidcClientId         string
+	oidcClientSecretFile string
+	oidcRedirectUrl      string
+	oidcScopes           string
+	oidcSessionKeyFile   string
+	verbose              bool
+	experimentalUpgrade  bool
 )
 
 func (src *singleRouteClient) LoadAll() ([]*eskip.Route, error) {
@@ -146,6 +697,14 @@ func (src *singleRouteClient) LoadUpdate() ([]*eskip.Route, []string, error) {
 	return nil, nil, nil
 }
 
+func (src inlineRoutesClient) LoadAll() ([]*eskip.Route, error) {
+	return src, nil
+}
+
+func (src inlineRoutesClient) LoadUpdate() ([]*eskip.Route, []string, error) {
+	return nil, nil, nil
+}
+
 func usage() {
 	fmt.Fprint(os.Stderr, usageHeader)
 	fs.PrintDefaults()
@@ -158,65 +717,472 @@ func init() {
 	fs.StringVar(&address, addressFlag, defaultAddress, addressUsage)
 	fs.StringVar(&targetAddress, targetAddressFlag, "", targetAddressUsage)
 	fs.BoolVar(&preserveHeader, preserveHeaderFlag, false, preserveHeaderUsage)
+	fs.BoolVar(&forwardAuth, forwardAuthFlag, false, forwardAuthUsage)
 	fs.StringVar(&realm, realmFlag, "", realmUsage)
 	fs.StringVar(&scopes, scopesFlag, "", scopesUsage)
 	fs.StringVar(&teams, teamsFlag, "", teamsUsage)
+	fs.StringVar(&groups, groupsFlag, "", groupsUsage)
 	fs.BoolVar(&audit, auditFlag, false, auditUsage)
 	fs.IntVar(&auditBody, auditBodyFlag, 1024, auditBodyUsage)
-	fs.StringVar(&routesFile, routesFileFlag, "", routesFileUsage)
+	fs.StringVar(&auditFile, auditFileFlag, "", auditFileUsage)
+	fs.IntVar(&auditMaxSize, auditMaxSizeFlag, 0, auditMaxSizeUsage)
+	fs.DurationVar(&auditMaxAge, auditMaxAgeFlag, 0, auditMaxAgeUsage)
+	fs.IntVar(&auditMaxBackups, auditMaxBackupsFlag, 0, auditMaxBackupsUsage)
+	fs.StringVar(&auditFormat, auditFormatFlag, "json", auditFormatUsage)
+	fs.StringVar(&auditSyslog, auditSyslogFlag, "", auditSyslogUsage)
+	fs.StringVar(&auditUrl, auditUrlFlag, "", auditUrlUsage)
+	fs.IntVar(&auditMaxBody, auditMaxBodyFlag, 0, auditMaxBodyUsage)
+	fs.StringVar(&trustedProxies, trustedProxiesFlag, "", trustedProxiesUsage)
+	fs.IntVar(&forwardedDepth, forwardedDepthFlag, 0, forwardedDepthUsage)
+	fs.StringVar(&auditRedact, auditRedactFlag, "", auditRedactUsage)
+	fs.StringVar(&auditSaltFile, auditSaltFileFlag, "", auditSaltFileUsage)
+	fs.StringVar(&auditFields, auditFieldsFlag, "", auditFieldsUsage)
+	fs.StringVar(&auditSampling, auditSamplingFlag, "", auditSamplingUsage)
+	fs.Var(&routesFiles, routesFileFlag, routesFileUsage)
+	fs.StringVar(&routesDir, routesDirFlag, "", routesDirUsage)
 	fs.BoolVar(&insecure, insecureFlag, false, insecureUsage)
+	fs.BoolVar(&requireAuth, requireAuthFlag, false, requireAuthUsage)
+	fs.StringVar(&publicRoutes, publicRoutesFlag, "", publicRoutesUsage)
+	fs.StringVar(&ownersFile, ownersFileFlag, "", ownersFileUsage)
+	fs.StringVar(&etcdUrls, etcdUrlsFlag, "", etcdUrlsUsage)
+	fs.StringVar(&etcdPrefix, etcdPrefixFlag, "/skipper", etcdPrefixUsage)
+	fs.StringVar(&inlineRoutes, inlineRoutesFlag, "", inlineRoutesUsage)
+	fs.BoolVar(&kubernetes, kubernetesFlag, false, kubernetesUsage)
+	fs.StringVar(&kubeUrl, kubeUrlFlag, "", kubeUrlUsage)
+	fs.StringVar(&kubeClass, kubeClassFlag, "", kubeClassUsage)
+	fs.StringVar(&kubeNamespace, kubeNamespaceFlag, "", kubeNamespaceUsage)
 	fs.StringVar(&authUrlBase, authUrlBaseFlag, "", authUrlBaseUsage)
+	fs.StringVar(&authFallbacks, authFallbacksFlag, "", authFallbacksUsage)
 	fs.StringVar(&teamUrlBase, teamUrlBaseFlag, "", teamUrlBaseUsage)
+	fs.StringVar(&groupUrlBase, groupUrlBaseFlag, "", groupUrlBaseUsage)
+	fs.StringVar(&groupIdField, groupIdFieldFlag, "id", groupIdFieldUsage)
+	fs.StringVar(&teamIdField, teamIdFieldFlag, "id", teamIdFieldUsage)
+	fs.StringVar(&githubTeamsUrl, githubTeamsFlag, "", githubTeamsUsage)
+	fs.StringVar(&uidField, uidFieldFlag, "uid", uidFieldUsage)
+	fs.StringVar(&realmField, realmFieldFlag, "realm", realmFieldUsage)
+	fs.StringVar(&scopeField, scopeFieldFlag, "scope", scopeFieldUsage)
 	fs.StringVar(&certPathTLS, tlsCertFlag, "", certPathTLSUsage)
 	fs.StringVar(&keyPathTLS, tlsKeyFlag, "", keyPathTLSUsage)
+	fs.StringVar(&certDirTLS, tlsCertDirFlag, "", certDirTLSUsage)
+	fs.StringVar(&tlsMinVersion, tlsMinVersionFlag, "", tlsMinVersionUsage)
+	fs.StringVar(&tlsCipherSuites, tlsCipherSuitesFlag, "", tlsCipherSuitesUsage)
+	fs.StringVar(&tlsCurves, tlsCurvesFlag, "", tlsCurvesUsage)
+	fs.StringVar(&tlsClientCA, tlsClientCAFlag, "", tlsClientCAUsage)
+	fs.BoolVar(&tlsRequireCert, tlsRequireCertFlag, false, tlsRequireCertUsage)
+	fs.BoolVar(&ocspStapling, ocspStaplingFlag, false, ocspStaplingUsage)
+	fs.StringVar(&acmeDomains, acmeDomainsFlag, "", acmeDomainsUsage)
+	fs.StringVar(&acmeCacheDir, acmeCacheDirFlag, "", acmeCacheDirUsage)
+	fs.StringVar(&acmeEmail, acmeEmailFlag, "", acmeEmailUsage)
+	fs.StringVar(&acmeHTTPAddress, acmeHTTPAddressFlag, "", acmeHTTPAddressUsage)
+	fs.StringVar(&acmeDirectory, acmeDirectoryFlag, "", acmeDirectoryUsage)
+	fs.DurationVar(&readTimeout, readTimeoutFlag, 5*time.Minute, readTimeoutUsage)
+	fs.DurationVar(&readHeaderTimeout, readHeaderTimeoutFlag, time.Minute, readHeaderTimeoutUsage)
+	fs.DurationVar(&writeTimeout, writeTimeoutFlag, 0, writeTimeoutUsage)
+	fs.DurationVar(&idleTimeout, idleTimeoutFlag, time.Minute, idleTimeoutUsage)
+	fs.IntVar(&maxHeaderBytes, maxHeaderBytesFlag, http.DefaultMaxHeaderBytes, maxHeaderBytesUsage)
+	fs.IntVar(&maxConnections, maxConnectionsFlag, 0, maxConnectionsUsage)
+	fs.BoolVar(&accessLog, accessLogFlag, false, accessLogUsage)
+	fs.StringVar(&accessLogFormat, accessLogFormatFlag, string(skoap.AccessLogCombined), accessLogFormatUsage)
+	fs.BoolVar(&jsonErrors, jsonErrorsFlag, false, jsonErrorsUsage)
+	fs.BoolVar(&dryRun, dryRunFlag, false, dryRunUsage)
+	fs.BoolVar(&allowPreflight, preflightFlag, false, preflightUsage)
+	fs.StringVar(&sessionKeyFile, sessionKeyFlag, "", sessionKeyUsage)
+	fs.DurationVar(&sessionTTL, sessionTTLFlag, 5*time.Minute, sessionTTLUsage)
+	fs.BoolVar(&secureCookie, secureCookieFlag, true, secureCookieUsage)
+	fs.DurationVar(&expiryLeeway, expiryLeewayFlag, 30*time.Second, expiryLeewayUsage)
+	fs.IntVar(&maxTokenLength, tokenLengthFlag, 8192, tokenLengthUsage)
+	fs.StringVar(&tokenFormat, tokenFormatFlag, "", tokenFormatUsage)
+	fs.StringVar(&tokenCookie, tokenCookieFlag, "", tokenCookieUsage)
+	fs.StringVar(&tokenQuery, tokenQueryFlag, "", tokenQueryUsage)
+	fs.Var(&claimsMapping, claimsMappingFlag, claimsMappingUsage)
+	fs.StringVar(&plugins, pluginsFlag, "", pluginsUsage)
+	fs.StringVar(&userInfoUrl, userInfoUrlFlag, "", userInfoUrlUsage)
+	fs.StringVar(&userInfoClaims, userInfoClaimsFlag, "email,name", userInfoClaimsUsage)
+	fs.DurationVar(&userInfoCacheTTL, userInfoCacheTTLFlag, 5*time.Minute, userInfoCacheTTLUsage)
+	fs.StringVar(&serviceTokenUrl, serviceTokenUrlFlag, "", serviceTokenUrlUsage)
+	fs.StringVar(&clientId, clientIdFlag, "", clientIdUsage)
+	fs.StringVar(&clientSecretFile, clientSecretFileFlag, "", clientSecretFileUsage)
+	fs.BoolVar(&teamServiceToken, teamServiceTokenFlag, false, teamServiceTokenUsage)
+	fs.StringVar(&teamServiceScopes, teamServiceTokenScopesFlag, "", teamServiceTokenScopesUsage)
+	fs.StringVar(&enableFilters, enableFiltersFlag, "", enableFiltersUsage)
+	fs.StringVar(&disableFilters, disableFiltersFlag, "", disableFiltersUsage)
+	fs.IntVar(&tokenReuseIPs, tokenReuseIPsFlag, 0, tokenReuseIPsUsage)
+	fs.DurationVar(&tokenReuseWindow, tokenReuseWindowFlag, time.Minute, tokenReuseWindowUsage)
+	fs.StringVar(&teamQuotas, teamQuotasFlag, "", teamQuotasUsage)
+	fs.DurationVar(&teamQuotaPeriod, teamQuotaPeriodFlag, time.Hour, teamQuotaPeriodUsage)
+	fs.DurationVar(&teamQuotaReload, teamQuotaReloadFlag, 5*time.Minute, teamQuotaReloadUsage)
+	fs.StringVar(&teamQuotaWeights, teamQuotaWeightsFlag, "", teamQuotaWeightsUsage)
+	fs.StringVar(&oidcAuthorizationUrl, oidcAuthorizationUrlFlag, "", oidcAuthorizationUrlUsage)
+	fs.StringVar(&oidcTokenUrl, oidcTokenUrlFlag, "", oidcTokenUrlUsage)
+	fs.StringVar(&oidcIssuer, oidcIssuerFlag, "", oidcIssuerUsage)
+	fs.StringVar(&oidcClientId, oidcClientIdFlag, "", oidcClientIdUsage)
+	fs.StringVar(&oidcClientSecretFile, oidcClientSecretFileFlag, "", oidcClientSecretFileUsage)
+	fs.StringVar(&oidcRedirectUrl, oidcRedirectUrlFlag, "", oidcRedirectUrlUsage)
+	fs.StringVar(&oidcScopes, oidcScopesFlag, "openid", oidcScopesUsage)
+	fs.StringVar(&oidcSessionKeyFile, oidcSessionKeyFileFlag, "", oidcSessionKeyFileUsage)
+	fs.StringVar(&adminAddress, adminAddressFlag, "", adminAddressUsage)
+	fs.StringVar(&adminTokenFile, adminTokenFileFlag, "", adminTokenFileUsage)
+	fs.StringVar(&supportListener, supportListenerFlag, "", supportListenerUsage)
+	fs.BoolVar(&enableProfiling, enableProfilingFlag, false, enableProfilingUsage)
+	fs.DurationVar(&readinessWindow, readinessWindowFlag, 30*time.Second, readinessWindowUsage)
+	fs.IntVar(&memoryBudget, memoryBudgetFlag, 0, memoryBudgetUsage)
+	fs.IntVar(&serviceRetries, serviceRetriesFlag, 0, serviceRetriesUsage)
+	fs.DurationVar(&serviceBackoff, serviceBackoffFlag, 100*time.Millisecond, serviceBackoffUsage)
+	fs.DurationVar(&serviceDeadline, serviceDeadlineFlag, 0, serviceDeadlineUsage)
+	fs.DurationVar(&authDeadline, authDeadlineFlag, 0, authDeadlineUsage)
+	fs.StringVar(&failurePolicy, failurePolicyFlag, string(skoap.FailUnavailable), failurePolicyUsage)
+	fs.DurationVar(&retryAfter, retryAfterFlag, 30*time.Second, retryAfterUsage)
+	fs.StringVar(&profile, profileFlag, "", profileUsage)
 	fs.BoolVar(&verbose, verboseFlag, false, verboseUsage)
 	fs.BoolVar(&experimentalUpgrade, experimentalUpgradeFlag, false, experimentalUpgradeUsage)
+}
 
+// parses the command line, and applies the profile. It is called from
+// main, and not from init, so that the tests of the command don't parse
+// the flags of the test binary.
+func parseFlags() {
 	err := fs.Parse(os.Args[1:])
 	if err != nil {
 		if err == flag.ErrHelp {
 			os.Exit(0)
 		}
 
-		os.Exit(-1)
+		os.Exit(exitConfig)
+	}
+
+	if profile != "" {
+		if err := applyProfile(profile); err != nil {
+			logUsage(err.Error())
+		}
 	}
 }
 
 func logUsage(message string) {
 	fmt.Fprintf(os.Stderr, "%s\n", message)
-	os.Exit(-1)
+	os.Exit(exitConfig)
+}
+
+// tells whether a flag was set on the command line
+func isFlagSet(name string) bool {
+	var set bool
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+func splitList(l string) []string {
+	if l == "" {
+		return nil
+	}
+
+	return strings.Split(l, ",")
+}
+
+// selects the filters to be registered based on the enable-filters and
+// disable-filters flags
+func selectFilters(specs []filters.Spec, enable, disable []string) ([]filters.Spec, error) {
+	known := make(map[string]bool)
+	for _, s := range specs {
+		known[s.Name()] = true
+	}
+
+	for _, n := range append(enable, disable...) {
+		if !known[n] {
+			return nil, fmt.Errorf("unknown filter: %s", n)
+		}
+	}
+
+	contains := func(names []string, name string) bool {
+		for _, n := range names {
+			if n == name {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	var selected []filters.Spec
+	for _, s := range specs {
+		if len(enable) > 0 && !contains(enable, s.Name()) || contains(disable, s.Name()) {
+			continue
+		}
+
+		selected = append(selected, s)
+	}
+
+	return selected, nil
+}
+
+func loadOwners(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var owners map[string]string
+	err = json.Unmarshal(b, &owners)
+	return owners, err
+}
+
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// the timeout of loading the team quotas from a URL, so that an
+// unresponsive endpoint cannot block the startup or the reloads
+const teamQuotasTimeout = 10 * time.Second
+
+var teamQuotasClient = &http.Client{Timeout: teamQuotasTimeout}
+
+// loads the team quotas from a file, or from an http or https URL
+func loadTeamQuotas(location string) (map[string]int, error) {
+	var (
+		b   []byte
+		err error
+	)
+
+	if isURL(location) {
+		var rsp *http.Response
+		rsp, err = teamQuotasClient.Get(location)
+		if err != nil {
+			return nil, err
+		}
+
+		defer rsp.Body.Close()
+		if rsp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to load team quotas: %s", rsp.Status)
+		}
+
+		b, err = ioutil.ReadAll(rsp.Body)
+	} else {
+		b, err = ioutil.ReadFile(location)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var quotas map[string]int
+	err = json.Unmarshal(b, &quotas)
+	return quotas, err
+}
+
+// parses a list of name=value pairs
+func parseFields(l []string) (map[string]string, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	fields := make(map[string]string)
+	for _, f := range l {
+		nv := strings.SplitN(f, "=", 2)
+		if len(nv) != 2 || nv[0] == "" {
+			return nil, fmt.Errorf("invalid field: %s", f)
+		}
+
+		fields[nv[0]] = nv[1]
+	}
+
+	return fields, nil
+}
+
+// parses the syslog address in the form of network://address, or
+// local for the local syslog daemon
+func parseSyslogAddress(a string) (string, string, error) {
+	if a == "local" {
+		return "", "", nil
+	}
+
+	u, err := url.Parse(a)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch u.Scheme {
+	case "tcp", "udp":
+		return u.Scheme, u.Host, nil
+	case "unix", "unixgram":
+		return u.Scheme, u.Path, nil
+	default:
+		return "", "", fmt.Errorf("invalid syslog address: %s", a)
+	}
 }
 
 func main() {
+	parseFlags()
 	if verbose {
 		logrus.SetLevel(logrus.DebugLevel)
 	} else {
 		logrus.SetLevel(logrus.WarnLevel)
 	}
 
-	if targetAddress == "" && routesFile == "" {
-		logUsage("either the target address or a routes file needs to be specified")
+	if fs.NArg() > 0 {
+		if fs.Arg(0) != diffCommand {
+			logUsage(fmt.Sprintf("unknown command: %s", fs.Arg(0)))
+		}
+
+		runDiff(fs.Args()[1:])
+		return
+	}
+
+	var routeSources int
+	for _, set := range []bool{
+		targetAddress != "",
+		len(routesFiles) > 0 || routesDir != "",
+		etcdUrls != "",
+		inlineRoutes != "",
+		kubernetes || kubeUrl != "",
+	} {
+		if set {
+			routeSources++
+		}
+	}
+
+	if routeSources == 0 {
+		logUsage("either the target address, a routes file, the etcd urls, inline routes or kubernetes need to be specified")
 	}
 
-	if targetAddress != "" && routesFile != "" {
-		logUsage("cannot set both the target address and a routes file")
+	if routeSources > 1 {
+		logUsage("only one of the target address, the routes file, the etcd urls, the inline routes and kubernetes can be set")
 	}
 
 	singleRouteMode := targetAddress != ""
 
-	if !singleRouteMode && (preserveHeader || realm != "" || scopes != "" || teams != "" || audit || auditBody != 1024) {
-		logUsage("the preserve-header, realm, scopes, teams, audit-log and audit-log-limit flags can be used only together with the target-address flag (single route mode)")
+	if !singleRouteMode && (preserveHeader || forwardAuth || realm != "" || scopes != "" || teams != "" || groups != "" || audit || auditBody != 1024) {
+		logUsage("the preserve-header, forward-auth, realm, scopes, teams, groups, audit-log and audit-log-limit flags can be used only together with the target-address flag (single route mode)")
+	}
+
+	if singleRouteMode && (requireAuth || publicRoutes != "" || ownersFile != "") {
+		logUsage("the require-auth, public-routes and owners-file flags cannot be used together with the target-address flag (single route mode)")
+	}
+
+	if publicRoutes != "" && !requireAuth {
+		logUsage("the public-routes flag can be set only together with the require-auth flag")
+	}
+
+	// in single route mode, selecting an audit sink enables the audit
+	// log, too
+	if singleRouteMode && (auditFile != "" || auditSyslog != "" || auditUrl != "") {
+		audit = true
 	}
 
 	if !audit && auditBody != 1024 {
 		logUsage("the audit-log-limit flag can be set only together with the audit-log flag")
 	}
 
-	if scopes != "" && teams != "" {
-		logUsage("the scopes and teams flags cannot be used together")
+	if auditFile != "" && auditSyslog != "" || auditFile != "" && auditUrl != "" || auditSyslog != "" && auditUrl != "" {
+		logUsage("only one of the audit-log-file, audit-log-syslog and audit-log-url flags can be used")
+	}
+
+	if auditFile == "" && (auditMaxSize != 0 || auditMaxAge != 0 || auditMaxBackups != 0) {
+		logUsage("the audit-log-max-size, audit-log-max-age and audit-log-max-backups flags can be set only together with the audit-log-file flag")
+	}
+
+	if enableFilters != "" && disableFilters != "" {
+		logUsage("the enable-filters and disable-filters flags cannot be used together")
+	}
+
+	if acmeDomains != "" && (certPathTLS != "" || keyPathTLS != "" || certDirTLS != "") {
+		logUsage("the acme-domains flag cannot be used together with the tls-cert, tls-key and tls-cert-dir flags")
+	}
+
+	if ocspStapling && certPathTLS == "" && certDirTLS == "" {
+		logUsage("the ocsp-stapling flag can be set only together with the tls-cert or tls-cert-dir flags")
+	}
+
+	if tlsClientCA != "" && certPathTLS == "" && certDirTLS == "" && acmeDomains == "" {
+		logUsage("the tls-client-ca flag can be set only together with the tls-cert, tls-cert-dir or acme-domains flags")
+	}
+
+	if tlsRequireCert && tlsClientCA == "" {
+		logUsage("the tls-require-client-cert flag can be set only together with the tls-client-ca flag")
+	}
+
+	if len(splitList(certPathTLS)) != len(splitList(keyPathTLS)) {
+		logUsage("the tls-cert and tls-key flags need to contain the same number of files")
+	}
+
+	if err := skoap.ValidateClaimsMappings(claimsMapping...); err != nil {
+		logUsage(err.Error())
+	}
+
+	if adminAddress != "" && adminTokenFile == "" && !isLoopbackAddress(adminAddress) {
+		logUsage("the admin-address flag can be set to a non-loopback address only together with the admin-token-file flag")
+	}
+
+	if adminTokenFile != "" && adminAddress == "" {
+		logUsage("the admin-token-file flag can be set only together with the admin-address flag")
+	}
+
+	if (clientId != "" || clientSecretFile != "") && serviceTokenUrl == "" {
+		logUsage("the client-id and client-secret-file flags can be set only together with the service-token-url flag")
+	}
+
+	if teamServiceToken && serviceTokenUrl == "" {
+		logUsage("the team-service-token flag can be set only together with the service-token-url flag")
+	}
+
+	if teamServiceScopes != "" && !teamServiceToken {
+		logUsage("the team-service-token-scopes flag can be set only together with the team-service-token flag")
+	}
+
+	if acmeDomains != "" && acmeCacheDir == "" {
+		logUsage("the acme-cache-dir flag needs to be set when using the acme-domains flag")
+	}
+
+	if acmeHTTPAddress != "" && acmeDomains == "" {
+		logUsage("the acme-http-address flag requires the acme-domains flag")
+	}
+
+	if acmeHTTPAddress != "" {
+		if _, _, err := net.SplitHostPort(acmeHTTPAddress); err != nil || acmeHTTPAddress == address {
+			logUsage(fmt.Sprintf("the acme-http-address needs to be a host:port other than the address: %s", acmeHTTPAddress))
+		}
+	}
+
+	if acmeDirectory != "" && acmeDomains == "" {
+		logUsage("the acme-directory-url flag can be set only together with the acme-domains flag")
+	}
+
+	if enableProfiling && supportListener == "" {
+		logUsage("the enable-profiling flag can be set only together with the support-listener flag")
+	}
+
+	if readTimeout < 0 || readHeaderTimeout < 0 || writeTimeout < 0 || idleTimeout < 0 {
+		logUsage("the server timeouts cannot be negative")
+	}
+
+	if maxHeaderBytes <= 0 {
+		logUsage("the max-header-bytes flag needs to be greater than zero")
+	}
+
+	if maxConnections < 0 {
+		logUsage("the max-connections flag cannot be negative")
+	}
+
+	switch skoap.ServiceFailurePolicy(failurePolicy) {
+	case skoap.FailClosed, skoap.FailOpenReadOnly, skoap.FailUnavailable:
+	default:
+		logUsage("invalid service failure policy: " + failurePolicy)
+	}
+
+	if tokenFormat != "" && tokenFormat != skoap.TokenFormatJWT && tokenFormat != skoap.TokenFormatUUID {
+		if _, err := regexp.Compile(tokenFormat); err != nil {
+			logUsage("invalid token format: " + err.Error())
+		}
+	}
+
+	if scopes != "" && teams != "" || scopes != "" && groups != "" || teams != "" && groups != "" {
+		logUsage("only one of the scopes, teams and groups flags can be used")
 	}
 
 	teamCheckMode := teams != ""
+	groupCheckMode := groups != ""
 
 	if authUrlBase == "" {
 		authUrlBase = defaultAuthUrlBase
@@ -226,26 +1192,388 @@ func main() {
 		teamUrlBase = defaultTeamUrlBase
 	}
 
-	o := skipper.Options{
-		Address: address,
-		CustomFilters: []filters.Spec{
-			skoap.NewAuth(authUrlBase),
-			skoap.NewAuthTeam(authUrlBase, teamUrlBase),
-			skoap.NewBasicAuth(),
-			skoap.NewAuditLog(os.Stderr)},
-		AccessLogDisabled:   true,
-		ProxyOptions:        proxy.OptionsPreserveOriginal,
-		CertPathTLS:         certPathTLS,
-		KeyPathTLS:          keyPathTLS,
-		ExperimentalUpgrade: experimentalUpgrade,
+	if groupUrlBase == "" {
+		groupUrlBase = defaultGroupUrlBase
+	}
+
+	trustedNetworks, err := skoap.ParseNetworks(splitList(trustedProxies))
+	if err != nil {
+		logUsage(err.Error())
+	}
+
+	authOptions := skoap.Options{
+		AuthUrlBase:      authUrlBase,
+		AuthUrlFallbacks: splitList(authFallbacks),
+		TeamUrlBase:      teamUrlBase,
+		GroupUrlBase:     groupUrlBase,
+		GroupIdField:     groupIdField,
+		TeamIdField:      teamIdField,
+		UidField:         uidField,
+		RealmField:       realmField,
+		ScopeField:       scopeField,
+		JSONErrors:       jsonErrors,
+		DryRun:           dryRun,
+		AllowPreflight:   allowPreflight,
+		SessionTTL:       sessionTTL,
+		ExpiryLeeway:     expiryLeeway,
+		MaxTokenLength:   maxTokenLength,
+		TokenFormat:      tokenFormat,
+		AuthDeadline:     authDeadline,
+		RetryAfter:       retryAfter,
+		TokenReuseIPs:    tokenReuseIPs,
+		TokenReuseWindow: tokenReuseWindow,
+		TrustedProxies:   trustedNetworks,
+		ForwardedDepth:   forwardedDepth,
+		TokenCookie:      tokenCookie,
+		TokenQueryParam:  tokenQuery,
+		UserInfoUrl:      userInfoUrl,
+		UserInfoClaims:   splitList(userInfoClaims),
+		UserInfoCacheTTL: userInfoCacheTTL}
+
+	if githubTeamsUrl != "" {
+		authOptions.TeamSource = skoap.NewGitHubTeamSource(githubTeamsUrl)
+	}
+
+	authOptions.ServiceFailurePolicy = skoap.ServiceFailurePolicy(failurePolicy)
+	authOptions.SessionCookieInsecure = !secureCookie
+
+	if sessionKeyFile != "" {
+		key, err := ioutil.ReadFile(sessionKeyFile)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+
+		authOptions.SessionKey = key
+	}
+
+	serviceTokenOptions := skoap.ServiceTokenOptions{
+		TokenUrl: serviceTokenUrl,
+		ClientId: clientId}
+	if clientSecretFile != "" {
+		secret, err := ioutil.ReadFile(clientSecretFile)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+
+		serviceTokenOptions.ClientSecret = strings.TrimSpace(string(secret))
+	}
+
+	if teamServiceToken {
+		authOptions.TeamServiceToken = serviceTokenOptions
+		authOptions.TeamServiceTokenScopes = splitList(teamServiceScopes)
+
+		// the team client is created once, so that all the filters
+		// and predicates share the same service token
+		if authOptions.TeamSource == nil {
+			authOptions.TeamSource = skoap.NewTeamServiceSource(authOptions)
+		}
+	}
+
+	if oidcAuthorizationUrl != "" &&
+		(oidcTokenUrl == "" || oidcClientId == "" || oidcRedirectUrl == "" || oidcSessionKeyFile == "") {
+		logUsage("the oidc-authorization-url flag requires the oidc-token-url, oidc-client-id, oidc-redirect-url and oidc-session-key-file flags")
+	}
+
+	oidcOptions := skoap.OIDCOptions{
+		AuthorizationUrl: oidcAuthorizationUrl,
+		TokenUrl:         oidcTokenUrl,
+		Issuer:           oidcIssuer,
+		ClientId:         oidcClientId,
+		RedirectUrl:      oidcRedirectUrl,
+		Scopes:           splitList(oidcScopes),
+		JSONErrors:       jsonErrors}
+	if oidcClientSecretFile != "" {
+		secret, err := ioutil.ReadFile(oidcClientSecretFile)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+
+		oidcOptions.ClientSecret = strings.TrimSpace(string(secret))
+	}
+
+	if oidcSessionKeyFile != "" {
+		key, err := ioutil.ReadFile(oidcSessionKeyFile)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+
+		oidcOptions.SessionKey = key
+	}
+
+	accessFormat, err := skoap.ParseAccessLogFormat(accessLogFormat)
+	if err != nil {
+		logUsage(err.Error())
+	}
+
+	format, err := skoap.ParseAuditFormat(auditFormat)
+	if err != nil {
+		logUsage(err.Error())
+	}
+
+	if format != skoap.AuditFormatJSON && (auditSyslog != "" || auditUrl != "") {
+		logUsage("the audit-log-format flag cannot be used together with the audit-log-syslog and audit-log-url flags")
+	}
+
+	auditSink := skoap.NewWriterSinkWithFormat(os.Stderr, format)
+	if auditFile != "" {
+		auditSink, err = skoap.NewRotatingFileSink(skoap.RotationOptions{
+			Path:       auditFile,
+			MaxSize:    int64(auditMaxSize) << 20,
+			MaxAge:     auditMaxAge,
+			MaxBackups: auditMaxBackups,
+			Format:     format})
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+
+	if auditSyslog != "" {
+		network, raddr, err := parseSyslogAddress(auditSyslog)
+		if err != nil {
+			logUsage(err.Error())
+		}
+
+		auditSink, err = skoap.NewSyslogSink(network, raddr, "skoap")
+		if err != nil {
+			fatal(exitUpstream, err)
+		}
+	}
+
+	for _, p := range splitList(auditRedact) {
+		if _, err := regexp.Compile(p); err != nil {
+			logUsage(fmt.Sprintf("invalid audit-redact expression: %v", err))
+		}
+	}
+
+	var auditSalt []byte
+	if auditSaltFile != "" {
+		salt, err := ioutil.ReadFile(auditSaltFile)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+
+		auditSalt = []byte(strings.TrimSpace(string(salt)))
+		if len(auditSalt) == 0 {
+			logUsage("the audit fingerprint salt file is empty")
+		}
+	}
+
+	staticFields, err := parseFields(splitList(auditFields))
+	if err != nil {
+		logUsage(err.Error())
+	}
+
+	sampleRates, err := skoap.ParseMethodSampleRates(splitList(auditSampling))
+	if err != nil {
+		logUsage(err.Error())
+	}
+
+	checks, err := loadChecks(splitList(plugins))
+	if err != nil {
+		fatal(exitConfig, err)
+	}
+
+	ipFilterOptions := skoap.IPFilterOptions{
+		TrustedProxies: trustedNetworks,
+		ForwardedDepth: forwardedDepth,
+		JSONErrors:     jsonErrors}
+
+	quotaWeights, err := skoap.ParseMethodWeights(splitList(teamQuotaWeights))
+	if err != nil {
+		logUsage(err.Error())
+	}
+
+	var (
+		quotas     map[string]int
+		loadQuotas func() (map[string]int, error)
+	)
+
+	if teamQuotas != "" {
+		loadQuotas = func() (map[string]int, error) { return loadTeamQuotas(teamQuotas) }
+		quotas, err = loadTeamQuotas(teamQuotas)
+		if err != nil && isURL(teamQuotas) {
+			fatal(exitUpstream, err)
+		} else if err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+
+	if auditUrl != "" {
+		auditSink = skoap.NewBatchingWebhookSink(skoap.BatchOptions{Url: auditUrl})
+	}
+
+	skoap.SetMemoryBudget(int64(memoryBudget) << 20)
+	skoap.SetServiceRetries(skoap.RetryOptions{
+		Retries:  serviceRetries,
+		Backoff:  serviceBackoff,
+		Deadline: serviceDeadline})
+
+	if err := applyKillSwitchEnv(auditSink); err != nil {
+		logUsage(err.Error())
+	}
+
+	// the same timeouts apply to all the listeners
+	limits := serverLimits{
+		readTimeout:       readTimeout,
+		readHeaderTimeout: readHeaderTimeout,
+		writeTimeout:      writeTimeout,
+		idleTimeout:       idleTimeout,
+		maxHeaderBytes:    maxHeaderBytes}
+
+	if adminAddress != "" {
+		var adminToken string
+		if adminTokenFile != "" {
+			token, err := ioutil.ReadFile(adminTokenFile)
+			if err != nil {
+				fatal(exitConfig, err)
+			}
+
+			if adminToken = strings.TrimSpace(string(token)); adminToken == "" {
+				logUsage("the admin token file is empty")
+			}
+		}
+
+		if err := serveAdmin(adminAddress, adminToken, auditSink, limits); err != nil {
+			fatalRun(err)
+		}
+	}
+
+	// the hedge filter sends the requests with the transport of the proxy
+	transport := &proxyTransport{}
+	customFilters, err := selectFilters([]filters.Spec{
+		skoap.NewAuthWithOptions(authOptions),
+		skoap.NewAuthAllWithOptions(authOptions),
+		skoap.NewAuthTeamWithOptions(authOptions),
+		skoap.NewAuthTeamAllWithOptions(authOptions),
+		skoap.NewAuthGroupWithOptions(authOptions),
+		skoap.NewAuthOptionalWithOptions(authOptions),
+		skoap.NewAuthScopeOrTeamWithOptions(authOptions),
+		skoap.NewBasicAuth(),
+		skoap.NewVerifyBasicAuth(),
+		skoap.NewAuditLogWithOptions(skoap.AuditLogOptions{
+			Sink:                 auditSink,
+			MaxBody:              auditMaxBody,
+			TrustedProxies:       trustedNetworks,
+			Redact:               splitList(auditRedact),
+			TokenFingerprintSalt: auditSalt,
+			Fields:               staticFields,
+			MethodSampleRates:    sampleRates}),
+		skoap.NewForwardAuth(),
+		skoap.NewForwardToken(),
+		skoap.NewBearerToken(serviceTokenOptions),
+		skoap.NewExchangeToken(serviceTokenOptions),
+		skoap.NewSetHeaderTemplate(),
+		skoap.NewSetHeaderFromAuth(),
+		skoap.NewMapClaims(),
+		skoap.NewCheck(checks, jsonErrors),
+		skoap.NewAllowIf(jsonErrors),
+		skoap.NewAuthWebhook(jsonErrors),
+		skoap.NewClientCert(jsonErrors),
+		skoap.NewIPAllow(ipFilterOptions),
+		skoap.NewIPDeny(ipFilterOptions),
+		skoap.NewDenyUser(jsonErrors),
+		skoap.NewDenyTeam(jsonErrors),
+		skoap.NewAuthUser(jsonErrors),
+		skoap.NewCorsHeaders(),
+		skoap.NewSecureHeaders(),
+		skoap.NewOIDC(oidcOptions),
+		skoap.NewLogout(authOptions, oidcOptions),
+		skoap.NewTeamQuota(skoap.TeamQuotaOptions{
+			Limits:         quotas,
+			LoadLimits:     loadQuotas,
+			ReloadInterval: teamQuotaReload,
+			MethodWeights:  quotaWeights,
+			Period:         teamQuotaPeriod,
+			JSONErrors:     jsonErrors}),
+		skoap.NewOwner(),
+		skoap.NewHedgeWithTransport(transport),
+	}, splitList(enableFilters), splitList(disableFilters))
+	if err != nil {
+		logUsage(err.Error())
+	}
+
+	o := serverOptions{
+		address:             address,
+		customFilters:       customFilters,
+		customPredicates:    skoap.NewAuthPredicates(authOptions),
+		unixSockets:         newUnixSockets(),
+		proxyTransport:      transport,
+		proxyFlags:          proxy.PreserveOriginal,
+		experimentalUpgrade: experimentalUpgrade,
+		certPathsTLS:        splitList(certPathTLS),
+		keyPathsTLS:         splitList(keyPathTLS),
+		certDirTLS:          certDirTLS,
+		tlsMinVersion:       tlsMinVersion,
+		tlsCipherSuites:     splitList(tlsCipherSuites),
+		tlsCurves:           splitList(tlsCurves),
+		tlsClientCA:         tlsClientCA,
+		tlsRequireCert:      tlsRequireCert,
+		ocspStapling:        ocspStapling,
+		acmeDomains:         splitList(acmeDomains),
+		acmeCacheDir:        acmeCacheDir,
+		acmeEmail:           acmeEmail,
+		acmeHTTPAddress:     acmeHTTPAddress,
+		acmeDirectory:       acmeDirectory,
+		limits:              limits,
+		maxConnections:      maxConnections,
+		accessLog:           accessLog,
+		accessLogFormat:     accessFormat,
+		tokenQueryParams:    splitList(tokenQuery),
 	}
 
 	if insecure {
-		o.ProxyOptions |= proxy.OptionsInsecure
+		o.proxyFlags |= proxy.Insecure
 	}
 
 	if targetAddress == "" {
-		o.RoutesFile = routesFile
+		var dc routing.DataClient
+		switch {
+		case etcdUrls != "":
+			dc, err = etcd.New(etcd.Options{Endpoints: splitList(etcdUrls), Prefix: etcdPrefix})
+		case kubernetes || kubeUrl != "":
+			dc, err = skoap.NewKubernetesClient(skoap.KubernetesOptions{
+				APIServerUrl: kubeUrl,
+				IngressClass: kubeClass,
+				Namespace:    kubeNamespace})
+		case inlineRoutes != "":
+			var routes []*eskip.Route
+			routes, err = eskip.Parse(inlineRoutes)
+			dc = inlineRoutesClient(routes)
+		default:
+			paths := []string(routesFiles)
+			if routesDir != "" {
+				var dirPaths []string
+				if dirPaths, err = routesDirFiles(routesDir); err != nil {
+					fatal(exitConfig, err)
+				}
+
+				paths = append(paths, dirPaths...)
+			}
+
+			dc, err = openRouteFiles(paths)
+		}
+
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+
+		if ownersFile != "" {
+			owners, err := loadOwners(ownersFile)
+			if err != nil {
+				fatal(exitConfig, err)
+			}
+
+			dc = skoap.NewOwnerClient(dc, owners)
+		}
+
+		if requireAuth {
+			dc = skoap.NewAuthRequiredClient(dc, splitList(publicRoutes)...)
+			if _, err := dc.LoadAll(); err != nil {
+				fatal(exitConfig, err)
+			}
+		}
+
+		o.dataClients = []routing.DataClient{dc}
 	} else {
 		var filterArgs []interface{}
 		if realm != "" {
@@ -257,6 +1585,9 @@ func main() {
 		if teamCheckMode {
 			args = teams
 			name = skoap.AuthTeamName
+		} else if groupCheckMode {
+			args = groups
+			name = skoap.AuthGroupName
 		}
 
 		if args != "" {
@@ -281,20 +1612,65 @@ func main() {
 				Args: []interface{}{"Authorization"}})
 		}
 
+		if forwardAuth {
+			f = append(f, &eskip.Filter{Name: skoap.ForwardAuthName})
+		}
+
 		if audit {
+			// without an explicit audit-log-limit, the audit-max-body
+			// default of the spec applies
+			var auditArgs []interface{}
+			if auditMaxBody == 0 || isFlagSet(auditBodyFlag) {
+				auditArgs = []interface{}{float64(auditBody)}
+			}
+
 			f = append([]*eskip.Filter{&eskip.Filter{
 				Name: skoap.AuditLogName,
-				Args: []interface{}{float64(auditBody)}}}, f...)
+				Args: auditArgs}}, f...)
+		}
+
+		registered := make(map[string]bool)
+		for _, s := range customFilters {
+			registered[s.Name()] = true
+		}
+
+		for _, fi := range f {
+			if fi.Name != builtin.DropRequestHeaderName && !registered[fi.Name] {
+				logUsage(fmt.Sprintf("the %s filter is required in single route mode, but it is disabled", fi.Name))
+			}
 		}
 
-		o.CustomDataClients = []routing.DataClient{
+		o.dataClients = []routing.DataClient{
 			&singleRouteClient{
 				Filters: f,
 				Backend: targetAddress}}
 	}
 
-	err := skipper.Run(o)
+	for i, dc := range o.dataClients {
+		if len(claimsMapping) > 0 {
+			dc = skoap.NewClaimsMappingClient(dc, claimsMapping...)
+		}
+
+		o.dataClients[i] = newUnixBackendClient(dc, o.unixSockets)
+	}
+
+	if supportListener != "" {
+		rl := &routesLoaded{}
+		for i, dc := range o.dataClients {
+			o.dataClients[i] = rl.wrap(dc)
+		}
+
+		if err := serveSupport(supportListener, rl, authOptions, readinessWindow, enableProfiling, limits); err != nil {
+			fatalRun(err)
+		}
+	}
+
+	err = run(o)
+	if !skoap.FlushAuditSinks(auditFlushTimeout) {
+		log.Println("timeout while sending the buffered audit log entries")
+	}
+
 	if err != nil {
-		log.Fatal(err)
+		fatalRun(err)
 	}
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zalando-incubator/skoap"
+	"github.com/zalando/skipper/filters"
+)
+
+func TestSelectFilters(t *testing.T) {
+	specs := []filters.Spec{
+		skoap.NewAuth("https://auth.example.org"),
+		skoap.NewBasicAuth(),
+		skoap.NewSecureHeaders()}
+	for _, ti := range []struct {
+		msg      string
+		enable   []string
+		disable  []string
+		selected int
+		fail     bool
+	}{{
+		msg:      "all",
+		selected: 3,
+	}, {
+		msg:      "enabled only",
+		enable:   []string{skoap.AuthName},
+		selected: 1,
+	}, {
+		msg:      "disabled",
+		disable:  []string{skoap.BasicAuthName},
+		selected: 2,
+	}, {
+		msg:    "unknown",
+		enable: []string{"foo"},
+		fail:   true,
+	}} {
+		selected, err := selectFilters(specs, ti.enable, ti.disable)
+		if ti.fail != (err != nil) {
+			t.Error(ti.msg, "unexpected result", err)
+		}
+
+		if len(selected) != ti.selected {
+			t.Error(ti.msg, "invalid selection", len(selected))
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	if f, err := parseFields(nil); err != nil || f != nil {
+		t.Error("failed to handle no fields", f, err)
+	}
+
+	f, err := parseFields(splitList("env=prod,cluster=a=b"))
+	if err != nil || len(f) != 2 || f["env"] != "prod" || f["cluster"] != "a=b" {
+		t.Error("invalid fields", f, err)
+	}
+
+	if _, err := parseFields([]string{"=foo"}); err == nil {
+		t.Error("failed to fail on missing name")
+	}
+
+	if _, err := parseFields([]string{"foo"}); err == nil {
+		t.Error("failed to fail on missing value")
+	}
+}
+
+func TestParseSyslogAddress(t *testing.T) {
+	for _, ti := range []struct {
+		address string
+		network string
+		raddr   string
+		fail    bool
+	}{
+		{"local", "", "", false},
+		{"tcp://syslog.example.org:514", "tcp", "syslog.example.org:514", false},
+		{"unixgram:///dev/log", "unixgram", "/dev/log", false},
+		{"http://syslog.example.org", "", "", true},
+	} {
+		network, raddr, err := parseSyslogAddress(ti.address)
+		if ti.fail != (err != nil) || network != ti.network || raddr != ti.raddr {
+			t.Error(ti.address, "invalid result", network, raddr, err)
+		}
+	}
+}
+
+func TestLoadTeamQuotas(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skoap-quotas")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "quotas.json")
+	if err := ioutil.WriteFile(path, []byte(`{"teapot": 10, "*": 1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/quotas":
+			w.Write([]byte(`{"teapot": 10, "*": 1}`))
+		case "/slow":
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	defer func(c *http.Client) { teamQuotasClient = c }(teamQuotasClient)
+	teamQuotasClient = &http.Client{Timeout: 30 * time.Millisecond}
+
+	for _, ti := range []struct {
+		msg      string
+		location string
+		fail     bool
+	}{{
+		msg:      "file",
+		location: path,
+	}, {
+		msg:      "url",
+		location: server.URL + "/quotas",
+	}, {
+		msg:      "missing file",
+		location: filepath.Join(dir, "missing.json"),
+		fail:     true,
+	}, {
+		msg:      "not found",
+		location: server.URL + "/missing",
+		fail:     true,
+	}, {
+		msg:      "timeout",
+		location: server.URL + "/slow",
+		fail:     true,
+	}} {
+		q, err := loadTeamQuotas(ti.location)
+		if ti.fail != (err != nil) {
+			t.Error(ti.msg, "unexpected result", err)
+		}
+
+		if !ti.fail && (q["teapot"] != 10 || q["*"] != 1) {
+			t.Error(ti.msg, "invalid quotas", q)
+		}
+	}
+}
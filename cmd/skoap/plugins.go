@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"plugin"
+
+	"github.com/zalando-incubator/skoap"
+)
+
+// the symbol that the plugins need to export, e.g.:
+//
+//	func Checks() map[string]skoap.Check
+const checksSymbol = "Checks"
+
+// loads the custom checks from Go plugins
+func loadChecks(paths []string) (map[string]skoap.Check, error) {
+	checks := make(map[string]skoap.Check)
+	for _, p := range paths {
+		pl, err := plugin.Open(p)
+		if err != nil {
+			return nil, err
+		}
+
+		s, err := pl.Lookup(checksSymbol)
+		if err != nil {
+			return nil, err
+		}
+
+		f, ok := s.(func() map[string]skoap.Check)
+		if !ok {
+			return nil, fmt.Errorf("invalid %s symbol in plugin: %s", checksSymbol, p)
+		}
+
+		for name, c := range f() {
+			if _, exists := checks[name]; exists {
+				return nil, fmt.Errorf("duplicate check %s in plugin: %s", name, p)
+			}
+
+			checks[name] = c
+		}
+	}
+
+	return checks, nil
+}
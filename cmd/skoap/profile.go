@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tuning profiles, presetting the flags consistently for common
+// deployments. The flags set on the command line override the values
+// of the profile.
+var profiles = map[string]map[string]string{
+	"edge-high-traffic": {
+		memoryBudgetFlag:     "256",
+		userInfoCacheTTLFlag: "10m",
+		auditSamplingFlag:    "GET:0.1,HEAD:0,OPTIONS:0",
+		auditMaxBodyFlag:     "256",
+		tlsMinVersionFlag:    "1.2",
+		jsonErrorsFlag:       "true",
+	},
+	"internal-low-latency": {
+		userInfoCacheTTLFlag: "15m",
+		auditSamplingFlag:    "GET:0.5,HEAD:0",
+		auditMaxBodyFlag:     "128",
+	},
+	"strict-compliance": {
+		userInfoCacheTTLFlag: "1m",
+		auditMaxBodyFlag:     "65536",
+		auditRedactFlag:      "password,secret,.*token.*,.*card.*,ssn",
+		tlsMinVersionFlag:    "1.2",
+		jsonErrorsFlag:       "true",
+		tokenReuseIPsFlag:    "3",
+	},
+}
+
+// returns the names of the profiles, sorted
+func profileNames() []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// sets the flags of a profile, that were not set on the command line.
+// The values are set as the defaults, without marking the flags as set,
+// so that isFlagSet tells only about the command line.
+func applyProfile(name string) error {
+	p, ok := profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile: %s, expected one of: %s", name, strings.Join(profileNames(), ", "))
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for flagName, value := range p {
+		if set[flagName] {
+			continue
+		}
+
+		if err := fs.Lookup(flagName).Value.Set(value); err != nil {
+			return fmt.Errorf("invalid value %q for flag -%s in profile %s: %v", value, flagName, name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/eskipfile"
+	"github.com/zalando/skipper/routing"
+)
+
+// stringList is a flag that can be set multiple times
+type stringList []string
+
+// routeFilesClient merges the routes of multiple eskip files, e.g. the
+// fragments owned by different teams. The route ids need to be unique
+// across the files.
+type routeFilesClient struct {
+	paths   []string
+	clients []routing.DataClient
+}
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// lists the eskip files in a directory, sorted by name
+func routesDirFiles(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.eskip"))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no eskip files found in %s", dir)
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func openRouteFiles(paths []string) (routing.DataClient, error) {
+	if len(paths) == 1 {
+		return eskipfile.Open(paths[0])
+	}
+
+	c := &routeFilesClient{paths: paths}
+	for _, p := range paths {
+		fc, err := eskipfile.Open(p)
+		if err != nil {
+			return nil, err
+		}
+
+		c.clients = append(c.clients, fc)
+	}
+
+	return c, nil
+}
+
+func (c *routeFilesClient) LoadAll() ([]*eskip.Route, error) {
+	var all []*eskip.Route
+	ids := make(map[string]string)
+	for i, fc := range c.clients {
+		routes, err := fc.LoadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", c.paths[i], err)
+		}
+
+		for _, r := range routes {
+			if p, ok := ids[r.Id]; ok {
+				return nil, fmt.Errorf("duplicate route id %s in %s and %s", r.Id, p, c.paths[i])
+			}
+
+			ids[r.Id] = c.paths[i]
+		}
+
+		all = append(all, routes...)
+	}
+
+	return all, nil
+}
+
+func (c *routeFilesClient) LoadUpdate() ([]*eskip.Route, []string, error) {
+	var (
+		all        []*eskip.Route
+		allDeleted []string
+	)
+
+	for i, fc := range c.clients {
+		routes, deleted, err := fc.LoadUpdate()
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", c.paths[i], err)
+		}
+
+		all = append(all, routes...)
+		allDeleted = append(allDeleted, deleted...)
+	}
+
+	return all, allDeleted, nil
+}
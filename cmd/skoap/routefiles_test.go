@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+func TestRoutesDirFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skoap-routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	if _, err := routesDirFiles(dir); err == nil {
+		t.Error("failed to fail on empty directory")
+	}
+
+	for _, n := range []string{"b.eskip", "a.eskip", "c.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, n), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths, err := routesDirFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paths) != 2 || filepath.Base(paths[0]) != "a.eskip" || filepath.Base(paths[1]) != "b.eskip" {
+		t.Error("invalid routes files", paths)
+	}
+}
+
+func TestRouteFilesClient(t *testing.T) {
+	for _, ti := range []struct {
+		msg     string
+		clients []*testDataClient
+		routes  int
+		fail    bool
+	}{{
+		msg: "merged",
+		clients: []*testDataClient{
+			{routes: []*eskip.Route{{Id: "foo"}}},
+			{routes: []*eskip.Route{{Id: "bar"}, {Id: "baz"}}}},
+		routes: 3,
+	}, {
+		msg: "duplicate id",
+		clients: []*testDataClient{
+			{routes: []*eskip.Route{{Id: "foo"}}},
+			{routes: []*eskip.Route{{Id: "foo"}}}},
+		fail: true,
+	}, {
+		msg: "failing file",
+		clients: []*testDataClient{
+			{routes: []*eskip.Route{{Id: "foo"}}},
+			{err: errors.New("parse error")}},
+		fail: true,
+	}} {
+		c := &routeFilesClient{paths: []string{"a.eskip", "b.eskip"}}
+		for _, dc := range ti.clients {
+			c.clients = append(c.clients, dc)
+		}
+
+		routes, err := c.LoadAll()
+		if ti.fail != (err != nil) {
+			t.Error(ti.msg, "unexpected result", err)
+		}
+
+		if len(routes) != ti.routes {
+			t.Error(ti.msg, "invalid routes", len(routes))
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zalando-incubator/skoap"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/filters/builtin"
+	"github.com/zalando/skipper/predicates/cookie"
+	"github.com/zalando/skipper/predicates/interval"
+	"github.com/zalando/skipper/predicates/query"
+	"github.com/zalando/skipper/predicates/source"
+	"github.com/zalando/skipper/predicates/traffic"
+	"github.com/zalando/skipper/proxy"
+	"github.com/zalando/skipper/routing"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const (
+	sourcePollTimeout = 3 * time.Second
+
+	// the time given to the open requests to complete on SIGTERM or
+	// SIGINT
+	shutdownTimeout = 30 * time.Second
+
+	// the time given to the audit sinks to send the buffered entries,
+	// before the process exits
+	auditFlushTimeout = 10 * time.Second
+)
+
+// skoap creates the Skipper routing and proxy itself, instead of using
+// skipper.Run, in order to have control over the listener, e.g. for
+// automatic certificates. The defaults of skipper.Run that are not
+// applied are listed in the readme.
+type serverOptions struct {
+	address             string
+	customFilters       []filters.Spec
+	customPredicates    []routing.PredicateSpec
+	dataClients         []routing.DataClient
+	unixSockets         *unixSockets
+	proxyTransport      *proxyTransport
+	proxyFlags          proxy.Flags
+	experimentalUpgrade bool
+	certPathsTLS        []string
+	keyPathsTLS         []string
+	certDirTLS          string
+	tlsMinVersion       string
+	tlsCipherSuites     []string
+	tlsCurves           []string
+	tlsClientCA         string
+	tlsRequireCert      bool
+	ocspStapling        bool
+	acmeDomains         []string
+	acmeCacheDir        string
+	acmeEmail           string
+	acmeHTTPAddress     string
+	acmeDirectory       string
+	limits              serverLimits
+	maxConnections      int
+	accessLog           bool
+	accessLogFormat     skoap.AccessLogFormat
+	tokenQueryParams    []string
+}
+
+// the timeouts and the header size limit of the HTTP servers, applied to
+// the proxy, the admin, the support and the ACME HTTP listeners alike
+type serverLimits struct {
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	maxHeaderBytes    int
+}
+
+func (sl serverLimits) server(h http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           h,
+		ReadTimeout:       sl.readTimeout,
+		ReadHeaderTimeout: sl.readHeaderTimeout,
+		WriteTimeout:      sl.writeTimeout,
+		IdleTimeout:       sl.idleTimeout,
+		MaxHeaderBytes:    sl.maxHeaderBytes}
+}
+
+// limits the number of the concurrently open connections. When the limit
+// is reached, Accept blocks until one of the connections is closed.
+type limitListener struct {
+	net.Listener
+	sem chan struct{}
+}
+
+type limitConn struct {
+	net.Conn
+	release func()
+}
+
+// the predicates bundled with Skipper, registered by skipper.Run, that
+// the routes used before skoap created the proxy itself
+func bundledPredicates() []routing.PredicateSpec {
+	return []routing.PredicateSpec{
+		source.New(),
+		interval.NewBetween(),
+		interval.NewBefore(),
+		interval.NewAfter(),
+		cookie.New(),
+		query.New(),
+		traffic.New()}
+}
+
+func newProxy(o serverOptions) (*routing.Routing, *proxy.Proxy) {
+	registry := builtin.MakeRegistry()
+	for _, f := range o.customFilters {
+		registry.Register(f)
+	}
+
+	rt := routing.New(routing.Options{
+		FilterRegistry: registry,
+		PollTimeout:    sourcePollTimeout,
+		DataClients:    o.dataClients,
+		Predicates:     append(bundledPredicates(), o.customPredicates...)})
+
+	p := proxy.WithParams(proxy.Params{
+		Routing:                    rt,
+		Flags:                      o.proxyFlags,
+		ExperimentalUpgrade:        o.experimentalUpgrade,
+		CustomHttpRoundTripperWrap: o.wrapTransport})
+
+	return rt, p
+}
+
+// wraps the transport of the proxy for the Unix socket backends, and
+// shares it with the hedge filter
+func (o serverOptions) wrapTransport(next http.RoundTripper) http.RoundTripper {
+	next = o.unixSockets.wrapTransport(next)
+	o.proxyTransport.set(next)
+	return next
+}
+
+// proxyTransport sends the requests of the filters that call the
+// backends themselves, e.g. hedge, with the transport of the proxy,
+// captured when the proxy is created
+type proxyTransport struct {
+	mu   sync.Mutex
+	next http.RoundTripper
+}
+
+func (t *proxyTransport) set(next http.RoundTripper) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.next = next
+}
+
+func (t *proxyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	next := t.next
+	t.mu.Unlock()
+
+	if next == nil {
+		return http.DefaultTransport.RoundTrip(r)
+	}
+
+	return next.RoundTrip(r)
+}
+
+// serves the certificates obtained from an ACME provider, e.g. Let's
+// Encrypt. The TLS-ALPN-01 challenge is answered by the main listener,
+// and only when the ACME HTTP address is set explicitly, the HTTP-01
+// challenge is answered there, while all other requests are redirected
+// to HTTPS.
+func serveACME(s *http.Server, l net.Listener, o serverOptions) error {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(o.acmeDomains...),
+		Cache:      autocert.DirCache(o.acmeCacheDir),
+		Email:      o.acmeEmail}
+	if o.acmeDirectory != "" {
+		m.Client = &acme.Client{DirectoryURL: o.acmeDirectory}
+	}
+
+	s.TLSConfig = m.TLSConfig()
+	if err := applyTLSPolicy(s.TLSConfig, o); err != nil {
+		return err
+	}
+
+	if o.acmeHTTPAddress != "" {
+		hl, err := net.Listen("tcp", o.acmeHTTPAddress)
+		if err != nil {
+			return bindError{err}
+		}
+
+		go func() {
+			fatal(exitRuntime, o.limits.server(m.HTTPHandler(nil)).Serve(hl))
+		}()
+	}
+
+	return s.ServeTLS(l, "", "")
+}
+
+// loads the certificate and key pairs from the tls-cert and tls-key
+// flags, and from the tls-cert-dir directory. The certificate served
+// to a client is selected by crypto/tls based on the SNI hostname,
+// falling back to the first one.
+func loadCertificates(o serverOptions) ([]tls.Certificate, error) {
+	certs, keys := o.certPathsTLS, o.keyPathsTLS
+	if o.certDirTLS != "" {
+		files, err := ioutil.ReadDir(o.certDirTLS)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range files {
+			if f.IsDir() || filepath.Ext(f.Name()) != ".crt" {
+				continue
+			}
+
+			name := strings.TrimSuffix(f.Name(), ".crt")
+			certs = append(certs, filepath.Join(o.certDirTLS, name+".crt"))
+			keys = append(keys, filepath.Join(o.certDirTLS, name+".key"))
+		}
+	}
+
+	var loaded []tls.Certificate
+	for i := range certs {
+		c, err := tls.LoadX509KeyPair(certs[i], keys[i])
+		if err != nil {
+			return nil, err
+		}
+
+		loaded = append(loaded, c)
+	}
+
+	if len(loaded) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+
+	return loaded, nil
+}
+
+func serveTLS(s *http.Server, l net.Listener, o serverOptions) error {
+	c, err := newTLSConfig(o)
+	if err != nil {
+		return err
+	}
+
+	s.TLSConfig = c
+	return s.ServeTLS(l, "", "")
+}
+
+func newLimitListener(l net.Listener, max int) net.Listener {
+	return &limitListener{Listener: l, sem: make(chan struct{}, max)}
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	l.sem <- struct{}{}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+
+	var once sync.Once
+	return &limitConn{Conn: c, release: func() { once.Do(func() { <-l.sem }) }}, nil
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+// on SIGTERM or SIGINT, the server stops accepting new connections,
+// and waits for the open requests to complete. Closing the listener
+// removes the Unix socket file, too. The returned channel is closed
+// when the shutdown is done.
+func shutdownOnSignal(s *http.Server) <-chan struct{} {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	done := make(chan struct{})
+	go func() {
+		<-sigs
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+
+		close(done)
+	}()
+
+	return done
+}
+
+func run(o serverOptions) error {
+	rt, p := newProxy(o)
+	defer rt.Close()
+	defer p.Close()
+
+	l, err := listen(o.address)
+	if err != nil {
+		return bindError{err}
+	}
+
+	if o.maxConnections > 0 {
+		l = newLimitListener(l, o.maxConnections)
+	}
+
+	var h http.Handler = p
+	if o.accessLog {
+		h = skoap.NewAccessLog(p, os.Stderr, o.accessLogFormat, o.tokenQueryParams...)
+	}
+
+	s := o.limits.server(h)
+	shutdown := shutdownOnSignal(s)
+	switch {
+	case len(o.acmeDomains) > 0:
+		err = serveACME(s, l, o)
+	case len(o.certPathsTLS) > 0 || o.certDirTLS != "":
+		err = serveTLS(s, l, o)
+	default:
+		err = s.Serve(l)
+	}
+
+	if err == http.ErrServerClosed {
+		<-shutdown
+		return nil
+	}
+
+	return err
+}
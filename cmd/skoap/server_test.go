@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBundledPredicates(t *testing.T) {
+	var names []string
+	for _, p := range bundledPredicates() {
+		names = append(names, p.Name())
+	}
+
+	sort.Strings(names)
+	if strings.Join(names, ",") != "After,Before,Between,Cookie,QueryParam,Source,Traffic" {
+		t.Error("invalid bundled predicates", names)
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	sl := serverLimits{
+		readTimeout:       time.Second,
+		readHeaderTimeout: 2 * time.Second,
+		writeTimeout:      3 * time.Second,
+		idleTimeout:       4 * time.Second,
+		maxHeaderBytes:    1 << 10}
+	s := sl.server(http.NotFoundHandler())
+	if s.ReadTimeout != time.Second || s.ReadHeaderTimeout != 2*time.Second || s.WriteTimeout != 3*time.Second ||
+		s.IdleTimeout != 4*time.Second || s.MaxHeaderBytes != 1<<10 {
+		t.Error("failed to apply the limits", s)
+	}
+}
+
+func TestLimitListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ll := newLimitListener(l, 1)
+	defer ll.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := ll.Accept()
+			if err != nil {
+				return
+			}
+
+			accepted <- c
+		}
+	}()
+
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return c
+	}
+
+	c1 := dial()
+	defer c1.Close()
+	first := <-accepted
+
+	c2 := dial()
+	defer c2.Close()
+	select {
+	case <-accepted:
+		t.Fatal("failed to limit the connections")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	// closing twice releases the slot only once
+	first.Close()
+	first.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("failed to release the closed connection")
+	}
+}
+
+func TestProxyTransport(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	var pt proxyTransport
+	req, err := http.NewRequest("GET", backend.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := pt.RoundTrip(req)
+	if err != nil {
+		t.Fatal("failed to fall back to the default transport", err)
+	}
+
+	rsp.Body.Close()
+
+	var wrapped int
+	o := serverOptions{unixSockets: newUnixSockets(), proxyTransport: &pt}
+	next := o.wrapTransport(roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		wrapped++
+		return http.DefaultTransport.RoundTrip(r)
+	}))
+
+	if _, ok := next.(*unixRoundTripper); !ok {
+		t.Error("failed to wrap the transport for the Unix sockets")
+	}
+
+	rsp, err = pt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+	if wrapped != 1 {
+		t.Error("failed to share the transport of the proxy")
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
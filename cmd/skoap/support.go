@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zalando-incubator/skoap"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/routing"
+)
+
+// the timeout of the token validation probe made by /readyz
+const readinessProbeTimeout = 5 * time.Second
+
+// routesLoaded tells whether every data client has loaded its routes
+// at least once
+type routesLoaded struct {
+	pending int64
+}
+
+type readyClient struct {
+	client routing.DataClient
+	loaded *routesLoaded
+	once   sync.Once
+}
+
+// wraps a data client, marking the routes loaded after its first
+// successful LoadAll
+func (rl *routesLoaded) wrap(client routing.DataClient) routing.DataClient {
+	atomic.AddInt64(&rl.pending, 1)
+	return &readyClient{client: client, loaded: rl}
+}
+
+func (rl *routesLoaded) ready() bool {
+	return atomic.LoadInt64(&rl.pending) == 0
+}
+
+func (c *readyClient) LoadAll() ([]*eskip.Route, error) {
+	routes, err := c.client.LoadAll()
+	if err == nil {
+		c.once.Do(func() { atomic.AddInt64(&c.loaded.pending, -1) })
+	}
+
+	return routes, err
+}
+
+func (c *readyClient) LoadUpdate() ([]*eskip.Route, []string, error) {
+	return c.client.LoadUpdate()
+}
+
+// the auth service is probed only when it was not reached by the
+// requests recently, so that an idle instance can still become ready
+func authServiceReady(o skoap.Options, window time.Duration) bool {
+	if time.Since(skoap.AuthServiceReached()) <= window {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), readinessProbeTimeout)
+	defer cancel()
+	if err := skoap.ProbeAuthService(ctx, o); err != nil {
+		log.Println("readiness probe failed:", err)
+		return false
+	}
+
+	return true
+}
+
+// serves the health, readiness, metrics and profiling endpoints on a
+// separate listener. /healthz responds with 200 while the process is
+// running, and /readyz only when the routes are loaded and the token
+// validation service was reached within the window. The expvar metrics
+// are served on /debug/vars, and when profiling is enabled, the pprof
+// profiles on /debug/pprof/. Listening fails synchronously, serving in
+// the background.
+func serveSupport(address string, rl *routesLoaded, o skoap.Options, window time.Duration, profiling bool, limits serverLimits) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return bindError{err}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		switch {
+		case !rl.ready():
+			http.Error(w, "routes not loaded", http.StatusServiceUnavailable)
+		case !authServiceReady(o, window):
+			http.Error(w, "auth service not reachable", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("ok\n"))
+		}
+	})
+
+	mux.Handle("/debug/vars", expvar.Handler())
+	if profiling {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	go func() {
+		fatal(exitRuntime, limits.server(mux).Serve(l))
+	}()
+
+	return nil
+}
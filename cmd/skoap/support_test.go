@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zalando-incubator/skoap"
+)
+
+// returns a free local address for the listeners started by the tested
+// functions themselves
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestRoutesLoaded(t *testing.T) {
+	var rl routesLoaded
+	failing := &testDataClient{err: errors.New("not yet")}
+	c1 := rl.wrap(failing)
+	c2 := rl.wrap(&testDataClient{})
+
+	c1.LoadAll()
+	c2.LoadAll()
+	if rl.ready() {
+		t.Error("ready before all the clients loaded their routes")
+	}
+
+	failing.err = nil
+	c1.LoadAll()
+	c1.LoadAll()
+	if !rl.ready() {
+		t.Error("failed to get ready")
+	}
+}
+
+func TestServeSupport(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer authServer.Close()
+
+	var rl routesLoaded
+	c := rl.wrap(&testDataClient{})
+
+	address := freeAddress(t)
+	o := skoap.Options{AuthUrlBase: authServer.URL}
+	if err := serveSupport(address, &rl, o, time.Millisecond, false, serverLimits{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := serveSupport(address, &rl, o, time.Millisecond, false, serverLimits{}); err == nil {
+		t.Error("failed to fail on an address in use")
+	} else if _, ok := err.(bindError); !ok {
+		t.Error("invalid error type", err)
+	}
+
+	get := func(path string) int {
+		rsp, err := http.Get("http://" + address + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+
+	if s := get("/healthz"); s != http.StatusOK {
+		t.Error("invalid health status", s)
+	}
+
+	if s := get("/readyz"); s != http.StatusServiceUnavailable {
+		t.Error("ready before the routes were loaded", s)
+	}
+
+	c.LoadAll()
+	if s := get("/readyz"); s != http.StatusOK {
+		t.Error("failed to get ready", s)
+	}
+
+	if s := get("/debug/vars"); s != http.StatusOK {
+		t.Error("failed to serve the metrics", s)
+	}
+
+	if s := get("/debug/pprof/"); s != http.StatusNotFound {
+		t.Error("profiling served without enabling it", s)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"expvar"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	ocspMinRefresh = time.Minute
+	ocspMaxRefresh = 12 * time.Hour
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var curveNames = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// counts the TLS handshakes of the client connections by protocol
+// version, cipher suite, session resumption and client certificates,
+// published with the expvar package
+var tlsHandshakes = expvar.NewMap("tls-handshakes")
+
+// holds the listener certificates, and keeps their OCSP staples up to
+// date when stapling is enabled
+type certStore struct {
+	mu    sync.RWMutex
+	certs []tls.Certificate
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	if v == "" {
+		return 0, nil
+	}
+
+	if tv, ok := tlsVersions[v]; ok {
+		return tv, nil
+	}
+
+	return 0, fmt.Errorf("invalid TLS version: %s", v)
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[s.Name] = s.ID
+	}
+
+	var ids []uint16
+	for _, n := range names {
+		id, ok := known[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite: %s", n)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func parseCurves(names []string) ([]tls.CurveID, error) {
+	var curves []tls.CurveID
+	for _, n := range names {
+		c, ok := curveNames[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown curve: %s", n)
+		}
+
+		curves = append(curves, c)
+	}
+
+	return curves, nil
+}
+
+func recordHandshake(cs tls.ConnectionState) error {
+	tlsHandshakes.Add("total", 1)
+	tlsHandshakes.Add("version."+tls.VersionName(cs.Version), 1)
+	tlsHandshakes.Add("cipher."+tls.CipherSuiteName(cs.CipherSuite), 1)
+	if cs.DidResume {
+		tlsHandshakes.Add("resumed", 1)
+	}
+
+	if len(cs.PeerCertificates) > 0 {
+		tlsHandshakes.Add("client-cert", 1)
+	}
+
+	return nil
+}
+
+// applies the minimum version, the cipher suites, the curve
+// preferences and the client CAs to a TLS config, and registers the
+// handshake metrics
+func applyTLSPolicy(c *tls.Config, o serverOptions) error {
+	v, err := parseTLSVersion(o.tlsMinVersion)
+	if err != nil {
+		return err
+	}
+
+	ciphers, err := parseCipherSuites(o.tlsCipherSuites)
+	if err != nil {
+		return err
+	}
+
+	curves, err := parseCurves(o.tlsCurves)
+	if err != nil {
+		return err
+	}
+
+	c.MinVersion = v
+	c.CipherSuites = ciphers
+	c.CurvePreferences = curves
+	c.VerifyConnection = recordHandshake
+	if o.tlsClientCA == "" {
+		return nil
+	}
+
+	pool, err := loadCertPool(o.tlsClientCA)
+	if err != nil {
+		return err
+	}
+
+	// unless required for every connection, the clientCert filter
+	// rejects the requests without a certificate, when required by the
+	// route
+	c.ClientCAs = pool
+	c.ClientAuth = tls.VerifyClientCertIfGiven
+	if o.tlsRequireCert {
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i := range s.certs {
+		if hello.SupportsCertificate(&s.certs[i]) == nil {
+			return &s.certs[i], nil
+		}
+	}
+
+	return &s.certs[0], nil
+}
+
+func fetchOCSP(c *tls.Certificate) (*ocsp.Response, []byte, error) {
+	if len(c.Certificate) < 2 {
+		return nil, nil, errors.New("missing issuer certificate")
+	}
+
+	leaf, err := x509.ParseCertificate(c.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	issuer, err := x509.ParseCertificate(c.Certificate[1])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, errors.New("no OCSP server in certificate")
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rsp, err := http.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP request failed: %s", rsp.Status)
+	}
+
+	raw, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parsed, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parsed, raw, nil
+}
+
+// fetches the OCSP responses for all certificates, and returns how long
+// to wait until the next refresh, half way to the earliest next update
+func (s *certStore) updateOCSP() time.Duration {
+	s.mu.RLock()
+	certs := make([]tls.Certificate, len(s.certs))
+	copy(certs, s.certs)
+	s.mu.RUnlock()
+
+	next := ocspMaxRefresh
+	for i := range certs {
+		parsed, raw, err := fetchOCSP(&certs[i])
+		if err != nil {
+			log.Println("failed to fetch OCSP response:", err)
+			next = ocspMinRefresh
+			continue
+		}
+
+		certs[i].OCSPStaple = raw
+		if d := parsed.NextUpdate.Sub(time.Now()) / 2; !parsed.NextUpdate.IsZero() && d < next {
+			next = d
+		}
+	}
+
+	if next < ocspMinRefresh {
+		next = ocspMinRefresh
+	}
+
+	s.mu.Lock()
+	s.certs = certs
+	s.mu.Unlock()
+	return next
+}
+
+func (s *certStore) staple(next time.Duration) {
+	for {
+		time.Sleep(next)
+		next = s.updateOCSP()
+	}
+}
+
+// creates the TLS config of the listener with the loaded certificates,
+// the TLS policy and optionally OCSP stapling
+func newTLSConfig(o serverOptions) (*tls.Config, error) {
+	certs, err := loadCertificates(o)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &certStore{certs: certs}
+	c := &tls.Config{GetCertificate: s.getCertificate}
+	if err := applyTLSPolicy(c, o); err != nil {
+		return nil, err
+	}
+
+	if o.ocspStapling {
+		go s.staple(s.updateOCSP())
+	}
+
+	return c, nil
+}
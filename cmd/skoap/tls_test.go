@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writes a self-signed certificate and its key to dir, as name.crt and
+// name.key
+func writeTestCert(t *testing.T, dir, name, host string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseTLSPolicy(t *testing.T) {
+	if v, err := parseTLSVersion(""); err != nil || v != 0 {
+		t.Error("failed to default the TLS version", v, err)
+	}
+
+	if v, err := parseTLSVersion("1.2"); err != nil || v != tls.VersionTLS12 {
+		t.Error("invalid TLS version", v, err)
+	}
+
+	if _, err := parseTLSVersion("1.4"); err == nil {
+		t.Error("failed to fail on unknown TLS version")
+	}
+
+	if ids, err := parseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}); err != nil ||
+		len(ids) != 1 || ids[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Error("invalid cipher suites", ids, err)
+	}
+
+	if _, err := parseCipherSuites([]string{"TLS_FOO"}); err == nil {
+		t.Error("failed to fail on unknown cipher suite")
+	}
+
+	if curves, err := parseCurves([]string{"X25519", "P256"}); err != nil ||
+		len(curves) != 2 || curves[0] != tls.X25519 || curves[1] != tls.CurveP256 {
+		t.Error("invalid curves", curves, err)
+	}
+
+	if _, err := parseCurves([]string{"P192"}); err == nil {
+		t.Error("failed to fail on unknown curve")
+	}
+}
+
+func TestApplyTLSPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skoap-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	writeTestCert(t, dir, "ca", "ca.example.org")
+
+	for _, ti := range []struct {
+		msg        string
+		options    serverOptions
+		clientAuth tls.ClientAuthType
+		fail       bool
+	}{{
+		msg:     "min version",
+		options: serverOptions{tlsMinVersion: "1.2"},
+	}, {
+		msg:        "optional client certificates",
+		options:    serverOptions{tlsClientCA: filepath.Join(dir, "ca.crt")},
+		clientAuth: tls.VerifyClientCertIfGiven,
+	}, {
+		msg:        "required client certificates",
+		options:    serverOptions{tlsClientCA: filepath.Join(dir, "ca.crt"), tlsRequireCert: true},
+		clientAuth: tls.RequireAndVerifyClientCert,
+	}, {
+		msg:     "invalid client CA",
+		options: serverOptions{tlsClientCA: filepath.Join(dir, "ca.key")},
+		fail:    true,
+	}, {
+		msg:     "invalid version",
+		options: serverOptions{tlsMinVersion: "2.0"},
+		fail:    true,
+	}} {
+		c := &tls.Config{}
+		err := applyTLSPolicy(c, ti.options)
+		if ti.fail != (err != nil) {
+			t.Error(ti.msg, "unexpected result", err)
+			continue
+		}
+
+		if ti.fail {
+			continue
+		}
+
+		if c.ClientAuth != ti.clientAuth || (ti.options.tlsClientCA != "") != (c.ClientCAs != nil) {
+			t.Error(ti.msg, "invalid client auth", c.ClientAuth)
+		}
+
+		if ti.options.tlsMinVersion == "1.2" && c.MinVersion != tls.VersionTLS12 {
+			t.Error(ti.msg, "invalid min version", c.MinVersion)
+		}
+	}
+}
+
+func TestCertificateBySNI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skoap-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	writeTestCert(t, dir, "foo", "foo.example.org")
+	writeTestCert(t, dir, "bar", "bar.example.org")
+
+	c, err := newTLSConfig(serverOptions{certDirTLS: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ti := range []struct {
+		msg        string
+		serverName string
+		expected   string
+	}{{
+		msg:        "foo",
+		serverName: "foo.example.org",
+		expected:   "foo.example.org",
+	}, {
+		msg:        "bar",
+		serverName: "bar.example.org",
+		expected:   "bar.example.org",
+	}, {
+		msg:        "fallback to the first one",
+		serverName: "baz.example.org",
+		expected:   "bar.example.org",
+	}} {
+		cert, err := c.GetCertificate(&tls.ClientHelloInfo{
+			ServerName:        ti.serverName,
+			SupportedVersions: []uint16{tls.VersionTLS13},
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256}})
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if leaf.Subject.CommonName != ti.expected {
+			t.Error(ti.msg, "invalid certificate", leaf.Subject.CommonName)
+		}
+	}
+
+	if _, err := newTLSConfig(serverOptions{certDirTLS: filepath.Join(dir, "missing")}); err == nil {
+		t.Error("failed to fail on missing certificate directory")
+	}
+}
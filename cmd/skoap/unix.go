@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/routing"
+)
+
+// addresses and backends starting with this prefix are Unix domain
+// sockets, either as unix:/path or as unix:///path. Socket paths
+// starting with @ are in the abstract namespace.
+const (
+	unixPrefix    = "unix:"
+	unixURLPrefix = "unix://"
+
+	// the prefix of the synthetic hosts of the Unix socket backends
+	unixHostPrefix = "skoap-unix-"
+)
+
+// Skipper proxies only to http and https backends. The Unix socket
+// backends of the routes are replaced with a synthetic host name, and
+// the requests to these hosts are sent over the socket by the transport
+// of the proxy, without exposing the socket on a TCP port.
+type unixSockets struct {
+	mu    sync.Mutex
+	paths map[string]string
+}
+
+type unixBackendClient struct {
+	client  routing.DataClient
+	sockets *unixSockets
+}
+
+type unixRoundTripper struct {
+	sockets   *unixSockets
+	next      http.RoundTripper
+	transport *http.Transport
+}
+
+func unixSocketPath(address string) string {
+	if strings.HasPrefix(address, unixURLPrefix) {
+		return strings.TrimPrefix(address, unixURLPrefix)
+	}
+
+	return strings.TrimPrefix(address, unixPrefix)
+}
+
+// the socket file is removed when the listener is closed, see the
+// shutdown in run()
+func listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, unixPrefix) {
+		return net.Listen("tcp", address)
+	}
+
+	path := unixSocketPath(address)
+	if !strings.HasPrefix(path, "@") {
+		if err := removeStaleSocket(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return net.Listen("unix", path)
+}
+
+// removes the socket left by a previous run, only when no process is
+// listening on it anymore
+func removeStaleSocket(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+
+	c, err := net.DialTimeout("unix", path, time.Second)
+	switch {
+	case err == nil:
+		c.Close()
+		return fmt.Errorf("socket in use: %s", path)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return os.Remove(path)
+	default:
+		return err
+	}
+}
+
+func newUnixSockets() *unixSockets {
+	return &unixSockets{paths: make(map[string]string)}
+}
+
+// the host name is derived from the path of the socket, so that it is
+// the same for every route with the same backend
+func (s *unixSockets) host(path string) string {
+	h := sha256.Sum256([]byte(path))
+	host := unixHostPrefix + hex.EncodeToString(h[:8])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paths[host] = path
+	return host
+}
+
+func (s *unixSockets) path(host string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.paths[host]
+	return p, ok
+}
+
+func (s *unixSockets) dial(ctx context.Context, _, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	path, ok := s.path(host)
+	if !ok {
+		return nil, fmt.Errorf("unknown unix socket backend: %s", host)
+	}
+
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", path)
+}
+
+// wraps the transport of the proxy, sending the requests to the Unix
+// socket backends over their sockets
+func (s *unixSockets) wrapTransport(next http.RoundTripper) http.RoundTripper {
+	return &unixRoundTripper{
+		sockets:   s,
+		next:      next,
+		transport: &http.Transport{DialContext: s.dial}}
+}
+
+func (rt *unixRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if _, ok := rt.sockets.path(r.URL.Hostname()); ok {
+		return rt.transport.RoundTrip(r)
+	}
+
+	return rt.next.RoundTrip(r)
+}
+
+// wraps a data client, and replaces the Unix socket backends of the
+// routes with the synthetic hosts served by the proxy transport
+func newUnixBackendClient(client routing.DataClient, sockets *unixSockets) routing.DataClient {
+	return &unixBackendClient{client: client, sockets: sockets}
+}
+
+func (c *unixBackendClient) setBackends(routes []*eskip.Route) {
+	for _, r := range routes {
+		if strings.HasPrefix(r.Backend, unixPrefix) {
+			r.Backend = "http://" + c.sockets.host(unixSocketPath(r.Backend))
+		}
+	}
+}
+
+func (c *unixBackendClient) LoadAll() ([]*eskip.Route, error) {
+	routes, err := c.client.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	c.setBackends(routes)
+	return routes, nil
+}
+
+func (c *unixBackendClient) LoadUpdate() ([]*eskip.Route, []string, error) {
+	routes, deleted, err := c.client.LoadUpdate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.setBackends(routes)
+	return routes, deleted, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zalando/skipper/eskip"
+)
+
+type testDataClient struct {
+	routes  []*eskip.Route
+	deleted []string
+	err     error
+}
+
+func (c *testDataClient) LoadAll() ([]*eskip.Route, error) { return c.routes, c.err }
+
+func (c *testDataClient) LoadUpdate() ([]*eskip.Route, []string, error) {
+	return c.routes, c.deleted, c.err
+}
+
+func TestUnixSocketPath(t *testing.T) {
+	for _, ti := range []struct {
+		address string
+		path    string
+	}{
+		{"unix:/run/skoap.sock", "/run/skoap.sock"},
+		{"unix:///run/skoap.sock", "/run/skoap.sock"},
+		{"unix:@skoap", "@skoap"},
+	} {
+		if p := unixSocketPath(ti.address); p != ti.path {
+			t.Error(ti.address, "invalid socket path", p)
+		}
+	}
+}
+
+func TestUnixListenAndBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skoap-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backend.sock")
+
+	l, err := listen("unix:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := listen("unix:" + path); err == nil || !strings.Contains(err.Error(), "socket in use") {
+		t.Error("failed to fail on a socket in use", err)
+	}
+
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("unix"))
+	}))
+
+	sockets := newUnixSockets()
+	c := newUnixBackendClient(&testDataClient{routes: []*eskip.Route{
+		{Id: "unix", Backend: "unix://" + path},
+		{Id: "tcp", Backend: "https://www.example.org"}}}, sockets)
+	routes, err := c.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(routes[0].Backend, "http://"+unixHostPrefix) || routes[1].Backend != "https://www.example.org" {
+		t.Fatal("invalid backends", routes[0].Backend, routes[1].Backend)
+	}
+
+	client := &http.Client{Transport: sockets.wrapTransport(http.DefaultTransport)}
+	rsp, err := client.Get(routes[0].Backend + "/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rsp.Body.Close()
+	b, err := ioutil.ReadAll(rsp.Body)
+	if err != nil || string(b) != "unix" {
+		t.Error("failed to proxy over the socket", string(b), err)
+	}
+
+	// the socket left by a stopped process is removed
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	l, err = listen("unix:" + path)
+	if err != nil {
+		t.Fatal("failed to replace the stale socket", err)
+	}
+
+	l.Close()
+}
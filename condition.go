@@ -0,0 +1,240 @@
+package skoap
+
+import (
+	"fmt"
+	"github.com/zalando/skipper/filters"
+	"strings"
+)
+
+// The conditions of the allowIf filter extend the claims mapping
+// language with comparisons and logical operators:
+//
+//     realm == "/employees" || "ops" in teams
+//     !("admin" in scopes) && method == "GET"
+//
+// The == and != operators compare the values joined by commas, and the
+// in operator checks if any value of the left side is contained by the
+// right side.
+
+const conditionFailed rejectReason = "condition-failed"
+
+type (
+	condition interface {
+		test(ctx filters.FilterContext) bool
+	}
+
+	conditionOr  []condition
+	conditionAnd []condition
+
+	conditionNot struct {
+		c condition
+	}
+
+	comparison struct {
+		op          string
+		left, right mappingExpr
+	}
+
+	allowIfSpec struct {
+		jsonErrors bool
+	}
+
+	allowIf struct {
+		condition  condition
+		jsonErrors bool
+	}
+)
+
+func (p *mappingParser) consume(op string) bool {
+	p.skipSpace()
+	if !strings.HasPrefix(p.src[p.pos:], op) {
+		return false
+	}
+
+	// keywords must not be followed by name characters
+	end := p.pos + len(op)
+	if isNameChar(op[0]) && end < len(p.src) && isNameChar(p.src[end]) {
+		return false
+	}
+
+	p.pos = end
+	return true
+}
+
+func (p *mappingParser) parseOr() (condition, error) {
+	var or conditionOr
+	for {
+		c, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		or = append(or, c)
+		if !p.consume("||") {
+			break
+		}
+	}
+
+	if len(or) == 1 {
+		return or[0], nil
+	}
+
+	return or, nil
+}
+
+func (p *mappingParser) parseAnd() (condition, error) {
+	var and conditionAnd
+	for {
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		and = append(and, c)
+		if !p.consume("&&") {
+			break
+		}
+	}
+
+	if len(and) == 1 {
+		return and[0], nil
+	}
+
+	return and, nil
+}
+
+func (p *mappingParser) parseUnary() (condition, error) {
+	if p.consume("!") {
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return conditionNot{c}, nil
+	}
+
+	if p.consume("(") {
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if !p.consume(")") {
+			return nil, fmt.Errorf("%v: missing closing parenthesis at %d", errInvalidMapping, p.pos)
+		}
+
+		return c, nil
+	}
+
+	left, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"==", "!=", "in"} {
+		if p.consume(op) {
+			right, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+
+			return &comparison{op: op, left: left, right: right}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%v: missing operator at %d", errInvalidMapping, p.pos)
+}
+
+func parseCondition(src string) (condition, error) {
+	p := &mappingParser{src: src}
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos != len(p.src) {
+		return nil, fmt.Errorf("%v: unexpected character at %d", errInvalidMapping, p.pos)
+	}
+
+	return c, nil
+}
+
+func (c conditionOr) test(ctx filters.FilterContext) bool {
+	for _, ci := range c {
+		if ci.test(ctx) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c conditionAnd) test(ctx filters.FilterContext) bool {
+	for _, ci := range c {
+		if !ci.test(ctx) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c conditionNot) test(ctx filters.FilterContext) bool {
+	return !c.c.test(ctx)
+}
+
+func (c *comparison) test(ctx filters.FilterContext) bool {
+	left, right := c.left.eval(ctx), c.right.eval(ctx)
+	switch c.op {
+	case "==":
+		return single(left) == single(right)
+	case "!=":
+		return single(left) != single(right)
+	default:
+		for _, l := range left {
+			for _, r := range right {
+				if l == r {
+					return true
+				}
+			}
+		}
+
+		return false
+	}
+}
+
+// Creates an allowIf filter specification. The filter rejects the
+// requests with 401, unless the condition set as its argument is met.
+func NewAllowIf(jsonErrors bool) filters.Spec { return allowIfSpec{jsonErrors: jsonErrors} }
+
+func (s allowIfSpec) Name() string { return AllowIfName }
+
+func (s allowIfSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	if len(args) != 1 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	src, ok := args[0].(string)
+	if !ok {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	c, err := parseCondition(src)
+	if err != nil {
+		return nil, err
+	}
+
+	return &allowIf{condition: c, jsonErrors: s.jsonErrors}, nil
+}
+
+func (f *allowIf) Request(ctx filters.FilterContext) {
+	if f.condition.test(ctx) {
+		return
+	}
+
+	uname, _ := ctx.StateBag()[authUserKey].(string)
+	unauthorized(ctx, uname, conditionFailed, f.jsonErrors)
+}
+
+func (f *allowIf) Response(_ filters.FilterContext) {}
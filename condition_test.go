@@ -0,0 +1,108 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	for _, ti := range []struct {
+		msg       string
+		condition string
+		fail      bool
+	}{{
+		msg:       "comparison",
+		condition: `realm == "/employees"`,
+	}, {
+		msg:       "in",
+		condition: `"ops" in teams`,
+	}, {
+		msg:       "logical operators",
+		condition: `!(realm != "/employees") && ("ops" in teams || lower(method) == "get")`,
+	}, {
+		msg:       "name starting with a keyword",
+		condition: `"x" in index`,
+	}, {
+		msg:       "missing operator",
+		condition: `realm`,
+		fail:      true,
+	}, {
+		msg:       "missing right side",
+		condition: `realm ==`,
+		fail:      true,
+	}, {
+		msg:       "unclosed parenthesis",
+		condition: `(realm == "/employees"`,
+		fail:      true,
+	}, {
+		msg:       "trailing characters",
+		condition: `realm == "/employees" realm`,
+		fail:      true,
+	}} {
+		_, err := parseCondition(ti.condition)
+		if ti.fail != (err != nil) {
+			t.Error(ti.msg, "unexpected result", err)
+		}
+	}
+}
+
+func TestAllowIf(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	for _, ti := range []struct {
+		msg        string
+		condition  string
+		method     string
+		statusCode int
+	}{{
+		msg:        "realm matches",
+		condition:  `realm == "/employees" || "ops" in teams`,
+		method:     "GET",
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "team matches",
+		condition:  `realm == "/services" || "ops" in teams`,
+		method:     "GET",
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "nothing matches",
+		condition:  `realm == "/services" || "dev" in teams`,
+		method:     "GET",
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "method",
+		condition:  `method == "GET" && !("admin" in scopes)`,
+		method:     "POST",
+		statusCode: http.StatusUnauthorized,
+	}} {
+		fr := make(filters.Registry)
+		fr.Register(stateBagFilter{
+			authDocKey:   &authDoc{Uid: testUid, Realm: "/employees", Scopes: []string{"read"}},
+			authTeamsKey: []string{"ops", "sre"}})
+		fr.Register(NewAllowIf(false))
+		proxy := proxytest.New(fr, &eskip.Route{
+			Filters: []*eskip.Filter{{Name: "testStateBag"}, {Name: AllowIfName, Args: []interface{}{ti.condition}}},
+			Backend: backend.URL})
+
+		req, err := http.NewRequest(ti.method, proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		proxy.Close()
+		if rsp.StatusCode != ti.statusCode {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode, ti.statusCode)
+		}
+	}
+}
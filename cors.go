@@ -0,0 +1,142 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters"
+	"net/http"
+	"strconv"
+)
+
+const (
+	originHeader           = "Origin"
+	requestMethodHeader    = "Access-Control-Request-Method"
+	requestHeadersHeader   = "Access-Control-Request-Headers"
+	allowOriginHeader      = "Access-Control-Allow-Origin"
+	allowMethodsHeader     = "Access-Control-Allow-Methods"
+	allowHeadersHeader     = "Access-Control-Allow-Headers"
+	allowCredentialsHeader = "Access-Control-Allow-Credentials"
+	maxAgeHeader           = "Access-Control-Max-Age"
+	varyHeader             = "Vary"
+)
+
+type (
+	corsSpec struct{}
+
+	corsFilter struct {
+		origins     []string
+		maxAge      int
+		credentials bool
+	}
+)
+
+// checks if the request is a CORS preflight request
+func isPreflight(r *http.Request) bool {
+	return r.Method == "OPTIONS" && r.Header.Get(requestMethodHeader) != ""
+}
+
+// Creates a corsHeaders filter specification. The filter answers the
+// CORS preflight requests from the origins set as its arguments, and
+// sets the Access-Control-Allow-Origin header of the responses to the
+// other requests from these origins. The origins can contain the *
+// wildcard. The preflight requests from other origins are rejected
+// with 403.
+//
+// Leading arguments in the form of name=value are options: maxAge sets
+// how long the browsers can cache the preflight responses, in seconds,
+// and credentials=true allows requests with credentials. The * origin,
+// allowing any origin, cannot be used together with credentials=true.
+func NewCorsHeaders() filters.Spec { return corsSpec{} }
+
+func (s corsSpec) Name() string { return CorsHeadersName }
+
+func (s corsSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	sargs, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &corsFilter{}
+	for len(sargs) > 0 {
+		name, value, ok := namedArg(sargs[0])
+		if !ok {
+			break
+		}
+
+		switch name {
+		case "maxAge":
+			f.maxAge, err = strconv.Atoi(value)
+			if err != nil || f.maxAge < 0 {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+		case "credentials":
+			f.credentials, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+		default:
+			return nil, filters.ErrInvalidFilterParameters
+		}
+
+		sargs = sargs[1:]
+	}
+
+	if len(sargs) == 0 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	// the credentials of any origin would be accepted
+	if f.credentials {
+		for _, o := range sargs {
+			if o == "*" {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+		}
+	}
+
+	f.origins = sargs
+	return f, nil
+}
+
+func (f *corsFilter) setAllowOrigin(h http.Header, origin string) {
+	h.Set(allowOriginHeader, origin)
+	h.Add(varyHeader, originHeader)
+	if f.credentials {
+		h.Set(allowCredentialsHeader, "true")
+	}
+}
+
+func (f *corsFilter) Request(ctx filters.FilterContext) {
+	r := ctx.Request()
+	if !isPreflight(r) {
+		return
+	}
+
+	origin := r.Header.Get(originHeader)
+	if origin == "" || !matchAny(f.origins, origin) {
+		ctx.Serve(&http.Response{StatusCode: http.StatusForbidden})
+		return
+	}
+
+	h := make(http.Header)
+	f.setAllowOrigin(h, origin)
+	h.Set(allowMethodsHeader, r.Header.Get(requestMethodHeader))
+	if rh := r.Header.Get(requestHeadersHeader); rh != "" {
+		h.Set(allowHeadersHeader, rh)
+	}
+
+	if f.maxAge > 0 {
+		h.Set(maxAgeHeader, strconv.Itoa(f.maxAge))
+	}
+
+	ctx.Serve(&http.Response{StatusCode: http.StatusNoContent, Header: h})
+}
+
+func (f *corsFilter) Response(ctx filters.FilterContext) {
+	r := ctx.Request()
+	if isPreflight(r) {
+		return
+	}
+
+	if origin := r.Header.Get(originHeader); origin != "" && matchAny(f.origins, origin) {
+		f.setAllowOrigin(ctx.Response().Header, origin)
+	}
+}
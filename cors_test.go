@@ -0,0 +1,100 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters/filtertest"
+	"net/http"
+	"testing"
+)
+
+func TestCorsHeaders(t *testing.T) {
+	for _, args := range [][]interface{}{nil, {"maxAge=600"}, {"maxAge=soon", "*"}, {"foo=bar", "*"},
+		{"credentials=true", "https://app.example.org", "*"}} {
+		if _, err := NewCorsHeaders().CreateFilter(args); err == nil {
+			t.Error("failed to fail", args)
+		}
+	}
+
+	f, err := NewCorsHeaders().CreateFilter([]interface{}{"maxAge=600", "https://*.example.org"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ti := range []struct {
+		msg         string
+		method      string
+		origin      string
+		preflight   bool
+		statusCode  int
+		allowOrigin string
+	}{{
+		msg:    "no origin",
+		method: "GET",
+	}, {
+		msg:         "allowed origin",
+		method:      "GET",
+		origin:      "https://app.example.org",
+		allowOrigin: "https://app.example.org",
+	}, {
+		msg:    "other origin",
+		method: "GET",
+		origin: "https://www.example.com",
+	}, {
+		msg:         "preflight",
+		method:      "OPTIONS",
+		origin:      "https://app.example.org",
+		preflight:   true,
+		statusCode:  http.StatusNoContent,
+		allowOrigin: "https://app.example.org",
+	}, {
+		msg:        "preflight from other origin",
+		method:     "OPTIONS",
+		origin:     "https://www.example.com",
+		preflight:  true,
+		statusCode: http.StatusForbidden,
+	}, {
+		msg:         "options without preflight",
+		method:      "OPTIONS",
+		origin:      "https://app.example.org",
+		allowOrigin: "https://app.example.org",
+	}} {
+		req, err := http.NewRequest(ti.method, "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if ti.origin != "" {
+			req.Header.Set(originHeader, ti.origin)
+		}
+
+		if ti.preflight {
+			req.Header.Set(requestMethodHeader, "PUT")
+		}
+
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		f.Request(ctx)
+		if ctx.FServedWithResponse != (ti.statusCode != 0) {
+			t.Error(ti.msg, "invalid served state", ctx.FServedWithResponse)
+			continue
+		}
+
+		if !ctx.FServedWithResponse {
+			ctx.FResponse = &http.Response{StatusCode: http.StatusOK, Header: make(http.Header)}
+			f.Response(ctx)
+		} else if ctx.FResponse.StatusCode != ti.statusCode {
+			t.Error(ti.msg, "invalid status code", ctx.FResponse.StatusCode)
+		}
+
+		h := ctx.FResponse.Header
+		if h == nil {
+			h = make(http.Header)
+		}
+
+		if h.Get(allowOriginHeader) != ti.allowOrigin {
+			t.Error(ti.msg, "invalid allowed origin", h.Get(allowOriginHeader))
+		}
+
+		if ti.statusCode == http.StatusNoContent && (h.Get(allowMethodsHeader) != "PUT" || h.Get(maxAgeHeader) != "600") {
+			t.Error(ti.msg, "invalid preflight response headers", h)
+		}
+	}
+}
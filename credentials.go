@@ -0,0 +1,118 @@
+package skoap
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/zalando/skipper/filters"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	envCredentialsPrefix  = "env:"
+	fileCredentialsPrefix = "file:"
+)
+
+// the minimum time between checking if a credentials file has changed
+var credentialsCheckInterval = time.Second
+
+// basicFile sets the outgoing basic authorization header from a
+// credentials file, and reloads the file when it changes.
+type basicFile struct {
+	path     string
+	mu       sync.Mutex
+	header   string
+	modTime  time.Time
+	lastStat time.Time
+}
+
+func basicHeader(credentials string) (string, error) {
+	credentials = strings.TrimSpace(credentials)
+	if !strings.Contains(credentials, ":") {
+		return "", errors.New("invalid credentials format, expected username:password")
+	}
+
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)), nil
+}
+
+func readCredentialsFile(path string) (string, time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	h, err := basicHeader(string(b))
+	return h, fi.ModTime(), err
+}
+
+// resolves the credentials referenced by the basicAuth filter argument,
+// either from an environment variable or from a file, containing the
+// username and the password separated by a colon.
+func credentialsFilter(ref string) (filters.Filter, error) {
+	switch {
+	case strings.HasPrefix(ref, envCredentialsPrefix):
+		v, ok := os.LookupEnv(strings.TrimPrefix(ref, envCredentialsPrefix))
+		if !ok {
+			return nil, filters.ErrInvalidFilterParameters
+		}
+
+		h, err := basicHeader(v)
+		if err != nil {
+			return nil, err
+		}
+
+		return basic(h), nil
+	default:
+		path := strings.TrimPrefix(ref, fileCredentialsPrefix)
+		h, modTime, err := readCredentialsFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		return &basicFile{path: path, header: h, modTime: modTime, lastStat: time.Now()}, nil
+	}
+}
+
+func isCredentialsRef(arg string) bool {
+	return strings.HasPrefix(arg, envCredentialsPrefix) || strings.HasPrefix(arg, fileCredentialsPrefix)
+}
+
+func (b *basicFile) current() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(b.lastStat) < credentialsCheckInterval {
+		return b.header
+	}
+
+	b.lastStat = now
+	fi, err := os.Stat(b.path)
+	if err != nil || fi.ModTime().Equal(b.modTime) {
+		return b.header
+	}
+
+	h, modTime, err := readCredentialsFile(b.path)
+	if err != nil {
+		log.Println("failed to reload credentials file:", err)
+		return b.header
+	}
+
+	b.header, b.modTime = h, modTime
+	return b.header
+}
+
+func (b *basicFile) Request(ctx filters.FilterContext) {
+	ctx.Request().Header.Set(authHeaderName, b.current())
+}
+
+func (b *basicFile) Response(_ filters.FilterContext) {}
@@ -0,0 +1,107 @@
+package skoap
+
+import (
+	"encoding/base64"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBasicAuthEnv(t *testing.T) {
+	os.Setenv("SKOAP_TEST_CREDS", "user9:secret")
+	defer os.Unsetenv("SKOAP_TEST_CREDS")
+
+	f, err := NewBasicAuth().CreateFilter([]interface{}{"env:SKOAP_TEST_CREDS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(f.(basic)) != "Basic "+base64.StdEncoding.EncodeToString([]byte("user9:secret")) {
+		t.Error("invalid header", f)
+	}
+
+	if _, err := NewBasicAuth().CreateFilter([]interface{}{"env:SKOAP_TEST_MISSING"}); err == nil {
+		t.Error("failed to fail on missing environment variable")
+	}
+}
+
+func TestBasicAuthFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skoap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "creds")
+	if err := ioutil.WriteFile(path, []byte("user9:secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewBasicAuth().CreateFilter([]interface{}{"file:" + path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bf := f.(*basicFile)
+	if bf.current() != "Basic "+base64.StdEncoding.EncodeToString([]byte("user9:secret")) {
+		t.Error("invalid header", bf.current())
+	}
+
+	if err := ioutil.WriteFile(path, []byte("user10:changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	bf.lastStat = time.Time{}
+	if bf.current() != "Basic "+base64.StdEncoding.EncodeToString([]byte("user10:changed")) {
+		t.Error("failed to reload the credentials", bf.current())
+	}
+}
+
+func TestBasicAuthFileProxy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skoap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "creds")
+	if err := ioutil.WriteFile(path, []byte("user9:secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		if user, pwd, ok := r.BasicAuth(); !ok || user != "user9" || pwd != "secret" {
+			t.Error("invalid credentials", user, pwd)
+		}
+	}))
+
+	defer backend.Close()
+
+	fr := make(filters.Registry)
+	fr.Register(NewBasicAuth())
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{Name: BasicAuthName, Args: []interface{}{"file:" + path}}},
+		Backend: backend.URL})
+	defer proxy.Close()
+
+	rsp, err := http.Get(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+}
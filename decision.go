@@ -0,0 +1,132 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters"
+	"log"
+	"net/http"
+)
+
+// Decision contains the details of an allow or deny decision made by
+// the auth filters.
+type Decision struct {
+
+	// The name of the filter making the decision, e.g. auth or authTeam.
+	Filter string
+
+	// The incoming request.
+	Method string
+	Host   string
+	Path   string
+
+	// The user id and the realm of the validated token. Empty when the
+	// token could not be validated.
+	User  string
+	Realm string
+
+	// The realm, and the scopes, teams or groups required by the
+	// filter.
+	RequiredRealm string
+	Required      []string
+
+	// The scopes, teams or groups of the user, when known.
+	Held []string
+
+	// Tells if the request was allowed, and when not, the reject
+	// reason, e.g. invalid-scope.
+	Allowed bool
+	Reason  string
+
+	// Tells that the filter runs in dry-run mode, and a denied request
+	// was let through.
+	DryRun bool
+}
+
+// DecisionLogger can be set in the auth filter options to receive
+// every decision made by the filters, independent of the auditLog
+// filter. Implementations must be safe for concurrent use, and should
+// return quickly, because they are called in the request path.
+type DecisionLogger interface {
+	LogDecision(*Decision)
+}
+
+// DecisionLoggerFunc implements the DecisionLogger interface with a
+// function.
+type DecisionLoggerFunc func(*Decision)
+
+// Calls the function.
+func (f DecisionLoggerFunc) LogDecision(d *Decision) { f(d) }
+
+func (f *filter) logDecision(ctx filters.FilterContext, a *authDoc, held []string, reason rejectReason) {
+	if f.decisionLogger == nil {
+		return
+	}
+
+	r := ctx.OriginalRequest()
+	d := &Decision{
+		Filter:        f.name,
+		Method:        r.Method,
+		Host:          r.Host,
+		Path:          r.URL.Path,
+		RequiredRealm: f.realm,
+		Required:      f.args,
+		Held:          held,
+		Allowed:       reason == "",
+		Reason:        string(reason),
+		DryRun:        reason != "" && f.isDryRun()}
+	if a != nil {
+		d.User = a.Uid
+		d.Realm = a.Realm
+	}
+
+	f.decisionLogger.LogDecision(d)
+}
+
+// tells whether the filter only records the denials. It can be turned
+// on only in the configuration of the spec or of the filter, because it
+// disables the enforcement, while the dry-run kill switch can turn it
+// off at runtime.
+func (f *filter) isDryRun() bool {
+	return f.dryRun && !KillSwitchOn(KillSwitchDryRun)
+}
+
+func (f *filter) reject(ctx filters.FilterContext, a *authDoc, held []string, reason rejectReason) {
+	f.rejectWithStatus(ctx, a, held, reason, http.StatusUnauthorized, nil)
+}
+
+func (f *filter) rejectWithStatus(ctx filters.FilterContext, a *authDoc, held []string, reason rejectReason, status int, h http.Header) {
+	f.logDecision(ctx, a, held, reason)
+	if f.isDryRun() {
+		// the would-be decision is recorded, and the identity of a valid
+		// token is passed on as if the request was allowed
+		ctx.StateBag()[authDryRunReasonKey] = string(reason)
+		if a != nil {
+			authorized(ctx, a)
+			ctx.StateBag()[authContextKey] = authContext(ctx)
+		}
+
+		return
+	}
+
+	var uid string
+	if a != nil {
+		uid = a.Uid
+	}
+
+	rejectWithHeader(ctx, status, uid, reason, f.jsonErrors, h)
+}
+
+func (f *filter) allow(ctx filters.FilterContext, a *authDoc, held []string) {
+	f.logDecision(ctx, a, held, "")
+	authorized(ctx, a)
+	if f.userInfoClient != nil {
+		// the claims are optional, the request is allowed without them
+		token, _ := ctx.StateBag()[authTokenKey].(string)
+		if claims, err := f.userInfoClient.getClaims(ctx.Request().Context(), token); err != nil {
+			log.Println(err)
+		} else {
+			ctx.StateBag()[authClaimsKey] = claims
+		}
+	}
+
+	ctx.StateBag()[authContextKey] = authContext(ctx)
+}
@@ -0,0 +1,188 @@
+package skoap
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecisionLogger(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer authServer.Close()
+
+	for _, ti := range []struct {
+		msg      string
+		args     []interface{}
+		hasAuth  bool
+		allowed  bool
+		reason   string
+		user     string
+		required int
+	}{{
+		msg:    "missing token",
+		reason: string(missingBearerToken),
+	}, {
+		msg:      "invalid scope",
+		args:     []interface{}{testRealm, "other-scope"},
+		hasAuth:  true,
+		reason:   string(invalidScope),
+		user:     testUid,
+		required: 1,
+	}, {
+		msg:      "allowed",
+		args:     []interface{}{testRealm, "other-scope", testScope},
+		hasAuth:  true,
+		allowed:  true,
+		user:     testUid,
+		required: 2,
+	}} {
+		var decisions []*Decision
+		s := NewAuthWithOptions(Options{
+			AuthUrlBase: authServer.URL,
+			DecisionLogger: DecisionLoggerFunc(func(d *Decision) {
+				decisions = append(decisions, d)
+			})})
+
+		fr := make(filters.Registry)
+		fr.Register(s)
+		r := &eskip.Route{Filters: []*eskip.Filter{{Name: s.Name(), Args: ti.args}}, Backend: backend.URL}
+		proxy := proxytest.New(fr, r)
+
+		req, err := http.NewRequest("GET", proxy.URL+"/foo", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if ti.hasAuth {
+			req.Header.Set(authHeaderName, "Bearer "+testToken)
+		}
+
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+
+		if len(decisions) != 1 {
+			t.Error(ti.msg, "invalid number of decisions", len(decisions))
+			continue
+		}
+
+		d := decisions[0]
+		if d.Filter != AuthName || d.Method != "GET" || d.Path != "/foo" || d.Allowed != ti.allowed ||
+			d.Reason != ti.reason || d.User != ti.user || len(d.Required) != ti.required {
+			t.Error(ti.msg, "invalid decision", d)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(&authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}})
+	}))
+	defer authServer.Close()
+	defer SetKillSwitch(KillSwitchDryRun, false)
+
+	for _, ti := range []struct {
+		msg        string
+		dryRun     bool
+		killSwitch bool
+		args       []interface{}
+		statusCode int
+	}{{
+		msg:        "enforced",
+		args:       []interface{}{testRealm, "new-scope"},
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "dry-run option",
+		dryRun:     true,
+		args:       []interface{}{testRealm, "new-scope"},
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "dry-run filter option",
+		args:       []interface{}{"dryRun=true", testRealm, "new-scope"},
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "filter option overriding the spec",
+		dryRun:     true,
+		args:       []interface{}{"dryRun=false", testRealm, "new-scope"},
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "kill switch enforcing the decisions",
+		dryRun:     true,
+		killSwitch: true,
+		args:       []interface{}{"dryRun=true", testRealm, "new-scope"},
+		statusCode: http.StatusUnauthorized,
+	}} {
+		SetKillSwitch(KillSwitchDryRun, ti.killSwitch)
+		var decisions []*Decision
+		s := NewAuthWithOptions(Options{
+			AuthUrlBase: authServer.URL,
+			DryRun:      ti.dryRun,
+			DecisionLogger: DecisionLoggerFunc(func(d *Decision) {
+				decisions = append(decisions, d)
+			})})
+
+		var out bytes.Buffer
+		al := NewAuditLog(&out)
+		fr := make(filters.Registry)
+		fr.Register(s)
+		fr.Register(al)
+		proxy := proxytest.New(fr, &eskip.Route{
+			Filters: []*eskip.Filter{
+				{Name: AuditLogName},
+				{Name: s.Name(), Args: ti.args}},
+			Backend: backend.URL})
+
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		proxy.Close()
+		flushAuditLog(al)
+
+		if rsp.StatusCode != ti.statusCode {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode)
+		}
+
+		dryRun := ti.statusCode == http.StatusOK
+		if len(decisions) != 1 || decisions[0].Allowed || decisions[0].DryRun != dryRun {
+			t.Error(ti.msg, "invalid decision", decisions)
+		}
+
+		var doc AuditDoc
+		if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if doc.AuthStatus == nil || doc.AuthStatus.User != testUid ||
+			doc.AuthStatus.Rejected == dryRun || dryRun && doc.AuthStatus.DryRunReason != string(invalidScope) {
+			t.Error(ti.msg, "invalid audit entry", doc.AuthStatus)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package skoap
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// a component is reported as degraded for this long after it dropped
+// an entry
+const degradationWindow = time.Minute
+
+// DegradationStats contains the state of a component that relaxes its
+// guarantees under load or failures.
+type DegradationStats struct {
+	Name     string `json:"name"`
+	Degraded bool   `json:"degraded"`
+	Detail   string `json:"detail,omitempty"`
+	Queued   int    `json:"queued,omitempty"`
+	Dropped  uint64 `json:"dropped,omitempty"`
+}
+
+// DegradationReport summarizes which guarantees skoap is currently
+// relaxing: the kill switches turned on, whether the memory budget is
+// exceeded, and the state of the audit buffers and the service tokens.
+type DegradationReport struct {
+	Degraded         bool               `json:"degraded"`
+	KillSwitches     []string           `json:"killSwitches,omitempty"`
+	OverMemoryBudget bool               `json:"overMemoryBudget"`
+	Components       []DegradationStats `json:"components,omitempty"`
+}
+
+// degradable is implemented by the components that can relax their
+// guarantees.
+type degradable interface {
+	degradation() DegradationStats
+}
+
+var degradables struct {
+	mu         sync.Mutex
+	components []degradable
+}
+
+func init() {
+	expvar.Publish("skoap-degradation", expvar.Func(func() interface{} { return Degradation() }))
+}
+
+func registerDegradable(d degradable) {
+	degradables.mu.Lock()
+	defer degradables.mu.Unlock()
+	degradables.components = append(degradables.components, d)
+}
+
+// tells whether a drop stored as unix nanoseconds happened recently
+func droppedRecently(lastDrop *int64) bool {
+	t := atomic.LoadInt64(lastDrop)
+	return t != 0 && time.Since(time.Unix(0, t)) < degradationWindow
+}
+
+// tells whether a buffer is at least three quarters full
+func spooling(queued, size int) bool {
+	return size > 0 && queued*4 >= size*3
+}
+
+// Returns the current degradation state.
+func Degradation() DegradationReport {
+	degradables.mu.Lock()
+	components := degradables.components
+	degradables.mu.Unlock()
+
+	r := DegradationReport{OverMemoryBudget: overMemoryBudget()}
+	for _, name := range KillSwitchNames() {
+		if KillSwitchOn(name) {
+			r.KillSwitches = append(r.KillSwitches, name)
+		}
+	}
+
+	r.Degraded = r.OverMemoryBudget || len(r.KillSwitches) > 0
+	for _, c := range components {
+		s := c.degradation()
+		r.Degraded = r.Degraded || s.Degraded
+		r.Components = append(r.Components, s)
+	}
+
+	return r
+}
+
+// Creates an HTTP handler returning the degradation state as JSON.
+func NewDegradationHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Degradation())
+	})
+}
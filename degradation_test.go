@@ -0,0 +1,85 @@
+package skoap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type blockingSink chan struct{}
+
+func (s blockingSink) Log(*AuditDoc) error {
+	<-s
+	return nil
+}
+
+func findDegradation(r DegradationReport, name string) (DegradationStats, bool) {
+	for _, s := range r.Components {
+		if s.Name == name {
+			return s, true
+		}
+	}
+
+	return DegradationStats{}, false
+}
+
+func TestDegradationKillSwitch(t *testing.T) {
+	defer SetKillSwitch(KillSwitchCaching, false)
+	SetKillSwitch(KillSwitchCaching, true)
+
+	rsp := httptest.NewRecorder()
+	NewDegradationHandler().ServeHTTP(rsp, &http.Request{Method: "GET"})
+
+	var r DegradationReport
+	if err := json.NewDecoder(rsp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.Degraded || len(r.KillSwitches) != 1 || r.KillSwitches[0] != KillSwitchCaching {
+		t.Error("invalid degradation report", r)
+	}
+}
+
+func TestDegradationAuditBuffer(t *testing.T) {
+	block := make(blockingSink)
+	defer close(block)
+
+	s := NewAsyncSink(block, 4).(*asyncSink)
+	if s.degradation().Degraded {
+		t.Error("empty buffer reported as degraded")
+	}
+
+	for i := 0; i < 8; i++ {
+		s.Log(&AuditDoc{})
+	}
+
+	d := s.degradation()
+	if !d.Degraded || d.Dropped == 0 {
+		t.Error("full buffer not reported as degraded", d)
+	}
+}
+
+func TestDegradationServiceToken(t *testing.T) {
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer tokens.Close()
+
+	newServiceToken(ServiceTokenOptions{TokenUrl: tokens.URL}, []string{"degradation-test"})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		d, ok := findDegradation(Degradation(), "service-token degradation-test")
+		if ok && d.Degraded {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("missing service token not reported as degraded", d)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,122 @@
+package skoap
+
+import (
+	"fmt"
+	"github.com/zalando/skipper/eskip"
+	"sort"
+	"strings"
+)
+
+// ChangeType tells how the authentication of a route changed between
+// two versions of a route configuration.
+type ChangeType string
+
+const (
+	RouteAdded   ChangeType = "route-added"
+	RouteRemoved ChangeType = "route-removed"
+	AuthAdded    ChangeType = "auth-added"
+	AuthRemoved  ChangeType = "auth-removed"
+	AuthChanged  ChangeType = "auth-changed"
+)
+
+const (
+	unprotected = "none"
+	filterSep   = " -> "
+)
+
+// AuthChange describes a security relevant change of a route. The Old
+// and New fields contain the auth filters of the route, in eskip
+// format, or "none" when the route has no auth filters.
+type AuthChange struct {
+	RouteId string
+	Type    ChangeType
+	Old     string
+	New     string
+}
+
+func (c AuthChange) String() string {
+	switch c.Type {
+	case RouteAdded:
+		return fmt.Sprintf("%s %s: %s", c.Type, c.RouteId, c.New)
+	case RouteRemoved:
+		return fmt.Sprintf("%s %s: %s", c.Type, c.RouteId, c.Old)
+	default:
+		return fmt.Sprintf("%s %s: %s => %s", c.Type, c.RouteId, c.Old, c.New)
+	}
+}
+
+func filterString(f *eskip.Filter) string {
+	args := make([]string, len(f.Args))
+	for i, a := range f.Args {
+		if s, ok := a.(string); ok {
+			args[i] = fmt.Sprintf("%q", s)
+		} else {
+			args[i] = fmt.Sprint(a)
+		}
+	}
+
+	return fmt.Sprintf("%s(%s)", f.Name, strings.Join(args, ", "))
+}
+
+func authString(r *eskip.Route) string {
+	var fs []string
+	for _, f := range r.Filters {
+		if authFilterNames[f.Name] {
+			fs = append(fs, filterString(f))
+		}
+	}
+
+	if len(fs) == 0 {
+		return unprotected
+	}
+
+	return strings.Join(fs, filterSep)
+}
+
+func routeMap(routes []*eskip.Route) map[string]*eskip.Route {
+	m := make(map[string]*eskip.Route)
+	for _, r := range routes {
+		m[r.Id] = r
+	}
+
+	return m
+}
+
+// DiffAuth compares two versions of a route configuration, and returns
+// the changes of the auth filters of the routes, matched by the route
+// ids. Routes without any change in their auth filters are not
+// reported. The changes are sorted by the route id.
+func DiffAuth(oldRoutes, newRoutes []*eskip.Route) []AuthChange {
+	oldMap, newMap := routeMap(oldRoutes), routeMap(newRoutes)
+	var changes []AuthChange
+
+	for id, r := range oldMap {
+		if _, ok := newMap[id]; !ok {
+			changes = append(changes, AuthChange{RouteId: id, Type: RouteRemoved, Old: authString(r)})
+		}
+	}
+
+	for id, r := range newMap {
+		na := authString(r)
+		or, ok := oldMap[id]
+		if !ok {
+			changes = append(changes, AuthChange{RouteId: id, Type: RouteAdded, New: na})
+			continue
+		}
+
+		oa := authString(or)
+		switch {
+		case oa == na:
+			continue
+		case oa == unprotected:
+			changes = append(changes, AuthChange{RouteId: id, Type: AuthAdded, Old: oa, New: na})
+		case na == unprotected:
+			changes = append(changes, AuthChange{RouteId: id, Type: AuthRemoved, Old: oa, New: na})
+		default:
+			changes = append(changes, AuthChange{RouteId: id, Type: AuthChanged, Old: oa, New: na})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].RouteId < changes[j].RouteId })
+	return changes
+}
@@ -0,0 +1,62 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"testing"
+)
+
+func TestDiffAuth(t *testing.T) {
+	oldRoutes := []*eskip.Route{{
+		Id:      "unchanged",
+		Filters: []*eskip.Filter{{Name: AuthName, Args: []interface{}{"/employees"}}},
+	}, {
+		Id:      "removed",
+		Filters: []*eskip.Filter{{Name: AuthName}},
+	}, {
+		Id:      "protected",
+		Filters: []*eskip.Filter{{Name: AuditLogName}},
+	}, {
+		Id:      "unprotected",
+		Filters: []*eskip.Filter{{Name: AuthTeamName, Args: []interface{}{"", "teapot"}}},
+	}, {
+		Id:      "scopes",
+		Filters: []*eskip.Filter{{Name: AuthName, Args: []interface{}{"", "read"}}},
+	}}
+
+	newRoutes := []*eskip.Route{{
+		Id: "unchanged",
+		Filters: []*eskip.Filter{
+			{Name: AuditLogName, Args: []interface{}{float64(1024)}},
+			{Name: AuthName, Args: []interface{}{"/employees"}}},
+	}, {
+		Id: "added",
+	}, {
+		Id:      "protected",
+		Filters: []*eskip.Filter{{Name: AuditLogName}, {Name: AuthName}},
+	}, {
+		Id:      "unprotected",
+		Filters: []*eskip.Filter{{Name: AuditLogName}},
+	}, {
+		Id:      "scopes",
+		Filters: []*eskip.Filter{{Name: AuthName, Args: []interface{}{"", "read", "write"}}},
+	}}
+
+	expected := []string{
+		`route-added added: none`,
+		`auth-added protected: none => auth()`,
+		`route-removed removed: auth()`,
+		`auth-changed scopes: auth("", "read") => auth("", "read", "write")`,
+		`auth-removed unprotected: authTeam("", "teapot") => none`,
+	}
+
+	changes := DiffAuth(oldRoutes, newRoutes)
+	if len(changes) != len(expected) {
+		t.Fatal("invalid number of changes", changes)
+	}
+
+	for i, c := range changes {
+		if c.String() != expected[i] {
+			t.Error("invalid change", c.String(), expected[i])
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package skoap
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/zalando/skipper/filters"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tokenExchangeGrant = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType    = "urn:ietf:params:oauth:token-type:access_token"
+
+	// the max number of exchanged tokens cached for an audience and
+	// scopes
+	maxExchangedTokens = 4096
+)
+
+const tokenExchangeFailed rejectReason = "token-exchange-failed"
+
+type (
+	exchangeTokenSpec struct {
+		options ServiceTokenOptions
+
+		// the exchanged tokens are cached by the subject token, in a
+		// separate cache for every audience and scopes, so that they
+		// are purged together with the subject token on logout
+		mu     sync.Mutex
+		caches map[string]*ttlCache
+	}
+
+	exchangeToken struct {
+		options  ServiceTokenOptions
+		audience string
+		scopes   []string
+		cache    *ttlCache
+	}
+)
+
+// Creates an exchangeToken filter specification. The filter exchanges
+// the incoming token, validated by a preceding auth filter, for a token
+// issued for the backend, using the OAuth2 token exchange grant, and
+// sets it as the outgoing Authorization header. The arguments are the
+// requested scopes, optionally preceded by the audience, e.g.
+// "audience=orders". The exchanged tokens are cached until they expire.
+func NewExchangeToken(o ServiceTokenOptions) filters.Spec {
+	return &exchangeTokenSpec{options: o, caches: make(map[string]*ttlCache)}
+}
+
+// returns the cache of the tokens exchanged for an audience and scopes
+func (s *exchangeTokenSpec) cache(audience string, scopes []string) *ttlCache {
+	key := audience + "\x00" + strings.Join(scopes, " ")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.caches[key]
+	if !ok {
+		c = newTTLCache("exchanged-tokens", 0, maxExchangedTokens)
+		registerTokenCache(c)
+		s.caches[key] = c
+	}
+
+	return c
+}
+
+func (s *exchangeTokenSpec) Name() string { return ExchangeTokenName }
+
+func (s *exchangeTokenSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	if s.options.TokenUrl == "" {
+		return nil, errMissingTokenUrl
+	}
+
+	sargs, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &exchangeToken{options: s.options}
+	if len(sargs) > 0 {
+		if name, value, ok := namedArg(sargs[0]); ok {
+			if name != "audience" {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+
+			f.audience = value
+			sargs = sargs[1:]
+		}
+	}
+
+	f.scopes = sargs
+	f.cache = s.cache(f.audience, f.scopes)
+	return f, nil
+}
+
+func (f *exchangeToken) exchange(subjectToken string) (string, time.Duration, error) {
+	form := url.Values{
+		"grant_type":         {tokenExchangeGrant},
+		"subject_token":      {subjectToken},
+		"subject_token_type": {accessTokenType}}
+	if f.audience != "" {
+		form.Set("audience", f.audience)
+	}
+
+	if len(f.scopes) > 0 {
+		form.Set("scope", strings.Join(f.scopes, " "))
+	}
+
+	req, err := http.NewRequest("POST", f.options.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(f.options.ClientId, f.options.ClientSecret)
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("failed to exchange token: %s", rsp.Status)
+	}
+
+	var d serviceTokenDoc
+	if err := json.NewDecoder(rsp.Body).Decode(&d); err != nil {
+		return "", 0, err
+	}
+
+	return d.AccessToken, time.Duration(d.ExpiresIn) * time.Second, nil
+}
+
+func (f *exchangeToken) Request(ctx filters.FilterContext) {
+	uname, _ := ctx.StateBag()[authUserKey].(string)
+	subjectToken, ok := ctx.StateBag()[authTokenKey].(string)
+	if !ok {
+		unauthorized(ctx, uname, missingBearerToken, false)
+		return
+	}
+
+	now := time.Now()
+	cached, ok := f.cache.get(subjectToken, now)
+	token, _ := cached.(string)
+	if !ok {
+		var (
+			expiresIn time.Duration
+			err       error
+		)
+
+		token, expiresIn, err = f.exchange(subjectToken)
+		if err != nil {
+			log.Println(err)
+			reject(ctx, http.StatusServiceUnavailable, uname, tokenExchangeFailed, false)
+			return
+		}
+
+		f.cache.setUntil(subjectToken, token, now.Add(expiresIn))
+	}
+
+	ctx.Request().Header.Set(authHeaderName, "Bearer "+token)
+}
+
+func (f *exchangeToken) Response(_ filters.FilterContext) {}
@@ -0,0 +1,97 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExchangeToken(t *testing.T) {
+	var exchanges int
+	tokenService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != tokenExchangeGrant ||
+			r.FormValue("subject_token") != testToken ||
+			r.FormValue("audience") != "orders" ||
+			r.FormValue("scope") != "read-orders" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		exchanges++
+		w.Write([]byte(`{"access_token": "backend-token", "expires_in": 3600}`))
+	}))
+	defer tokenService.Close()
+
+	var auth string
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get(authHeaderName)
+	}))
+	defer backend.Close()
+
+	for _, ti := range []struct {
+		msg        string
+		filters    []*eskip.Filter
+		statusCode int
+		auth       string
+	}{{
+		msg:        "not authenticated",
+		filters:    []*eskip.Filter{{Name: ExchangeTokenName, Args: []interface{}{"audience=orders", "read-orders"}}},
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg: "exchange failed",
+		filters: []*eskip.Filter{
+			{Name: "testStateBag"},
+			{Name: ExchangeTokenName, Args: []interface{}{"audience=payments", "read-orders"}}},
+		statusCode: http.StatusServiceUnavailable,
+	}, {
+		msg: "exchanged",
+		filters: []*eskip.Filter{
+			{Name: "testStateBag"},
+			{Name: ExchangeTokenName, Args: []interface{}{"audience=orders", "read-orders"}}},
+		statusCode: http.StatusOK,
+		auth:       "Bearer backend-token",
+	}} {
+		auth = ""
+		fr := make(filters.Registry)
+		fr.Register(NewExchangeToken(ServiceTokenOptions{TokenUrl: tokenService.URL}))
+		fr.Register(stateBagFilter{authTokenKey: testToken})
+		proxy := proxytest.New(fr, &eskip.Route{Filters: ti.filters, Backend: backend.URL})
+
+		for i := 0; i < 2; i++ {
+			rsp, err := http.Get(proxy.URL)
+			if err != nil {
+				t.Fatal(ti.msg, err)
+			}
+
+			rsp.Body.Close()
+			if rsp.StatusCode != ti.statusCode || auth != ti.auth {
+				t.Error(ti.msg, "invalid response", rsp.StatusCode, auth)
+			}
+		}
+
+		proxy.Close()
+	}
+
+	if exchanges != 1 {
+		t.Error("failed to cache the exchanged token", exchanges)
+	}
+}
+
+func TestExchangedTokensPurged(t *testing.T) {
+	s := NewExchangeToken(ServiceTokenOptions{TokenUrl: "https://auth.example.org/token"})
+	f, err := s.CreateFilter([]interface{}{"audience=purged", "read-orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := f.(*exchangeToken).cache
+	c.set("purged-subject-token", "backend-token", time.Now())
+	purgeToken("purged-subject-token")
+	if _, ok := c.get("purged-subject-token", time.Now()); ok {
+		t.Error("failed to purge the exchanged token")
+	}
+}
@@ -0,0 +1,120 @@
+package skoap
+
+import (
+	"fmt"
+	"github.com/zalando/skipper/filters"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServiceFailurePolicy tells how the auth filters handle the requests,
+// when the token validation, team or group service cannot be reached.
+type ServiceFailurePolicy string
+
+const (
+
+	// The requests are rejected with 401.
+	FailClosed ServiceFailurePolicy = "closed"
+
+	// When the token validation service is down, the GET, HEAD and
+	// OPTIONS requests are let through without an identity. The other
+	// requests, the failures of the team and group services, and the
+	// failures while the service is otherwise healthy, are rejected
+	// with 401.
+	FailOpenReadOnly ServiceFailurePolicy = "open-read-only"
+
+	// The requests are rejected with 503, the Retry-After header, and
+	// the reject reason in the X-Auth-Error header. This is the
+	// default, so that the clients can tell the failures of the
+	// infrastructure from invalid tokens.
+	FailUnavailable ServiceFailurePolicy = "unavailable"
+)
+
+const (
+	authFailedOpen anomaly = "auth-failed-open"
+
+	defaultRetryAfter = 30 * time.Second
+	authErrorHeader   = "X-Auth-Error"
+)
+
+// failOpenCounter reports the requests let through without token
+// validation in the degradation report
+type failOpenCounter struct {
+	count    uint64
+	lastOpen int64
+}
+
+var (
+	failedOpen         = &failOpenCounter{}
+	registerFailedOpen sync.Once
+)
+
+// the counter is reported only when a filter uses the fail-open policy
+func reportFailedOpen() {
+	registerFailedOpen.Do(func() { registerDegradable(failedOpen) })
+}
+
+func (c *failOpenCounter) record() {
+	atomic.AddUint64(&c.count, 1)
+	atomic.StoreInt64(&c.lastOpen, time.Now().UnixNano())
+}
+
+// the fail-open mode is reported as active while the token validation
+// service is down, unless the kill switch disables it
+func (c *failOpenCounter) degradation() DegradationStats {
+	var state string
+	active := authServiceDown() && !KillSwitchOn(KillSwitchFailOpen)
+	switch {
+	case KillSwitchOn(KillSwitchFailOpen):
+		state = "disabled by the kill switch"
+	case active:
+		state = "active"
+	default:
+		state = "inactive"
+	}
+
+	return DegradationStats{
+		Name:     "auth-fail-open",
+		Degraded: active || droppedRecently(&c.lastOpen),
+		Detail: fmt.Sprintf(
+			"fail-open %s, %d requests let through without token validation",
+			state,
+			atomic.LoadUint64(&c.count))}
+}
+
+func validFailurePolicy(p ServiceFailurePolicy) bool {
+	switch p {
+	case FailClosed, FailOpenReadOnly, FailUnavailable:
+		return true
+	default:
+		return false
+	}
+}
+
+func isReadOnly(r *http.Request) bool {
+	return r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS"
+}
+
+// handles the failed service calls according to the failure policy of
+// the filter
+func (f *filter) serviceFailure(ctx filters.FilterContext, a *authDoc, reason rejectReason) {
+	switch f.failurePolicy {
+	case FailOpenReadOnly:
+		if reason == authServiceAccess && isReadOnly(ctx.Request()) && authServiceDown() && !KillSwitchOn(KillSwitchFailOpen) {
+			reportAnomaly(ctx, authFailedOpen)
+			failedOpen.record()
+			return
+		}
+	case FailUnavailable:
+		h := http.Header{
+			"Retry-After":   []string{strconv.Itoa(int(f.retryAfter / time.Second))},
+			authErrorHeader: []string{string(reason)}}
+		f.rejectWithStatus(ctx, a, nil, reason, http.StatusServiceUnavailable, h)
+		return
+	}
+
+	f.reject(ctx, a, nil, reason)
+}
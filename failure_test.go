@@ -0,0 +1,256 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/filters/filtertest"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServiceFailurePolicy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer authServer.Close()
+
+	if _, err := NewAuth(authServer.URL).CreateFilter([]interface{}{"serviceFailure=sometimes"}); err == nil {
+		t.Error("failed to fail on invalid policy")
+	}
+
+	// the requests are let through only when the service is down
+	markAuthServiceReached()
+	for i := 0; i < authServiceDownFailures; i++ {
+		markAuthServiceFailed()
+	}
+
+	defer markAuthServiceReached()
+
+	for _, ti := range []struct {
+		msg        string
+		policy     ServiceFailurePolicy
+		args       []interface{}
+		method     string
+		status     int
+		retryAfter string
+	}{{
+		msg:        "default",
+		method:     "GET",
+		status:     http.StatusServiceUnavailable,
+		retryAfter: "30",
+	}, {
+		msg:    "closed",
+		policy: FailClosed,
+		method: "GET",
+		status: http.StatusUnauthorized,
+	}, {
+		msg:    "open for read-only",
+		policy: FailOpenReadOnly,
+		method: "GET",
+		status: http.StatusOK,
+	}, {
+		msg:    "closed for writes",
+		policy: FailOpenReadOnly,
+		method: "POST",
+		status: http.StatusUnauthorized,
+	}, {
+		msg:        "unavailable",
+		policy:     FailUnavailable,
+		method:     "POST",
+		status:     http.StatusServiceUnavailable,
+		retryAfter: "30",
+	}, {
+		msg:    "route overrides the spec",
+		policy: FailUnavailable,
+		args:   []interface{}{"serviceFailure=open-read-only"},
+		method: "HEAD",
+		status: http.StatusOK,
+	}} {
+		s := NewAuthWithOptions(Options{AuthUrlBase: authServer.URL, ServiceFailurePolicy: ti.policy})
+		fr := make(filters.Registry)
+		fr.Register(s)
+		proxy := proxytest.New(fr, &eskip.Route{
+			Filters: []*eskip.Filter{{Name: s.Name(), Args: ti.args}},
+			Backend: backend.URL})
+
+		req, err := http.NewRequest(ti.method, proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		rsp, err := http.DefaultClient.Do(req)
+		proxy.Close()
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		if rsp.StatusCode != ti.status {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode)
+		}
+
+		if rsp.Header.Get("Retry-After") != ti.retryAfter {
+			t.Error(ti.msg, "invalid Retry-After header", rsp.Header.Get("Retry-After"))
+		}
+
+		if ti.status == http.StatusServiceUnavailable && rsp.Header.Get(authErrorHeader) != string(authServiceAccess) {
+			t.Error(ti.msg, "invalid reject reason header", rsp.Header.Get(authErrorHeader))
+		}
+	}
+
+	if d, ok := findDegradation(Degradation(), "auth-fail-open"); !ok || !d.Degraded {
+		t.Error("failed to report the requests let through", d)
+	}
+}
+
+func TestServiceFailureHealthyService(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer authServer.Close()
+
+	markAuthServiceReached()
+	defer markAuthServiceReached()
+
+	f, err := NewAuthWithOptions(Options{AuthUrlBase: authServer.URL, ServiceFailurePolicy: FailOpenReadOnly}).CreateFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "https://www.example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set(authHeaderName, "Bearer "+testToken)
+	ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+	f.Request(ctx)
+	if !ctx.FServedWithResponse || ctx.FResponse.StatusCode != http.StatusUnauthorized {
+		t.Error("failed to reject the request when a single validation fails")
+	}
+}
+
+func TestTeamServiceFailure(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"uid": "jdoe", "realm": "/immortals"}`))
+	}))
+	defer authServer.Close()
+
+	teamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer teamServer.Close()
+
+	for _, ti := range []struct {
+		msg    string
+		policy ServiceFailurePolicy
+		method string
+		status int
+	}{{
+		msg:    "default",
+		method: "GET",
+		status: http.StatusServiceUnavailable,
+	}, {
+		msg:    "open for read-only applies only to the token validation",
+		policy: FailOpenReadOnly,
+		method: "GET",
+		status: http.StatusUnauthorized,
+	}} {
+		s := NewAuthTeamWithOptions(Options{
+			AuthUrlBase:          authServer.URL,
+			TeamUrlBase:          teamServer.URL,
+			ServiceFailurePolicy: ti.policy})
+		f, err := s.CreateFilter([]interface{}{testRealm, testTeam})
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest(ti.method, "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		f.Request(ctx)
+
+		if !ctx.FServedWithResponse || ctx.FResponse.StatusCode != ti.status {
+			t.Error(ti.msg, "invalid response", ctx.FResponse)
+		}
+
+		if reason, _ := ctx.FStateBag[authRejectReasonKey].(string); reason != string(teamServiceAccess) {
+			t.Error(ti.msg, "invalid reject reason", reason)
+		}
+	}
+}
+
+func TestServiceFailureKillSwitch(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer authServer.Close()
+
+	markAuthServiceReached()
+	for i := 0; i < authServiceDownFailures; i++ {
+		markAuthServiceFailed()
+	}
+
+	defer markAuthServiceReached()
+	defer SetKillSwitch(KillSwitchFailOpen, false)
+
+	s := NewAuthWithOptions(Options{AuthUrlBase: authServer.URL, ServiceFailurePolicy: FailOpenReadOnly})
+	fr := make(filters.Registry)
+	fr.Register(s)
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{Name: s.Name()}},
+		Backend: backend.URL})
+	defer proxy.Close()
+
+	for _, ti := range []struct {
+		msg        string
+		killSwitch bool
+		status     int
+		detail     string
+	}{{
+		msg:    "fail-open active",
+		status: http.StatusOK,
+		detail: "fail-open active",
+	}, {
+		msg:        "fail-open disabled by the kill switch",
+		killSwitch: true,
+		status:     http.StatusUnauthorized,
+		detail:     "fail-open disabled by the kill switch",
+	}} {
+		SetKillSwitch(KillSwitchFailOpen, ti.killSwitch)
+
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		if rsp.StatusCode != ti.status {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode)
+		}
+
+		if d, ok := findDegradation(Degradation(), "auth-fail-open"); !ok || !strings.HasPrefix(d.Detail, ti.detail) {
+			t.Error(ti.msg, "invalid degradation report", d)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package skoap
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditFormat selects the serialization of the audit log entries
+// written by the writer and file sinks.
+type AuditFormat string
+
+const (
+	// AuditFormatJSON encodes the entries as JSON objects. This is the
+	// default.
+	AuditFormatJSON AuditFormat = "json"
+
+	// AuditFormatCLF encodes the entries in the Common Log Format. The
+	// time is the time of writing the entry, and the size of the
+	// response is not known. The request line contains the query, when
+	// captured, and the protocol.
+	AuditFormatCLF AuditFormat = "clf"
+
+	// AuditFormatLogfmt encodes the entries as key=value pairs. The
+	// fields of the nested documents and the captured headers are
+	// prefixed with the name of the document, e.g. tls.version.
+	AuditFormatLogfmt AuditFormat = "logfmt"
+)
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// Parses the name of an audit log format. Empty means JSON.
+func ParseAuditFormat(s string) (AuditFormat, error) {
+	switch f := AuditFormat(strings.ToLower(s)); f {
+	case "":
+		return AuditFormatJSON, nil
+	case AuditFormatJSON, AuditFormatCLF, AuditFormatLogfmt:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid audit log format: %s", s)
+	}
+}
+
+// Encodes an audit log entry, without a trailing newline.
+func (f AuditFormat) Encode(d *AuditDoc) ([]byte, error) {
+	switch f {
+	case AuditFormatCLF:
+		return []byte(formatCLF(d, time.Now())), nil
+	case AuditFormatLogfmt:
+		return []byte(formatLogfmt(d)), nil
+	default:
+		return json.Marshal(d)
+	}
+}
+
+func clfValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+
+	return v
+}
+
+func formatCLF(d *AuditDoc, now time.Time) string {
+	var user string
+	if d.AuthStatus != nil {
+		user = d.AuthStatus.User
+	}
+
+	request := d.Method + " " + d.Path
+	if d.Query != "" {
+		request += "?" + d.Query
+	}
+
+	if d.Proto != "" {
+		request += " " + d.Proto
+	}
+
+	return fmt.Sprintf(
+		`%s - %s [%s] "%s" %d -`,
+		clfValue(d.RemoteAddr),
+		clfValue(strings.Replace(user, " ", "_", -1)),
+		now.Format(clfTimeFormat),
+		strings.Replace(request, `"`, `\"`, -1),
+		d.Status)
+}
+
+func logfmtValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " =\"\\\t\n") {
+		return v
+	}
+
+	return strconv.Quote(v)
+}
+
+func formatLogfmt(d *AuditDoc) string {
+	var kv []string
+	add := func(k, v string) {
+		if v != "" {
+			kv = append(kv, k+"="+logfmtValue(v))
+		}
+	}
+
+	// the maps are written in the order of their keys
+	addMap := func(prefix string, m map[string]string) {
+		var keys []string
+		for k := range m {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+		for _, k := range keys {
+			add(prefix+k, m[k])
+		}
+	}
+
+	add("method", d.Method)
+	add("path", d.Path)
+	add("query", d.Query)
+	add("status", strconv.Itoa(d.Status))
+	add("proto", d.Proto)
+	add("remoteAddr", d.RemoteAddr)
+	add("userAgent", d.UserAgent)
+	add("category", d.Category)
+	add("owner", d.Owner)
+	if d.AuthStatus != nil {
+		add("user", d.AuthStatus.User)
+		add("rejected", strconv.FormatBool(d.AuthStatus.Rejected))
+		add("reason", d.AuthStatus.Reason)
+		add("dryRunReason", d.AuthStatus.DryRunReason)
+		add("anomalies", strings.Join(d.AuthStatus.Anomalies, ","))
+		addMap("claims.", d.AuthStatus.Claims)
+	}
+
+	add("tokenFingerprint", d.TokenFingerprint)
+	if d.TLS != nil {
+		add("tls.version", d.TLS.Version)
+		add("tls.cipherSuite", d.TLS.CipherSuite)
+		add("tls.serverName", d.TLS.ServerName)
+		add("tls.resumed", strconv.FormatBool(d.TLS.Resumed))
+		add("tls.clientSubject", d.TLS.ClientSubject)
+	}
+
+	if d.Backend != nil {
+		add("backend.address", d.Backend.Address)
+		add("backend.reused", strconv.FormatBool(d.Backend.Reused))
+		if d.Backend.TLSVerified != nil {
+			add("backend.tlsVerified", strconv.FormatBool(*d.Backend.TLSVerified))
+		}
+
+		add("backend.tlsError", d.Backend.TLSError)
+		add("backend.retries", strconv.Itoa(d.Backend.Retries))
+		add("backend.error", d.Backend.Error)
+	}
+
+	if d.KillSwitch != nil {
+		add("killSwitch.name", d.KillSwitch.Name)
+		add("killSwitch.on", strconv.FormatBool(d.KillSwitch.On))
+		add("killSwitch.source", d.KillSwitch.Source)
+	}
+
+	addMap("requestHeaders.", d.RequestHeaders)
+	addMap("responseHeaders.", d.ResponseHeaders)
+	if d.SampleRate > 0 {
+		add("sampleRate", strconv.FormatFloat(d.SampleRate, 'f', -1, 64))
+	}
+
+	var fields []string
+	for k := range d.Fields {
+		if !auditDocFields[k] {
+			fields = append(fields, k)
+		}
+	}
+
+	sort.Strings(fields)
+	for _, k := range fields {
+		add(k, d.Fields[k])
+	}
+
+	add("requestBody", d.RequestBody)
+	return strings.Join(kv, " ")
+}
@@ -0,0 +1,152 @@
+package skoap
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAuditFormat(t *testing.T) {
+	for _, ti := range []struct {
+		msg      string
+		format   string
+		expected AuditFormat
+		fail     bool
+	}{{
+		msg:      "empty",
+		expected: AuditFormatJSON,
+	}, {
+		msg:      "case insensitive",
+		format:   "CLF",
+		expected: AuditFormatCLF,
+	}, {
+		msg:      "logfmt",
+		format:   "logfmt",
+		expected: AuditFormatLogfmt,
+	}, {
+		msg:    "invalid",
+		format: "xml",
+		fail:   true,
+	}} {
+		f, err := ParseAuditFormat(ti.format)
+		if ti.fail != (err != nil) {
+			t.Error(ti.msg, "failed to fail or unexpected error", err)
+			continue
+		}
+
+		if f != ti.expected {
+			t.Error(ti.msg, "invalid format", f)
+		}
+	}
+}
+
+func TestFormatCLF(t *testing.T) {
+	now := time.Date(2016, 10, 10, 13, 55, 36, 0, time.UTC)
+	for _, ti := range []struct {
+		msg      string
+		doc      *AuditDoc
+		expected string
+	}{{
+		msg:      "anonymous",
+		doc:      &AuditDoc{Method: "GET", Path: "/foo", Status: 401},
+		expected: `- - - [10/Oct/2016:13:55:36 +0000] "GET /foo" 401 -`,
+	}, {
+		msg:      "protocol and query",
+		doc:      &AuditDoc{Method: "GET", Path: "/foo", Query: "page=2", Proto: "HTTP/1.1", Status: 200},
+		expected: `- - - [10/Oct/2016:13:55:36 +0000] "GET /foo?page=2 HTTP/1.1" 200 -`,
+	}, {
+		msg: "authenticated",
+		doc: &AuditDoc{
+			Method:     "POST",
+			Path:       `/foo"bar`,
+			Status:     200,
+			RemoteAddr: "10.0.0.1",
+			AuthStatus: &AuthStatusDoc{User: "jdoe"}},
+		expected: `10.0.0.1 - jdoe [10/Oct/2016:13:55:36 +0000] "POST /foo\"bar" 200 -`,
+	}} {
+		if l := formatCLF(ti.doc, now); l != ti.expected {
+			t.Error(ti.msg, "invalid entry", l)
+		}
+	}
+}
+
+func TestFormatLogfmt(t *testing.T) {
+	l := formatLogfmt(&AuditDoc{
+		Method:      "POST",
+		Path:        "/foo",
+		Status:      200,
+		UserAgent:   "curl/7.50",
+		AuthStatus:  &AuthStatusDoc{User: "jdoe", Reason: "invalid token"},
+		Fields:      map[string]string{"zone": "eu", "env": "prod"},
+		RequestBody: `{"a": "b"}`})
+
+	expected := `method=POST path=/foo status=200 userAgent=curl/7.50 user=jdoe rejected=false ` +
+		`reason="invalid token" env=prod zone=eu requestBody="{\"a\": \"b\"}"`
+	if l != expected {
+		t.Error("invalid entry", l)
+	}
+}
+
+func TestFormatLogfmtAllFields(t *testing.T) {
+	verified := true
+	l := formatLogfmt(&AuditDoc{
+		Method:           "GET",
+		Path:             "/foo",
+		Query:            "page=2",
+		Proto:            "HTTP/1.1",
+		Status:           200,
+		RemoteAddr:       "10.0.0.1",
+		UserAgent:        "curl/7.50",
+		Category:         "payments",
+		Owner:            "team-a",
+		TokenFingerprint: "abc",
+		SampleRate:       0.5,
+		RequestBody:      "body",
+		AuthStatus: &AuthStatusDoc{
+			User:         "jdoe",
+			DryRunReason: "invalid-scope",
+			Anomalies:    []string{"token-reuse"},
+			Claims:       map[string]string{"email": "jdoe@example.org"}},
+		TLS: &TLSDoc{
+			Version:       "TLS 1.3",
+			CipherSuite:   "TLS_AES_128_GCM_SHA256",
+			ServerName:    "www.example.org",
+			ClientSubject: "CN=jdoe"},
+		Backend: &BackendDoc{
+			Address:     "10.0.0.2:443",
+			TLSVerified: &verified,
+			TLSError:    "none",
+			Retries:     1,
+			Error:       "none"},
+		KillSwitch:      &KillSwitchDoc{Name: KillSwitchCaching, On: true, Source: "env"},
+		RequestHeaders:  map[string]string{"X-Request-Id": "42"},
+		ResponseHeaders: map[string]string{"Content-Type": "text/plain"}})
+
+	// every field of the JSON document is present, the nested ones with
+	// a prefix
+	for name := range auditDocFields {
+		if !strings.Contains(l, name+"=") && !strings.Contains(l, name+".") &&
+			!(name == "authStatus" && strings.Contains(l, " user=jdoe ")) {
+			t.Error("missing field", name, l)
+		}
+	}
+
+	for _, kv := range []string{
+		`query="page=2"`, `proto=HTTP/1.1`, `dryRunReason=invalid-scope`, `claims.email=jdoe@example.org`,
+		`tls.version="TLS 1.3"`, `backend.tlsVerified=true`, `killSwitch.name=caching`,
+		`requestHeaders.X-Request-Id=42`, `responseHeaders.Content-Type=text/plain`,
+	} {
+		if !strings.Contains(l, " "+kv) {
+			t.Error("missing field", kv, l)
+		}
+	}
+}
+
+func TestWriterSinkFormat(t *testing.T) {
+	var out bytes.Buffer
+	testAuditLog(t, NewAuditLogWithSink(NewWriterSinkWithFormat(&out, AuditFormatLogfmt)), nil, "")
+	if l := out.String(); !strings.HasPrefix(l, "method=POST path=/foo status=200 ") {
+		t.Error("invalid entry", l)
+	}
+}
@@ -0,0 +1,108 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters"
+	"net/url"
+	"strings"
+)
+
+const (
+	forwardUserHeader   = "X-Auth-User"
+	forwardRealmHeader  = "X-Auth-Realm"
+	forwardScopesHeader = "X-Auth-Scopes"
+)
+
+type forwardAuth struct{}
+
+// Creates a forwardAuth filter specification. The filter sets the
+// identity of the authenticated user in the outgoing request headers.
+func NewForwardAuth() filters.Spec { return forwardAuth{} }
+
+func (fa forwardAuth) Name() string { return ForwardAuthName }
+
+func (fa forwardAuth) CreateFilter(args []interface{}) (filters.Filter, error) {
+	if len(args) != 0 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	return fa, nil
+}
+
+func (fa forwardAuth) Request(ctx filters.FilterContext) {
+	h := ctx.Request().Header
+	h.Del(forwardUserHeader)
+	h.Del(forwardRealmHeader)
+	h.Del(forwardScopesHeader)
+	for k := range h {
+		if strings.HasPrefix(k, claimHeaderPrefix) {
+			h.Del(k)
+		}
+	}
+
+	a, ok := ctx.StateBag()[authDocKey].(*authDoc)
+	if !ok {
+		return
+	}
+
+	h.Set(forwardUserHeader, a.Uid)
+	if a.Realm != "" {
+		h.Set(forwardRealmHeader, a.Realm)
+	}
+
+	if len(a.Scopes) > 0 {
+		h.Set(forwardScopesHeader, strings.Join(a.Scopes, ","))
+	}
+
+	claims, _ := ctx.StateBag()[authClaimsKey].(map[string]string)
+	for name, value := range claims {
+		h.Set(claimHeaderPrefix+name, value)
+	}
+}
+
+func (fa forwardAuth) Response(_ filters.FilterContext) {}
+
+type forwardToken []string
+
+// Creates a forwardToken filter specification. The filter drops the
+// Authorization header of the outgoing request, unless the host of the
+// route backend is one of the hosts set as the filter arguments.
+func NewForwardToken() filters.Spec { return forwardToken(nil) }
+
+func (ft forwardToken) Name() string { return ForwardTokenName }
+
+func (ft forwardToken) CreateFilter(args []interface{}) (filters.Filter, error) {
+	hosts, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, h := range hosts {
+		hosts[i] = strings.ToLower(h)
+	}
+
+	return forwardToken(hosts), nil
+}
+
+func (ft forwardToken) allowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range ft {
+		if h == host {
+			return true
+		}
+
+		if strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (ft forwardToken) Request(ctx filters.FilterContext) {
+	u, err := url.Parse(ctx.BackendUrl())
+	if err != nil || !ft.allowed(u.Hostname()) {
+		ctx.Request().Header.Del(authHeaderName)
+	}
+}
+
+func (ft forwardToken) Response(_ filters.FilterContext) {}
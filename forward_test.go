@@ -0,0 +1,135 @@
+package skoap
+
+import (
+	"encoding/json"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardAuth(t *testing.T) {
+	for _, ti := range []struct {
+		msg    string
+		auth   bool
+		user   string
+		realm  string
+		scopes string
+	}{{
+		msg:  "not authenticated, fake headers dropped",
+		auth: false,
+	}, {
+		msg:    "authenticated",
+		auth:   true,
+		user:   testUid,
+		realm:  testRealm,
+		scopes: testScope + ",other-scope",
+	}} {
+		var user, realm, scopes string
+		backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			user = r.Header.Get(forwardUserHeader)
+			realm = r.Header.Get(forwardRealmHeader)
+			scopes = r.Header.Get(forwardScopesHeader)
+		}))
+
+		authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope, "other-scope"}}
+			if err := json.NewEncoder(w).Encode(&d); err != nil {
+				t.Error(ti.msg, err)
+			}
+		}))
+
+		fr := make(filters.Registry)
+		fr.Register(NewAuth(authServer.URL))
+		fr.Register(NewForwardAuth())
+
+		var fs []*eskip.Filter
+		if ti.auth {
+			fs = append(fs, &eskip.Filter{Name: AuthName})
+		}
+
+		fs = append(fs, &eskip.Filter{Name: ForwardAuthName})
+		proxy := proxytest.New(fr, &eskip.Route{Filters: fs, Backend: backend.URL})
+
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		req.Header.Set(forwardUserHeader, "fake-user")
+		req.Header.Set(forwardRealmHeader, "/fake-realm")
+
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		backend.Close()
+		authServer.Close()
+
+		if user != ti.user || realm != ti.realm || scopes != ti.scopes {
+			t.Error(ti.msg, "invalid forwarded headers", user, realm, scopes)
+		}
+	}
+}
+
+func TestForwardToken(t *testing.T) {
+	for _, ti := range []struct {
+		msg       string
+		args      []interface{}
+		forwarded bool
+	}{{
+		msg: "no hosts allowed",
+	}, {
+		msg:  "host not allowed",
+		args: []interface{}{"api.example.org", "*.example.org"},
+	}, {
+		msg:       "host allowed",
+		args:      []interface{}{"api.example.org", "127.0.0.1"},
+		forwarded: true,
+	}} {
+		var auth string
+		backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get(authHeaderName)
+		}))
+
+		fr := make(filters.Registry)
+		fr.Register(NewForwardToken())
+		proxy := proxytest.New(fr, &eskip.Route{
+			Filters: []*eskip.Filter{{Name: ForwardTokenName, Args: ti.args}},
+			Backend: backend.URL})
+
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		backend.Close()
+
+		if (auth != "") != ti.forwarded {
+			t.Error(ti.msg, "invalid forwarding of the token", auth)
+		}
+	}
+}
+
+func TestForwardTokenWildcard(t *testing.T) {
+	ft := forwardToken{"*.example.org"}
+	if !ft.allowed("API.example.org") {
+		t.Error("failed to match subdomain")
+	}
+
+	if ft.allowed("example.org") || ft.allowed("api.example.org.evil.com") {
+		t.Error("unexpected match")
+	}
+}
@@ -0,0 +1,43 @@
+package skoap
+
+import (
+	"context"
+	"strings"
+)
+
+const defaultGitHubApiUrl = "https://api.github.com"
+
+type githubTeams struct {
+	apiUrl string
+}
+
+// Creates a team source resolving the teams of the users from the
+// GitHub API, in the form of organization/team, e.g. acme/platform.
+// The tokens of the requests need to be GitHub tokens with the read:org
+// scope, e.g. validated with the /user endpoint of the GitHub API and
+// the login uid field. When apiUrl is empty, https://api.github.com is
+// used.
+func NewGitHubTeamSource(apiUrl string) TeamSource {
+	if apiUrl == "" {
+		apiUrl = defaultGitHubApiUrl
+	}
+
+	return &githubTeams{apiUrl: strings.TrimSuffix(apiUrl, "/")}
+}
+
+func (g *githubTeams) Teams(ctx context.Context, _, token string) ([]string, error) {
+	ts := []string{}
+	err := jsonGetPages(ctx, g.apiUrl+"/user/teams?per_page=100", token, func(t map[string]interface{}) {
+		org, _ := t["organization"].(map[string]interface{})
+		login, slug := stringField(org["login"]), stringField(t["slug"])
+		if login != "" && slug != "" {
+			ts = append(ts, login+"/"+slug)
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ts, nil
+}
@@ -0,0 +1,40 @@
+package skoap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGitHubTeamSource(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user/teams" || r.Header.Get(authHeaderName) != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", `</user/teams?per_page=100&page=2>; rel="next"`)
+			w.Write([]byte(`[{"slug": "platform", "organization": {"login": "acme"}}]`))
+			return
+		}
+
+		w.Write([]byte(`[{"slug": "security", "organization": {"login": "acme"}}, {"slug": "no-org"}]`))
+	}))
+	defer api.Close()
+
+	teams, err := NewGitHubTeamSource(api.URL+"/").Teams(context.Background(), testUid, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(teams, ",") != "acme/platform,acme/security" {
+		t.Error("invalid teams", teams)
+	}
+
+	if _, err := NewGitHubTeamSource(api.URL).Teams(context.Background(), testUid, "invalid-token"); err == nil {
+		t.Error("failed to fail on invalid token")
+	}
+}
@@ -0,0 +1,176 @@
+package skoap
+
+import (
+	"errors"
+	"github.com/zalando/skipper/filters"
+	"net/http"
+	"strings"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+type (
+	templatePart struct {
+		text     string
+		variable bool
+	}
+
+	headerTemplate []templatePart
+
+	setHeaderTemplateSpec struct{}
+
+	setHeaderTemplate struct {
+		name     string
+		template headerTemplate
+	}
+
+	setHeaderFromAuthSpec struct{}
+
+	setHeaderFromAuth struct {
+		name  string
+		value string
+	}
+)
+
+var errUnclosedTemplateVariable = errors.New("unclosed template variable")
+
+func parseHeaderTemplate(s string) (headerTemplate, error) {
+	var t headerTemplate
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			if s != "" {
+				t = append(t, templatePart{text: s})
+			}
+
+			return t, nil
+		}
+
+		if i > 0 {
+			t = append(t, templatePart{text: s[:i]})
+		}
+
+		s = s[i+2:]
+		j := strings.Index(s, "}")
+		if j < 0 {
+			return nil, errUnclosedTemplateVariable
+		}
+
+		t = append(t, templatePart{text: s[:j], variable: true})
+		s = s[j+1:]
+	}
+}
+
+func templateValue(ctx filters.FilterContext, name string) string {
+	sb := ctx.StateBag()
+	a, _ := sb[authDocKey].(*authDoc)
+	switch name {
+	case "uid":
+		if a != nil {
+			return a.Uid
+		}
+	case "realm":
+		if a != nil {
+			return a.Realm
+		}
+	case "scopes":
+		if a != nil {
+			return strings.Join(a.Scopes, ",")
+		}
+	case "teams":
+		teams, _ := sb[authTeamsKey].([]string)
+		return strings.Join(teams, ",")
+	case "groups":
+		groups, _ := sb[authGroupsKey].([]string)
+		return strings.Join(groups, ",")
+	case "rejectReason":
+		r, _ := sb[authRejectReasonKey].(string)
+		return r
+	case "requestId":
+		return ctx.Request().Header.Get(requestIdHeader)
+	case authTokenKey:
+		// the validated token is not exposed in the templates
+		return ""
+	default:
+		v, _ := sb[name].(string)
+		return v
+	}
+
+	return ""
+}
+
+func (t headerTemplate) execute(ctx filters.FilterContext) string {
+	var b []string
+	for _, p := range t {
+		if p.variable {
+			b = append(b, templateValue(ctx, p.text))
+		} else {
+			b = append(b, p.text)
+		}
+	}
+
+	return strings.Join(b, "")
+}
+
+// Creates a setHeaderTemplate filter specification. The filter
+// expects two arguments: the name of the outgoing request header and
+// the template of its value.
+func NewSetHeaderTemplate() filters.Spec { return setHeaderTemplateSpec{} }
+
+func (s setHeaderTemplateSpec) Name() string { return SetHeaderTemplateName }
+
+func (s setHeaderTemplateSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	sargs, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sargs) != 2 || sargs[0] == "" {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	t, err := parseHeaderTemplate(sargs[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return &setHeaderTemplate{name: http.CanonicalHeaderKey(sargs[0]), template: t}, nil
+}
+
+func (f *setHeaderTemplate) Request(ctx filters.FilterContext) {
+	ctx.Request().Header.Set(f.name, f.template.execute(ctx))
+}
+
+func (f *setHeaderTemplate) Response(_ filters.FilterContext) {}
+
+// Creates a setHeaderFromAuth filter specification. The filter expects
+// two arguments: the name of the outgoing request header and the name
+// of the value copied from the state bag, one of the variables of the
+// header templates. The incoming header with the same name is removed,
+// and when the value is missing, the header is not set.
+func NewSetHeaderFromAuth() filters.Spec { return setHeaderFromAuthSpec{} }
+
+func (s setHeaderFromAuthSpec) Name() string { return SetHeaderFromAuthName }
+
+func (s setHeaderFromAuthSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	sargs, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sargs) != 2 || sargs[0] == "" || sargs[1] == "" {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	return &setHeaderFromAuth{name: http.CanonicalHeaderKey(sargs[0]), value: sargs[1]}, nil
+}
+
+func (f *setHeaderFromAuth) Request(ctx filters.FilterContext) {
+	// the clients must not be able to set the header themselves
+	ctx.Request().Header.Del(f.name)
+	if v := templateValue(ctx, f.value); v != "" {
+		ctx.Request().Header.Set(f.name, v)
+	}
+}
+
+func (f *setHeaderFromAuth) Response(_ filters.FilterContext) {}
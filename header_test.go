@@ -0,0 +1,179 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type stateBagFilter map[string]interface{}
+
+func (sb stateBagFilter) Name() string { return "testStateBag" }
+
+func (sb stateBagFilter) CreateFilter(_ []interface{}) (filters.Filter, error) { return sb, nil }
+
+func (sb stateBagFilter) Request(ctx filters.FilterContext) {
+	for k, v := range sb {
+		ctx.StateBag()[k] = v
+	}
+}
+
+func (sb stateBagFilter) Response(_ filters.FilterContext) {}
+
+func TestParseHeaderTemplate(t *testing.T) {
+	if _, err := parseHeaderTemplate("${uid"); err != errUnclosedTemplateVariable {
+		t.Error("failed to fail", err)
+	}
+
+	tpl, err := parseHeaderTemplate("user: ${uid}@${realm}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := headerTemplate{
+		{text: "user: "},
+		{text: "uid", variable: true},
+		{text: "@"},
+		{text: "realm", variable: true}}
+	if len(tpl) != len(expected) {
+		t.Fatal("invalid template", tpl)
+	}
+
+	for i := range tpl {
+		if tpl[i] != expected[i] {
+			t.Error("invalid template part", tpl[i], expected[i])
+		}
+	}
+}
+
+func TestSetHeaderTemplate(t *testing.T) {
+	for _, ti := range []struct {
+		msg      string
+		state    stateBagFilter
+		template string
+		expected string
+	}{{
+		msg:      "no auth",
+		state:    stateBagFilter{},
+		template: "${uid}@${realm}",
+		expected: "@",
+	}, {
+		msg: "auth values",
+		state: stateBagFilter{
+			authDocKey:   &authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope, "other-scope"}},
+			authTeamsKey: []string{testTeam, "other-team"}},
+		template: "${uid} ${realm} ${scopes} ${teams}",
+		expected: testUid + " " + testRealm + " " + testScope + ",other-scope " + testTeam + ",other-team",
+	}, {
+		msg:      "request id and custom state",
+		state:    stateBagFilter{"tag": "foo"},
+		template: "${requestId}/${tag}",
+		expected: "42/foo",
+	}} {
+		var header string
+		backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			header = r.Header.Get("X-Test")
+		}))
+
+		fr := make(filters.Registry)
+		fr.Register(ti.state)
+		fr.Register(NewSetHeaderTemplate())
+		proxy := proxytest.New(fr, &eskip.Route{
+			Filters: []*eskip.Filter{
+				{Name: ti.state.Name()},
+				{Name: SetHeaderTemplateName, Args: []interface{}{"X-Test", ti.template}}},
+			Backend: backend.URL})
+
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(requestIdHeader, "42")
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		backend.Close()
+
+		if header != ti.expected {
+			t.Error(ti.msg, "invalid header", header, ti.expected)
+		}
+	}
+}
+
+func TestSetHeaderFromAuth(t *testing.T) {
+	if _, err := NewSetHeaderFromAuth().CreateFilter([]interface{}{"X-Test"}); err == nil {
+		t.Error("failed to fail on missing value name")
+	}
+
+	for _, ti := range []struct {
+		msg      string
+		state    stateBagFilter
+		value    string
+		incoming string
+		expected []string
+	}{{
+		msg:      "no auth",
+		state:    stateBagFilter{},
+		value:    "uid",
+		incoming: "spoofed",
+	}, {
+		msg:      "uid",
+		state:    stateBagFilter{authDocKey: &authDoc{Uid: testUid, Realm: testRealm, Scopes: nil}},
+		value:    "uid",
+		incoming: "spoofed",
+		expected: []string{testUid},
+	}, {
+		msg:      "scopes",
+		state:    stateBagFilter{authDocKey: &authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope, "other-scope"}}},
+		value:    "scopes",
+		expected: []string{testScope + ",other-scope"},
+	}, {
+		msg:      "reject reason",
+		state:    stateBagFilter{authRejectReasonKey: "invalid-scope"},
+		value:    "rejectReason",
+		expected: []string{"invalid-scope"},
+	}} {
+		var header []string
+		backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			header = r.Header["X-Test"]
+		}))
+
+		fr := make(filters.Registry)
+		fr.Register(ti.state)
+		fr.Register(NewSetHeaderFromAuth())
+		proxy := proxytest.New(fr, &eskip.Route{
+			Filters: []*eskip.Filter{
+				{Name: ti.state.Name()},
+				{Name: SetHeaderFromAuthName, Args: []interface{}{"X-Test", ti.value}}},
+			Backend: backend.URL})
+
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if ti.incoming != "" {
+			req.Header.Set("X-Test", ti.incoming)
+		}
+
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		proxy.Close()
+		backend.Close()
+
+		if len(header) != len(ti.expected) || len(header) == 1 && header[0] != ti.expected[0] {
+			t.Error(ti.msg, "invalid header", header, ti.expected)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package skoap
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// the token sent by ProbeAuthService, expected to be rejected
+	probeToken = "skoap-readiness-probe"
+
+	// the token validation service is considered down after this many
+	// failures in a row, or when it failed, and was not reached within
+	// the window
+	authServiceDownFailures = 5
+	authServiceDownWindow   = 30 * time.Second
+)
+
+var (
+	// the last response of the token validation service, that was not
+	// a failure, stored as unix nanoseconds
+	authServiceReached int64
+
+	// the number of the failures since the last response
+	authServiceFailures int64
+)
+
+func markAuthServiceReached() {
+	atomic.StoreInt64(&authServiceReached, time.Now().UnixNano())
+	atomic.StoreInt64(&authServiceFailures, 0)
+}
+
+func markAuthServiceFailed() {
+	atomic.AddInt64(&authServiceFailures, 1)
+}
+
+// tells whether the token validation service is down, and not only
+// failing for a single token
+func authServiceDown() bool {
+	failures := atomic.LoadInt64(&authServiceFailures)
+	if failures >= authServiceDownFailures {
+		return true
+	}
+
+	reached := AuthServiceReached()
+	return failures > 0 && !reached.IsZero() && time.Since(reached) > authServiceDownWindow
+}
+
+// Returns the time when the token validation service last responded
+// without a failure, either validating or rejecting a token. Zero when
+// it was not reached yet.
+func AuthServiceReached() time.Time {
+	t := atomic.LoadInt64(&authServiceReached)
+	if t == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, t)
+}
+
+// sends the probe token to a token validation service. Only the
+// responses of a working service count: 200, and the rejection of the
+// token with 401 or 400, and not e.g. 404 or 403 from a proxy in front
+// of a misconfigured service.
+func probeAuthUrl(ctx context.Context, url string) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set(authHeaderName, "Bearer "+probeToken)
+	rsp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+
+	rsp.Body.Close()
+	switch rsp.StatusCode {
+	case http.StatusOK, http.StatusUnauthorized, http.StatusBadRequest:
+		return nil
+	default:
+		return fmt.Errorf("unexpected response of the token validation service to the probe: %s", rsp.Status)
+	}
+}
+
+// Checks whether the token validation service set in the options, or
+// one of its fallbacks, can be reached, by validating a probe token.
+// The rejection of the token with 401 or 400 counts as reachable.
+func ProbeAuthService(ctx context.Context, o Options) error {
+	err := probeAuthUrl(ctx, o.AuthUrlBase)
+	for _, u := range o.AuthUrlFallbacks {
+		if err == nil {
+			break
+		}
+
+		err = probeAuthUrl(ctx, u)
+	}
+
+	if err == nil {
+		markAuthServiceReached()
+	}
+
+	return err
+}
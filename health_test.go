@@ -0,0 +1,82 @@
+package skoap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbeAuthService(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer authServer.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	if err := ProbeAuthService(context.Background(), Options{AuthUrlBase: failing.URL}); err == nil {
+		t.Error("failed to fail on the server error")
+	}
+
+	for _, status := range []int{http.StatusNotFound, http.StatusForbidden, http.StatusTooManyRequests} {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+		}))
+
+		err := ProbeAuthService(context.Background(), Options{AuthUrlBase: s.URL})
+		s.Close()
+		if err == nil {
+			t.Error("failed to fail on status", status)
+		}
+	}
+
+	if err := ProbeAuthService(context.Background(), Options{
+		AuthUrlBase:      failing.URL,
+		AuthUrlFallbacks: []string{authServer.URL}}); err != nil {
+		t.Error("failed to probe the fallback", err)
+	}
+
+	start := time.Now()
+	if err := ProbeAuthService(context.Background(), Options{AuthUrlBase: authServer.URL}); err != nil {
+		t.Error(err)
+	}
+
+	if AuthServiceReached().Before(start) {
+		t.Error("failed to record the reached auth service")
+	}
+}
+
+func TestAuthServiceDown(t *testing.T) {
+	markAuthServiceReached()
+	if authServiceDown() {
+		t.Error("unexpected down service after a response")
+	}
+
+	markAuthServiceFailed()
+	if authServiceDown() {
+		t.Error("unexpected down service after a single failure")
+	}
+
+	for i := 1; i < authServiceDownFailures; i++ {
+		markAuthServiceFailed()
+	}
+
+	if !authServiceDown() {
+		t.Error("failed to detect the failures in a row")
+	}
+
+	markAuthServiceReached()
+	atomic.StoreInt64(&authServiceReached, time.Now().Add(-2*authServiceDownWindow).UnixNano())
+	markAuthServiceFailed()
+	if !authServiceDown() {
+		t.Error("failed to detect the failure after the window")
+	}
+
+	markAuthServiceReached()
+}
@@ -0,0 +1,204 @@
+package skoap
+
+import (
+	"context"
+	"github.com/zalando/skipper/filters"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type (
+	hedgeSpec struct {
+		transport http.RoundTripper
+	}
+
+	hedge struct {
+		delay     time.Duration
+		transport http.RoundTripper
+	}
+
+	hedgeResult struct {
+		index    int
+		response *http.Response
+		err      error
+	}
+
+	// cancels the context of the winning request when its body is
+	// closed
+	cancelBody struct {
+		io.ReadCloser
+		cancel context.CancelFunc
+	}
+)
+
+var idempotentMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+}
+
+// Creates a hedge filter specification. For idempotent requests, the
+// filter sends the request to the backend, and if no response arrives
+// within the delay set as the argument, it sends a second request, and
+// uses whichever responds first. The delay is set either as a duration
+// string, e.g. "50ms", or as a number of milliseconds. The filter sends
+// the requests itself, so it needs to be the last filter of the route.
+// The requests are sent with http.DefaultTransport.
+func NewHedge() filters.Spec { return NewHedgeWithTransport(nil) }
+
+// Creates a hedge filter specification sending the requests with the
+// transport, typically the one of the proxy, so that the hedged
+// requests use the same connection pool, timeouts and dialing as the
+// proxied ones. When nil, http.DefaultTransport is used.
+func NewHedgeWithTransport(rt http.RoundTripper) filters.Spec {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	return hedgeSpec{transport: rt}
+}
+
+func (s hedgeSpec) Name() string { return HedgeName }
+
+func (s hedgeSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	if len(args) != 1 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	var d time.Duration
+	switch v := args[0].(type) {
+	case float64:
+		d = time.Duration(v * float64(time.Millisecond))
+	case string:
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			return nil, filters.ErrInvalidFilterParameters
+		}
+	default:
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	if d <= 0 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	return &hedge{delay: d, transport: s.transport}, nil
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func outgoingRequest(c context.Context, backend, host string, r *http.Request) (*http.Request, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = r.URL.Path
+	u.RawPath = r.URL.RawPath
+	u.RawQuery = r.URL.RawQuery
+	req, err := http.NewRequest(r.Method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// every attempt gets its own copy of the header, because the
+	// transport may modify it while the other one is still in flight
+	req.Header = r.Header.Clone()
+	req.Host = host
+	return req.WithContext(c), nil
+}
+
+// closes the responses that arrive after the winner
+func drain(results <-chan hedgeResult, pending int) {
+	for ; pending > 0; pending-- {
+		if res := <-results; res.response != nil {
+			res.response.Body.Close()
+		}
+	}
+}
+
+func (h *hedge) Request(ctx filters.FilterContext) {
+	r := ctx.Request()
+	if !idempotentMethods[r.Method] || r.ContentLength > 0 {
+		return
+	}
+
+	results := make(chan hedgeResult, 2)
+	var cancels []context.CancelFunc
+	send := func() {
+		i := len(cancels)
+		c, cancel := context.WithCancel(r.Context())
+		cancels = append(cancels, cancel)
+		req, err := outgoingRequest(c, ctx.BackendUrl(), ctx.OutgoingHost(), r)
+		if err != nil {
+			results <- hedgeResult{index: i, err: err}
+			return
+		}
+
+		go func() {
+			rsp, err := h.transport.RoundTrip(req)
+			results <- hedgeResult{index: i, response: rsp, err: err}
+		}()
+	}
+
+	send()
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+
+	received := 0
+	for {
+		select {
+		case <-timer.C:
+			if len(cancels) == 1 {
+				ctx.Metrics().IncCounter("skoap.hedge.sent")
+				send()
+			}
+		case res := <-results:
+			received++
+			if res.err != nil {
+				// when the first request fails, the second one is sent
+				// without waiting for the delay
+				if len(cancels) == 1 {
+					send()
+					continue
+				}
+
+				if received < len(cancels) {
+					continue
+				}
+
+				log.Println(res.err)
+				for _, cancel := range cancels {
+					cancel()
+				}
+
+				ctx.Serve(&http.Response{StatusCode: http.StatusBadGateway})
+				return
+			}
+
+			for i, cancel := range cancels {
+				if i != res.index {
+					cancel()
+				}
+			}
+
+			go drain(results, len(cancels)-received)
+			res.response.Body = cancelBody{res.response.Body, cancels[res.index]}
+			if res.index > 0 {
+				ctx.Metrics().IncCounter("skoap.hedge.won")
+			}
+
+			ctx.Serve(res.response)
+			return
+		}
+	}
+}
+
+func (h *hedge) Response(_ filters.FilterContext) {}
@@ -0,0 +1,139 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedgeArgs(t *testing.T) {
+	for _, ti := range []struct {
+		msg  string
+		args []interface{}
+		fail bool
+	}{{
+		msg:  "no args",
+		fail: true,
+	}, {
+		msg:  "duration",
+		args: []interface{}{"50ms"},
+	}, {
+		msg:  "milliseconds",
+		args: []interface{}{float64(50)},
+	}, {
+		msg:  "invalid duration",
+		args: []interface{}{"fifty"},
+		fail: true,
+	}, {
+		msg:  "zero delay",
+		args: []interface{}{float64(0)},
+		fail: true,
+	}} {
+		_, err := NewHedge().CreateFilter(ti.args)
+		if ti.fail != (err != nil) {
+			t.Error(ti.msg, "unexpected result", err)
+		}
+	}
+}
+
+// counts the requests, and marks their header, to detect the header
+// shared between the attempts
+type countingTransport struct {
+	requests int32
+	shared   int32
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.requests, 1)
+	if r.Header.Get("X-Attempt") != "" {
+		atomic.AddInt32(&t.shared, 1)
+	}
+
+	r.Header.Set("X-Attempt", "true")
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestHedge(t *testing.T) {
+	var requests int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		w.Write([]byte(r.Method + " " + r.URL.RequestURI()))
+	}))
+	defer backend.Close()
+
+	transport := &countingTransport{}
+	fr := make(filters.Registry)
+	fr.Register(NewHedgeWithTransport(transport))
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{Name: HedgeName, Args: []interface{}{"20ms"}}},
+		Backend: backend.URL})
+	defer proxy.Close()
+
+	start := time.Now()
+	rsp, err := http.Get(proxy.URL + "/foo?bar=baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rsp.Body.Close()
+	b, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "GET /foo?bar=baz" {
+		t.Error("invalid response", string(b))
+	}
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("failed to use the hedged response")
+	}
+
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Error("invalid number of backend requests", requests)
+	}
+
+	if atomic.LoadInt32(&transport.requests) != 2 || atomic.LoadInt32(&transport.shared) != 0 {
+		t.Error("failed to use the transport with separate headers", transport.requests, transport.shared)
+	}
+}
+
+func TestHedgeNotIdempotent(t *testing.T) {
+	var requests int32
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(60 * time.Millisecond)
+	}))
+	defer backend.Close()
+
+	fr := make(filters.Registry)
+	fr.Register(NewHedge())
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{Name: HedgeName, Args: []interface{}{"20ms"}}},
+		Backend: backend.URL})
+	defer proxy.Close()
+
+	rsp, err := http.Post(proxy.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Error("invalid number of backend requests", requests)
+	}
+}
@@ -0,0 +1,215 @@
+package skoap
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/zalando/skipper/filters"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	apr1Magic         = "$apr1$"
+	sha1Prefix        = "{SHA}"
+	apr1Alphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	defaultBasicRealm = "skoap"
+)
+
+const (
+	missingBasicAuth rejectReason = "missing-basic-auth"
+	invalidBasicAuth rejectReason = "invalid-basic-auth"
+)
+
+type (
+	verifyBasicAuthSpec struct{}
+
+	verifyBasicAuth struct {
+		realm string
+		users map[string]string
+	}
+)
+
+func loadHtpasswd(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	users := make(map[string]string)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+
+		i := strings.Index(l, ":")
+		if i <= 0 {
+			continue
+		}
+
+		users[l[:i]] = l[i+1:]
+	}
+
+	return users, s.Err()
+}
+
+func apr1To64(v uint32, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = apr1Alphabet[v&0x3f]
+		v >>= 6
+	}
+
+	return b
+}
+
+// apr1 implements the Apache variant of the MD5 based crypt algorithm.
+func apr1(password, salt string) string {
+	pw := []byte(password)
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(apr1Magic + salt))
+
+	alt := md5.Sum([]byte(password + salt + password))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			h.Write(alt[:])
+		} else {
+			h.Write(alt[:i])
+		}
+	}
+
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+
+	final := h.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 == 1 {
+			h.Write(pw)
+		} else {
+			h.Write(final)
+		}
+
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+
+		if i&1 == 1 {
+			h.Write(final)
+		} else {
+			h.Write(pw)
+		}
+
+		final = h.Sum(nil)
+	}
+
+	var r []byte
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		v := uint32(final[g[0]])<<16 | uint32(final[g[1]])<<8 | uint32(final[g[2]])
+		r = append(r, apr1To64(v, 4)...)
+	}
+
+	r = append(r, apr1To64(uint32(final[11]), 2)...)
+	return apr1Magic + salt + "$" + string(r)
+}
+
+func equalStrings(left, right string) bool {
+	return subtle.ConstantTimeCompare([]byte(left), []byte(right)) == 1
+}
+
+// checks a password against an htpasswd hash. Supported are the bcrypt,
+// the apr1 and the SHA1 formats.
+func checkHtpasswd(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, apr1Magic):
+		salt := strings.SplitN(hash[len(apr1Magic):], "$", 2)[0]
+		return equalStrings(apr1(password, salt), hash)
+	case strings.HasPrefix(hash, sha1Prefix):
+		s := sha1.Sum([]byte(password))
+		return equalStrings(sha1Prefix+base64.StdEncoding.EncodeToString(s[:]), hash)
+	default:
+		return false
+	}
+}
+
+// Creates a verifyBasicAuth filter specification. The filter validates
+// the basic authorization credentials of the incoming requests against
+// an htpasswd file. The first argument of the filter is the path of the
+// htpasswd file, the optional second argument is the realm sent in the
+// WWW-Authenticate header of the rejected requests.
+func NewVerifyBasicAuth() filters.Spec { return verifyBasicAuthSpec{} }
+
+func (s verifyBasicAuthSpec) Name() string { return VerifyBasicAuthName }
+
+func (s verifyBasicAuthSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	sargs, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sargs) == 0 || len(sargs) > 2 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	users, err := loadHtpasswd(sargs[0])
+	if err != nil {
+		return nil, err
+	}
+
+	f := &verifyBasicAuth{realm: defaultBasicRealm, users: users}
+	if len(sargs) == 2 {
+		f.realm = sargs[1]
+	}
+
+	return f, nil
+}
+
+func (f *verifyBasicAuth) reject(ctx filters.FilterContext, uname string, reason rejectReason) {
+	setAuthUser(ctx, uname)
+	ctx.StateBag()[authRejectReasonKey] = string(reason)
+	ctx.Serve(&http.Response{
+		StatusCode: http.StatusUnauthorized,
+		Header:     http.Header{"Www-Authenticate": []string{`Basic realm="` + f.realm + `"`}}})
+}
+
+func (f *verifyBasicAuth) Request(ctx filters.FilterContext) {
+	uname, pwd, ok := ctx.Request().BasicAuth()
+	if !ok {
+		f.reject(ctx, "", missingBasicAuth)
+		return
+	}
+
+	hash, ok := f.users[uname]
+	if !ok || !checkHtpasswd(hash, pwd) {
+		f.reject(ctx, uname, invalidBasicAuth)
+		return
+	}
+
+	setAuthUser(ctx, uname)
+}
+
+func (f *verifyBasicAuth) Response(_ filters.FilterContext) {}
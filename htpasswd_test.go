@@ -0,0 +1,94 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApr1(t *testing.T) {
+	for _, ti := range []struct {
+		password string
+		salt     string
+		hash     string
+	}{
+		{"myPassword", "r31Yi2dQ", "$apr1$r31Yi2dQ$qujpP2ejEPmnDxk8il5yw1"},
+		{"secret", "ab", "$apr1$ab$jiiV6N7hIIuIoJbc1hxOE/"},
+	} {
+		if h := apr1(ti.password, ti.salt); h != ti.hash {
+			t.Error("invalid apr1 hash", h, ti.hash)
+		}
+	}
+}
+
+func TestVerifyBasicAuth(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	fr := make(filters.Registry)
+	fr.Register(NewVerifyBasicAuth())
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{Name: VerifyBasicAuthName, Args: []interface{}{"testdata/htpasswd", "test"}}},
+		Backend: backend.URL})
+
+	for _, ti := range []struct {
+		msg        string
+		user       string
+		password   string
+		statusCode int
+	}{{
+		msg:        "missing credentials",
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "unknown user",
+		user:       "nobody",
+		password:   "myPassword",
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "invalid password",
+		user:       "apr1user",
+		password:   "wrong",
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "apr1",
+		user:       "apr1user",
+		password:   "myPassword",
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "bcrypt",
+		user:       "bcryptuser",
+		password:   "bcrypt-secret",
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "sha1",
+		user:       "shauser",
+		password:   "sha-secret",
+		statusCode: http.StatusOK,
+	}} {
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if ti.user != "" {
+			req.SetBasicAuth(ti.user, ti.password)
+		}
+
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		if rsp.StatusCode != ti.statusCode {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode, ti.statusCode)
+		}
+
+		if rsp.StatusCode == http.StatusUnauthorized && rsp.Header.Get("WWW-Authenticate") != `Basic realm="test"` {
+			t.Error(ti.msg, "invalid WWW-Authenticate header", rsp.Header.Get("WWW-Authenticate"))
+		}
+	}
+}
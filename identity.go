@@ -0,0 +1,115 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters"
+	"net/http"
+)
+
+const (
+	userDenied     rejectReason = "user-denied"
+	teamDenied     rejectReason = "team-denied"
+	userNotAllowed rejectReason = "user-not-allowed"
+)
+
+type (
+	identitySpec struct {
+		name       string
+		team       bool
+		allow      bool
+		jsonErrors bool
+	}
+
+	identityFilter struct {
+		ids        []string
+		team       bool
+		allow      bool
+		jsonErrors bool
+	}
+)
+
+// Creates a denyUser filter specification. The filter rejects the
+// requests with 403, when the user id set by a preceding auth filter
+// matches one of its arguments, regardless of the scopes of the user.
+// The arguments can contain the * wildcard.
+func NewDenyUser(jsonErrors bool) filters.Spec {
+	return &identitySpec{name: DenyUserName, jsonErrors: jsonErrors}
+}
+
+// Creates a denyTeam filter specification. The filter rejects the
+// requests with 403, when any of the teams of the user, resolved by a
+// preceding authTeam filter, matches one of its arguments. The
+// arguments can contain the * wildcard.
+func NewDenyTeam(jsonErrors bool) filters.Spec {
+	return &identitySpec{name: DenyTeamName, team: true, jsonErrors: jsonErrors}
+}
+
+// Creates an authUser filter specification. The filter rejects the
+// requests with 403, unless the user id set by a preceding auth filter
+// matches one of its arguments. The arguments can contain the *
+// wildcard.
+func NewAuthUser(jsonErrors bool) filters.Spec {
+	return &identitySpec{name: AuthUserName, allow: true, jsonErrors: jsonErrors}
+}
+
+func (s *identitySpec) Name() string { return s.name }
+
+func (s *identitySpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	sargs, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sargs) == 0 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	return &identityFilter{
+		ids:        sargs,
+		team:       s.team,
+		allow:      s.allow,
+		jsonErrors: s.jsonErrors}, nil
+}
+
+func (f *identityFilter) matches(a *AuthContext) bool {
+	if !f.team {
+		return matchAny(f.ids, a.User)
+	}
+
+	for _, t := range a.Teams {
+		if matchAny(f.ids, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (f *identityFilter) Request(ctx filters.FilterContext) {
+	a := authContext(ctx)
+	if f.allow {
+		if a != nil && f.matches(a) {
+			return
+		}
+
+		var uname string
+		if a != nil {
+			uname = a.User
+		}
+
+		reject(ctx, http.StatusForbidden, uname, userNotAllowed, f.jsonErrors)
+		return
+	}
+
+	if a == nil || !f.matches(a) {
+		return
+	}
+
+	reason := userDenied
+	if f.team {
+		reason = teamDenied
+	}
+
+	reject(ctx, http.StatusForbidden, a.User, reason, f.jsonErrors)
+}
+
+func (f *identityFilter) Response(_ filters.FilterContext) {}
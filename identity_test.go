@@ -0,0 +1,109 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/filters/filtertest"
+	"net/http"
+	"testing"
+)
+
+func TestIdentityFilters(t *testing.T) {
+	if _, err := NewDenyUser(false).CreateFilter(nil); err == nil {
+		t.Error("failed to fail on missing user ids")
+	}
+
+	for _, ti := range []struct {
+		msg    string
+		spec   filters.Spec
+		args   []interface{}
+		auth   *authDoc
+		teams  []string
+		reason rejectReason
+	}{{
+		msg:  "no auth",
+		spec: NewDenyUser(false),
+		args: []interface{}{testUid},
+	}, {
+		msg:  "user not denied",
+		spec: NewDenyUser(false),
+		args: []interface{}{"asmith"},
+		auth: &authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}},
+	}, {
+		msg:    "user denied",
+		spec:   NewDenyUser(false),
+		args:   []interface{}{"asmith", testUid},
+		auth:   &authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}},
+		reason: userDenied,
+	}, {
+		msg:    "user denied by wildcard",
+		spec:   NewDenyUser(false),
+		args:   []interface{}{"jd*"},
+		auth:   &authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}},
+		reason: userDenied,
+	}, {
+		msg:   "team not denied",
+		spec:  NewDenyTeam(false),
+		args:  []interface{}{"team-x"},
+		auth:  &authDoc{Uid: testUid, Realm: testRealm, Scopes: nil},
+		teams: []string{testTeam},
+	}, {
+		msg:    "team denied",
+		spec:   NewDenyTeam(false),
+		args:   []interface{}{"team-x", testTeam},
+		auth:   &authDoc{Uid: testUid, Realm: testRealm, Scopes: nil},
+		teams:  []string{"other-team", testTeam},
+		reason: teamDenied,
+	}, {
+		msg:  "teams not resolved",
+		spec: NewDenyTeam(false),
+		args: []interface{}{testTeam},
+		auth: &authDoc{Uid: testUid, Realm: testRealm, Scopes: nil},
+	}, {
+		msg:    "allow list, no auth",
+		spec:   NewAuthUser(false),
+		args:   []interface{}{testUid},
+		reason: userNotAllowed,
+	}, {
+		msg:  "allow list, user allowed",
+		spec: NewAuthUser(false),
+		args: []interface{}{"asmith", testUid},
+		auth: &authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}},
+	}, {
+		msg:    "allow list, user not allowed",
+		spec:   NewAuthUser(false),
+		args:   []interface{}{"asmith"},
+		auth:   &authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}},
+		reason: userNotAllowed,
+	}} {
+		f, err := ti.spec.CreateFilter(ti.args)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest("GET", "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		if ti.auth != nil {
+			authorized(ctx, ti.auth)
+		}
+
+		if ti.teams != nil {
+			ctx.FStateBag[authTeamsKey] = ti.teams
+		}
+
+		f.Request(ctx)
+
+		reason, _ := ctx.FStateBag[authRejectReasonKey].(string)
+		if reason != string(ti.reason) || ctx.FServedWithResponse != (ti.reason != "") {
+			t.Error(ti.msg, "invalid reject reason", reason, ti.reason)
+			continue
+		}
+
+		if ti.reason != "" && ctx.FResponse.StatusCode != http.StatusForbidden {
+			t.Error(ti.msg, "invalid status code", ctx.FResponse.StatusCode)
+		}
+	}
+}
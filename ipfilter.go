@@ -0,0 +1,89 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters"
+	"net"
+	"net/http"
+)
+
+const ipNotAllowed rejectReason = "ip-not-allowed"
+
+// IPFilterOptions contains the settings of the ipAllow and ipDeny
+// filters.
+type IPFilterOptions struct {
+
+	// The proxies trusted to set the X-Forwarded-For header. When the
+	// connection comes from one of them, the header is followed from
+	// right to left, skipping the trusted proxies.
+	TrustedProxies []*net.IPNet
+
+	// When greater than zero, the client address is taken from the
+	// X-Forwarded-For header, at this depth from the right, e.g. 1 when
+	// skoap runs behind a single load balancer. It takes precedence over
+	// the trusted proxies.
+	ForwardedDepth int
+
+	// When set, the rejected requests are responded with a JSON body
+	// containing the reject reason.
+	JSONErrors bool
+}
+
+type (
+	ipFilterSpec struct {
+		name    string
+		allow   bool
+		options IPFilterOptions
+	}
+
+	ipFilter struct {
+		networks []*net.IPNet
+		allow    bool
+		options  IPFilterOptions
+	}
+)
+
+// Creates an ipAllow filter specification. The filter rejects the
+// requests with 403, unless the client address is in one of the
+// networks set as its arguments, in CIDR notation or as single
+// addresses.
+func NewIPAllow(o IPFilterOptions) filters.Spec {
+	return &ipFilterSpec{name: IPAllowName, allow: true, options: o}
+}
+
+// Creates an ipDeny filter specification. The filter rejects the
+// requests with 403, when the client address is in one of the networks
+// set as its arguments, in CIDR notation or as single addresses.
+func NewIPDeny(o IPFilterOptions) filters.Spec {
+	return &ipFilterSpec{name: IPDenyName, options: o}
+}
+
+func (s *ipFilterSpec) Name() string { return s.name }
+
+func (s *ipFilterSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	sargs, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sargs) == 0 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	networks, err := ParseNetworks(sargs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ipFilter{networks: networks, allow: s.allow, options: s.options}, nil
+}
+
+func (f *ipFilter) Request(ctx filters.FilterContext) {
+	addr := clientAddr(ctx.Request(), f.options.TrustedProxies, f.options.ForwardedDepth)
+	if containsIP(f.networks, addr) == f.allow {
+		return
+	}
+
+	reject(ctx, http.StatusForbidden, "", ipNotAllowed, f.options.JSONErrors)
+}
+
+func (f *ipFilter) Response(_ filters.FilterContext) {}
@@ -0,0 +1,86 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters/filtertest"
+	"net/http"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	if _, err := NewIPAllow(IPFilterOptions{}).CreateFilter(nil); err == nil {
+		t.Error("failed to fail on missing networks")
+	}
+
+	if _, err := NewIPDeny(IPFilterOptions{}).CreateFilter([]interface{}{"10.0.0.0/33"}); err == nil {
+		t.Error("failed to fail on invalid network")
+	}
+
+	trusted, err := ParseNetworks([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ti := range []struct {
+		msg        string
+		options    IPFilterOptions
+		remoteAddr string
+		forwarded  string
+		rejected   bool
+	}{{
+		msg:        "allowed",
+		remoteAddr: "192.168.1.7:4242",
+	}, {
+		msg:        "not allowed",
+		remoteAddr: "203.0.113.1:4242",
+		rejected:   true,
+	}, {
+		msg:        "allowed through trusted proxy",
+		options:    IPFilterOptions{TrustedProxies: trusted},
+		remoteAddr: "10.0.0.1:4242",
+		forwarded:  "192.168.1.7",
+	}, {
+		msg:        "spoofed header from untrusted peer",
+		remoteAddr: "203.0.113.1:4242",
+		forwarded:  "192.168.1.7",
+		rejected:   true,
+	}, {
+		msg:        "forwarded depth",
+		options:    IPFilterOptions{ForwardedDepth: 1},
+		remoteAddr: "203.0.113.1:4242",
+		forwarded:  "203.0.113.9, 192.168.1.7",
+	}, {
+		msg:        "forwarded depth, spoofed address",
+		options:    IPFilterOptions{ForwardedDepth: 1},
+		remoteAddr: "203.0.113.1:4242",
+		forwarded:  "192.168.1.7, 203.0.113.9",
+		rejected:   true,
+	}} {
+		for _, deny := range []bool{false, true} {
+			spec, rejected := NewIPAllow(ti.options), ti.rejected
+			if deny {
+				spec, rejected = NewIPDeny(ti.options), !ti.rejected
+			}
+
+			f, err := spec.CreateFilter([]interface{}{"192.168.1.0/24"})
+			if err != nil {
+				t.Fatal(ti.msg, err)
+			}
+
+			r := &http.Request{RemoteAddr: ti.remoteAddr, Header: http.Header{}}
+			if ti.forwarded != "" {
+				r.Header.Set(forwardedForHeader, ti.forwarded)
+			}
+
+			ctx := &filtertest.Context{FRequest: r, FStateBag: make(map[string]interface{})}
+			f.Request(ctx)
+			if ctx.FServedWithResponse != rejected {
+				t.Error(ti.msg, spec.Name(), "invalid decision", ctx.FServedWithResponse)
+				continue
+			}
+
+			if rejected && ctx.FResponse.StatusCode != http.StatusForbidden {
+				t.Error(ti.msg, spec.Name(), "invalid status code", ctx.FResponse.StatusCode)
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package skoap
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Kill switches disable risky subsystems at runtime, without changing
+// the configuration. Turning a switch on never relaxes the enforcement
+// of the auth filters.
+const (
+	// KillSwitchCaching disables the caching of the userinfo claims,
+	// the exchanged tokens, and the tokens and teams validated by the
+	// predicates. Every request calls the upstream services. The
+	// revoked tokens are remembered regardless.
+	KillSwitchCaching = "caching"
+
+	// KillSwitchFailOpen disables the open-read-only service failure
+	// policy. When the token validation service is down, the requests
+	// are rejected with 401, as with the closed policy.
+	KillSwitchFailOpen = "fail-open"
+
+	// KillSwitchDryRun disables the dry-run mode of the auth filters.
+	// The denied requests are rejected, even when the filters are
+	// configured to only record the denials.
+	KillSwitchDryRun = "dry-run"
+
+	// KillSwitchWebhookSinks disables the audit sinks posting to HTTP
+	// endpoints. The entries sent to them are discarded.
+	KillSwitchWebhookSinks = "webhook-sinks"
+)
+
+const killSwitchPath = "/kill-switches/"
+
+// KillSwitchDoc contains a change of a kill switch in the audit log
+// entries.
+type KillSwitchDoc struct {
+	Name   string `json:"name"`
+	On     bool   `json:"on"`
+	Source string `json:"source"`
+}
+
+// the changes of the kill switches are always written to stderr, too,
+// because the configured audit sink may be disabled by a kill switch
+var killSwitchAuditSink = NewWriterSink(os.Stderr)
+
+// the set of the kill switches is fixed, and their state is stored
+// atomically, because they are checked on the hot path of every request
+var killSwitches = map[string]*int32{
+	KillSwitchCaching:      new(int32),
+	KillSwitchFailOpen:     new(int32),
+	KillSwitchDryRun:       new(int32),
+	KillSwitchWebhookSinks: new(int32),
+}
+
+// Turns a kill switch on or off. Returns an error when the name is not
+// a known kill switch.
+func SetKillSwitch(name string, on bool) error {
+	ks, ok := killSwitches[name]
+	if !ok {
+		return fmt.Errorf("unknown kill switch: %s", name)
+	}
+
+	var v int32
+	if on {
+		v = 1
+	}
+
+	atomic.StoreInt32(ks, v)
+	return nil
+}
+
+// Tells whether a kill switch is turned on.
+func KillSwitchOn(name string) bool {
+	ks, ok := killSwitches[name]
+	return ok && atomic.LoadInt32(ks) == 1
+}
+
+// Returns the current state of all the kill switches.
+func KillSwitchStates() map[string]bool {
+	s := make(map[string]bool)
+	for name := range killSwitches {
+		s[name] = KillSwitchOn(name)
+	}
+
+	return s
+}
+
+// Returns the names of the known kill switches, sorted.
+func KillSwitchNames() []string {
+	var names []string
+	for name := range KillSwitchStates() {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+type killSwitchHandler struct {
+	sink AuditSink
+}
+
+// Creates an HTTP handler for inspecting and changing the kill
+// switches. GET /kill-switches/ returns the state of all switches as
+// JSON, and PUT /kill-switches/<name>?on=true|false turns a switch on or
+// off. Every change is logged to stderr, and to the audit sink, when set.
+func NewKillSwitchHandler(s AuditSink) http.Handler {
+	return &killSwitchHandler{sink: s}
+}
+
+func (h *killSwitchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, killSwitchPath)
+	switch {
+	case r.Method == "GET" && name == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(KillSwitchStates())
+	case r.Method == "PUT" && name != "":
+		on, err := strconv.ParseBool(r.URL.Query().Get("on"))
+		if err != nil {
+			http.Error(w, "invalid value for on", http.StatusBadRequest)
+			return
+		}
+
+		if err := SetKillSwitch(name, on); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		AuditKillSwitch(h.sink, &AuditDoc{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Status: http.StatusNoContent,
+			KillSwitch: &KillSwitchDoc{
+				Name:   name,
+				On:     on,
+				Source: r.RemoteAddr}})
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// Logs the change of a kill switch, and sends it to the audit sink,
+// when set. The audit entry is written to stderr, too, where no kill
+// switch can discard it.
+func AuditKillSwitch(s AuditSink, d *AuditDoc) {
+	state := "off"
+	if d.KillSwitch.On {
+		state = "on"
+	}
+
+	log.Printf("kill switch %s turned %s by %s", d.KillSwitch.Name, state, d.KillSwitch.Source)
+	if err := killSwitchAuditSink.Log(d); err != nil {
+		log.Println(err)
+	}
+
+	if s == nil {
+		return
+	}
+
+	if err := s.Log(d); err != nil {
+		log.Println(err)
+	}
+}
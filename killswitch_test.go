@@ -0,0 +1,124 @@
+package skoap
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestKillSwitchHandler(t *testing.T) {
+	var audited []*AuditDoc
+	server := httptest.NewServer(NewKillSwitchHandler(AuditSinkFunc(func(d *AuditDoc) error {
+		audited = append(audited, d)
+		return nil
+	})))
+	defer server.Close()
+	defer SetKillSwitch(KillSwitchCaching, false)
+
+	put := func(path string) int {
+		req, err := http.NewRequest("PUT", server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+
+	if s := put("/kill-switches/caching?on=true"); s != http.StatusNoContent {
+		t.Fatal("failed to turn on the kill switch", s)
+	}
+
+	if s := put("/kill-switches/unknown?on=true"); s != http.StatusNotFound {
+		t.Error("failed to reject unknown kill switch", s)
+	}
+
+	if s := put("/kill-switches/caching?on=maybe"); s != http.StatusBadRequest {
+		t.Error("failed to reject invalid value", s)
+	}
+
+	rsp, err := http.Get(server.URL + "/kill-switches/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rsp.Body.Close()
+	var states map[string]bool
+	if err := json.NewDecoder(rsp.Body).Decode(&states); err != nil {
+		t.Fatal(err)
+	}
+
+	if !states[KillSwitchCaching] || states[KillSwitchWebhookSinks] {
+		t.Error("invalid states", states)
+	}
+
+	if len(audited) != 1 || audited[0].KillSwitch.Name != KillSwitchCaching || !audited[0].KillSwitch.On {
+		t.Error("failed to audit the change", audited)
+	}
+}
+
+func TestKillSwitchCaching(t *testing.T) {
+	now := time.Now()
+	c := newTTLCache("test", time.Minute, 0)
+	c.set("foo", "bar", now)
+
+	SetKillSwitch(KillSwitchCaching, true)
+	if _, ok := c.get("foo", now); ok {
+		t.Error("failed to bypass the cache")
+	}
+
+	SetKillSwitch(KillSwitchCaching, false)
+	if v, ok := c.get("foo", now); !ok || v != "bar" {
+		t.Error("failed to use the cache")
+	}
+}
+
+func TestKillSwitchAuditedToStderr(t *testing.T) {
+	var stderr bytes.Buffer
+	defer func(s AuditSink) { killSwitchAuditSink = s }(killSwitchAuditSink)
+	killSwitchAuditSink = NewWriterSink(&stderr)
+	defer SetKillSwitch(KillSwitchWebhookSinks, false)
+
+	// the configured webhook sink is disabled by the switch itself
+	var posted int
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		posted++
+	}))
+	defer webhook.Close()
+
+	server := httptest.NewServer(NewKillSwitchHandler(NewWebhookSink(webhook.URL)))
+	defer server.Close()
+
+	req, err := http.NewRequest("PUT", server.URL+"/kill-switches/webhook-sinks?on=true", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+
+	var d AuditDoc
+	if err := json.Unmarshal(stderr.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.KillSwitch == nil || d.KillSwitch.Name != KillSwitchWebhookSinks || !d.KillSwitch.On {
+		t.Error("failed to audit the change to stderr", stderr.String())
+	}
+
+	if posted != 0 {
+		t.Error("webhook sink not disabled")
+	}
+}
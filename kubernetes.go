@@ -0,0 +1,424 @@
+package skoap
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/routing"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The annotations of the Ingress objects, telling the auth filter of
+// the generated routes.
+const (
+	RealmAnnotation  = "skoap.zalando.org/realm"
+	ScopesAnnotation = "skoap.zalando.org/scopes"
+	TeamsAnnotation  = "skoap.zalando.org/teams"
+	PublicAnnotation = "skoap.zalando.org/public"
+)
+
+const (
+	ingressClassAnnotation = "kubernetes.io/ingress.class"
+	ingressesPath          = "/apis/networking.k8s.io/v1/ingresses"
+	serviceAccountDir      = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubernetesTimeout      = 10 * time.Second
+)
+
+var (
+	errMissingInClusterEnv = errors.New("missing KUBERNETES_SERVICE_HOST or KUBERNETES_SERVICE_PORT, not running in a cluster")
+	routeIdInvalidChars    = regexp.MustCompile("[^a-zA-Z0-9_]")
+)
+
+// KubernetesOptions contains the settings of the Kubernetes Ingress
+// data client.
+type KubernetesOptions struct {
+
+	// The url of the API server, e.g. http://localhost:8001 when using
+	// kubectl proxy. When empty, the in-cluster address and the
+	// service account credentials are used.
+	APIServerUrl string
+
+	// When set, only the ingresses of this class are used, set either
+	// in the ingressClassName field, or in the kubernetes.io/ingress.class
+	// annotation.
+	IngressClass string
+
+	// When set, only the ingresses of this namespace are used.
+	Namespace string
+}
+
+type (
+	kubeClient struct {
+		options KubernetesOptions
+		url     string
+		token   string
+		http    *http.Client
+		current map[string]*eskip.Route
+	}
+
+	kubeMeta struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	}
+
+	kubePort struct {
+		Name   string `json:"name"`
+		Number int    `json:"number"`
+	}
+
+	kubeBackend struct {
+		Service *struct {
+			Name string   `json:"name"`
+			Port kubePort `json:"port"`
+		} `json:"service"`
+	}
+
+	kubePath struct {
+		Path     string      `json:"path"`
+		PathType string      `json:"pathType"`
+		Backend  kubeBackend `json:"backend"`
+	}
+
+	kubeRule struct {
+		Host string `json:"host"`
+		Http *struct {
+			Paths []kubePath `json:"paths"`
+		} `json:"http"`
+	}
+
+	kubeIngress struct {
+		Metadata kubeMeta `json:"metadata"`
+		Spec     struct {
+			IngressClassName string     `json:"ingressClassName"`
+			Rules            []kubeRule `json:"rules"`
+		} `json:"spec"`
+	}
+
+	kubeIngressList struct {
+		Items []kubeIngress `json:"items"`
+	}
+
+	kubeService struct {
+		Spec struct {
+			Ports []struct {
+				Name string `json:"name"`
+				Port int    `json:"port"`
+			} `json:"ports"`
+		} `json:"spec"`
+	}
+)
+
+// NewKubernetesClient creates a Skipper data client, that generates the
+// routes from the Kubernetes Ingress objects, so that skoap can act as
+// an authenticating ingress controller. Every path of the ingress rules
+// becomes a route to the service, with an auth filter taken from the
+// annotations: skoap.zalando.org/realm sets the realm, and the comma
+// separated skoap.zalando.org/scopes and skoap.zalando.org/teams set
+// the required scopes or teams, resulting in an auth, authTeam or
+// authScopeOrTeam filter. The routes of the ingresses without these
+// annotations require a valid token of any realm, unless the
+// skoap.zalando.org/public annotation is set to true. The ingresses are
+// listed again on every update.
+func NewKubernetesClient(o KubernetesOptions) (routing.DataClient, error) {
+	c := &kubeClient{
+		options: o,
+		url:     strings.TrimSuffix(o.APIServerUrl, "/"),
+		http:    &http.Client{Timeout: kubernetesTimeout}}
+	if c.url != "" {
+		return c, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errMissingInClusterEnv
+	}
+
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, err
+	}
+
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account CA certificate")
+	}
+
+	c.url = "https://" + net.JoinHostPort(host, port)
+	c.token = strings.TrimSpace(string(token))
+	c.http.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	return c, nil
+}
+
+func (c *kubeClient) get(path string, doc interface{}) error {
+	req, err := http.NewRequest("GET", c.url+path, nil)
+	if err != nil {
+		return err
+	}
+
+	if c.token != "" {
+		req.Header.Set(authHeaderName, "Bearer "+c.token)
+	}
+
+	rsp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get %s from the Kubernetes API: %s", path, rsp.Status)
+	}
+
+	return json.NewDecoder(rsp.Body).Decode(doc)
+}
+
+func (c *kubeClient) ingresses() ([]kubeIngress, error) {
+	path := ingressesPath
+	if c.options.Namespace != "" {
+		path = "/apis/networking.k8s.io/v1/namespaces/" + c.options.Namespace + "/ingresses"
+	}
+
+	var l kubeIngressList
+	if err := c.get(path, &l); err != nil {
+		return nil, err
+	}
+
+	return l.Items, nil
+}
+
+// the named ports are resolved from the service
+func (c *kubeClient) servicePort(namespace, service string, p kubePort) (int, error) {
+	if p.Number > 0 {
+		return p.Number, nil
+	}
+
+	var s kubeService
+	if err := c.get("/api/v1/namespaces/"+namespace+"/services/"+service, &s); err != nil {
+		return 0, err
+	}
+
+	for _, sp := range s.Spec.Ports {
+		if sp.Name == p.Name {
+			return sp.Port, nil
+		}
+	}
+
+	return 0, fmt.Errorf("port %s not found in service %s/%s", p.Name, namespace, service)
+}
+
+func (c *kubeClient) matchesClass(i kubeIngress) bool {
+	if c.options.IngressClass == "" {
+		return true
+	}
+
+	class := i.Spec.IngressClassName
+	if class == "" {
+		class = i.Metadata.Annotations[ingressClassAnnotation]
+	}
+
+	return class == c.options.IngressClass
+}
+
+// creates the auth filter from the annotations, or nil for the public
+// ingresses
+func ingressAuthFilter(annotations map[string]string) *eskip.Filter {
+	if public, _ := strconv.ParseBool(annotations[PublicAnnotation]); public {
+		return nil
+	}
+
+	realm := annotations[RealmAnnotation]
+	scopes := splitAnnotation(annotations[ScopesAnnotation])
+	teams := splitAnnotation(annotations[TeamsAnnotation])
+
+	args := []interface{}{realm}
+	name := AuthName
+	switch {
+	case len(scopes) > 0 && len(teams) > 0:
+		name = AuthScopeOrTeamName
+		args = appendStrings(append(appendStrings(args, scopes), scopeTeamSeparator), teams)
+	case len(teams) > 0:
+		name = AuthTeamName
+		args = appendStrings(args, teams)
+	case len(scopes) > 0:
+		args = appendStrings(args, scopes)
+	case realm == "":
+		args = nil
+	}
+
+	return &eskip.Filter{Name: name, Args: args}
+}
+
+func splitAnnotation(v string) []string {
+	var l []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			l = append(l, s)
+		}
+	}
+
+	return l
+}
+
+func appendStrings(args []interface{}, s []string) []interface{} {
+	for _, si := range s {
+		args = append(args, si)
+	}
+
+	return args
+}
+
+// the route ids contain the components in a readable form, with the
+// invalid characters replaced, and a hash of the original components,
+// so that e.g. the hosts a.b and a_b don't get the same id
+func ingressRouteId(namespace, name, host, path string) string {
+	id := strings.Join([]string{"kube", namespace, name, host, path}, "__")
+	h := sha256.Sum256([]byte(strings.Join([]string{namespace, name, host, path}, "\x00")))
+	return routeIdInvalidChars.ReplaceAllString(id, "_") + "__" + hex.EncodeToString(h[:4])
+}
+
+func (c *kubeClient) ingressRoutes(i kubeIngress) []*eskip.Route {
+	var routes []*eskip.Route
+	f := ingressAuthFilter(i.Metadata.Annotations)
+	for _, rule := range i.Spec.Rules {
+		if rule.Http == nil {
+			continue
+		}
+
+		for _, p := range rule.Http.Paths {
+			if p.Backend.Service == nil {
+				continue
+			}
+
+			ns := i.Metadata.Namespace
+			port, err := c.servicePort(ns, p.Backend.Service.Name, p.Backend.Service.Port)
+			if err != nil {
+				// the other paths of the ingress can still be served
+				log.Println(err)
+				continue
+			}
+
+			r := &eskip.Route{
+				Id:      ingressRouteId(ns, i.Metadata.Name, rule.Host, p.Path),
+				Backend: fmt.Sprintf("http://%s.%s.svc:%d", p.Backend.Service.Name, ns, port)}
+			if rule.Host != "" {
+				r.HostRegexps = []string{"^" + regexp.QuoteMeta(rule.Host) + "$"}
+			}
+
+			switch {
+			case p.Path == "":
+			case p.PathType == "Exact":
+				r.Path = p.Path
+			case p.PathType == "Prefix":
+				r.PathRegexps = []string{"^" + regexp.QuoteMeta(strings.TrimSuffix(p.Path, "/")) + "($|/)"}
+			default:
+				r.PathRegexps = []string{"^" + regexp.QuoteMeta(p.Path)}
+			}
+
+			if f != nil {
+				r.Filters = []*eskip.Filter{f}
+			}
+
+			routes = append(routes, r)
+		}
+	}
+
+	return routes
+}
+
+func (c *kubeClient) loadRoutes() (map[string]*eskip.Route, error) {
+	items, err := c.ingresses()
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make(map[string]*eskip.Route)
+	for _, i := range items {
+		if !c.matchesClass(i) {
+			continue
+		}
+
+		for _, r := range c.ingressRoutes(i) {
+			if _, exists := routes[r.Id]; exists {
+				log.Printf("route id collision of ingress %s/%s, route ignored: %s", i.Metadata.Namespace, i.Metadata.Name, r.Id)
+				continue
+			}
+
+			routes[r.Id] = r
+		}
+	}
+
+	return routes, nil
+}
+
+func sortedRoutes(m map[string]*eskip.Route) []*eskip.Route {
+	var ids []string
+	for id := range m {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	routes := make([]*eskip.Route, 0, len(ids))
+	for _, id := range ids {
+		routes = append(routes, m[id])
+	}
+
+	return routes
+}
+
+func (c *kubeClient) LoadAll() ([]*eskip.Route, error) {
+	routes, err := c.loadRoutes()
+	if err != nil {
+		return nil, err
+	}
+
+	c.current = routes
+	return sortedRoutes(routes), nil
+}
+
+// the ingresses are listed again, and only the changed and the deleted
+// routes are returned
+func (c *kubeClient) LoadUpdate() ([]*eskip.Route, []string, error) {
+	routes, err := c.loadRoutes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed := make(map[string]*eskip.Route)
+	for id, r := range routes {
+		if !reflect.DeepEqual(c.current[id], r) {
+			changed[id] = r
+		}
+	}
+
+	var deleted []string
+	for id := range c.current {
+		if _, ok := routes[id]; !ok {
+			deleted = append(deleted, id)
+		}
+	}
+
+	sort.Strings(deleted)
+	c.current = routes
+	return sortedRoutes(changed), deleted, nil
+}
@@ -0,0 +1,159 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testIngresses = `{"items": [{
+	"metadata": {
+		"name": "orders",
+		"namespace": "shop",
+		"annotations": {"skoap.zalando.org/realm": "/employees", "skoap.zalando.org/scopes": "read-orders, write-orders"}
+	},
+	"spec": {
+		"rules": [{
+			"host": "orders.example.org",
+			"http": {"paths": [
+				{"path": "/api", "pathType": "Prefix", "backend": {"service": {"name": "orders", "port": {"number": 8080}}}},
+				{"path": "/admin", "pathType": "Exact", "backend": {"service": {"name": "admin", "port": {"name": "http"}}}}
+			]}
+		}]
+	}
+}, {
+	"metadata": {"name": "docs", "namespace": "shop", "annotations": {"skoap.zalando.org/public": "true"}},
+	"spec": {"rules": [{"http": {"paths": [{"backend": {"service": {"name": "docs", "port": {"number": 80}}}}]}}]}
+}, {
+	"metadata": {"name": "other", "namespace": "shop"},
+	"spec": {"ingressClassName": "other", "rules": [{"http": {"paths": [{"backend": {"service": {"name": "other", "port": {"number": 80}}}}]}}]}
+}]}`
+
+func TestKubernetesClient(t *testing.T) {
+	ingresses := testIngresses
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apis/networking.k8s.io/v1/ingresses":
+			w.Write([]byte(ingresses))
+		case "/api/v1/namespaces/shop/services/admin":
+			w.Write([]byte(`{"spec": {"ports": [{"name": "http", "port": 9090}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer apiServer.Close()
+
+	c, err := NewKubernetesClient(KubernetesOptions{APIServerUrl: apiServer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes, err := c.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*eskip.Route{{
+		Id:      ingressRouteId("shop", "docs", "", ""),
+		Backend: "http://docs.shop.svc:80",
+	}, {
+		Id:          ingressRouteId("shop", "orders", "orders.example.org", "/admin"),
+		HostRegexps: []string{`^orders\.example\.org$`},
+		Path:        "/admin",
+		Filters: []*eskip.Filter{{
+			Name: AuthName,
+			Args: []interface{}{"/employees", "read-orders", "write-orders"}}},
+		Backend: "http://admin.shop.svc:9090",
+	}, {
+		Id:          ingressRouteId("shop", "orders", "orders.example.org", "/api"),
+		HostRegexps: []string{`^orders\.example\.org$`},
+		PathRegexps: []string{`^/api($|/)`},
+		Filters: []*eskip.Filter{{
+			Name: AuthName,
+			Args: []interface{}{"/employees", "read-orders", "write-orders"}}},
+		Backend: "http://orders.shop.svc:8080",
+	}, {
+		Id:      ingressRouteId("shop", "other", "", ""),
+		Filters: []*eskip.Filter{{Name: AuthName}},
+		Backend: "http://other.shop.svc:80",
+	}}
+
+	if !reflect.DeepEqual(routes, expected) {
+		t.Error("invalid routes", eskip.String(routes...))
+	}
+
+	ingresses = `{"items": []}`
+	routes, deleted, err := c.LoadUpdate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(routes) != 0 || len(deleted) != len(expected) {
+		t.Error("invalid update", len(routes), deleted)
+	}
+}
+
+func TestIngressAuthFilter(t *testing.T) {
+	for _, ti := range []struct {
+		msg         string
+		annotations map[string]string
+		expected    *eskip.Filter
+	}{{
+		msg:      "no annotations",
+		expected: &eskip.Filter{Name: AuthName},
+	}, {
+		msg:         "public",
+		annotations: map[string]string{PublicAnnotation: "true", ScopesAnnotation: "read"},
+	}, {
+		msg:         "realm",
+		annotations: map[string]string{RealmAnnotation: "/services"},
+		expected:    &eskip.Filter{Name: AuthName, Args: []interface{}{"/services"}},
+	}, {
+		msg:         "teams",
+		annotations: map[string]string{TeamsAnnotation: "team-a,team-b"},
+		expected:    &eskip.Filter{Name: AuthTeamName, Args: []interface{}{"", "team-a", "team-b"}},
+	}, {
+		msg:         "scopes or teams",
+		annotations: map[string]string{ScopesAnnotation: "read", TeamsAnnotation: "team-a"},
+		expected:    &eskip.Filter{Name: AuthScopeOrTeamName, Args: []interface{}{"", "read", "--", "team-a"}},
+	}} {
+		if f := ingressAuthFilter(ti.annotations); !reflect.DeepEqual(f, ti.expected) {
+			t.Error(ti.msg, "invalid filter", f)
+		}
+	}
+}
+
+func TestKubernetesIngressClass(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(testIngresses))
+	}))
+	defer apiServer.Close()
+
+	c, err := NewKubernetesClient(KubernetesOptions{APIServerUrl: apiServer.URL, IngressClass: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes, err := c.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(routes) != 1 || routes[0].Id != ingressRouteId("shop", "other", "", "") {
+		t.Error("failed to filter by ingress class", eskip.String(routes...))
+	}
+}
+
+func TestIngressRouteId(t *testing.T) {
+	id := ingressRouteId("shop", "orders", "orders.example.org", "/api")
+	if !strings.HasPrefix(id, "kube__shop__orders__orders_example_org___api__") || routeIdInvalidChars.MatchString(id) {
+		t.Error("invalid route id", id)
+	}
+
+	if id == ingressRouteId("shop", "orders", "orders_example_org", "/api") {
+		t.Error("route id collision", id)
+	}
+}
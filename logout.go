@@ -0,0 +1,186 @@
+package skoap
+
+import (
+	"context"
+	"github.com/zalando/skipper/filters"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// the revoked tokens are remembered for a day, longer than the
+	// lifetime of the sessions and the caches
+	revokedTokenTTL = 24 * time.Hour
+
+	// the max number of the remembered revoked tokens
+	maxRevokedTokens = 1 << 16
+)
+
+var revocation = struct {
+	mu      sync.Mutex
+	revoked *ttlCache
+	caches  []*ttlCache
+}{revoked: newTTLCache("revoked-tokens", revokedTokenTTL, maxRevokedTokens)}
+
+type (
+	logoutSpec struct {
+		sessions   *authSessions
+		authClient *authClient
+		authCookie string
+		oidcCookie string
+		oidcCodec  *sessionCodec
+	}
+
+	logoutFilter struct {
+		spec     *logoutSpec
+		location string
+	}
+)
+
+// registers a cache keyed by the tokens, to be purged on logout
+func registerTokenCache(c *ttlCache) {
+	revocation.mu.Lock()
+	defer revocation.mu.Unlock()
+	revocation.caches = append(revocation.caches, c)
+}
+
+// purges the token from the caches, and rejects it for a day. The
+// revoked tokens are stored in the shards directly, so that the caching
+// kill switch doesn't disable the revocation.
+func revokeToken(token string) {
+	revocation.revoked.shard(token).set(&cacheEntry{
+		key:     token,
+		value:   true,
+		size:    entryOverhead + int64(len(token)),
+		expires: time.Now().Add(revokedTokenTTL)})
+	for _, c := range tokenCaches() {
+		c.delete(token)
+	}
+}
+
+func tokenRevoked(token string) bool {
+	_, ok := revocation.revoked.shard(token).get(token, time.Now())
+	return ok
+}
+
+// tells whether the token is stored in any of the token caches, i.e. it
+// was validated earlier. The invalid tokens cached by the predicates
+// don't count.
+func tokenCached(token string) bool {
+	now := time.Now()
+	for _, c := range tokenCaches() {
+		if v, ok := c.get(token, now); ok {
+			if _, invalid := v.(error); !invalid {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Creates a logout filter specification. The filter clears the session
+// cookies of the auth filters and of the oidc filter, purges the token
+// of the request from the caches, and rejects it in the subsequent
+// requests, even when it is still valid at the token validation
+// service. Only the tokens of the sessions, and the tokens found in the
+// caches or accepted by the token validation service are revoked, so
+// that arbitrary tokens cannot fill the list of the revoked ones. The
+// optional argument of the filter is the location where the client is
+// redirected, otherwise it is responded with 204.
+func NewLogout(o Options, oidc OIDCOptions) filters.Spec {
+	s := &logoutSpec{
+		sessions:   newAuthSessions(o),
+		authCookie: o.SessionCookie,
+		oidcCookie: oidc.SessionCookie}
+	if s.authCookie == "" {
+		s.authCookie = defaultAuthSessionCookie
+	}
+
+	if s.oidcCookie == "" {
+		s.oidcCookie = defaultSessionCookie
+	}
+
+	if o.AuthUrlBase != "" {
+		s.authClient = newAuthClient(o)
+	}
+
+	if len(oidc.SessionKey) > 0 {
+		s.oidcCodec, _ = newSessionCodec(oidc.SessionKey)
+	}
+
+	return s
+}
+
+func (s *logoutSpec) Name() string { return LogoutName }
+
+func (s *logoutSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	sargs, err := getStrings(args)
+	if err != nil || len(sargs) > 1 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	f := &logoutFilter{spec: s}
+	if len(sargs) == 1 {
+		f.location = sargs[0]
+	}
+
+	return f, nil
+}
+
+// tells whether the token was validated earlier, or is valid now
+func (s *logoutSpec) validated(ctx context.Context, token string) bool {
+	if tokenCached(token) {
+		return true
+	}
+
+	if s.authClient == nil {
+		return false
+	}
+
+	_, err := s.authClient.validate(ctx, token)
+	return err == nil
+}
+
+// collects the token of the request from the Authorization header and
+// from the sessions
+func (s *logoutSpec) tokens(r *http.Request) []string {
+	var tokens []string
+	if token, err := getToken(r); err == nil && s.validated(r.Context(), token) {
+		tokens = append(tokens, token)
+	}
+
+	if _, token, ok := s.sessions.get(r); ok {
+		tokens = append(tokens, token)
+	}
+
+	if s.oidcCodec != nil {
+		var session oidcSession
+		if c, err := r.Cookie(s.oidcCookie); err == nil && s.oidcCodec.decode(s.oidcCookie, c.Value, &session) == nil {
+			tokens = append(tokens, session.Token)
+		}
+	}
+
+	return tokens
+}
+
+func (f *logoutFilter) Request(ctx filters.FilterContext) {
+	for _, token := range f.spec.tokens(ctx.Request()) {
+		revokeToken(token)
+	}
+
+	rsp := &http.Response{StatusCode: http.StatusNoContent, Header: make(http.Header)}
+	if f.location != "" {
+		rsp.StatusCode = http.StatusFound
+		rsp.Header.Set("Location", f.location)
+	}
+
+	for _, name := range []string{f.spec.authCookie, f.spec.oidcCookie} {
+		rsp.Header.Add("Set-Cookie", (&http.Cookie{Name: name, Path: "/", MaxAge: -1}).String())
+	}
+
+	ctx.Serve(rsp)
+}
+
+func (f *logoutFilter) Response(_ filters.FilterContext) {}
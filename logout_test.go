@@ -0,0 +1,116 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters/filtertest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogout(t *testing.T) {
+	const token = "logout-token"
+
+	c := newTTLCache("test-tokens", time.Minute, 0)
+	registerTokenCache(c)
+	c.set(token, "cached", time.Now())
+
+	for _, ti := range []struct {
+		msg      string
+		args     []interface{}
+		status   int
+		location string
+	}{{
+		msg:    "no redirect",
+		status: http.StatusNoContent,
+	}, {
+		msg:      "redirect",
+		args:     []interface{}{"/"},
+		status:   http.StatusFound,
+		location: "/",
+	}} {
+		f, err := NewLogout(Options{}, OIDCOptions{}).CreateFilter(ti.args)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest("GET", "https://www.example.org/logout", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+token)
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		f.Request(ctx)
+
+		if !ctx.FServedWithResponse || ctx.FResponse.StatusCode != ti.status ||
+			ctx.FResponse.Header.Get("Location") != ti.location {
+			t.Error(ti.msg, "invalid response", ctx.FResponse)
+		}
+
+		cookies := strings.Join(ctx.FResponse.Header["Set-Cookie"], "\n")
+		if !strings.Contains(cookies, defaultAuthSessionCookie+"=;") || !strings.Contains(cookies, defaultSessionCookie+"=;") {
+			t.Error(ti.msg, "failed to clear the session cookies", cookies)
+		}
+	}
+
+	if _, ok := c.get(token, time.Now()); ok {
+		t.Error("failed to purge the token from the cache")
+	}
+
+	f, err := NewAuth("https://auth.example.org").CreateFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "https://www.example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set(authHeaderName, "Bearer "+token)
+	ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+	f.Request(ctx)
+	if reason, _ := ctx.FStateBag[authRejectReasonKey].(string); reason != string(invalidToken) {
+		t.Error("failed to reject the revoked token", reason)
+	}
+}
+
+func TestLogoutRevokesOnlyValidatedTokens(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(authHeaderName) != "Bearer valid-logout-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`{"uid": "jdoe"}`))
+	}))
+	defer authServer.Close()
+
+	f, err := NewLogout(Options{AuthUrlBase: authServer.URL}, OIDCOptions{}).CreateFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ti := range []struct {
+		token   string
+		revoked bool
+	}{{
+		token: "unknown-logout-token",
+	}, {
+		token:   "valid-logout-token",
+		revoked: true,
+	}} {
+		req, err := http.NewRequest("GET", "https://www.example.org/logout", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+ti.token)
+		f.Request(&filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})})
+		if tokenRevoked(ti.token) != ti.revoked {
+			t.Error(ti.token, "invalid revocation", ti.revoked)
+		}
+	}
+}
@@ -0,0 +1,450 @@
+package skoap
+
+import (
+	"errors"
+	"fmt"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/routing"
+	"strings"
+	"unicode"
+)
+
+// The claims mapping language is a small expression language to
+// transform the identity of the user into outgoing headers or into
+// variables for the following filters. An expression is a name, a
+// quoted string or a function call:
+//
+//     lower(email)
+//     domain(email)
+//     join(groups, ";")
+//
+// The names are uid, realm, scopes, teams, groups, the method, path and
+// host of the request, and the claims taken from the userinfo endpoint.
+// The values are lists of strings.
+
+type (
+	mappingExpr interface {
+		eval(ctx filters.FilterContext) []string
+	}
+
+	mappingName string
+
+	mappingLiteral string
+
+	mappingCall struct {
+		fn   mappingFunc
+		args []mappingExpr
+	}
+
+	mappingFunc struct {
+		arity int
+		eval  func(args [][]string) []string
+	}
+
+	claimMapping struct {
+		target   string
+		variable bool
+		expr     mappingExpr
+	}
+
+	mapClaimsSpec struct{}
+
+	mapClaims []claimMapping
+
+	claimsMappingClient struct {
+		client routing.DataClient
+		args   []interface{}
+	}
+)
+
+var (
+	errInvalidMapping       = errors.New("invalid claims mapping")
+	errUnexpectedMappingEnd = errors.New("unexpected end of claims mapping expression")
+)
+
+func mapEach(f func(string) string) func([][]string) []string {
+	return func(args [][]string) []string {
+		r := make([]string, len(args[0]))
+		for i, v := range args[0] {
+			r[i] = f(v)
+		}
+
+		return r
+	}
+}
+
+func afterAt(v string) string {
+	if i := strings.LastIndex(v, "@"); i >= 0 {
+		return v[i+1:]
+	}
+
+	return ""
+}
+
+func beforeAt(v string) string {
+	if i := strings.LastIndex(v, "@"); i >= 0 {
+		return v[:i]
+	}
+
+	return v
+}
+
+func single(v []string) string {
+	return strings.Join(v, ",")
+}
+
+var mappingFuncs = map[string]mappingFunc{
+	"lower":     {arity: 1, eval: mapEach(strings.ToLower)},
+	"upper":     {arity: 1, eval: mapEach(strings.ToUpper)},
+	"trim":      {arity: 1, eval: mapEach(strings.TrimSpace)},
+	"domain":    {arity: 1, eval: mapEach(afterAt)},
+	"localpart": {arity: 1, eval: mapEach(beforeAt)},
+	"first": {arity: 1, eval: func(args [][]string) []string {
+		if len(args[0]) == 0 {
+			return nil
+		}
+
+		return args[0][:1]
+	}},
+	"join": {arity: 2, eval: func(args [][]string) []string {
+		return []string{strings.Join(args[0], single(args[1]))}
+	}},
+	"trimprefix": {arity: 2, eval: func(args [][]string) []string {
+		p := single(args[1])
+		return mapEach(func(v string) string { return strings.TrimPrefix(v, p) })(args[:1])
+	}},
+	"replace": {arity: 3, eval: func(args [][]string) []string {
+		o, n := single(args[1]), single(args[2])
+		return mapEach(func(v string) string { return strings.Replace(v, o, n, -1) })(args[:1])
+	}},
+}
+
+type mappingParser struct {
+	src string
+	pos int
+}
+
+func (p *mappingParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// the variable names can contain only letters, digits and underscores,
+// so they cannot override the internal state bag keys
+func isVariableName(n string) bool {
+	for _, c := range n {
+		if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *mappingParser) parseString() (mappingExpr, error) {
+	var b []byte
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		switch c := p.src[p.pos]; c {
+		case '\\':
+			p.pos++
+			if p.pos == len(p.src) {
+				return nil, errUnexpectedMappingEnd
+			}
+
+			b = append(b, p.src[p.pos])
+		case '"':
+			p.pos++
+			return mappingLiteral(b), nil
+		default:
+			b = append(b, c)
+		}
+	}
+
+	return nil, errUnexpectedMappingEnd
+}
+
+func (p *mappingParser) parseExpr() (mappingExpr, error) {
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return nil, errUnexpectedMappingEnd
+	}
+
+	if p.src[p.pos] == '"' {
+		return p.parseString()
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) && isNameChar(p.src[p.pos]) {
+		p.pos++
+	}
+
+	name := p.src[start:p.pos]
+	if name == "" {
+		return nil, fmt.Errorf("%v: unexpected character at %d", errInvalidMapping, p.pos)
+	}
+
+	p.skipSpace()
+	if p.pos == len(p.src) || p.src[p.pos] != '(' {
+		return mappingName(name), nil
+	}
+
+	fn, ok := mappingFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("%v: unknown function: %s", errInvalidMapping, name)
+	}
+
+	p.pos++
+	call := &mappingCall{fn: fn}
+	for {
+		p.skipSpace()
+		if p.pos < len(p.src) && p.src[p.pos] == ')' && len(call.args) == 0 {
+			p.pos++
+			break
+		}
+
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		call.args = append(call.args, arg)
+		p.skipSpace()
+		if p.pos == len(p.src) {
+			return nil, errUnexpectedMappingEnd
+		}
+
+		c := p.src[p.pos]
+		p.pos++
+		if c == ')' {
+			break
+		}
+
+		if c != ',' {
+			return nil, fmt.Errorf("%v: unexpected character at %d", errInvalidMapping, p.pos-1)
+		}
+	}
+
+	if len(call.args) != fn.arity {
+		return nil, fmt.Errorf("%v: %s expects %d arguments", errInvalidMapping, name, fn.arity)
+	}
+
+	return call, nil
+}
+
+func parseMappingExpr(src string) (mappingExpr, error) {
+	p := &mappingParser{src: src}
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos != len(p.src) {
+		return nil, fmt.Errorf("%v: unexpected character at %d", errInvalidMapping, p.pos)
+	}
+
+	return e, nil
+}
+
+// parses a mapping in the form of target=expression. Targets starting
+// with $ are variables, the others are outgoing headers.
+func parseClaimMapping(m string) (claimMapping, error) {
+	target, src, ok := namedArg(m)
+	if !ok {
+		return claimMapping{}, errInvalidMapping
+	}
+
+	target = strings.TrimSpace(target)
+	cm := claimMapping{target: target}
+	if strings.HasPrefix(target, "$") {
+		cm.variable = true
+		cm.target = target[1:]
+	}
+
+	if cm.target == "" || cm.variable && !isVariableName(cm.target) {
+		return claimMapping{}, errInvalidMapping
+	}
+
+	e, err := parseMappingExpr(src)
+	if err != nil {
+		return claimMapping{}, err
+	}
+
+	cm.expr = e
+	return cm, nil
+}
+
+func (n mappingName) eval(ctx filters.FilterContext) []string {
+	sb := ctx.StateBag()
+	a, _ := sb[authDocKey].(*authDoc)
+	switch n {
+	case "uid":
+		if a != nil {
+			return []string{a.Uid}
+		}
+	case "realm":
+		if a != nil {
+			return []string{a.Realm}
+		}
+	case "scopes":
+		if a != nil {
+			return a.Scopes
+		}
+	case "teams":
+		teams, _ := sb[authTeamsKey].([]string)
+		return teams
+	case "groups":
+		groups, _ := sb[authGroupsKey].([]string)
+		return groups
+	case "method":
+		return []string{ctx.Request().Method}
+	case "path":
+		return []string{ctx.Request().URL.Path}
+	case "host":
+		return []string{ctx.Request().Host}
+	default:
+		claims, _ := sb[authClaimsKey].(map[string]string)
+		if v, ok := claims[string(n)]; ok {
+			return []string{v}
+		}
+	}
+
+	return nil
+}
+
+func (l mappingLiteral) eval(_ filters.FilterContext) []string {
+	return []string{string(l)}
+}
+
+func (c *mappingCall) eval(ctx filters.FilterContext) []string {
+	args := make([][]string, len(c.args))
+	for i, a := range c.args {
+		args[i] = a.eval(ctx)
+	}
+
+	return c.fn.eval(args)
+}
+
+// Creates a mapClaims filter specification. The filter transforms the
+// identity of the authenticated user with the claims mapping language,
+// and sets the results as outgoing headers, or as variables that can
+// be used by the setHeaderTemplate filter. The arguments are mappings
+// in the form of target=expression:
+//
+//	mapClaims("X-Email=lower(email)", "$domain=domain(email)")
+func NewMapClaims() filters.Spec { return mapClaimsSpec{} }
+
+func (s mapClaimsSpec) Name() string { return MapClaimsName }
+
+func (s mapClaimsSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	sargs, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sargs) == 0 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	var f mapClaims
+	for _, a := range sargs {
+		m, err := parseClaimMapping(a)
+		if err != nil {
+			return nil, err
+		}
+
+		f = append(f, m)
+	}
+
+	return f, nil
+}
+
+func (f mapClaims) Request(ctx filters.FilterContext) {
+	h := ctx.Request().Header
+	for _, m := range f {
+		v := m.expr.eval(ctx)
+		switch {
+		case m.variable:
+			ctx.StateBag()[m.target] = single(v)
+		case len(v) == 0:
+			h.Del(m.target)
+		default:
+			h.Set(m.target, single(v))
+		}
+	}
+}
+
+func (f mapClaims) Response(_ filters.FilterContext) {}
+
+// ValidateClaimsMappings checks the claims mappings in the form of
+// target=expression, e.g. before passing them to
+// NewClaimsMappingClient.
+func ValidateClaimsMappings(mappings ...string) error {
+	for _, m := range mappings {
+		if _, err := parseClaimMapping(m); err != nil {
+			return fmt.Errorf("invalid claims mapping: %s: %v", m, err)
+		}
+	}
+
+	return nil
+}
+
+// NewClaimsMappingClient wraps a Skipper data client, and applies the
+// claims mappings to all routes, by inserting a mapClaims filter after
+// the last auth filter of the routes. The routes without auth filters
+// are not changed. The routes with invalid mappings fail when the
+// filters are created, see ValidateClaimsMappings.
+func NewClaimsMappingClient(client routing.DataClient, mappings ...string) routing.DataClient {
+	args := make([]interface{}, len(mappings))
+	for i, m := range mappings {
+		args[i] = m
+	}
+
+	return &claimsMappingClient{client: client, args: args}
+}
+
+func (c *claimsMappingClient) setMappings(routes []*eskip.Route) {
+	for _, r := range routes {
+		last := -1
+		for i, f := range r.Filters {
+			if authFilterNames[f.Name] {
+				last = i
+			}
+		}
+
+		if last < 0 {
+			continue
+		}
+
+		fs := make([]*eskip.Filter, 0, len(r.Filters)+1)
+		fs = append(fs, r.Filters[:last+1]...)
+		fs = append(fs, &eskip.Filter{Name: MapClaimsName, Args: c.args})
+		r.Filters = append(fs, r.Filters[last+1:]...)
+	}
+}
+
+func (c *claimsMappingClient) LoadAll() ([]*eskip.Route, error) {
+	routes, err := c.client.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	c.setMappings(routes)
+	return routes, nil
+}
+
+func (c *claimsMappingClient) LoadUpdate() ([]*eskip.Route, []string, error) {
+	routes, deleted, err := c.client.LoadUpdate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.setMappings(routes)
+	return routes, deleted, nil
+}
@@ -0,0 +1,143 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseClaimMapping(t *testing.T) {
+	for _, ti := range []struct {
+		msg     string
+		mapping string
+		fail    bool
+	}{{
+		msg:     "name",
+		mapping: "X-Uid=uid",
+	}, {
+		msg:     "function",
+		mapping: "X-Email=lower(email)",
+	}, {
+		msg:     "nested functions with literal",
+		mapping: `X-Groups = join(trimprefix(groups, "team-"), ";")`,
+	}, {
+		msg:     "variable",
+		mapping: "$domain=domain(email)",
+	}, {
+		msg:     "missing target",
+		mapping: "lower(email)",
+		fail:    true,
+	}, {
+		msg:     "invalid variable name",
+		mapping: "$auth-user=email",
+		fail:    true,
+	}, {
+		msg:     "unknown function",
+		mapping: "X-Email=exec(email)",
+		fail:    true,
+	}, {
+		msg:     "wrong number of arguments",
+		mapping: "X-Email=lower(email, name)",
+		fail:    true,
+	}, {
+		msg:     "unclosed call",
+		mapping: "X-Email=lower(email",
+		fail:    true,
+	}, {
+		msg:     "unclosed string",
+		mapping: `X-Groups=join(groups, ";)`,
+		fail:    true,
+	}, {
+		msg:     "trailing characters",
+		mapping: "X-Email=lower(email) email",
+		fail:    true,
+	}} {
+		_, err := parseClaimMapping(ti.mapping)
+		if ti.fail != (err != nil) {
+			t.Error(ti.msg, "unexpected result", err)
+		}
+	}
+}
+
+func TestMapClaims(t *testing.T) {
+	var headers http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+	}))
+	defer backend.Close()
+
+	fr := make(filters.Registry)
+	fr.Register(stateBagFilter{
+		authDocKey:    &authDoc{Uid: testUid, Realm: testRealm},
+		authGroupsKey: []string{"team-foo", "team-bar"},
+		authClaimsKey: map[string]string{"email": "JDoe@Example.org"}})
+	fr.Register(NewMapClaims())
+	fr.Register(NewSetHeaderTemplate())
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{
+			{Name: "testStateBag"},
+			{Name: MapClaimsName, Args: []interface{}{
+				"X-Email=lower(email)",
+				`X-Groups=join(trimprefix(groups, "team-"), ";")`,
+				"$domain=domain(lower(email))",
+				"X-Missing=name"}},
+			{Name: SetHeaderTemplateName, Args: []interface{}{"X-Tenant", "${domain}"}}},
+		Backend: backend.URL})
+	defer proxy.Close()
+
+	req, err := http.NewRequest("GET", proxy.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("X-Missing", "fake")
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+	if headers.Get("X-Email") != "jdoe@example.org" ||
+		headers.Get("X-Groups") != "foo;bar" ||
+		headers.Get("X-Tenant") != "example.org" ||
+		headers.Get("X-Missing") != "" {
+		t.Error("invalid headers", headers)
+	}
+}
+
+func TestClaimsMappingClient(t *testing.T) {
+	routes := []*eskip.Route{{
+		Id:      "protected",
+		Filters: []*eskip.Filter{{Name: OwnerName}, {Name: AuthName}, {Name: ForwardAuthName}},
+	}, {
+		Id:      "public",
+		Filters: []*eskip.Filter{{Name: OwnerName}},
+	}}
+
+	c := NewClaimsMappingClient(&testDataClient{all: routes}, "X-Email=lower(email)")
+	loaded, err := c.LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded[0].Filters) != 4 || loaded[0].Filters[2].Name != MapClaimsName {
+		t.Error("failed to insert the mapping filter", loaded[0].Filters)
+	}
+
+	if len(loaded[1].Filters) != 1 {
+		t.Error("unexpected change of public route", loaded[1].Filters)
+	}
+}
+
+func TestValidateClaimsMappings(t *testing.T) {
+	if err := ValidateClaimsMappings("X-Email=lower(email)", `X-Groups=join(groups, ";")`); err != nil {
+		t.Error(err)
+	}
+
+	if err := ValidateClaimsMappings("X-Email=lower(email)", "X-Groups=join(groups"); err == nil {
+		t.Error("failed to fail")
+	}
+}
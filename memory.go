@@ -0,0 +1,129 @@
+package skoap
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// the estimated overhead of a cache or buffer entry, in addition to the
+// size of its keys and values
+const entryOverhead = 96
+
+// MemoryStats contains the footprint of a cache or a buffer.
+type MemoryStats struct {
+	Name      string `json:"name"`
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// MemoryReport contains the footprint of all the caches and buffers,
+// and the memory budget.
+type MemoryReport struct {
+	Budget int64         `json:"budget"`
+	Bytes  int64         `json:"bytes"`
+	Stats  []MemoryStats `json:"stats"`
+}
+
+// memoryUser is implemented by the caches and buffers whose footprint
+// is accounted.
+type memoryUser interface {
+	memoryStats() MemoryStats
+}
+
+var memory struct {
+	mu     sync.Mutex
+	users  []memoryUser
+	budget int64
+	bytes  int64
+}
+
+func init() {
+	expvar.Publish("skoap-memory", expvar.Func(func() interface{} { return Memory() }))
+}
+
+func registerMemoryUser(u memoryUser) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	memory.users = append(memory.users, u)
+}
+
+func addMemory(bytes int64) {
+	atomic.AddInt64(&memory.bytes, bytes)
+}
+
+func overMemoryBudget() bool {
+	budget := atomic.LoadInt64(&memory.budget)
+	return budget > 0 && atomic.LoadInt64(&memory.bytes) > budget
+}
+
+// Sets the global memory budget of the caches and buffers in bytes.
+// When the budget is exceeded, the caches evict their least recently
+// used entries. Zero means no budget.
+func SetMemoryBudget(bytes int64) {
+	atomic.StoreInt64(&memory.budget, bytes)
+}
+
+// Returns the footprint of all the caches and buffers.
+func Memory() MemoryReport {
+	memory.mu.Lock()
+	users := memory.users
+	memory.mu.Unlock()
+
+	r := MemoryReport{
+		Budget: atomic.LoadInt64(&memory.budget),
+		Bytes:  atomic.LoadInt64(&memory.bytes)}
+	for _, u := range users {
+		r.Stats = append(r.Stats, u.memoryStats())
+	}
+
+	return r
+}
+
+// Creates an HTTP handler returning the footprint of the caches and
+// buffers as JSON.
+func NewMemoryHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Memory())
+	})
+}
+
+// estimates the size of a cached value
+func sizeOf(v interface{}) int64 {
+	switch vv := v.(type) {
+	case string:
+		return int64(len(vv))
+	case map[string]string:
+		var s int64
+		for k, v := range vv {
+			s += int64(len(k) + len(v))
+		}
+
+		return s
+	case []string:
+		var s int64
+		for _, vi := range vv {
+			s += int64(len(vi))
+		}
+
+		return s
+	case *authDoc:
+		return int64(len(vv.Uid)+len(vv.Realm)+len(vv.ClientId)) + sizeOf(vv.Scopes) + sizeOf(vv.Audience)
+	default:
+		return 0
+	}
+}
+
+// estimates the size of an audit entry
+func docSize(d *AuditDoc) int64 {
+	s := int64(entryOverhead + len(d.Method) + len(d.Path) + len(d.RequestBody))
+	if d.AuthStatus != nil {
+		s += int64(len(d.AuthStatus.User)+len(d.AuthStatus.Reason)) + sizeOf(d.AuthStatus.Claims)
+	}
+
+	return s
+}
@@ -0,0 +1,112 @@
+package skoap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	now := time.Now()
+	c := newTTLCache("test", time.Minute, 2)
+	c.set("foo", "1", now)
+	c.set("bar", "2", now)
+	c.get("foo", now)
+	c.set("baz", "3", now)
+
+	if _, ok := c.get("bar", now); ok {
+		t.Error("failed to evict the least recently used entry")
+	}
+
+	for _, k := range []string{"foo", "baz"} {
+		if _, ok := c.get(k, now); !ok {
+			t.Error("failed to keep entry", k)
+		}
+	}
+
+	if s := c.memoryStats(); s.Entries != 2 || s.Evictions != 1 {
+		t.Error("invalid stats", s)
+	}
+}
+
+func TestMemoryBudgetUnderChurn(t *testing.T) {
+	const cacheBudget = 64 << 10
+	budget := Memory().Bytes + cacheBudget
+	SetMemoryBudget(budget)
+	defer SetMemoryBudget(0)
+
+	now := time.Now()
+	c := newTTLCache("test", time.Minute, 1<<20)
+	value := strings.Repeat("x", 100)
+	for i := 0; i < 100000; i++ {
+		c.set(fmt.Sprintf("token-%d", i), value, now)
+		if Memory().Bytes > budget {
+			t.Fatal("memory budget exceeded", i)
+		}
+	}
+
+	s := c.memoryStats()
+	if s.Bytes > cacheBudget || s.Evictions == 0 {
+		t.Error("invalid stats", s)
+	}
+
+	if _, ok := c.get("token-99999", now); !ok {
+		t.Error("failed to keep the most recent entry")
+	}
+
+	t.Logf("entries: %d, bytes: %d, evictions: %d", s.Entries, s.Bytes, s.Evictions)
+}
+
+func TestMemoryHandler(t *testing.T) {
+	c := newTTLCache("test-handler", time.Minute, 0)
+	c.set("foo", "bar", time.Now())
+
+	w := httptest.NewRecorder()
+	NewMemoryHandler().ServeHTTP(w, httptest.NewRequest("GET", "/memory", nil))
+
+	var r MemoryReport
+	if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range r.Stats {
+		if s.Name == "test-handler" {
+			if s.Entries != 1 || s.Bytes == 0 {
+				t.Error("invalid stats", s)
+			}
+
+			return
+		}
+	}
+
+	t.Error("cache not found")
+}
+
+func BenchmarkCacheParallel(b *testing.B) {
+	for _, shards := range []int{1, defaultCacheShards} {
+		b.Run(fmt.Sprintf("shards-%d", shards), func(b *testing.B) {
+			c := newShardedCache("bench", time.Minute, 1<<16, shards)
+			now := time.Now()
+			keys := make([]string, 1<<12)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("uid-%d", i)
+				c.set(keys[i], "value", now)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					k := keys[i%len(keys)]
+					if i%10 == 0 {
+						c.set(k, "value", now)
+					} else {
+						c.get(k, now)
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,461 @@
+package skoap
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/zalando/skipper/filters"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSessionCookie = "skoap-session"
+	stateCookieSuffix    = "-state"
+	defaultSessionTTL    = time.Hour
+	loginStateTTL        = 10 * time.Minute
+	oidcTimeout          = 10 * time.Second
+)
+
+const oidcLoginFailed rejectReason = "oidc-login-failed"
+
+var (
+	errMissingOIDCSettings = errors.New("missing OIDC settings, the authorization, token and redirect urls and the session key are required")
+	errInvalidIDToken      = errors.New("invalid ID token")
+)
+
+// OIDCOptions contains the settings of the oidc filter.
+type OIDCOptions struct {
+
+	// The authorization endpoint of the identity provider.
+	AuthorizationUrl string
+
+	// The token endpoint of the identity provider.
+	TokenUrl string
+
+	// The issuer of the identity provider. When set, the iss claim of
+	// the ID tokens is checked, too.
+	Issuer string
+
+	// The client id and secret of skoap registered at the identity
+	// provider.
+	ClientId     string
+	ClientSecret string
+
+	// The absolute url of the callback, registered at the identity
+	// provider. The requests to its path are handled by the filter.
+	RedirectUrl string
+
+	// The requested scopes. Defaults to openid.
+	Scopes []string
+
+	// The secret used to encrypt the session cookies.
+	SessionKey []byte
+
+	// The name of the session cookie. Defaults to skoap-session.
+	SessionCookie string
+
+	// The lifetime of the sessions, when the identity provider doesn't
+	// tell the expiry of the tokens. Defaults to one hour.
+	SessionTTL time.Duration
+
+	// When set, the failed logins are responded with a JSON body
+	// containing the reject reason.
+	JSONErrors bool
+}
+
+type (
+	oidcSpec struct {
+		options OIDCOptions
+	}
+
+	oidcFilter struct {
+		options      OIDCOptions
+		codec        *sessionCodec
+		callbackPath string
+		secure       bool
+		client       *http.Client
+	}
+
+	oidcSession struct {
+		Token   string `json:"t"`
+		Expires int64  `json:"e"`
+	}
+
+	// the PKCE code verifier and the nonce are kept in the state cookie
+	oidcState struct {
+		State    string `json:"s"`
+		Url      string `json:"u"`
+		Verifier string `json:"v"`
+		Nonce    string `json:"n"`
+	}
+
+	oidcTokenDoc struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		IdToken     string `json:"id_token"`
+	}
+
+	idTokenClaims struct {
+		Issuer   string          `json:"iss"`
+		Audience json.RawMessage `json:"aud"`
+		Expires  int64           `json:"exp"`
+		Nonce    string          `json:"nonce"`
+	}
+)
+
+// Creates an oidc filter specification. The filter lets the browser
+// clients log in with the OIDC authorization code flow: when a browser
+// request has neither a token, nor a session, it is redirected to the
+// authorization endpoint of the identity provider. The filter handles
+// the callback, exchanges the code for an access token, and stores the
+// token in an encrypted session cookie. For the subsequent requests
+// with a safe method, GET, HEAD or OPTIONS, the token is taken from the
+// session and set as the Authorization header, to be validated by the
+// following auth filters. The requests with other methods need to send
+// the token themselves, so that cross-site requests cannot use the
+// session.
+//
+// The login uses PKCE and a nonce. The ID token, received directly from
+// the token endpoint, is checked for the nonce, the audience, the expiry,
+// and when set, the issuer. Its signature is not verified, relying on
+// the TLS connection to the token endpoint.
+func NewOIDC(o OIDCOptions) filters.Spec {
+	if o.SessionCookie == "" {
+		o.SessionCookie = defaultSessionCookie
+	}
+
+	if o.SessionTTL <= 0 {
+		o.SessionTTL = defaultSessionTTL
+	}
+
+	if len(o.Scopes) == 0 {
+		o.Scopes = []string{"openid"}
+	}
+
+	return &oidcSpec{options: o}
+}
+
+func (s *oidcSpec) Name() string { return OIDCName }
+
+func (s *oidcSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	if len(args) != 0 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	o := s.options
+	if o.AuthorizationUrl == "" || o.TokenUrl == "" || o.RedirectUrl == "" || len(o.SessionKey) == 0 {
+		return nil, errMissingOIDCSettings
+	}
+
+	ru, err := url.Parse(o.RedirectUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := newSessionCodec(o.SessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcFilter{
+		options:      o,
+		codec:        codec,
+		callbackPath: ru.Path,
+		secure:       ru.Scheme == "https",
+		client:       &http.Client{Timeout: oidcTimeout}}, nil
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// the PKCE code challenge of the S256 method
+func codeChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func isSafeMethod(r *http.Request) bool {
+	return r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS"
+}
+
+func isBrowserRequest(r *http.Request) bool {
+	return (r.Method == "GET" || r.Method == "HEAD") && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func (f *oidcFilter) setCookie(h http.Header, name, value string, expires time.Time) {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   f.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode}
+	if value == "" {
+		c.MaxAge = -1
+	}
+
+	h.Add("Set-Cookie", c.String())
+}
+
+func (f *oidcFilter) session(r *http.Request) (string, bool) {
+	c, err := r.Cookie(f.options.SessionCookie)
+	if err != nil {
+		return "", false
+	}
+
+	var s oidcSession
+	if err := f.codec.decode(f.options.SessionCookie, c.Value, &s); err != nil {
+		return "", false
+	}
+
+	if time.Now().Unix() >= s.Expires || tokenRevoked(s.Token) {
+		return "", false
+	}
+
+	return s.Token, true
+}
+
+// redirects to the authorization endpoint, and stores the state and
+// the original url in a short-lived cookie
+func (f *oidcFilter) login(ctx filters.FilterContext) {
+	var state, verifier, nonce string
+	var err error
+	for _, v := range []*string{&state, &verifier, &nonce} {
+		if *v, err = randomState(); err != nil {
+			log.Println(err)
+			ctx.Serve(&http.Response{StatusCode: http.StatusInternalServerError})
+			return
+		}
+	}
+
+	stateCookie := f.options.SessionCookie + stateCookieSuffix
+	value, err := f.codec.encode(stateCookie, &oidcState{
+		State:    state,
+		Url:      ctx.Request().URL.RequestURI(),
+		Verifier: verifier,
+		Nonce:    nonce})
+	if err != nil {
+		log.Println(err)
+		ctx.Serve(&http.Response{StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {f.options.ClientId},
+		"redirect_uri":          {f.options.RedirectUrl},
+		"scope":                 {strings.Join(f.options.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge(verifier)},
+		"code_challenge_method": {"S256"}}
+
+	location := f.options.AuthorizationUrl
+	if strings.Contains(location, "?") {
+		location += "&" + q.Encode()
+	} else {
+		location += "?" + q.Encode()
+	}
+
+	h := http.Header{"Location": []string{location}}
+	f.setCookie(h, stateCookie, value, time.Now().Add(loginStateTTL))
+	ctx.Serve(&http.Response{StatusCode: http.StatusFound, Header: h})
+}
+
+func (f *oidcFilter) exchangeCode(code, verifier string) (*oidcTokenDoc, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {f.options.RedirectUrl},
+		"code_verifier": {verifier}}
+
+	req, err := http.NewRequest("POST", f.options.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(f.options.ClientId, f.options.ClientSecret)
+	rsp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to exchange authorization code: %s", rsp.Status)
+	}
+
+	var d oidcTokenDoc
+	if err := json.NewDecoder(rsp.Body).Decode(&d); err != nil {
+		return nil, err
+	}
+
+	if d.AccessToken == "" {
+		return nil, errors.New("no access token received")
+	}
+
+	return &d, nil
+}
+
+func (f *oidcFilter) requestsIDToken() bool {
+	for _, s := range f.options.Scopes {
+		if s == "openid" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func audienceContains(aud json.RawMessage, clientId string) bool {
+	var single string
+	if err := json.Unmarshal(aud, &single); err == nil {
+		return single == clientId
+	}
+
+	var list []string
+	if err := json.Unmarshal(aud, &list); err != nil {
+		return false
+	}
+
+	for _, a := range list {
+		if a == clientId {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checks the claims of the ID token received from the token endpoint
+func (f *oidcFilter) verifyIDToken(idToken, nonce string) error {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return errInvalidIDToken
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return errInvalidIDToken
+	}
+
+	var c idTokenClaims
+	if err := json.Unmarshal(b, &c); err != nil {
+		return errInvalidIDToken
+	}
+
+	switch {
+	case c.Nonce != nonce:
+		return fmt.Errorf("%v: nonce mismatch", errInvalidIDToken)
+	case !audienceContains(c.Audience, f.options.ClientId):
+		return fmt.Errorf("%v: invalid audience", errInvalidIDToken)
+	case time.Now().Unix() >= c.Expires:
+		return fmt.Errorf("%v: expired", errInvalidIDToken)
+	case f.options.Issuer != "" && c.Issuer != f.options.Issuer:
+		return fmt.Errorf("%v: invalid issuer", errInvalidIDToken)
+	default:
+		return nil
+	}
+}
+
+// handles the redirect from the identity provider, and sets the session
+// cookie
+func (f *oidcFilter) callback(ctx filters.FilterContext) {
+	r := ctx.Request()
+	stateCookie := f.options.SessionCookie + stateCookieSuffix
+
+	var state oidcState
+	c, err := r.Cookie(stateCookie)
+	if err == nil {
+		err = f.codec.decode(stateCookie, c.Value, &state)
+	}
+
+	q := r.URL.Query()
+	if err != nil || state.State == "" || q.Get("state") != state.State || q.Get("code") == "" {
+		unauthorized(ctx, "", oidcLoginFailed, f.options.JSONErrors)
+		return
+	}
+
+	d, err := f.exchangeCode(q.Get("code"), state.Verifier)
+	if err == nil && (d.IdToken != "" || f.requestsIDToken()) {
+		err = f.verifyIDToken(d.IdToken, state.Nonce)
+	}
+
+	if err != nil {
+		log.Println(err)
+		unauthorized(ctx, "", oidcLoginFailed, f.options.JSONErrors)
+		return
+	}
+
+	ttl := f.options.SessionTTL
+	if d.ExpiresIn > 0 {
+		ttl = time.Duration(d.ExpiresIn) * time.Second
+	}
+
+	expires := time.Now().Add(ttl)
+	value, err := f.codec.encode(f.options.SessionCookie, &oidcSession{Token: d.AccessToken, Expires: expires.Unix()})
+	if err != nil {
+		log.Println(err)
+		ctx.Serve(&http.Response{StatusCode: http.StatusInternalServerError})
+		return
+	}
+
+	// only the path of the original url is stored, to avoid redirecting
+	// to other hosts
+	location := state.Url
+	if !strings.HasPrefix(location, "/") || strings.HasPrefix(location, "//") {
+		location = "/"
+	}
+
+	h := http.Header{"Location": []string{location}}
+	f.setCookie(h, f.options.SessionCookie, value, expires)
+	f.setCookie(h, stateCookie, "", time.Time{})
+	ctx.Serve(&http.Response{StatusCode: http.StatusFound, Header: h})
+}
+
+func (f *oidcFilter) Request(ctx filters.FilterContext) {
+	r := ctx.Request()
+	if r.URL.Path == f.callbackPath {
+		f.callback(ctx)
+		return
+	}
+
+	// the API clients sending their own tokens are not affected
+	if r.Header.Get(authHeaderName) != "" {
+		return
+	}
+
+	// the session is used only for the safe methods, so that the
+	// cross-site requests changing state are not authenticated by the
+	// cookie
+	if !isSafeMethod(r) {
+		return
+	}
+
+	if token, ok := f.session(r); ok {
+		r.Header.Set(authHeaderName, "Bearer "+token)
+		return
+	}
+
+	if isBrowserRequest(r) {
+		f.login(ctx)
+	}
+}
+
+func (f *oidcFilter) Response(_ filters.FilterContext) {}
@@ -0,0 +1,164 @@
+package skoap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/filters/filtertest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testIDToken(claims map[string]interface{}) string {
+	b, _ := json.Marshal(claims)
+	return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(b) + ".c2lnbmF0dXJl"
+}
+
+func oidcRequest(t *testing.T, f filters.Filter, u string, cookies []*http.Cookie, browser bool) *filtertest.Context {
+	return oidcMethodRequest(t, f, "GET", u, cookies, browser)
+}
+
+func oidcMethodRequest(t *testing.T, f filters.Filter, method, u string, cookies []*http.Cookie, browser bool) *filtertest.Context {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if browser {
+		req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	}
+
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+
+	ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+	f.Request(ctx)
+	return ctx
+}
+
+func responseCookies(rsp *http.Response) []*http.Cookie {
+	return (&http.Response{Header: rsp.Header}).Cookies()
+}
+
+func TestOIDC(t *testing.T) {
+	if _, err := NewOIDC(OIDCOptions{}).CreateFilter(nil); err == nil {
+		t.Error("failed to fail on missing settings")
+	}
+
+	// set from the login redirect
+	var challenge, nonce string
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("code") != "test-code" ||
+			r.Form.Get("grant_type") != "authorization_code" ||
+			codeChallenge(r.Form.Get("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		idToken := testIDToken(map[string]interface{}{
+			"iss":   "https://idp.example.org",
+			"aud":   []string{"skoap"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": nonce})
+		if err := json.NewEncoder(w).Encode(&oidcTokenDoc{AccessToken: testToken, ExpiresIn: 3600, IdToken: idToken}); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer tokenServer.Close()
+
+	f, err := NewOIDC(OIDCOptions{
+		AuthorizationUrl: "https://idp.example.org/authorize",
+		TokenUrl:         tokenServer.URL,
+		Issuer:           "https://idp.example.org",
+		ClientId:         "skoap",
+		RedirectUrl:      "https://www.example.org/.skoap/callback",
+		SessionKey:       []byte("test-secret")}).CreateFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := oidcRequest(t, f, "https://www.example.org/api", nil, false)
+	if ctx.FServedWithResponse || ctx.FRequest.Header.Get(authHeaderName) != "" {
+		t.Error("failed to let the API request through")
+	}
+
+	ctx = oidcRequest(t, f, "https://www.example.org/ui?page=2", nil, true)
+	if !ctx.FServedWithResponse || ctx.FResponse.StatusCode != http.StatusFound {
+		t.Fatal("failed to redirect to the login")
+	}
+
+	location, err := url.Parse(ctx.FResponse.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := location.Query().Get("state")
+	if !strings.HasPrefix(location.String(), "https://idp.example.org/authorize?") || state == "" ||
+		location.Query().Get("redirect_uri") != "https://www.example.org/.skoap/callback" ||
+		location.Query().Get("code_challenge_method") != "S256" {
+		t.Error("invalid login redirect", location)
+	}
+
+	challenge = location.Query().Get("code_challenge")
+	stateCookies := responseCookies(ctx.FResponse)
+	for _, c := range stateCookies {
+		if c.SameSite != http.SameSiteLaxMode {
+			t.Error("invalid SameSite attribute of the state cookie", c.SameSite)
+		}
+	}
+
+	nonce = "wrong-nonce"
+	ctx = oidcRequest(t, f, "https://www.example.org/.skoap/callback?code=test-code&state="+state, stateCookies, true)
+	if !ctx.FServedWithResponse || ctx.FResponse.StatusCode != http.StatusUnauthorized {
+		t.Error("failed to reject invalid nonce")
+	}
+
+	nonce = location.Query().Get("nonce")
+
+	ctx = oidcRequest(t, f, "https://www.example.org/.skoap/callback?code=test-code&state=wrong", stateCookies, true)
+	if !ctx.FServedWithResponse || ctx.FResponse.StatusCode != http.StatusUnauthorized {
+		t.Error("failed to reject invalid state")
+	}
+
+	ctx = oidcRequest(t, f, "https://www.example.org/.skoap/callback?code=test-code&state="+state, stateCookies, true)
+	if !ctx.FServedWithResponse || ctx.FResponse.StatusCode != http.StatusFound ||
+		ctx.FResponse.Header.Get("Location") != "/ui?page=2" {
+		t.Fatal("failed to handle the callback")
+	}
+
+	var session []*http.Cookie
+	for _, c := range responseCookies(ctx.FResponse) {
+		if c.Name == defaultSessionCookie {
+			session = append(session, c)
+		}
+	}
+
+	if len(session) != 1 || strings.Contains(session[0].Value, testToken) {
+		t.Fatal("invalid session cookie", session)
+	}
+
+	if session[0].SameSite != http.SameSiteLaxMode {
+		t.Error("invalid SameSite attribute of the session cookie", session[0].SameSite)
+	}
+
+	ctx = oidcRequest(t, f, "https://www.example.org/ui", session, true)
+	if ctx.FServedWithResponse || ctx.FRequest.Header.Get(authHeaderName) != "Bearer "+testToken {
+		t.Error("failed to authenticate with the session")
+	}
+
+	ctx = oidcMethodRequest(t, f, "POST", "https://www.example.org/ui", session, true)
+	if ctx.FServedWithResponse || ctx.FRequest.Header.Get(authHeaderName) != "" {
+		t.Error("failed to ignore the session for unsafe methods")
+	}
+
+	session[0].Value = session[0].Value[:len(session[0].Value)-2] + "xx"
+	ctx = oidcRequest(t, f, "https://www.example.org/ui", session, true)
+	if !ctx.FServedWithResponse || ctx.FResponse.StatusCode != http.StatusFound {
+		t.Error("failed to redirect with tampered session")
+	}
+}
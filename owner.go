@@ -0,0 +1,117 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/routing"
+	"net/http"
+	"time"
+)
+
+const (
+	routeOwnerKey       = "route-owner"
+	ownerStartKey       = "route-owner-start"
+	ownerMetricsPrefix  = "skoap.owner."
+	ownerRequestsMetric = ".requests"
+	ownerRejectedMetric = ".rejected"
+	ownerLatencyMetric  = ".latency"
+)
+
+type (
+	ownerSpec struct{}
+
+	owner string
+
+	ownerClient struct {
+		client routing.DataClient
+		owners map[string]string
+	}
+)
+
+// Creates an owner filter specification. The filter takes a single
+// argument, the owner of the route, typically a team name. The owner
+// is printed in the audit log entries, and the requests, the rejected
+// requests and the latency of the route are measured in metrics keyed
+// by the owner.
+func NewOwner() filters.Spec { return ownerSpec{} }
+
+func (s ownerSpec) Name() string { return OwnerName }
+
+func (s ownerSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	if len(args) != 1 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	o, ok := args[0].(string)
+	if !ok || o == "" {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	return owner(o), nil
+}
+
+func (o owner) Request(ctx filters.FilterContext) {
+	ctx.StateBag()[routeOwnerKey] = string(o)
+	ctx.StateBag()[ownerStartKey] = time.Now()
+	ctx.Metrics().IncCounter(ownerMetricsPrefix + string(o) + ownerRequestsMetric)
+}
+
+func (o owner) Response(ctx filters.FilterContext) {
+	if start, ok := ctx.StateBag()[ownerStartKey].(time.Time); ok {
+		ctx.Metrics().MeasureSince(ownerMetricsPrefix+string(o)+ownerLatencyMetric, start)
+	}
+
+	rsp := ctx.Response()
+	if rsp.StatusCode == http.StatusUnauthorized || rsp.StatusCode == http.StatusForbidden {
+		ctx.Metrics().IncCounter(ownerMetricsPrefix + string(o) + ownerRejectedMetric)
+	}
+}
+
+// NewOwnerClient wraps a Skipper data client, and prepends an owner
+// filter to the routes whose id is found in the owners map, unless the
+// route already contains an owner filter. The keys of the owners map
+// are the route ids, and the values are the owners.
+func NewOwnerClient(client routing.DataClient, owners map[string]string) routing.DataClient {
+	return &ownerClient{client: client, owners: owners}
+}
+
+func (c *ownerClient) setOwners(routes []*eskip.Route) {
+	for _, r := range routes {
+		o, ok := c.owners[r.Id]
+		if !ok {
+			continue
+		}
+
+		hasOwner := false
+		for _, f := range r.Filters {
+			if f.Name == OwnerName {
+				hasOwner = true
+				break
+			}
+		}
+
+		if !hasOwner {
+			r.Filters = append([]*eskip.Filter{{Name: OwnerName, Args: []interface{}{o}}}, r.Filters...)
+		}
+	}
+}
+
+func (c *ownerClient) LoadAll() ([]*eskip.Route, error) {
+	routes, err := c.client.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	c.setOwners(routes)
+	return routes, nil
+}
+
+func (c *ownerClient) LoadUpdate() ([]*eskip.Route, []string, error) {
+	routes, deleted, err := c.client.LoadUpdate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.setOwners(routes)
+	return routes, deleted, nil
+}
@@ -0,0 +1,75 @@
+package skoap
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOwnerInAuditLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	var out bytes.Buffer
+	fr := make(filters.Registry)
+	fr.Register(NewOwner())
+	al := NewAuditLog(&out)
+	fr.Register(al)
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{
+			{Name: OwnerName, Args: []interface{}{"teapot"}},
+			{Name: AuditLogName}},
+		Backend: backend.URL})
+
+	rsp, err := http.Get(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+	flushAuditLog(al)
+
+	var d AuditDoc
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Owner != "teapot" {
+		t.Error("failed to log the owner", d.Owner)
+	}
+}
+
+func TestOwnerClient(t *testing.T) {
+	dc := &testDataClient{all: []*eskip.Route{{
+		Id:      "foo",
+		Filters: []*eskip.Filter{{Name: AuthName}},
+	}, {
+		Id:      "bar",
+		Filters: []*eskip.Filter{{Name: OwnerName, Args: []interface{}{"explicit"}}},
+	}, {
+		Id: "baz",
+	}}}
+
+	routes, err := NewOwnerClient(dc, map[string]string{"foo": "teapot", "bar": "mop"}).LoadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(routes[0].Filters) != 2 || routes[0].Filters[0].Name != OwnerName ||
+		routes[0].Filters[0].Args[0] != "teapot" {
+		t.Error("failed to set owner")
+	}
+
+	if len(routes[1].Filters) != 1 || routes[1].Filters[0].Args[0] != "explicit" {
+		t.Error("failed to keep explicit owner")
+	}
+
+	if len(routes[2].Filters) != 0 {
+		t.Error("unexpected owner")
+	}
+}
@@ -0,0 +1,109 @@
+package skoap
+
+import (
+	"fmt"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/routing"
+	"strings"
+)
+
+type (
+	// RoutesWithoutAuthError is returned when routes are found without
+	// any of the auth filters.
+	RoutesWithoutAuthError []string
+
+	authRequiredClient struct {
+		client routing.DataClient
+		public map[string]bool
+	}
+)
+
+// the names of the filters that authenticate the incoming requests
+var authFilterNames = map[string]bool{
+	AuthName:            true,
+	AuthAllName:         true,
+	AuthTeamName:        true,
+	AuthTeamAllName:     true,
+	AuthGroupName:       true,
+	AuthScopeOrTeamName: true,
+	VerifyBasicAuthName: true,
+	ClientCertName:      true,
+}
+
+func (err RoutesWithoutAuthError) Error() string {
+	return fmt.Sprintf("routes without auth filter: %s", strings.Join(err, ", "))
+}
+
+func hasAuthFilter(r *eskip.Route) bool {
+	for _, f := range r.Filters {
+		if authFilterNames[f.Name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkAuthRequired(routes []*eskip.Route, public map[string]bool) error {
+	var missing RoutesWithoutAuthError
+	for _, r := range routes {
+		if !public[r.Id] && !hasAuthFilter(r) {
+			missing = append(missing, r.Id)
+		}
+	}
+
+	if len(missing) > 0 {
+		return missing
+	}
+
+	return nil
+}
+
+func publicSet(publicRouteIds []string) map[string]bool {
+	public := make(map[string]bool)
+	for _, id := range publicRouteIds {
+		public[id] = true
+	}
+
+	return public
+}
+
+// CheckAuthRequired returns a RoutesWithoutAuthError when any of the
+// routes doesn't contain an auth filter, except for the routes whose
+// id is listed as public.
+func CheckAuthRequired(routes []*eskip.Route, publicRouteIds ...string) error {
+	return checkAuthRequired(routes, publicSet(publicRouteIds))
+}
+
+// NewAuthRequiredClient wraps a Skipper data client, and rejects the
+// initial route set, or any update of it, that contains routes without
+// an auth filter, except for the routes whose id is listed as public.
+func NewAuthRequiredClient(client routing.DataClient, publicRouteIds ...string) routing.DataClient {
+	return &authRequiredClient{client: client, public: publicSet(publicRouteIds)}
+}
+
+func (c *authRequiredClient) LoadAll() ([]*eskip.Route, error) {
+	routes, err := c.client.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkAuthRequired(routes, c.public); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+func (c *authRequiredClient) LoadUpdate() ([]*eskip.Route, []string, error) {
+	routes, deleted, err := c.client.LoadUpdate()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkAuthRequired(routes, c.public); err != nil {
+		return nil, nil, err
+	}
+
+	return routes, deleted, nil
+}
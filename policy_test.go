@@ -0,0 +1,55 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/eskip"
+	"testing"
+)
+
+type testDataClient struct {
+	all    []*eskip.Route
+	update []*eskip.Route
+}
+
+func (c *testDataClient) LoadAll() ([]*eskip.Route, error) { return c.all, nil }
+
+func (c *testDataClient) LoadUpdate() ([]*eskip.Route, []string, error) {
+	return c.update, nil, nil
+}
+
+func TestCheckAuthRequired(t *testing.T) {
+	routes := []*eskip.Route{{
+		Id:      "authenticated",
+		Filters: []*eskip.Filter{{Name: AuditLogName}, {Name: AuthTeamName}},
+	}, {
+		Id:      "health",
+		Filters: []*eskip.Filter{{Name: AuditLogName}},
+	}, {
+		Id: "static",
+	}}
+
+	err := CheckAuthRequired(routes)
+	if missing, ok := err.(RoutesWithoutAuthError); !ok || len(missing) != 2 ||
+		missing[0] != "health" || missing[1] != "static" {
+		t.Error("failed to detect routes without auth", err)
+	}
+
+	if err := CheckAuthRequired(routes, "health", "static"); err != nil {
+		t.Error("failed to allow public routes", err)
+	}
+}
+
+func TestAuthRequiredClient(t *testing.T) {
+	dc := &testDataClient{
+		all:    []*eskip.Route{{Id: "public"}, {Id: "private", Filters: []*eskip.Filter{{Name: AuthName}}}},
+		update: []*eskip.Route{{Id: "unprotected"}}}
+	c := NewAuthRequiredClient(dc, "public")
+
+	routes, err := c.LoadAll()
+	if err != nil || len(routes) != 2 {
+		t.Error("failed to load valid routes", err)
+	}
+
+	if _, _, err := c.LoadUpdate(); err == nil {
+		t.Error("failed to reject update")
+	}
+}
@@ -0,0 +1,226 @@
+package skoap
+
+import (
+	"context"
+	"errors"
+	"github.com/zalando/skipper/routing"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// the validation results are cached only briefly, to avoid calling the
+// services for every route evaluated for the same request. The invalid
+// tokens are cached, too, for a shorter time
+const (
+	predicateCacheTTL        = 10 * time.Second
+	predicateInvalidCacheTTL = 2 * time.Second
+)
+
+var errMissingTeamUrl = errors.New("missing team service url")
+
+type (
+	predicateClient struct {
+		authClient     *authClient
+		teamClient     TeamSource
+		tokenCookie    string
+		tokenQuery     string
+		maxTokenLength int
+		tokens         *ttlCache
+		teams          *ttlCache
+
+		// the tokens validated by the services set with the authUrl
+		// option are cached separately, by the url of the service
+		mu        sync.Mutex
+		urlTokens map[string]*ttlCache
+	}
+
+	authPredicateSpec struct {
+		name   string
+		client *predicateClient
+	}
+
+	authPredicate struct {
+		name       string
+		client     *predicateClient
+		authClient *authClient
+		args       []string
+	}
+)
+
+// Creates the AuthRealm, AuthScope and AuthTeam routing predicates. They
+// validate the token of the request with the configured token
+// validation service, and match the requests whose user belongs to the
+// realm, has one of the scopes, or is a member of one of the teams set
+// as their arguments. The team predicate requires the team service
+// url. The arguments can contain the * wildcard. The realm and scope
+// predicates accept a leading authUrl=<url> argument, validating the
+// tokens with another token validation service.
+//
+// The predicates only select the routes for the authenticated
+// populations, e.g. employees or services. They don't reject the
+// requests, and don't replace the auth filters on the routes.
+func NewAuthPredicates(o Options) []routing.PredicateSpec {
+	c := &predicateClient{
+		authClient:     newAuthClient(o),
+		tokenCookie:    o.TokenCookie,
+		tokenQuery:     o.TokenQueryParam,
+		maxTokenLength: o.MaxTokenLength,
+		tokens:         newTTLCache("predicate-tokens", predicateCacheTTL, 0),
+		teams:          newTTLCache("predicate-teams", predicateCacheTTL, 0),
+		urlTokens:      make(map[string]*ttlCache)}
+	if c.maxTokenLength <= 0 {
+		c.maxTokenLength = defaultMaxTokenLength
+	}
+
+	registerTokenCache(c.tokens)
+	registerTokenCache(c.teams)
+	if o.TeamUrlBase != "" || o.TeamSource != nil {
+		c.teamClient = newTeamClient(o)
+	}
+
+	return []routing.PredicateSpec{
+		&authPredicateSpec{name: AuthRealmPredicateName, client: c},
+		&authPredicateSpec{name: AuthScopePredicateName, client: c},
+		&authPredicateSpec{name: AuthTeamPredicateName, client: c}}
+}
+
+func (s *authPredicateSpec) Name() string { return s.name }
+
+func (s *authPredicateSpec) Create(args []interface{}) (routing.Predicate, error) {
+	sargs, err := getStrings(args)
+	if err != nil || len(sargs) == 0 {
+		return nil, routing.ErrInvalidPredicateParameters
+	}
+
+	p := &authPredicate{name: s.name, client: s.client, authClient: s.client.authClient}
+	if name, value, ok := namedArg(sargs[0]); ok {
+		if u, err := url.Parse(value); name != "authUrl" || err != nil || u.Host == "" {
+			return nil, routing.ErrInvalidPredicateParameters
+		}
+
+		// the team service belongs to the default identity provider
+		if s.name == AuthTeamPredicateName {
+			return nil, errAuthUrlLookup
+		}
+
+		ac := *s.client.authClient
+		ac.urlBase, ac.fallbacks = value, nil
+		p.authClient, sargs = &ac, sargs[1:]
+	}
+
+	if len(sargs) == 0 {
+		return nil, routing.ErrInvalidPredicateParameters
+	}
+
+	switch s.name {
+	case AuthRealmPredicateName:
+		if len(sargs) != 1 {
+			return nil, routing.ErrInvalidPredicateParameters
+		}
+	case AuthTeamPredicateName:
+		if s.client.teamClient == nil {
+			return nil, errMissingTeamUrl
+		}
+	}
+
+	p.args = sargs
+	return p, nil
+}
+
+// the predicates read the token the same way as the auth filters, but
+// leave the query parameter to the filters
+func (c *predicateClient) getToken(r *http.Request) (string, error) {
+	return readToken(r, c.tokenCookie, c.tokenQuery, c.maxTokenLength)
+}
+
+func (c *predicateClient) tokenCache(ac *authClient) *ttlCache {
+	if ac == c.authClient {
+		return c.tokens
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tc, ok := c.urlTokens[ac.urlBase]
+	if !ok {
+		tc = newTTLCache("predicate-tokens", predicateCacheTTL, 0)
+		registerTokenCache(tc)
+		c.urlTokens[ac.urlBase] = tc
+	}
+
+	return tc
+}
+
+func (c *predicateClient) validate(ctx context.Context, ac *authClient, token string) (*authDoc, error) {
+	now := time.Now()
+	tokens := c.tokenCache(ac)
+	if a, ok := tokens.get(token, now); ok {
+		if err, ok := a.(error); ok {
+			return nil, err
+		}
+
+		if ac.expired(a.(*authDoc), now) {
+			return nil, errExpiredToken
+		}
+
+		return a.(*authDoc), nil
+	}
+
+	a, err := ac.validate(ctx, token)
+	switch err {
+	case nil:
+	case errInvalidToken:
+		// every route with a predicate would validate the invalid
+		// token again
+		tokens.setUntil(token, errInvalidToken, now.Add(predicateInvalidCacheTTL))
+		return nil, err
+	case errExpiredToken:
+		return nil, err
+	default:
+		log.Println(err)
+		return nil, err
+	}
+
+	tokens.setUntil(token, a, ac.validUntil(a, now.Add(predicateCacheTTL)))
+	return a, nil
+}
+
+func (c *predicateClient) getTeams(ctx context.Context, token string, a *authDoc) ([]string, error) {
+	now := time.Now()
+	if t, ok := c.teams.get(token, now); ok {
+		return t.([]string), nil
+	}
+
+	t, err := c.teamClient.Teams(ctx, a.Uid, token)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	c.teams.set(token, t, now)
+	return t, nil
+}
+
+func (p *authPredicate) Match(r *http.Request) bool {
+	token, err := p.client.getToken(r)
+	if err != nil {
+		return false
+	}
+
+	a, err := p.client.validate(r.Context(), p.authClient, token)
+	if err != nil {
+		return false
+	}
+
+	switch p.name {
+	case AuthRealmPredicateName:
+		return a.Realm == p.args[0]
+	case AuthScopePredicateName:
+		return intersect(p.args, a.Scopes)
+	default:
+		teams, err := p.client.getTeams(r.Context(), token, a)
+		return err == nil && intersect(p.args, teams)
+	}
+}
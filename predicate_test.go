@@ -0,0 +1,233 @@
+package skoap
+
+import (
+	"encoding/json"
+	"github.com/zalando/skipper/routing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuthPredicates(t *testing.T) {
+	var authRequests int
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authRequests++
+		if r.Header.Get(authHeaderName) != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer authServer.Close()
+
+	teamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if err := json.NewEncoder(w).Encode([]teamDoc{{testTeam}}); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer teamServer.Close()
+
+	specs := make(map[string]routing.PredicateSpec)
+	for _, s := range NewAuthPredicates(Options{AuthUrlBase: authServer.URL}) {
+		specs[s.Name()] = s
+	}
+
+	if _, err := specs[AuthTeamPredicateName].Create([]interface{}{testTeam}); err == nil {
+		t.Error("failed to fail on missing team url")
+	}
+
+	if _, err := specs[AuthRealmPredicateName].Create([]interface{}{testRealm, "/services"}); err == nil {
+		t.Error("failed to fail on multiple realms")
+	}
+
+	for _, s := range NewAuthPredicates(Options{AuthUrlBase: authServer.URL, TeamUrlBase: teamServer.URL + "?member="}) {
+		specs[s.Name()] = s
+	}
+
+	for _, ti := range []struct {
+		msg   string
+		name  string
+		args  []interface{}
+		token string
+		match bool
+	}{{
+		msg:  "no token",
+		name: AuthRealmPredicateName,
+		args: []interface{}{testRealm},
+	}, {
+		msg:   "invalid token",
+		name:  AuthRealmPredicateName,
+		args:  []interface{}{testRealm},
+		token: "invalid-token",
+	}, {
+		msg:   "matching realm",
+		name:  AuthRealmPredicateName,
+		args:  []interface{}{testRealm},
+		token: testToken,
+		match: true,
+	}, {
+		msg:   "other realm",
+		name:  AuthRealmPredicateName,
+		args:  []interface{}{"/services"},
+		token: testToken,
+	}, {
+		msg:   "matching scope",
+		name:  AuthScopePredicateName,
+		args:  []interface{}{"other-scope", "test-*"},
+		token: testToken,
+		match: true,
+	}, {
+		msg:   "no matching scope",
+		name:  AuthScopePredicateName,
+		args:  []interface{}{"other-scope"},
+		token: testToken,
+	}, {
+		msg:   "matching team",
+		name:  AuthTeamPredicateName,
+		args:  []interface{}{testTeam},
+		token: testToken,
+		match: true,
+	}, {
+		msg:   "no matching team",
+		name:  AuthTeamPredicateName,
+		args:  []interface{}{"other-team"},
+		token: testToken,
+	}} {
+		p, err := specs[ti.name].Create(ti.args)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest("GET", "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if ti.token != "" {
+			req.Header.Set(authHeaderName, "Bearer "+ti.token)
+		}
+
+		if p.Match(req) != ti.match {
+			t.Error(ti.msg, "invalid match result", !ti.match)
+		}
+	}
+
+	// the valid token is validated only once, the invalid token every
+	// time
+	if authRequests != 2 {
+		t.Error("invalid number of token validations", authRequests)
+	}
+}
+
+func TestAuthPredicateAuthUrl(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(&authDoc{Uid: testUid, Realm: testRealm})
+	}))
+	defer authServer.Close()
+
+	otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(&authDoc{Uid: testUid, Realm: "/services"})
+	}))
+	defer otherServer.Close()
+
+	specs := make(map[string]routing.PredicateSpec)
+	for _, s := range NewAuthPredicates(Options{AuthUrlBase: authServer.URL, TeamUrlBase: authServer.URL}) {
+		specs[s.Name()] = s
+	}
+
+	if _, err := specs[AuthTeamPredicateName].Create([]interface{}{"authUrl=" + otherServer.URL, testTeam}); err == nil {
+		t.Error("failed to fail on team predicate with another identity provider")
+	}
+
+	if _, err := specs[AuthRealmPredicateName].Create([]interface{}{"authUrl=not-a-url", testRealm}); err == nil {
+		t.Error("failed to fail on invalid url")
+	}
+
+	for _, ti := range []struct {
+		msg   string
+		args  []interface{}
+		match bool
+	}{{
+		msg:   "default service",
+		args:  []interface{}{testRealm},
+		match: true,
+	}, {
+		msg:  "other service, default realm",
+		args: []interface{}{"authUrl=" + otherServer.URL, testRealm},
+	}, {
+		msg:   "other service",
+		args:  []interface{}{"authUrl=" + otherServer.URL, "/services"},
+		match: true,
+	}} {
+		p, err := specs[AuthRealmPredicateName].Create(ti.args)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest("GET", "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		if p.Match(req) != ti.match {
+			t.Error(ti.msg, "invalid match result", !ti.match)
+		}
+	}
+}
+
+func TestAuthPredicateTokenSources(t *testing.T) {
+	var authRequests int
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authRequests++
+		if r.Header.Get(authHeaderName) != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(&authDoc{Uid: testUid, Realm: testRealm})
+	}))
+	defer authServer.Close()
+
+	o := Options{AuthUrlBase: authServer.URL, TokenQueryParam: "access_token", MaxTokenLength: 64}
+	p, err := NewAuthPredicates(o)[0].Create([]interface{}{testRealm})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "https://www.example.org/?access_token="+testToken, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.Match(req) {
+		t.Error("failed to match the token in the query")
+	}
+
+	// the parameter is left to the auth filters
+	if req.URL.Query().Get("access_token") != testToken {
+		t.Error("token removed from the query")
+	}
+
+	req.URL.RawQuery = "access_token=" + strings.Repeat("x", 65)
+	if p.Match(req) {
+		t.Error("failed to reject too long token")
+	}
+
+	authRequests = 0
+	for i := 0; i < 3; i++ {
+		req.URL.RawQuery = "access_token=invalid-predicate-token"
+		if p.Match(req) {
+			t.Error("failed to reject invalid token")
+		}
+	}
+
+	if authRequests != 1 {
+		t.Error("invalid token not cached", authRequests)
+	}
+}
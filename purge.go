@@ -0,0 +1,129 @@
+package skoap
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const cachesPath = "/caches/"
+
+// PurgeResult contains the number of the cache entries removed by a
+// purge request, and the number of the revoked tokens.
+type PurgeResult struct {
+	Purged  int `json:"purged"`
+	Revoked int `json:"revoked"`
+}
+
+func tokenCaches() []*ttlCache {
+	revocation.mu.Lock()
+	defer revocation.mu.Unlock()
+	return revocation.caches
+}
+
+// removes the token from the caches keyed by the tokens
+func purgeToken(token string) int {
+	var n int
+	for _, c := range tokenCaches() {
+		if c.delete(token) {
+			n++
+		}
+	}
+
+	return n
+}
+
+// returns the cached tokens validated for the user
+func tokensOf(uid string) []string {
+	isUser := func(v interface{}) bool {
+		a, ok := v.(*authDoc)
+		return ok && a.Uid == uid
+	}
+
+	now := time.Now()
+	set := make(map[string]bool)
+	for _, c := range tokenCaches() {
+		for _, t := range c.keysWhere(isUser, now) {
+			set[t] = true
+		}
+	}
+
+	var tokens []string
+	for t := range set {
+		tokens = append(tokens, t)
+	}
+
+	return tokens
+}
+
+// Creates an HTTP handler for inspecting and purging the caches. GET
+// /caches/ returns the statistics of the caches as JSON. POST
+// /caches/purge with either a token or a uid form parameter removes the
+// token, or the tokens validated for the user, from the caches keyed by
+// the tokens. With revoke=true, the tokens are also rejected for a day,
+// including the sessions issued for them, like by the logout filter.
+// The sessions of a user can be revoked only by their tokens that are
+// still cached.
+func NewCacheHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, cachesPath)
+		switch {
+		case r.Method == "GET" && path == "":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(Caches())
+		case r.Method == "POST" && path == "purge":
+			servePurge(w, r)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	})
+}
+
+func servePurge(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, uid := r.PostForm.Get("token"), r.PostForm.Get("uid")
+	if token == "" && uid == "" || token != "" && uid != "" {
+		http.Error(w, "either token or uid is required", http.StatusBadRequest)
+		return
+	}
+
+	var revoke bool
+	if v := r.PostForm.Get("revoke"); v != "" {
+		var err error
+		if revoke, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid value for revoke", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tokens := []string{token}
+	if uid != "" {
+		tokens = tokensOf(uid)
+	}
+
+	var result PurgeResult
+	for _, t := range tokens {
+		result.Purged += purgeToken(t)
+		if revoke {
+			revokeToken(t)
+			result.Revoked++
+		}
+	}
+
+	// the tokens are not logged
+	if uid != "" {
+		log.Printf("caches purged for user %s by %s, entries: %d, revoked: %d", uid, r.RemoteAddr, result.Purged, result.Revoked)
+	} else {
+		log.Printf("caches purged for a token by %s, entries: %d, revoked: %d", r.RemoteAddr, result.Purged, result.Revoked)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&result)
+}
@@ -0,0 +1,100 @@
+package skoap
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheHandler(t *testing.T) {
+	c := newTTLCache("test-purge", time.Minute, 0)
+	registerTokenCache(c)
+
+	now := time.Now()
+	c.set("purge-token-1", &authDoc{Uid: "purge-user"}, now)
+	c.set("purge-token-2", &authDoc{Uid: "purge-user"}, now)
+	c.set("purge-token-3", &authDoc{Uid: "other-user"}, now)
+	c.get("purge-token-3", now)
+
+	h := NewCacheHandler()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/caches/", nil))
+
+	var stats []CacheStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, s := range stats {
+		if s.Name == "test-purge" {
+			found = true
+			if s.Entries != 3 || s.Hits != 1 {
+				t.Error("invalid stats", s)
+			}
+		}
+	}
+
+	if !found {
+		t.Error("cache not found")
+	}
+
+	purge := func(form url.Values) (int, PurgeResult) {
+		req := httptest.NewRequest("POST", "/caches/purge", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		var r PurgeResult
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &r); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return w.Code, r
+	}
+
+	if status, _ := purge(url.Values{}); status != http.StatusBadRequest {
+		t.Error("failed to fail without token and uid", status)
+	}
+
+	if status, r := purge(url.Values{"uid": {"purge-user"}, "revoke": {"true"}}); status != http.StatusOK ||
+		r.Purged != 2 || r.Revoked != 2 {
+		t.Error("invalid purge by uid", status, r)
+	}
+
+	if !tokenRevoked("purge-token-1") || !tokenRevoked("purge-token-2") || tokenRevoked("purge-token-3") {
+		t.Error("invalid revocation")
+	}
+
+	if status, r := purge(url.Values{"token": {"purge-token-3"}}); status != http.StatusOK || r.Purged != 1 || r.Revoked != 0 {
+		t.Error("invalid purge by token", status, r)
+	}
+
+	if _, ok := c.get("purge-token-3", now); ok || tokenRevoked("purge-token-3") {
+		t.Error("failed to purge the token without revoking it")
+	}
+}
+
+func TestRejectCounts(t *testing.T) {
+	const reason rejectReason = "test-reject-reason"
+	countReject(reason)
+	countReject(reason)
+
+	w := httptest.NewRecorder()
+	NewRejectCountsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/rejects", nil))
+
+	var counts map[string]uint64
+	if err := json.Unmarshal(w.Body.Bytes(), &counts); err != nil {
+		t.Fatal(err)
+	}
+
+	if counts[string(reason)] != 2 {
+		t.Error("invalid reject count", counts[string(reason)])
+	}
+}
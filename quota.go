@@ -0,0 +1,242 @@
+package skoap
+
+import (
+	"fmt"
+	"github.com/zalando/skipper/filters"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultQuotaPeriod = time.Hour
+
+	// the key of the quota applied to the teams without their own
+	// limit
+	defaultQuotaTeam = "*"
+
+	// the counters are split into lock-striped shards by the team
+	// names
+	quotaShards = 32
+)
+
+const quotaExceeded rejectReason = "quota-exceeded"
+
+// TeamQuotaOptions contains the settings of the teamQuota filter.
+type TeamQuotaOptions struct {
+
+	// The max number of requests per period, by team. The limit set
+	// for "*" applies to the teams without their own limit. The teams
+	// without a limit are not restricted.
+	Limits map[string]int
+
+	// When set, the limits are reloaded with this function in every
+	// ReloadInterval. When the reload fails, the previous limits are
+	// kept.
+	LoadLimits     func() (map[string]int, error)
+	ReloadInterval time.Duration
+
+	// The weight of the requests by HTTP method, between 0 and 1,
+	// counted against the quotas, e.g. 0.1 for HEAD, or 0 to exclude
+	// OPTIONS. The methods not listed count as 1.
+	MethodWeights map[string]float64
+
+	// The length of the quota periods. The counters are reset at the
+	// start of every period. Defaults to one hour.
+	Period time.Duration
+
+	// When set, the rejected requests are responded with a JSON body
+	// containing the reject reason.
+	JSONErrors bool
+}
+
+type (
+	quotaCounter struct {
+		period time.Time
+		count  float64
+	}
+
+	quotaShard struct {
+		mu       sync.Mutex
+		counters map[string]*quotaCounter
+	}
+
+	teamQuotaSpec struct {
+		options  TeamQuotaOptions
+		limitsMu sync.RWMutex
+		limits   map[string]int
+		shards   [quotaShards]quotaShard
+	}
+
+	teamQuota struct {
+		spec *teamQuotaSpec
+	}
+)
+
+// Creates a teamQuota filter specification. The filter counts the
+// requests of the teams of the user, resolved by a preceding authTeam
+// filter, and rejects them with 429 when any of the teams has exceeded
+// its quota in the current period. The counters are shared by all the
+// routes using the filter.
+func NewTeamQuota(o TeamQuotaOptions) filters.Spec {
+	if o.Period <= 0 {
+		o.Period = defaultQuotaPeriod
+	}
+
+	s := &teamQuotaSpec{options: o, limits: o.Limits}
+	for i := range s.shards {
+		s.shards[i].counters = make(map[string]*quotaCounter)
+	}
+
+	if o.LoadLimits != nil && o.ReloadInterval > 0 {
+		go s.reloadLimits()
+	}
+
+	return s
+}
+
+// Parses a list of request weights by HTTP method, in the form of
+// METHOD:weight, e.g. HEAD:0.1, to be used as the MethodWeights of the
+// team quota options.
+func ParseMethodWeights(l []string) (map[string]float64, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	weights := make(map[string]float64)
+	for _, s := range l {
+		method, weight, err := parseSampleRate(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid method weight: %s", s)
+		}
+
+		weights[method] = weight
+	}
+
+	return weights, nil
+}
+
+func (s *teamQuotaSpec) Name() string { return TeamQuotaName }
+
+func (s *teamQuotaSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	if len(args) != 0 {
+		return nil, filters.ErrInvalidFilterParameters
+	}
+
+	return &teamQuota{spec: s}, nil
+}
+
+func (s *teamQuotaSpec) reloadLimits() {
+	for range time.Tick(s.options.ReloadInterval) {
+		l, err := s.options.LoadLimits()
+		if err != nil {
+			log.Println("failed to reload team quotas:", err)
+			continue
+		}
+
+		s.setLimits(l)
+	}
+}
+
+func (s *teamQuotaSpec) setLimits(l map[string]int) {
+	s.limitsMu.Lock()
+	defer s.limitsMu.Unlock()
+	s.limits = l
+}
+
+func (s *teamQuotaSpec) currentLimits() map[string]int {
+	s.limitsMu.RLock()
+	defer s.limitsMu.RUnlock()
+	return s.limits
+}
+
+func limit(limits map[string]int, team string) (int, bool) {
+	if l, ok := limits[team]; ok {
+		return l, true
+	}
+
+	l, ok := limits[defaultQuotaTeam]
+	return l, ok
+}
+
+func (s *teamQuotaSpec) weight(method string) float64 {
+	if w, ok := s.options.MethodWeights[method]; ok {
+		return w
+	}
+
+	return 1
+}
+
+// counts a request of a team with the given weight, and returns whether
+// it is within the limit
+func (s *quotaShard) take(team string, l int, w float64, period time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[team]
+	if !ok {
+		c = &quotaCounter{}
+		s.counters[team] = c
+	}
+
+	if !c.period.Equal(period) {
+		c.period = period
+		c.count = 0
+	}
+
+	c.count += w
+	return c.count <= float64(l)
+}
+
+// counts a request of the teams, and returns whether it is within the
+// quota of all of them, and when not, the time until the next period
+func (s *teamQuotaSpec) take(teams []string, method string, now time.Time) (bool, time.Duration) {
+	period := now.Truncate(s.options.Period)
+	reset := period.Add(s.options.Period).Sub(now)
+	w := s.weight(method)
+	if w == 0 {
+		return true, reset
+	}
+
+	limits := s.currentLimits()
+	allowed := true
+	for _, t := range teams {
+		l, ok := limit(limits, t)
+		if !ok {
+			continue
+		}
+
+		if !s.shards[shardIndex(t, quotaShards)].take(t, l, w, period) {
+			allowed = false
+		}
+	}
+
+	return allowed, reset
+}
+
+func (f *teamQuota) Request(ctx filters.FilterContext) {
+	teams, _ := ctx.StateBag()[authTeamsKey].([]string)
+	allowed, reset := f.spec.take(teams, ctx.Request().Method, time.Now())
+	if allowed {
+		return
+	}
+
+	uname, _ := ctx.StateBag()[authUserKey].(string)
+	ctx.StateBag()[authRejectReasonKey] = string(quotaExceeded)
+
+	rsp := &http.Response{StatusCode: http.StatusTooManyRequests}
+	if f.spec.options.JSONErrors {
+		rsp = errorResponse(http.StatusTooManyRequests, uname, quotaExceeded)
+	}
+
+	if rsp.Header == nil {
+		rsp.Header = make(http.Header)
+	}
+
+	rsp.Header.Set("Retry-After", strconv.Itoa(int(reset/time.Second)+1))
+	ctx.Serve(rsp)
+}
+
+func (f *teamQuota) Response(_ filters.FilterContext) {}
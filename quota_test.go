@@ -0,0 +1,125 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters/filtertest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTeamQuotaTake(t *testing.T) {
+	s := NewTeamQuota(TeamQuotaOptions{
+		Limits: map[string]int{"teapot": 2, "*": 1},
+		Period: time.Minute}).(*teamQuotaSpec)
+
+	now := time.Date(2016, 10, 10, 13, 55, 36, 0, time.UTC)
+	for i, ti := range []struct {
+		teams   []string
+		at      time.Time
+		allowed bool
+	}{
+		{[]string{"teapot"}, now, true},
+		{[]string{"teapot"}, now, true},
+		{[]string{"teapot"}, now, false},
+		{[]string{"mop"}, now, true},
+		{[]string{"mop", "teapot"}, now, false},
+		{nil, now, true},
+		{[]string{"teapot"}, now.Add(time.Minute), true},
+		{[]string{"mop"}, now.Add(time.Minute), true},
+	} {
+		if allowed, _ := s.take(ti.teams, "GET", ti.at); allowed != ti.allowed {
+			t.Error(i, "invalid quota decision", ti.teams, allowed)
+		}
+	}
+
+	if allowed, reset := s.take([]string{"mop"}, "GET", now.Add(time.Minute)); allowed || reset != 24*time.Second {
+		t.Error("invalid reset time", reset)
+	}
+}
+
+func TestTeamQuotaUnlimited(t *testing.T) {
+	s := NewTeamQuota(TeamQuotaOptions{Limits: map[string]int{"teapot": 1}}).(*teamQuotaSpec)
+	for i := 0; i < 3; i++ {
+		if allowed, _ := s.take([]string{"mop"}, "GET", time.Now()); !allowed {
+			t.Error("team without limit rejected")
+		}
+	}
+}
+
+func TestTeamQuotaMethodWeights(t *testing.T) {
+	weights, err := ParseMethodWeights([]string{"head:0.5", "OPTIONS:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseMethodWeights([]string{"POST:2"}); err == nil {
+		t.Error("failed to fail on invalid weight")
+	}
+
+	s := NewTeamQuota(TeamQuotaOptions{Limits: map[string]int{"teapot": 1}, MethodWeights: weights}).(*teamQuotaSpec)
+	now := time.Now()
+	for i, ti := range []struct {
+		method  string
+		allowed bool
+	}{
+		{"OPTIONS", true},
+		{"OPTIONS", true},
+		{"HEAD", true},
+		{"HEAD", true},
+		{"HEAD", false},
+		{"OPTIONS", true},
+	} {
+		if allowed, _ := s.take([]string{"teapot"}, ti.method, now); allowed != ti.allowed {
+			t.Error(i, "invalid quota decision", ti.method, allowed)
+		}
+	}
+}
+
+func TestTeamQuotaReload(t *testing.T) {
+	loaded := make(chan struct{}, 1)
+	s := NewTeamQuota(TeamQuotaOptions{
+		Limits:         map[string]int{"teapot": 0},
+		ReloadInterval: 10 * time.Millisecond,
+		LoadLimits: func() (map[string]int, error) {
+			select {
+			case loaded <- struct{}{}:
+			default:
+			}
+
+			return map[string]int{"teapot": 1}, nil
+		}}).(*teamQuotaSpec)
+
+	if allowed, _ := s.take([]string{"teapot"}, "GET", time.Now()); allowed {
+		t.Error("failed to apply the initial limits")
+	}
+
+	<-loaded
+	for i := 0; i < 100 && s.currentLimits()["teapot"] != 1; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if allowed, _ := s.take([]string{"mop", "teapot"}, "GET", time.Now().Add(s.options.Period)); !allowed {
+		t.Error("failed to apply the reloaded limits")
+	}
+}
+
+func TestTeamQuotaReject(t *testing.T) {
+	f, err := NewTeamQuota(TeamQuotaOptions{Limits: map[string]int{"*": 0}, JSONErrors: true}).CreateFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "https://www.example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &filtertest.Context{
+		FRequest:  req,
+		FStateBag: map[string]interface{}{authUserKey: testUid, authTeamsKey: []string{testTeam}}}
+	f.Request(ctx)
+	if ctx.FResponse == nil || ctx.FResponse.StatusCode != http.StatusTooManyRequests ||
+		ctx.FResponse.Header.Get("Retry-After") == "" || ctx.FStateBag[authRejectReasonKey] != string(quotaExceeded) {
+		t.Error("failed to reject", ctx.FResponse)
+	}
+}
@@ -0,0 +1,196 @@
+package skoap
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redactedValue = "[REDACTED]"
+
+var (
+	// the name of a JSON field, up to its value
+	jsonNameExp = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"\s*:\s*`)
+
+	// a scalar JSON value other than a string
+	jsonScalarExp = regexp.MustCompile(`^(?:[-+.0-9eE]+|true|false|null)`)
+
+	// a parameter of a form encoded body
+	formFieldExp = regexp.MustCompile(`(^|&)([^=&]*)=([^&]*)`)
+)
+
+// redactor replaces the values of the sensitive fields in the captured
+// request bodies, headers and query. The patterns are regular
+// expressions matching the whole field names, case insensitive.
+type redactor struct {
+	patterns []*regexp.Regexp
+}
+
+func newRedactor(patterns []string) (*redactor, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	r := &redactor{}
+	for _, p := range patterns {
+		rx, err := regexp.Compile("(?i)^(?:" + p + ")$")
+		if err != nil {
+			return nil, err
+		}
+
+		r.patterns = append(r.patterns, rx)
+	}
+
+	return r, nil
+}
+
+func (r *redactor) sensitive(name string) bool {
+	for _, p := range r.patterns {
+		if p.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// replaces the values of the sensitive fields in a JSON or form encoded
+// body. Other bodies are returned unchanged.
+func (r *redactor) redact(body string) string {
+	t := strings.TrimSpace(body)
+	if strings.HasPrefix(t, "{") || strings.HasPrefix(t, "[") {
+		return r.redactJSON(body)
+	}
+
+	return r.redactForm(body)
+}
+
+// replaces the values of the sensitive parameters in a form encoded body
+// or a query string
+func (r *redactor) redactForm(form string) string {
+	var (
+		b    strings.Builder
+		last int
+	)
+
+	for _, m := range formFieldExp.FindAllStringSubmatchIndex(form, -1) {
+		name := form[m[4]:m[5]]
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+
+		if !r.sensitive(name) {
+			continue
+		}
+
+		b.WriteString(form[last:m[6]])
+		b.WriteString(url.QueryEscape(redactedValue))
+		last = m[7]
+	}
+
+	if last == 0 {
+		return form
+	}
+
+	b.WriteString(form[last:])
+	return b.String()
+}
+
+// returns the end of the string starting at i, or the end of the body,
+// when the string is truncated
+func jsonStringEnd(body string, i int) int {
+	for j := i + 1; j < len(body); j++ {
+		switch body[j] {
+		case '\\':
+			j++
+		case '"':
+			return j + 1
+		}
+	}
+
+	return len(body)
+}
+
+// returns the end of the JSON value starting at i. The objects and the
+// arrays end at their closing bracket, or at the end of the body, when
+// they are truncated.
+func jsonValueEnd(body string, i int) int {
+	if i == len(body) {
+		return i
+	}
+
+	switch body[i] {
+	case '"':
+		return jsonStringEnd(body, i)
+	case '{', '[':
+		depth := 0
+		for j := i; j < len(body); j++ {
+			switch body[j] {
+			case '"':
+				j = jsonStringEnd(body, j) - 1
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+				if depth == 0 {
+					return j + 1
+				}
+			}
+		}
+
+		return len(body)
+	default:
+		return i + len(jsonScalarExp.FindString(body[i:]))
+	}
+}
+
+// replaces the values of the sensitive fields in a JSON body, at any
+// depth. The values of the sensitive fields are replaced whole, even
+// when they are objects or arrays. The other fields are searched for
+// nested sensitive fields.
+func (r *redactor) redactJSON(body string) string {
+	var (
+		b         strings.Builder
+		last, pos int
+	)
+
+	for pos < len(body) {
+		m := jsonNameExp.FindStringSubmatchIndex(body[pos:])
+		if m == nil {
+			break
+		}
+
+		name, value := body[pos+m[2]:pos+m[3]], pos+m[1]
+		end := jsonValueEnd(body, value)
+		if r.sensitive(name) {
+			b.WriteString(body[last:value])
+			b.WriteString(`"` + redactedValue + `"`)
+			last, pos = end, end
+			continue
+		}
+
+		// the nested fields of objects and arrays are searched, too,
+		// but not the contents of the strings
+		if value < len(body) && body[value] == '"' {
+			pos = end
+		} else {
+			pos = value
+		}
+	}
+
+	if last == 0 {
+		return body
+	}
+
+	b.WriteString(body[last:])
+	return b.String()
+}
+
+// replaces the values of the sensitive headers
+func (r *redactor) redactHeaders(h map[string]string) {
+	for name := range h {
+		if r.sensitive(name) {
+			h[name] = redactedValue
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package skoap
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r, err := newRedactor([]string{"password", "ssn", "card.*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ti := range []struct {
+		msg      string
+		body     string
+		expected string
+	}{{
+		msg:      "json",
+		body:     `{"user": "jdoe", "Password": "secret", "nested": {"ssn": 123456789}}`,
+		expected: `{"user": "jdoe", "Password": "[REDACTED]", "nested": {"ssn": "[REDACTED]"}}`,
+	}, {
+		msg:      "json with escaped quotes",
+		body:     `[{"cardNumber":"12\"34","note":"password: x"}]`,
+		expected: `[{"cardNumber":"[REDACTED]","note":"password: x"}]`,
+	}, {
+		msg:      "truncated json",
+		body:     `{"user": "jdoe", "password": "sec`,
+		expected: `{"user": "jdoe", "password": "[REDACTED]"`,
+	}, {
+		msg:      "form",
+		body:     "user=jdoe&password=secret&card_id=42",
+		expected: "user=jdoe&password=%5BREDACTED%5D&card_id=%5BREDACTED%5D",
+	}, {
+		msg:      "no sensitive fields",
+		body:     `{"user": "jdoe"}`,
+		expected: `{"user": "jdoe"}`,
+	}, {
+		msg:      "partial name",
+		body:     `{"passwordHint": "pet"}`,
+		expected: `{"passwordHint": "pet"}`,
+	}, {
+		msg:      "sensitive object",
+		body:     `{"card": {"number": "4111", "expiry": "12/30"}, "user": "jdoe"}`,
+		expected: `{"card": "[REDACTED]", "user": "jdoe"}`,
+	}, {
+		msg:      "sensitive array",
+		body:     `{"ssn": [123, "45\"6"], "user": "jdoe"}`,
+		expected: `{"ssn": "[REDACTED]", "user": "jdoe"}`,
+	}, {
+		msg:      "nested in arrays",
+		body:     `{"users": [{"name": "jdoe", "password": "a"}, {"name": "mdoe", "password": "b"}]}`,
+		expected: `{"users": [{"name": "jdoe", "password": "[REDACTED]"}, {"name": "mdoe", "password": "[REDACTED]"}]}`,
+	}, {
+		msg:      "field names in strings",
+		body:     `{"note": "{\"password\": \"x\"}", "tags": ["a", "b"]}`,
+		expected: `{"note": "{\"password\": \"x\"}", "tags": ["a", "b"]}`,
+	}, {
+		msg:      "truncated object",
+		body:     `{"user": "jdoe", "card": {"number": "41`,
+		expected: `{"user": "jdoe", "card": "[REDACTED]"`,
+	}} {
+		if b := r.redact(ti.body); b != ti.expected {
+			t.Error(ti.msg, "invalid body", b, ti.expected)
+		}
+	}
+}
+
+func TestAuditLogRedact(t *testing.T) {
+	var out bytes.Buffer
+	spec := NewAuditLogWithOptions(AuditLogOptions{Sink: NewWriterSink(&out), Redact: []string{"password"}})
+	testAuditLog(t, spec, []interface{}{float64(-1), "redact=ssn"}, `{"password": "secret", "ssn": "123", "name": "jdoe"}`)
+
+	var d AuditDoc
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.RequestBody != `{"password": "[REDACTED]", "ssn": "[REDACTED]", "name": "jdoe"}` {
+		t.Error("failed to redact the body", d.RequestBody)
+	}
+}
+
+func TestAuditLogInvalidRedactPattern(t *testing.T) {
+	if _, err := NewAuditLog(nil).CreateFilter([]interface{}{"redact=("}); err == nil {
+		t.Error("failed to fail")
+	}
+}
+
+func TestAuditLogRedactHeadersAndQuery(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	var out bytes.Buffer
+	spec := NewAuditLogWithOptions(AuditLogOptions{Sink: NewWriterSink(&out), Redact: []string{"x-user-ssn", "ssn"}})
+	fr := make(filters.Registry)
+	fr.Register(spec)
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{Name: AuditLogName, Args: []interface{}{"query=true", "X-User-Ssn", "X-Request-Id"}}},
+		Backend: backend.URL})
+	defer proxy.Close()
+
+	req, err := http.NewRequest("GET", proxy.URL+"/foo?ssn=123&page=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("X-User-Ssn", "123")
+	req.Header.Set("X-Request-Id", "42")
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+	flushAuditLog(spec)
+
+	var d AuditDoc
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Query != "ssn=%5BREDACTED%5D&page=2" {
+		t.Error("failed to redact the query", d.Query)
+	}
+
+	if d.RequestHeaders["X-User-Ssn"] != redactedValue || d.RequestHeaders["X-Request-Id"] != "42" {
+		t.Error("failed to redact the headers", d.RequestHeaders)
+	}
+}
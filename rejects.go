@@ -0,0 +1,48 @@
+package skoap
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+)
+
+var rejectCounters struct {
+	mu     sync.Mutex
+	counts map[rejectReason]uint64
+}
+
+func init() {
+	expvar.Publish("skoap-rejects", expvar.Func(func() interface{} { return RejectCounts() }))
+}
+
+func countReject(reason rejectReason) {
+	rejectCounters.mu.Lock()
+	defer rejectCounters.mu.Unlock()
+	if rejectCounters.counts == nil {
+		rejectCounters.counts = make(map[rejectReason]uint64)
+	}
+
+	rejectCounters.counts[reason]++
+}
+
+// Returns the number of the rejected requests by reject reason, since
+// the start of the process.
+func RejectCounts() map[string]uint64 {
+	rejectCounters.mu.Lock()
+	defer rejectCounters.mu.Unlock()
+	counts := make(map[string]uint64)
+	for reason, n := range rejectCounters.counts {
+		counts[string(reason)] = n
+	}
+
+	return counts
+}
+
+// Creates an HTTP handler returning the reject counters as JSON.
+func NewRejectCountsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(RejectCounts())
+	})
+}
@@ -0,0 +1,86 @@
+package skoap
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// RetryOptions contains the settings of retrying the failed calls to
+// the token validation, team and group services.
+type RetryOptions struct {
+
+	// The number of retries after a call failed with a network error
+	// or a server error. Zero disables the retries.
+	Retries int
+
+	// The wait before the first retry, doubled for every subsequent
+	// one. Defaults to 100ms.
+	Backoff time.Duration
+
+	// The overall deadline of a call, including the retries. Zero means
+	// no deadline.
+	Deadline time.Duration
+}
+
+var retryOptions atomic.Value
+
+func init() {
+	retryOptions.Store(RetryOptions{})
+}
+
+// Sets how the failed calls to the token validation, team and group
+// services are retried. By default, they are not.
+func SetServiceRetries(o RetryOptions) {
+	if o.Backoff <= 0 {
+		o.Backoff = defaultRetryBackoff
+	}
+
+	retryOptions.Store(o)
+}
+
+// only the network errors and the server errors are retried, the
+// rejected tokens are not
+func retryable(err error) bool {
+	if err == errServiceFailure {
+		return true
+	}
+
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// calls the function, and retries it with an exponential backoff, as
+// long as the retries and the deadline allow it. The deadline of the
+// context, e.g. of the incoming request, is respected, too.
+func withRetries(ctx context.Context, call func(context.Context) error) error {
+	o := retryOptions.Load().(RetryOptions)
+	if o.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Deadline)
+		defer cancel()
+	}
+
+	backoff := o.Backoff
+	for retry := 0; ; retry++ {
+		err := call(ctx)
+		if err == nil || retry >= o.Retries || !retryable(err) {
+			return err
+		}
+
+		if d, ok := ctx.Deadline(); ok && time.Now().Add(backoff).After(d) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
@@ -0,0 +1,83 @@
+package skoap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServiceRetries(t *testing.T) {
+	defer SetServiceRetries(RetryOptions{})
+
+	var requests, failures int
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= failures {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		if r.Header.Get(authHeaderName) != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`{"uid": "jdoe"}`))
+	}))
+	defer service.Close()
+
+	for _, ti := range []struct {
+		msg      string
+		options  RetryOptions
+		failures int
+		token    string
+		err      error
+		requests int
+	}{{
+		msg:      "no retries",
+		failures: 1,
+		token:    testToken,
+		err:      errServiceFailure,
+		requests: 1,
+	}, {
+		msg:      "succeeds after retries",
+		options:  RetryOptions{Retries: 2, Backoff: time.Millisecond},
+		failures: 2,
+		token:    testToken,
+		requests: 3,
+	}, {
+		msg:      "retries exhausted",
+		options:  RetryOptions{Retries: 2, Backoff: time.Millisecond},
+		failures: 3,
+		token:    testToken,
+		err:      errServiceFailure,
+		requests: 3,
+	}, {
+		msg:      "rejected token not retried",
+		options:  RetryOptions{Retries: 2, Backoff: time.Millisecond},
+		token:    "invalid-token",
+		err:      errInvalidToken,
+		requests: 1,
+	}, {
+		msg:      "backoff exceeds the deadline",
+		options:  RetryOptions{Retries: 2, Backoff: time.Second, Deadline: 100 * time.Millisecond},
+		failures: 1,
+		token:    testToken,
+		err:      errServiceFailure,
+		requests: 1,
+	}} {
+		SetServiceRetries(ti.options)
+		requests, failures = 0, ti.failures
+
+		var a authDoc
+		if err := jsonGet(context.Background(), service.URL, ti.token, &a); err != ti.err {
+			t.Error(ti.msg, "invalid error", err)
+		}
+
+		if requests != ti.requests {
+			t.Error(ti.msg, "invalid number of requests", requests)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package skoap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const rotationTimeFormat = "20060102T150405.000"
+
+// RotationOptions contains the settings of the rotating audit log files.
+type RotationOptions struct {
+
+	// The path of the current log file. The rotated files are named
+	// as the path with a timestamp suffix.
+	Path string
+
+	// When greater than zero, the file is rotated before it exceeds
+	// this size in bytes.
+	MaxSize int64
+
+	// When greater than zero, the file is rotated when it is older than
+	// this.
+	MaxAge time.Duration
+
+	// When greater than zero, the number of rotated files kept. The
+	// older ones are deleted.
+	MaxBackups int
+
+	// The format of the entries. Defaults to JSON.
+	Format AuditFormat
+}
+
+type rotatingFile struct {
+	mu      sync.Mutex
+	options RotationOptions
+	file    *os.File
+	size    int64
+	opened  time.Time
+}
+
+// Creates an audit sink writing the entries to a file, one entry per
+// line, and rotating the file based on its size and age.
+func NewRotatingFileSink(o RotationOptions) (AuditSink, error) {
+	rf := &rotatingFile{options: o}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return NewWriterSinkWithFormat(rf, rf.options.Format), nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.options.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.file = f
+	rf.size = fi.Size()
+	rf.opened = time.Now()
+	return nil
+}
+
+// returns the rotated files, oldest first
+func (rf *rotatingFile) backups() ([]string, error) {
+	m, err := filepath.Glob(rf.options.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(m)
+	return m, nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.%s", rf.options.Path, time.Now().UTC().Format(rotationTimeFormat))
+	for i := 1; ; i++ {
+		if _, err := os.Stat(backup); os.IsNotExist(err) {
+			break
+		}
+
+		backup = fmt.Sprintf("%s.%s-%d", rf.options.Path, time.Now().UTC().Format(rotationTimeFormat), i)
+	}
+	if err := os.Rename(rf.options.Path, backup); err != nil {
+		return err
+	}
+
+	if rf.options.MaxBackups > 0 {
+		b, err := rf.backups()
+		if err != nil {
+			return err
+		}
+
+		for len(b) > rf.options.MaxBackups {
+			os.Remove(b[0])
+			b = b[1:]
+		}
+	}
+
+	return rf.open()
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	tooLarge := rf.options.MaxSize > 0 && rf.size+int64(len(p)) > rf.options.MaxSize
+	tooOld := rf.options.MaxAge > 0 && time.Since(rf.opened) > rf.options.MaxAge
+	if rf.size > 0 && (tooLarge || tooOld) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
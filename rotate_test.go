@@ -0,0 +1,48 @@
+package skoap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "skoap-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	s, err := NewRotatingFileSink(RotationOptions{Path: path, MaxSize: 80, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 8; i++ {
+		// each entry is 44 bytes, so every entry is written to a new file
+		if err := s.Log(&AuditDoc{Method: "GET", Path: "/foo", Status: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(backups) != 2 {
+		t.Error("invalid number of backups", backups)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != `{"method":"GET","path":"/foo","status":200}`+"\n" {
+		t.Error("invalid log file content", string(b))
+	}
+}
@@ -0,0 +1,98 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters"
+	"net/http"
+	"strconv"
+)
+
+const (
+	hstsHeader               = "Strict-Transport-Security"
+	contentTypeOptionsHeader = "X-Content-Type-Options"
+	frameOptionsHeader       = "X-Frame-Options"
+	cspHeader                = "Content-Security-Policy"
+
+	defaultHSTSMaxAge   = 365 * 24 * 60 * 60
+	defaultFrameOptions = "DENY"
+)
+
+type (
+	secureHeadersSpec struct{}
+
+	// the headers set on the responses, unless the backend has
+	// already set them
+	secureHeaders [][2]string
+)
+
+// Creates a secureHeaders filter specification. The filter sets the
+// Strict-Transport-Security, X-Content-Type-Options and X-Frame-Options
+// headers of the responses, and optionally the Content-Security-Policy
+// header, unless the backend has already set them.
+//
+// The arguments are options in the form of name=value: hstsMaxAge sets
+// the max age of HSTS in seconds (default: one year, 0 disables it),
+// frameOptions sets X-Frame-Options (default: DENY, empty disables it),
+// and csp sets the Content-Security-Policy.
+func NewSecureHeaders() filters.Spec { return secureHeadersSpec{} }
+
+func (s secureHeadersSpec) Name() string { return SecureHeadersName }
+
+func (s secureHeadersSpec) CreateFilter(args []interface{}) (filters.Filter, error) {
+	sargs, err := getStrings(args)
+	if err != nil {
+		return nil, err
+	}
+
+	hstsMaxAge, frameOptions, csp := defaultHSTSMaxAge, defaultFrameOptions, ""
+	for _, a := range sargs {
+		name, value, ok := namedArg(a)
+		if !ok {
+			return nil, filters.ErrInvalidFilterParameters
+		}
+
+		switch name {
+		case "hstsMaxAge":
+			hstsMaxAge, err = strconv.Atoi(value)
+			if err != nil || hstsMaxAge < 0 {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+		case "frameOptions":
+			frameOptions = value
+		case "csp":
+			csp = value
+		default:
+			return nil, filters.ErrInvalidFilterParameters
+		}
+	}
+
+	h := secureHeaders{{contentTypeOptionsHeader, "nosniff"}}
+	if hstsMaxAge > 0 {
+		h = append(h, [2]string{hstsHeader, "max-age=" + strconv.Itoa(hstsMaxAge)})
+	}
+
+	if frameOptions != "" {
+		h = append(h, [2]string{frameOptionsHeader, frameOptions})
+	}
+
+	if csp != "" {
+		h = append(h, [2]string{cspHeader, csp})
+	}
+
+	return h, nil
+}
+
+func (h secureHeaders) Request(_ filters.FilterContext) {}
+
+func (h secureHeaders) Response(ctx filters.FilterContext) {
+	rh := ctx.Response().Header
+	if rh == nil {
+		rh = make(http.Header)
+		ctx.Response().Header = rh
+	}
+
+	for _, hi := range h {
+		if rh.Get(hi[0]) == "" {
+			rh.Set(hi[0], hi[1])
+		}
+	}
+}
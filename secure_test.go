@@ -0,0 +1,59 @@
+package skoap
+
+import (
+	"github.com/zalando/skipper/filters/filtertest"
+	"net/http"
+	"testing"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	for _, args := range [][]interface{}{{"hsts"}, {"hstsMaxAge=year"}, {"foo=bar"}, {42}} {
+		if _, err := NewSecureHeaders().CreateFilter(args); err == nil {
+			t.Error("failed to fail", args)
+		}
+	}
+
+	for _, ti := range []struct {
+		msg      string
+		args     []interface{}
+		backend  http.Header
+		expected map[string]string
+	}{{
+		msg: "defaults",
+		expected: map[string]string{
+			hstsHeader:               "max-age=31536000",
+			contentTypeOptionsHeader: "nosniff",
+			frameOptionsHeader:       "DENY",
+			cspHeader:                ""},
+	}, {
+		msg:  "options",
+		args: []interface{}{"hstsMaxAge=0", "frameOptions=SAMEORIGIN", "csp=default-src 'self'; img-src *"},
+		expected: map[string]string{
+			hstsHeader:               "",
+			contentTypeOptionsHeader: "nosniff",
+			frameOptionsHeader:       "SAMEORIGIN",
+			cspHeader:                "default-src 'self'; img-src *"},
+	}, {
+		msg:     "set by the backend",
+		args:    []interface{}{"frameOptions="},
+		backend: http.Header{frameOptionsHeader: []string{"SAMEORIGIN"}},
+		expected: map[string]string{
+			contentTypeOptionsHeader: "nosniff",
+			frameOptionsHeader:       "SAMEORIGIN"},
+	}} {
+		f, err := NewSecureHeaders().CreateFilter(ti.args)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp := &http.Response{StatusCode: http.StatusOK, Header: ti.backend}
+		ctx := &filtertest.Context{FResponse: rsp, FStateBag: make(map[string]interface{})}
+		f.Response(ctx)
+
+		for name, value := range ti.expected {
+			if v := rsp.Header.Get(name); v != value {
+				t.Error(ti.msg, "invalid header value", name, v)
+			}
+		}
+	}
+}
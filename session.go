@@ -0,0 +1,216 @@
+package skoap
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/zalando/skipper/filters"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultAuthSessionCookie = "skoap-auth"
+	defaultAuthSessionTTL    = 5 * time.Minute
+)
+
+var errInvalidSession = errors.New("invalid session")
+
+// sessionCodec encrypts and authenticates the values stored in the
+// cookies, with AES-GCM. The name of the cookie is used as additional
+// data, so that the values cannot be moved between cookies.
+type sessionCodec struct {
+	aead cipher.AEAD
+}
+
+// the key is derived from the secret, so that secrets of any length can
+// be used
+func newSessionCodec(secret []byte) (*sessionCodec, error) {
+	key := sha256.Sum256(secret)
+	b, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessionCodec{aead: aead}, nil
+}
+
+func (c *sessionCodec) encode(name string, v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, b, []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (c *sessionCodec) decode(name, value string, v interface{}) error {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return errInvalidSession
+	}
+
+	nonce, sealed := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	b, err := c.aead.Open(nil, nonce, sealed, []byte(name))
+	if err != nil {
+		return errInvalidSession
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+type (
+	// authSessions issues and validates the session cookies storing the
+	// result of the token validation
+	authSessions struct {
+		codec    *sessionCodec
+		cookie   string
+		ttl      time.Duration
+		insecure bool
+
+		// the sessions are accepted only by the filters validating
+		// the tokens with the same service
+		authUrl string
+	}
+
+	authSession struct {
+		Token    string   `json:"t"`
+		Uid      string   `json:"u"`
+		Realm    string   `json:"r"`
+		Scopes   []string `json:"s"`
+		Audience []string `json:"a,omitempty"`
+		ClientId string   `json:"c,omitempty"`
+		Expires  int64    `json:"e"`
+		AuthUrl  string   `json:"i"`
+
+		// the expiry of the token, when known
+		TokenExpires int64 `json:"x,omitempty"`
+	}
+)
+
+func newAuthSessions(o Options) *authSessions {
+	if len(o.SessionKey) == 0 {
+		return nil
+	}
+
+	codec, err := newSessionCodec(o.SessionKey)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	s := &authSessions{
+		codec:    codec,
+		cookie:   o.SessionCookie,
+		ttl:      o.SessionTTL,
+		insecure: o.SessionCookieInsecure,
+		authUrl:  o.AuthUrlBase}
+	if s.cookie == "" {
+		s.cookie = defaultAuthSessionCookie
+	}
+
+	if s.ttl <= 0 {
+		s.ttl = defaultAuthSessionTTL
+	}
+
+	return s
+}
+
+// returns the identity and the token stored in a valid session
+func (s *authSessions) get(r *http.Request) (*authDoc, string, bool) {
+	if s == nil {
+		return nil, "", false
+	}
+
+	c, err := r.Cookie(s.cookie)
+	if err != nil {
+		return nil, "", false
+	}
+
+	var as authSession
+	if err := s.codec.decode(s.cookie, c.Value, &as); err != nil || time.Now().Unix() >= as.Expires ||
+		as.AuthUrl != s.authUrl || tokenRevoked(as.Token) {
+		return nil, "", false
+	}
+
+	a := &authDoc{
+		Uid:      as.Uid,
+		Realm:    as.Realm,
+		Scopes:   as.Scopes,
+		Audience: as.Audience,
+		ClientId: as.ClientId}
+	if as.TokenExpires > 0 {
+		a.expires = time.Unix(as.TokenExpires, 0)
+	}
+
+	return a, as.Token, true
+}
+
+// stores a new session in the state bag, to be set as a cookie in the
+// response
+func (s *authSessions) issue(ctx filters.FilterContext, token string, a *authDoc) {
+	if s == nil {
+		return
+	}
+
+	expires := time.Now().Add(s.ttl)
+	as := &authSession{
+		Token:    token,
+		Uid:      a.Uid,
+		Realm:    a.Realm,
+		Scopes:   a.Scopes,
+		Audience: a.Audience,
+		ClientId: a.ClientId,
+		Expires:  expires.Unix(),
+		AuthUrl:  s.authUrl}
+	if !a.expires.IsZero() {
+		as.TokenExpires = a.expires.Unix()
+	}
+
+	v, err := s.codec.encode(s.cookie, as)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	c := &http.Cookie{
+		Name:     s.cookie,
+		Value:    v,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   !s.insecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode}
+	ctx.StateBag()[authSessionKey] = c.String()
+}
+
+func (s *authSessions) setCookie(ctx filters.FilterContext) {
+	if s == nil {
+		return
+	}
+
+	if c, ok := ctx.StateBag()[authSessionKey].(string); ok && ctx.Response() != nil {
+		if ctx.Response().Header == nil {
+			ctx.Response().Header = make(http.Header)
+		}
+
+		ctx.Response().Header.Add("Set-Cookie", c)
+	}
+}
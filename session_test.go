@@ -0,0 +1,150 @@
+package skoap
+
+import (
+	"encoding/json"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSessionCodec(t *testing.T) {
+	c, err := newSessionCodec([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := c.encode("foo", &authSession{Uid: testUid})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s authSession
+	if err := c.decode("foo", v, &s); err != nil || s.Uid != testUid {
+		t.Error("failed to decode session", err, s.Uid)
+	}
+
+	if err := c.decode("bar", v, &s); err == nil {
+		t.Error("failed to fail on different cookie name")
+	}
+
+	other, err := newSessionCodec([]byte("other-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := other.decode("foo", v, &s); err == nil {
+		t.Error("failed to fail on different key")
+	}
+}
+
+func TestAuthSessions(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	var validations int
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		validations++
+		d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer authServer.Close()
+
+	s := NewAuthWithOptions(Options{AuthUrlBase: authServer.URL, SessionKey: []byte("test-secret")})
+	fr := make(filters.Registry)
+	fr.Register(s)
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{Name: s.Name(), Args: []interface{}{testRealm, testScope}}},
+		Backend: backend.URL})
+	defer proxy.Close()
+
+	methodRequest := func(method, token string, cookies []*http.Cookie) *http.Response {
+		req, err := http.NewRequest(method, proxy.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token != "" {
+			req.Header.Set(authHeaderName, "Bearer "+token)
+		}
+
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp.Body.Close()
+		return rsp
+	}
+
+	request := func(token string, cookies []*http.Cookie) *http.Response {
+		return methodRequest("GET", token, cookies)
+	}
+
+	rsp := request(testToken, nil)
+	cookies := rsp.Cookies()
+	if rsp.StatusCode != http.StatusOK || len(cookies) != 1 || cookies[0].Name != defaultAuthSessionCookie {
+		t.Fatal("failed to issue session", rsp.StatusCode, cookies)
+	}
+
+	if !cookies[0].Secure || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteLaxMode {
+		t.Error("invalid session cookie attributes", cookies[0].Raw)
+	}
+
+	for _, token := range []string{"", testToken} {
+		rsp = request(token, cookies)
+		if rsp.StatusCode != http.StatusOK || len(rsp.Cookies()) != 0 {
+			t.Error("failed to accept session", token, rsp.StatusCode)
+		}
+	}
+
+	if validations != 1 {
+		t.Error("invalid number of token validations", validations)
+	}
+
+	// the cookie alone doesn't authenticate the unsafe methods
+	if rsp = methodRequest("POST", "", cookies); rsp.StatusCode != http.StatusUnauthorized {
+		t.Error("failed to reject session without token for unsafe method", rsp.StatusCode)
+	}
+
+	if rsp = methodRequest("POST", testToken, cookies); rsp.StatusCode != http.StatusOK || validations != 1 {
+		t.Error("failed to accept session with the same token for unsafe method", rsp.StatusCode, validations)
+	}
+
+	// a session issued for a different token validation service
+	other := newAuthSessions(Options{AuthUrlBase: "https://auth.example.org", SessionKey: []byte("test-secret")})
+	v, err := other.codec.encode(defaultAuthSessionCookie, &authSession{
+		Token:   testToken,
+		Uid:     testUid,
+		Realm:   testRealm,
+		Scopes:  []string{testScope},
+		Expires: time.Now().Add(time.Minute).Unix(),
+		AuthUrl: other.authUrl})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rsp = request("", []*http.Cookie{{Name: defaultAuthSessionCookie, Value: v}}); rsp.StatusCode != http.StatusUnauthorized {
+		t.Error("failed to reject session of different service", rsp.StatusCode)
+	}
+
+	// a different token is validated
+	rsp = request("other-token", cookies)
+	if rsp.StatusCode != http.StatusOK || validations != 2 || len(rsp.Cookies()) != 1 {
+		t.Error("failed to validate different token", rsp.StatusCode, validations)
+	}
+
+	cookies[0].Value = "invalid"
+	if rsp = request("", cookies); rsp.StatusCode != http.StatusUnauthorized {
+		t.Error("failed to reject invalid session", rsp.StatusCode)
+	}
+}
@@ -1,9 +1,15 @@
 /*
 Package skoap implements authentication extensions for Skipper.
 
-The package contains four filters: auth, authTeam, auditLog and
-basicAuth. For details on how to extend Skipper with additional
-filters, please see the main Skipper documentation:
+The package contains the following filters: auth, authAll,
+authOptional, authTeam, authTeamAll, authScopeOrTeam, authGroup,
+auditLog, basicAuth, verifyBasicAuth, forwardAuth, forwardToken,
+bearerToken, exchangeToken, setHeaderTemplate, setHeaderFromAuth,
+mapClaims, allowIf, authWebhook, clientCert, teamQuota, ipAllow,
+ipDeny, denyUser, denyTeam, authUser, corsHeaders, secureHeaders,
+oidc, logout, check, owner and hedge. For details on how to extend
+Skipper with additional filters, please see the main Skipper
+documentation:
 
 https://godoc.org/github.com/zalando/skipper
 
@@ -11,7 +17,25 @@ Filter auth
 
 The auth filter takes the Authorization header from the request,
 assuming that it is a Bearer token, and validates it against the
-configured token validation service.
+configured token validation service. Only the requests without an
+Authorization header are rejected with 401 as missing-bearer-token.
+The requests with multiple Authorization headers, other schemes than
+Bearer, malformed tokens, or tokens longer than the MaxTokenLength
+option, are rejected with 400, with the multiple-authorization-headers,
+malformed-authorization-header or token-too-long reasons.
+
+With the TokenFormat option, or the tokenFormat filter option, the
+tokens are checked against a format before calling the token
+validation service: jwt, uuid, or a regular expression matching the
+whole token. The tokens not matching it are rejected with
+invalid-token-format:
+
+	* -> auth("tokenFormat=uuid", "/employees") -> "https://www.example.org"
+
+To survive the outage of a single token validation service, e.g. in one
+region, additional urls can be set with the AuthUrlFallbacks option.
+They are tried in order, when the previous service cannot be reached or
+responds with a server error, but not when it rejects the token.
 
 If the OAuth2 realm is set for the filter, then it checks if the
 user of the token belongs to that realm.
@@ -19,6 +43,20 @@ user of the token belongs to that realm.
 If the OAuth2 scopes are set for the filter, then it checks if the
 user of the token has at least one of the configured scopes assigned.
 
+Filter authAll
+
+The authAll filter works the same way as the auth filter, but instead
+of accepting any of the configured scopes, it requires the user of the
+token to have all of them assigned.
+
+Filter authOptional
+
+The authOptional filter works the same way as the auth filter when the
+request contains a bearer token, and it rejects the request when the
+token is invalid or the realm and scope checks fail. The requests
+without a token are let through untagged, so the backends can serve
+both public and personalized responses on the same route.
+
 Filter authTeam
 
 The authTeam filter works exactly the same as the auth filter, but
@@ -27,6 +65,27 @@ get the teams of the user, the filter makes an additional request,
 with the available authorization token, to a configured team API
 endpoint.
 
+Filter authTeamAll
+
+The authTeamAll filter works the same way as the authTeam filter, but
+it requires the user to be a member of all the configured teams.
+
+Filter authScopeOrTeam
+
+The authScopeOrTeam filter accepts the request when either the scope
+or the team check succeeds, e.g. for routes used by both the members
+of a team and by services with a scope. The scopes and the teams are
+separated by a "--" argument:
+
+	* -> authScopeOrTeam("", "read-orders", "--", "team-x") -> "https://www.example.org"
+
+Filter authGroup
+
+The authGroup filter works the same way as the authTeam filter, but it
+checks the group membership of the user against a separate group
+service. The name of the field containing the group id in the items
+returned by the group service is configurable, and defaults to "id".
+
 Authentication examples
 
 To check only the scopes or the teams, the first argument of the
@@ -52,16 +111,307 @@ the token belongs to a realm and belongs to one of the specified teams:
 
 	* -> authTeam("/employees", "b-team") -> "https://www.example.org"
 
+Check if the request has a valid authentication token, the user of
+the token belongs to a realm and is a member of all the specified
+teams:
+
+	* -> authTeamAll("/employees", "b-team", "ops") -> "https://www.example.org"
+
+Check if the request has a valid authentication token, the user of
+the token belongs to a realm and belongs to one of the specified groups:
+
+	* -> authGroup("/employees", "admins") -> "https://www.example.org"
+
 Check if the request has a valid authentication token, and the user
 has one of the specified scopes assigned regardless of the realm they
 belong to:
 
 	* -> auth("", "read-zmon") -> "https://www.example.org"
 
+Check if the request has a valid authentication token, and the user
+has all of the specified scopes assigned:
+
+	* -> authAll("/services", "read-kio", "write-kio") -> "https://www.example.org"
+
+The scope, team and group arguments can contain the '*' wildcard, that
+matches any sequence of characters. E.g. to accept any scope starting
+with "read-", or any team under "platform/":
+
+	* -> auth("", "read-*") -> "https://www.example.org"
+	* -> authTeam("", "platform/*") -> "https://www.example.org"
+
+Browser based clients may store the token in a cookie instead of the
+Authorization header. When the TokenCookie option is set, or the
+tokenCookie option is passed to the filter as a leading argument, the
+token is taken from the cookie with the configured name, falling back
+to the Authorization header when the cookie is not present:
+
+	* -> auth("tokenCookie=oauth2_token", "/employees") -> "https://www.example.org"
+
+Similarly, for websocket and EventSource clients that cannot set
+headers, the token can be taken from a query parameter, when the
+TokenQueryParam option or the tokenQuery filter option is set. The
+query parameter is removed from the outgoing request:
+
+	* -> auth("tokenQuery=access_token", "/employees") -> "https://www.example.org"
+
+To prevent replaying the tokens issued for one API against another,
+the audience and clientId filter options restrict the accepted tokens
+to those whose aud or client_id field, returned by the token validation
+service, matches one of the configured values. The options can be
+repeated, and can contain the * wildcard:
+
+	* -> auth("audience=orders-api", "clientId=shop", "/services") -> "https://www.example.org"
+
+When a single instance fronts services using different identity
+providers, the token validation service can be set for individual
+filters with the authUrl option. These filters don't use the sessions
+and the userinfo claims, and cannot check teams or groups, because
+those belong to the default identity provider:
+
+	* -> auth("authUrl=https://idp.example.org/tokeninfo", "/employees") -> "https://www.example.org"
+
+To reduce the load on the token validation service caused by browser
+traffic, when the SessionKey option is set, the auth filters issue a
+short-lived, encrypted session cookie after a successful validation.
+The requests with a valid session, and either with the same token, or
+without a token and with a safe method, GET, HEAD or OPTIONS, are
+accepted without validating the token again, until the session
+expires. The session cookies are Secure, unless the
+SessionCookieInsecure option is set, and SameSite=Lax, and they are
+accepted only by the filters using the same token validation service.
+
+When the token validation service returns the expiry of the token, in
+the exp or in the expires_in field, the expired tokens are rejected,
+also when accepted from a session, allowing for the clock skew set with
+the ExpiryLeeway option.
+
 In many cases, it can be a good idea to remove the Authorization header:
 
 	* -> auth() -> dropRequestHeader("Authorization") -> "https://www.example.org"
 
+Rejected requests
+
+By default, the rejected requests are answered with an empty body. When
+the JSONErrors option is set for the auth specifications, the response
+body contains the reason of the rejection and, when known, the user:
+
+	{"error":"invalid-scope","user":"jdoe"}
+
+To roll out new requirements without breaking the existing clients, the
+auth filters can run in dry-run mode, set with the DryRun option, or
+with the dryRun filter option. In this mode, the filters never reject
+the requests: the would-be reject reason is stored in the state bag,
+printed in the audit log as dryRunReason, and passed to the decision
+logger. When the token is valid, the identity is passed on as if the
+request was allowed:
+
+	* -> auth("dryRun=true", "/employees", "new-scope") -> "https://www.example.org"
+
+The dry-run mode cannot be turned on at runtime. The opposite, the
+KillSwitchDryRun kill switch, makes all the filters enforce their
+decisions again, without changing the configuration.
+
+When the token validation, team or group service cannot be reached,
+the requests are rejected with 503, the Retry-After header, and the
+reject reason in the X-Auth-Error header, so that the clients can tell
+the failures of the infrastructure from invalid tokens. With the
+ServiceFailurePolicy option, or the serviceFailure filter option, these
+requests can be rejected with 401, or the read-only requests can be let
+through without an identity, when the token validation service is
+down. The service is considered down after 5 failures in a row, or
+when it failed, and didn't respond for 30 seconds, and not when the
+validation of a single token fails:
+
+	* -> auth("serviceFailure=open-read-only", "/employees") -> "https://www.example.org"
+
+Forwarding the user identity
+
+When the Authorization header is dropped, the backend doesn't know
+anymore who the caller is. The forwardAuth filter sets the X-Auth-User,
+X-Auth-Realm and X-Auth-Scopes headers of the outgoing request, based
+on the validated token. It needs to be placed after the auth or authTeam
+filter. The incoming values of these headers are always removed, so
+clients cannot set them:
+
+	* -> auth() -> dropRequestHeader("Authorization") -> forwardAuth() -> "https://www.example.org"
+
+Forwarding the token to trusted backends only
+
+The forwardToken filter keeps the incoming Authorization header only
+when the host of the route backend is listed in its arguments, and
+drops it otherwise. This prevents leaking the tokens to third-party
+backends configured in the same routes file. A host argument starting
+with "*." matches any subdomain:
+
+	* -> auth() -> forwardToken("api.example.org", "*.internal.example.org") -> "https://api.example.org"
+
+Header templates
+
+The setHeaderTemplate filter sets an outgoing request header from a
+template. The template can contain variables in the form of ${name},
+that are replaced with the following values:
+
+	uid:          the user id of the validated token
+	realm:        the realm of the validated token
+	scopes:       the comma separated scopes of the validated token
+	teams:        the comma separated teams of the user, when checked by authTeam
+	groups:       the comma separated groups of the user, when checked by authGroup
+	rejectReason: the reason of rejecting the request
+	requestId:    the value of the incoming X-Request-Id header
+
+Other variable names are looked up in the state bag of the request,
+and used when the value is a string. Missing values are replaced with
+an empty string.
+
+Example:
+
+	* -> auth() -> setHeaderTemplate("X-Principal", "${uid}@${realm}") -> "https://www.example.org"
+
+The setHeaderFromAuth filter copies a single value, named as the
+template variables, to an outgoing request header. It removes the
+incoming header with the same name, and doesn't set it when the value is
+missing:
+
+	* -> auth() -> setHeaderFromAuth("X-Uid", "uid") -> setHeaderFromAuth("X-Scopes", "scopes") -> "https://www.example.org"
+
+Anomaly detection
+
+The auth filters count the requests carrying multiple Authorization
+headers in the skoap.anomaly.duplicate-authorization-header metrics
+counter. When the TokenReuseIPs option is set, they also count the
+requests where the same token was used from more different client
+addresses within the configured time window, in the
+skoap.anomaly.token-reuse counter. The client addresses are taken
+from the X-Forwarded-For header with the TrustedProxies and
+ForwardedDepth options, the same way as by the ipAllow and ipDeny
+filters. The detected anomalies are also
+printed by the auditLog filter, in the anomalies field of the auth
+status.
+
+Decision logging
+
+Independent of the auditLog filter, every allow or deny decision of
+the auth filters can be received by setting a DecisionLogger in the
+options of the filter specifications. The decision contains the
+details of the request, the user, the required and the held scopes,
+teams or groups, and the reject reason.
+
+Route ownership
+
+The owner filter labels a route with its owner, typically the name of
+the team owning the service behind the route. The owner is printed by
+the auditLog filter, and the requests, the rejected requests and the
+latency of the route are measured in the skoap.owner.<owner>.requests,
+skoap.owner.<owner>.rejected and skoap.owner.<owner>.latency metrics:
+
+	* -> owner("teapot") -> auditLog() -> auth() -> "https://www.example.org"
+
+Instead of setting the owner filter in each route, the owners can be
+maintained separately and applied to the routes with the data client
+returned by NewOwnerClient.
+
+Incoming basic auth
+
+The verifyBasicAuth filter validates the basic authorization
+credentials of the incoming requests against an htpasswd file. The
+supported password formats are bcrypt, apr1 and SHA1. When the
+credentials are missing or invalid, the request is rejected with 401
+and a WWW-Authenticate header. The optional second argument is the
+realm sent in the header:
+
+	* -> verifyBasicAuth("/etc/skoap/htpasswd", "Admin area") -> "https://www.example.org"
+
+Client certificates
+
+When skoap terminates TLS and verifies the client certificates, the
+clientCert filter authorizes the requests based on the certificate. The
+named arguments cn and san match the subject common name and the DNS
+subject alternative names, and issuer matches the SHA-256 fingerprint
+of the certificate or the public key of the issuing CAs. The common name
+of the certificate is stored as the user, and printed in the audit log:
+
+	* -> clientCert("san=*.internal.example.org", "issuer=9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08") -> "https://www.example.org"
+
+Client networks
+
+The ipAllow and ipDeny filters reject the requests with 403, when the
+client address is not in, or respectively is in, one of the networks
+set as their arguments. Placed before the auth filters, they lock down
+the internal routes at the network layer, too. Behind proxies, the
+client address is taken from the X-Forwarded-For header, either by
+skipping the trusted proxies, or at a fixed depth from the right, see
+IPFilterOptions:
+
+	* -> ipAllow("10.0.0.0/8", "192.168.1.0/24") -> auth() -> "https://www.example.org"
+
+Blocking identities
+
+The denyUser and denyTeam filters reject the requests with 403, when
+the user, or respectively any of the teams of the user, matches one of
+their arguments, even when the scope or team checks of the preceding
+auth filters succeed. They are meant for blocking compromised accounts
+in emergencies. The teams are resolved by a preceding authTeam filter:
+
+	* -> auth("/employees", "read") -> denyUser("jdoe", "asmith") -> "https://www.example.org"
+	* -> authTeam("/employees", "*") -> denyTeam("team-x") -> "https://www.example.org"
+
+The authUser filter works the other way around: it rejects the
+requests with 403, unless the user matches one of its arguments. It is
+useful for admin endpoints that should not rely on broad scopes:
+
+	* -> auth("/employees") -> authUser("jdoe", "asmith") -> "https://admin.example.org"
+
+Routing predicates
+
+NewAuthPredicates creates the AuthRealm, AuthScope and AuthTeam
+routing predicates. They match the requests whose token owner belongs
+to the realm, has one of the scopes, or is a member of one of the
+teams, so that different authenticated populations can be routed to
+different backends. They don't replace the auth filters:
+
+	AuthRealm("/employees") -> auth("/employees") -> "https://internal.example.org"
+
+CORS
+
+Browsers send the CORS preflight requests without credentials. With
+the AllowPreflight option, the auth filters answer them with 204,
+without token validation, and never forward them to the backend. The
+corsHeaders filter, placed before the auth filters, answers them, and
+sets the Access-Control-Allow-Origin header of the responses, for the
+origins set as its arguments:
+
+	* -> corsHeaders("maxAge=600", "https://*.example.org") -> auth() -> "https://www.example.org"
+
+Security headers
+
+The secureHeaders filter sets the Strict-Transport-Security,
+X-Content-Type-Options and X-Frame-Options headers of the responses,
+and optionally the Content-Security-Policy header, unless the backend
+has already set them:
+
+	* -> secureHeaders("csp=default-src 'self'") -> auth() -> "https://www.example.org"
+
+Browser login
+
+The oidc filter lets the browser clients log in with the OIDC
+authorization code flow. The browser requests without a token or a
+session are redirected to the identity provider, and after the login,
+the access token is stored in an encrypted session cookie. The filter
+sets the token from the session as the Authorization header, so that
+the following auth filters validate it, see OIDCOptions:
+
+	* -> oidc() -> auth("/employees") -> "https://ui.example.org"
+
+The logout filter clears the session cookies, purges the token of the
+request from the caches, and rejects it in the following requests, so
+that the logout takes effect immediately, even when the token is still
+valid at the token validation service. Only the tokens of the sessions,
+and the tokens found in the caches or accepted by the token validation
+service, are revoked:
+
+	Path("/logout") -> logout("/") -> <shunt>
+
 Outgoing basic auth
 
 The package provides a filter that can set basic authorization headers
@@ -72,6 +422,133 @@ Example:
 
 	* -> basicAuth("username", "pwd") -> "https://www.example.org"
 
+To keep the secrets out of the route configuration, the credentials can
+be taken from an environment variable or a file, containing the username
+and the password separated by a colon. The file is reloaded when it
+changes:
+
+	* -> basicAuth("env:UPSTREAM_CREDS") -> "https://www.example.org"
+	* -> basicAuth("file:/etc/skoap/upstream-creds") -> "https://www.example.org"
+
+User info
+
+When the UserInfoUrl option is set, the auth filters fetch the selected
+claims of the authenticated users, by default the email and the name,
+from an OIDC userinfo endpoint, and cache them. The claims are
+forwarded by the forwardAuth filter in the X-Auth-Claim-<name> headers,
+and printed in the audit log.
+
+Claims mapping
+
+The mapClaims filter transforms the identity of the authenticated user
+with a small expression language, and sets the results as outgoing
+headers, or as variables, when the target starts with $, that can be
+used by the setHeaderTemplate filter. The expressions can refer to uid,
+realm, scopes, teams, groups and the userinfo claims, and can use the
+functions lower, upper, trim, domain, localpart, first, join,
+trimprefix and replace:
+
+	* -> auth()
+	  -> mapClaims("X-Email=lower(email)", "$domain=domain(email)", "X-Groups=join(groups, \";\")")
+	  -> setHeaderTemplate("X-Tenant", "${domain}")
+	  -> "https://www.example.org"
+
+The same mappings can be applied to all routes with the data client
+returned by NewClaimsMappingClient.
+
+Conditions
+
+The allowIf filter rejects the requests with 401, unless the condition
+set as its argument is met. The conditions use the claims mapping
+language, extended with the ==, != and in operators, and the !, && and
+|| logical operators:
+
+	* -> auth() -> allowIf("realm == \"/employees\" || \"ops\" in teams") -> "https://www.example.org"
+
+External policies
+
+The authWebhook filter delegates the authorization to an external policy
+engine, e.g. Open Policy Agent. After the token is validated by a
+preceding auth filter, it posts the request method and path, and the
+uid, realm, scopes, teams, groups and claims of the user to the url set
+as its argument, as the input document:
+
+	{"input": {"method": "GET", "path": "/orders", "uid": "jdoe", "realm": "/employees", "scopes": ["uid"]}}
+
+The engine responds with a boolean result, or an object containing the
+decision and optionally the reject reason:
+
+	{"result": true}
+	{"result": {"allow": false, "reason": "outside-business-hours"}}
+
+The denied requests, and when the engine cannot be reached, all
+requests, are rejected with 401:
+
+	* -> auth() -> authWebhook("http://localhost:8181/v1/data/skoap/allow") -> "https://www.example.org"
+
+Custom checks
+
+Custom authorization checks can be registered with the check filter
+specification. The checks receive the identity of the authenticated
+user and the request, and allow or deny it. The skoap command can load
+them from Go plugins:
+
+	* -> auth() -> check("businessHours", "Europe/Berlin") -> "https://www.example.org"
+
+Custom Skipper filters placed after the auth filters can read the
+identity of the allowed user with GetAuthContext, or from the state bag
+with the StateBagAuthContextKey key. The uid, and for the rejected
+requests the reject reason, are stored with the StateBagUserKey and
+StateBagRejectReasonKey keys.
+
+Team quotas
+
+The teamQuota filter limits the number of requests of the teams in
+fixed periods, e.g. per hour. The teams of the user are resolved by a
+preceding authTeam filter, and when any of them has exceeded its quota,
+the request is rejected with 429 and a Retry-After header. The limits
+are set per team in the TeamQuotaOptions, with the limit of "*"
+applying to the teams without their own limit:
+
+	* -> authTeam("/employees", "teapot", "mop") -> teamQuota() -> "https://www.example.org"
+
+With the LoadLimits and ReloadInterval options, the limits are reloaded
+periodically. The MethodWeights option down-weights the requests of
+some HTTP methods, e.g. HEAD, or excludes them from the quotas.
+
+Service tokens
+
+The bearerToken filter authenticates skoap itself to protected backends.
+It replaces the Authorization header of the outgoing request with a
+service token, obtained with the OAuth2 client credentials flow, and
+refreshed in the background. The arguments are the requested scopes:
+
+	* -> auth() -> bearerToken("read-orders") -> "https://orders.example.org"
+
+Until the first token is obtained, the requests are responded with 503.
+
+When the backends don't accept the tokens of the clients, the
+exchangeToken filter exchanges the incoming token, validated by a
+preceding auth filter, for a token issued for the backend, using the
+OAuth2 token exchange grant. The arguments are the requested scopes,
+optionally preceded by the audience:
+
+	* -> auth() -> exchangeToken("audience=orders", "read-orders") -> "https://orders.example.org"
+
+Request hedging
+
+The hedge filter tames the tail latency of slow backends. For
+idempotent requests, when no response arrives within the delay set as
+the argument, it sends a second request to the backend, and uses
+whichever responds first. The filter sends the requests itself, so it
+needs to be the last filter of the route. NewHedgeWithTransport sends
+them with the transport of the proxy:
+
+	* -> auth() -> hedge("50ms") -> "https://www.example.org"
+
+The second requests are counted in the skoap.hedge.sent metrics, and
+those that responded first in skoap.hedge.won.
+
 Audit log
 
 The auditLog filter prints the request method and path, and the response
@@ -87,28 +564,149 @@ Since the body is logged withing the same log entry as the other values,
 the logged part of the body is buffered until it is written to the output.
 With large or infinite limit, this can have performance implications.
 
+The sinks writing to a stream or to a file can use the Common Log Format
+or logfmt instead of JSON, see AuditFormat.
+
 Example:
 
 	* -> auditLog(1024) -> auth() -> "https://www.example.org"
+
+The audit events of a route can be labeled with a category, that is
+printed in the category field of the log entries:
+
+	* -> auditLog(1024, "category=payments-api") -> auth() -> "https://www.example.org"
+
+With the tls option, the audit log entries contain the details of the
+TLS connection of the client: the protocol version, the cipher suite,
+the server name, whether the session was resumed, and the subject of
+the client certificate, if any:
+
+	* -> auditLog(1024, "tls=true") -> auth() -> "https://www.example.org"
+
+With the backend option, the audit log entries contain the details of
+the connection to the backend: the resolved address, whether the
+connection was reused, the result of the TLS verification, and the
+number of retries:
+
+	* -> auditLog(1024, "backend=true") -> auth() -> "https://www.example.org"
+
+With the query option, the audit log entries contain the query of the
+forwarded request, without the token parameter removed by the auth
+filters:
+
+	* -> auditLog(1024, "query=true") -> auth() -> "https://www.example.org"
+
+The values of the sensitive fields in the captured JSON or form encoded
+request bodies can be replaced with [REDACTED], selected by regular
+expressions matching the field names. The fields are redacted at any
+depth, and the objects and arrays of the sensitive fields are replaced
+whole. The same expressions apply to the names of the captured headers
+and query parameters:
+
+	* -> auditLog(4096, "redact=password", "redact=card.*") -> auth() -> "https://www.example.org"
+
+The values of selected request and response headers can be captured by
+listing their names. The headers carrying credentials are never
+captured, like Authorization, Proxy-Authorization, Cookie, Set-Cookie,
+and the ones with a name containing a word like token, secret,
+password, api-key, credential or session:
+
+	* -> auditLog(1024, "X-Request-Id", "Content-Type") -> auth() -> "https://www.example.org"
+
+By default, the entries are written to the writer passed to
+NewAuditLog. With NewAuditLogWithSink, they can be sent to any
+AuditSink, e.g. a file, a syslog daemon or an HTTP webhook.
+
+The entries are encoded and written in the background, through a
+bounded buffer, so a slow sink never delays the responses. When the
+buffer is full, the oldest entries are dropped.
+
+Risky subsystems can be disabled at runtime with SetKillSwitch, or over
+HTTP with the handler returned by NewKillSwitchHandler, without changing
+the configuration. KillSwitchCaching bypasses the caches of the
+userinfo claims, the exchanged tokens and the predicates,
+KillSwitchFailOpen rejects the requests that the open-read-only service
+failure policy would let through, KillSwitchDryRun enforces the
+decisions of the filters in dry-run mode, and KillSwitchWebhookSinks
+discards the audit entries sent to HTTP endpoints. Turning a switch on
+never relaxes the enforcement. The changes made via the handler are
+logged to the audit sink, and to stderr, which no kill switch can
+disable.
+
+The footprint of the caches and the audit buffers is returned by Memory
+and NewMemoryHandler, and published via expvar as skoap-memory. With
+SetMemoryBudget, a global budget can be set, and when it is exceeded,
+the caches evict their least recently used entries.
+
+Degradation and NewDegradationHandler report which guarantees are
+currently relaxed: the kill switches turned on, an exceeded memory
+budget, the audit buffers filling up or dropping entries, the service
+tokens failing to refresh, and the open-read-only service failure
+policy, while it is active because the token validation service is
+down, or while it lets requests through. The report is published via
+expvar as skoap-degradation, too.
+
+NewCacheHandler returns the statistics of the caches, and purges a
+token, or the cached tokens of a user, from the caches keyed by the
+tokens, optionally revoking them, e.g. when the tokens were
+compromised. RejectCounts and NewRejectCountsHandler return the number
+of the rejected requests by reject reason, published via expvar as
+skoap-rejects, too.
+
+NewKubernetesClient creates a data client generating the routes from
+the Kubernetes Ingress objects, with the auth filters set by the
+skoap.zalando.org/realm, skoap.zalando.org/scopes,
+skoap.zalando.org/teams and skoap.zalando.org/public annotations.
+
+NewAccessLog wraps the proxy, and writes an access log entry for every
+request, in the combined or in the JSON format, with the user
+authenticated or rejected by the auth filters of the route.
 */
 package skoap
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/zalando/skipper/filters"
 	"io"
+	"io/ioutil"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	authHeaderName      = "Authorization"
+	scopeTeamSeparator  = "--"
+	uidPlaceholder      = "{uid}"
+	maxPages            = 100
 	authUserKey         = "auth-user"
 	authRejectReasonKey = "auth-reject-reason"
+	authDocKey          = "auth-doc"
+	authTeamsKey        = "auth-teams"
+	authGroupsKey       = "auth-groups"
+	authAnomaliesKey    = "auth-anomalies"
+	authTokenKey        = "auth-token"
+	authClaimsKey       = "auth-claims"
+	authContextKey      = "auth-context"
+	authDryRunReasonKey = "auth-dry-run-reason"
+	authSessionKey      = "auth-session"
+	backendTraceKey     = "backend-trace"
 )
 
 type roleCheckType int
@@ -116,35 +714,319 @@ type roleCheckType int
 const (
 	checkScope roleCheckType = iota
 	checkTeam
+	checkGroup
+	checkScopeOrTeam
 )
 
 type rejectReason string
 
 const (
 	missingBearerToken rejectReason = "missing-bearer-token"
+	malformedHeader    rejectReason = "malformed-authorization-header"
+	multipleHeaders    rejectReason = "multiple-authorization-headers"
+	tokenTooLong       rejectReason = "token-too-long"
 	authServiceAccess  rejectReason = "auth-service-access"
 	invalidToken       rejectReason = "invalid-token"
+	expiredToken       rejectReason = "expired-token"
 	invalidRealm       rejectReason = "invalid-realm"
 	invalidScope       rejectReason = "invalid-scope"
 	teamServiceAccess  rejectReason = "team-service-access"
 	invalidTeam        rejectReason = "invalid-team"
+	groupServiceAccess rejectReason = "group-service-access"
+	invalidGroup       rejectReason = "invalid-group"
+	invalidScopeOrTeam rejectReason = "invalid-scope-or-team"
+	invalidAudience    rejectReason = "invalid-audience"
+	invalidClientId    rejectReason = "invalid-client-id"
 )
 
 const (
-	AuthName      = "auth"
-	AuthTeamName  = "authTeam"
-	BasicAuthName = "basicAuth"
-	AuditLogName  = "auditLog"
+	AuthName            = "auth"
+	AuthTeamName        = "authTeam"
+	AuthGroupName       = "authGroup"
+	AuthAllName         = "authAll"
+	AuthTeamAllName     = "authTeamAll"
+	AuthOptionalName    = "authOptional"
+	AuthScopeOrTeamName = "authScopeOrTeam"
+	BasicAuthName       = "basicAuth"
+	VerifyBasicAuthName = "verifyBasicAuth"
+	AuditLogName        = "auditLog"
+	ForwardAuthName     = "forwardAuth"
+	ForwardTokenName    = "forwardToken"
+	BearerTokenName     = "bearerToken"
+	ExchangeTokenName   = "exchangeToken"
+	HedgeName           = "hedge"
+	MapClaimsName       = "mapClaims"
+	CheckName           = "check"
+	AllowIfName         = "allowIf"
+	AuthWebhookName     = "authWebhook"
+	ClientCertName      = "clientCert"
+	TeamQuotaName       = "teamQuota"
+	IPAllowName         = "ipAllow"
+	IPDenyName          = "ipDeny"
+	DenyUserName        = "denyUser"
+	DenyTeamName        = "denyTeam"
+	AuthUserName        = "authUser"
+
+	SetHeaderTemplateName = "setHeaderTemplate"
+	SetHeaderFromAuthName = "setHeaderFromAuth"
+	CorsHeadersName       = "corsHeaders"
+	SecureHeadersName     = "secureHeaders"
+	OIDCName              = "oidc"
+	LogoutName            = "logout"
+	OwnerName             = "owner"
+
+	AuthRealmPredicateName = "AuthRealm"
+	AuthScopePredicateName = "AuthScope"
+	AuthTeamPredicateName  = "AuthTeam"
 )
 
+// TeamSource resolves the teams of the users, used by the authTeam
+// and authScopeOrTeam filters, and the AuthTeam predicate. By default,
+// the team service set with TeamUrlBase is used.
+type TeamSource interface {
+
+	// Returns the ids of the teams that the user is a member of. The
+	// token is the validated token of the request. The context is
+	// cancelled together with the request.
+	Teams(ctx context.Context, uid, token string) ([]string, error)
+}
+
+// Options contains the settings of the auth and authTeam filter
+// specifications.
+type Options struct {
+
+	// The url of the token validation service.
+	AuthUrlBase string
+
+	// Additional urls of the token validation service, e.g. in other
+	// regions. They are tried in order, when the previous one cannot
+	// be reached or responds with a server error.
+	AuthUrlFallbacks []string
+
+	// The url of the team service. Used only by the authTeam filter.
+	// The user id is appended to the url, or, when present, it replaces
+	// the {uid} placeholder.
+	TeamUrlBase string
+
+	// The url of the group service. Used only by the authGroup filter.
+	// The user id is set the same way as for the team service.
+	GroupUrlBase string
+
+	// The name of the field in the items returned by the group service
+	// that contains the group id. Defaults to "id".
+	GroupIdField string
+
+	// The name of the field in the items returned by the team service
+	// that contains the team id. Defaults to "id".
+	TeamIdField string
+
+	// When set, the teams are resolved from this source instead of the
+	// team service, e.g. from GitHub. See NewGitHubTeamSource.
+	TeamSource TeamSource
+
+	// When set, the rejected requests are responded with a JSON body
+	// containing the reject reason and the user, if known.
+	JSONErrors bool
+
+	// When greater than zero, the use of the same token from more
+	// different client addresses than this value, within the
+	// TokenReuseWindow, is reported as an anomaly.
+	TokenReuseIPs int
+
+	// The time window of the token reuse detection. Defaults to one
+	// minute.
+	TokenReuseWindow time.Duration
+
+	// The proxies trusted to set the X-Forwarded-For header, and the
+	// depth of the client address in the header, used by the token
+	// reuse detection the same way as by the ipAllow and ipDeny
+	// filters. See IPFilterOptions.
+	TrustedProxies []*net.IPNet
+	ForwardedDepth int
+
+	// When set, it receives every allow or deny decision made by the
+	// filters.
+	DecisionLogger DecisionLogger
+
+	// When set, the token is taken from the cookie with this name, if
+	// present, before falling back to the Authorization header.
+	TokenCookie string
+
+	// When set, the token is taken from the query parameter with this
+	// name, if present, before falling back to the Authorization
+	// header. The parameter is removed from the outgoing request.
+	TokenQueryParam string
+
+	// The url of an OIDC userinfo endpoint. When set, the selected
+	// claims of the authenticated users are fetched from it, forwarded
+	// by the forwardAuth filter, and printed in the audit log.
+	UserInfoUrl string
+
+	// The claims taken from the userinfo endpoint. Defaults to email and
+	// name.
+	UserInfoClaims []string
+
+	// The time while the claims are cached for a token. Defaults to five
+	// minutes.
+	UserInfoCacheTTL time.Duration
+
+	// The name of the field in the response of the token validation
+	// service that contains the user id, used for the team and group
+	// lookups and in the audit log. Defaults to "uid". When set, the
+	// tokens without a user id are rejected.
+	UidField string
+
+	// The name of the field in the response of the token validation
+	// service that contains the realm. Defaults to "realm".
+	RealmField string
+
+	// The name of the field in the response of the token validation
+	// service that contains the scopes, either as a JSON array or as a
+	// space separated string. Defaults to "scope".
+	ScopeField string
+
+	// When its TokenUrl is set, the team service is called with a
+	// service token obtained with the client credentials flow, instead
+	// of the token of the user. The user id is passed in the url, as
+	// before. Every specification created with these options obtains
+	// its own token, see NewTeamServiceSource for sharing one.
+	TeamServiceToken ServiceTokenOptions
+
+	// The scopes requested for the team service token.
+	TeamServiceTokenScopes []string
+
+	// When set, the filters never reject the requests. The would-be
+	// decisions are recorded in the state bag, in the audit log and by
+	// the decision logger. The routes can override it with the dryRun
+	// option.
+	DryRun bool
+
+	// When set, the CORS preflight requests, OPTIONS requests with the
+	// Access-Control-Request-Method header, are answered with 204
+	// without token validation, and are not forwarded to the backend.
+	// Browsers send them without credentials.
+	AllowPreflight bool
+
+	// When set, after a successful token validation, a session cookie
+	// encrypted with this secret is issued, and accepted instead of
+	// validating the token again, until it expires.
+	SessionKey []byte
+
+	// The name of the session cookie. Defaults to skoap-auth.
+	SessionCookie string
+
+	// The lifetime of the sessions. Defaults to five minutes.
+	SessionTTL time.Duration
+
+	// When set, the session cookies are issued without the Secure
+	// attribute, e.g. for local development over plain HTTP.
+	SessionCookieInsecure bool
+
+	// Tells how the requests are handled when the token validation,
+	// team or group service cannot be reached. Defaults to
+	// FailUnavailable. The routes can override it with the
+	// serviceFailure option.
+	ServiceFailurePolicy ServiceFailurePolicy
+
+	// The value of the Retry-After header, when the FailUnavailable
+	// policy is used. Defaults to 30 seconds.
+	RetryAfter time.Duration
+
+	// The overall deadline of the service calls made by a filter for a
+	// request: the token validation and the team or group lookups.
+	// Zero means no deadline, other than the cancellation of the
+	// request.
+	AuthDeadline time.Duration
+
+	// The tolerated clock skew, when checking the expiry of the tokens
+	// returned by the token validation service in the exp or expires_in
+	// fields.
+	ExpiryLeeway time.Duration
+
+	// The maximum length of the tokens. The requests with longer tokens
+	// are rejected with 400. Defaults to 8192.
+	MaxTokenLength int
+
+	// When set, the tokens are checked against this format before
+	// calling the token validation service, and the ones not matching
+	// it are rejected with invalid-token-format, e.g. to absorb the
+	// scanner noise cheaply. It can be jwt, uuid, or a regular
+	// expression matching the whole token. The routes can override it
+	// with the tokenFormat option.
+	TokenFormat string
+}
+
+// AuditLogOptions contains the settings of the auditLog filter
+// specification.
+type AuditLogOptions struct {
+
+	// The sink receiving the audit log entries.
+	Sink AuditSink
+
+	// The default byte limit of the captured request body, used by
+	// the filters without an explicit limit argument. Zero means no
+	// body, negative values mean no limit.
+	MaxBody int
+
+	// The proxies trusted to set the X-Forwarded-For header. When a
+	// request comes from a trusted proxy, the remote address in the
+	// audit log is taken from the header.
+	TrustedProxies []*net.IPNet
+
+	// Regular expressions matching the names of the JSON fields or
+	// form parameters, whose values are replaced with [REDACTED] in
+	// the captured request bodies, e.g. password. The same expressions
+	// apply to the captured headers and query parameters. The
+	// expressions match the whole names, case insensitive.
+	Redact []string
+
+	// When set, the audit log entries contain a fingerprint of the
+	// presented token, the HMAC-SHA256 of the token keyed with this
+	// salt, so that the requests made with the same token can be
+	// correlated, without logging the token.
+	TokenFingerprintSalt []byte
+
+	// Static fields merged into every audit log entry, e.g. the
+	// environment, the datacenter or the application id.
+	Fields map[string]string
+
+	// Sample rates of the audit log entries by HTTP method, between 0
+	// and 1, e.g. to log only 1% of the HEAD and OPTIONS requests sent
+	// by monitoring systems. Zero excludes the method. The methods
+	// not listed, the rejected requests, and the requests with
+	// anomalies or a dry-run reject reason, are always logged.
+	MethodSampleRates map[string]float64
+}
+
 type (
-	authClient struct{ urlBase string }
-	teamClient struct{ urlBase string }
+	authClient struct {
+		urlBase      string
+		fallbacks    []string
+		uidField     string
+		realmField   string
+		scopeField   string
+		expiryLeeway time.Duration
+	}
+	teamClient struct {
+		urlBase      string
+		idField      string
+		serviceToken *serviceToken
+	}
+
+	groupClient struct {
+		urlBase string
+		idField string
+	}
 
 	authDoc struct {
-		Uid    string   `json:"uid"`
-		Realm  string   `json:"realm"`
-		Scopes []string `json:"scope"` // TODO: verify this with service2service authentication
+		Uid      string   `json:"uid"`
+		Realm    string   `json:"realm"`
+		Scopes   []string `json:"scope"`
+		Audience []string `json:"aud,omitempty"`
+		ClientId string   `json:"client_id,omitempty"`
+
+		// taken from the exp or expires_in fields, when present
+		expires time.Time
 	}
 
 	teamDoc struct {
@@ -152,24 +1034,78 @@ type (
 	}
 
 	spec struct {
-		typ        roleCheckType
-		authClient *authClient
-		teamClient *teamClient
+		typ            roleCheckType
+		all            bool
+		authClient     *authClient
+		teamClient     TeamSource
+		groupClient    *groupClient
+		jsonErrors     bool
+		reuseDetector  *reuseDetector
+		decisionLogger DecisionLogger
+		tokenCookie    string
+		tokenQuery     string
+		userInfoClient *userInfoClient
+		dryRun         bool
+		optional       bool
+		allowPreflight bool
+		sessions       *authSessions
+		authDeadline   time.Duration
+		failurePolicy  ServiceFailurePolicy
+		retryAfter     time.Duration
+		maxTokenLength int
+		tokenFormat    string
 	}
 
 	filter struct {
-		typ        roleCheckType
-		authClient *authClient
-		teamClient *teamClient
-		realm      string
-		args       []string
+		name           string
+		typ            roleCheckType
+		all            bool
+		authClient     *authClient
+		teamClient     TeamSource
+		groupClient    *groupClient
+		jsonErrors     bool
+		reuseDetector  *reuseDetector
+		decisionLogger DecisionLogger
+		tokenCookie    string
+		tokenQuery     string
+		userInfoClient *userInfoClient
+		dryRun         bool
+		optional       bool
+		allowPreflight bool
+		sessions       *authSessions
+		authDeadline   time.Duration
+		failurePolicy  ServiceFailurePolicy
+		retryAfter     time.Duration
+		maxTokenLength int
+		tokenFormat    *regexp.Regexp
+		realm          string
+		args           []string
+		teams          []string
+		audience       []string
+		clientIds      []string
+	}
+
+	errorDoc struct {
+		Error string `json:"error"`
+		User  string `json:"user,omitempty"`
 	}
 
 	basic string
 
 	auditLog struct {
-		writer     io.Writer
-		maxBodyLog int
+		sink           AuditSink
+		maxBodyLog     int
+		category       string
+		tls            bool
+		backend        bool
+		query          bool
+		headers        []string
+		trustedProxies []*net.IPNet
+		redactPatterns []string
+		redactor       *redactor
+		salt           []byte
+		fields         map[string]string
+		sampleRates    map[string]float64
 	}
 
 	teeBody struct {
@@ -178,45 +1114,118 @@ type (
 		teeReader io.Reader
 		maxTee    int
 	}
+)
 
-	authStatusDoc struct {
-		User     string `json:"user,omitempty"`
-		Rejected bool   `json:"rejected"`
-		Reason   string `json:"reason,omitempty"`
-	}
+var responseBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
 
-	auditDoc struct {
-		Method      string         `json:"method"`
-		Path        string         `json:"path"`
-		Status      int            `json:"status"`
-		AuthStatus  *authStatusDoc `json:"authStatus,omitempty"`
-		RequestBody string         `json:"requestBody,omitempty"`
-	}
+const (
+	// the max size of the JSON responses of the services
+	maxResponseSize = 1 << 20
+
+	// the buffers grown by larger responses are not put back in the
+	// pool, to not keep their memory
+	maxPooledBufferSize = 64 << 10
 )
 
+// the default limit of the token length, when not set in the options
+const defaultMaxTokenLength = 8192
+
 var (
+	errMissingToken               = errors.New("missing token")
 	errInvalidAuthorizationHeader = errors.New("invalid authorization header")
+	errMultipleAuthHeaders        = errors.New("multiple authorization headers")
+	errTokenTooLong               = errors.New("token too long")
 	errInvalidToken               = errors.New("invalid token")
+	errExpiredToken               = errors.New("expired token")
+	errServiceFailure             = errors.New("service failure")
+	errTooManyPages               = errors.New("too many pages")
+	errResponseTooLarge           = errors.New("response too large")
+	errAuthUrlLookup              = errors.New("the authUrl option cannot be used together with team or group checks")
 )
 
 func getToken(r *http.Request) (string, error) {
-	const b = "Bearer "
-	h := r.Header.Get(authHeaderName)
-	if !strings.HasPrefix(h, b) {
+	return parseToken(r, defaultMaxTokenLength)
+}
+
+// parses the bearer token from the Authorization header. Only the
+// absent header is reported as errMissingToken. Multiple headers, other
+// schemes than Bearer, empty tokens or tokens containing whitespace,
+// and the tokens longer than maxLength are reported as distinct
+// errors. The scheme is case insensitive.
+func parseToken(r *http.Request, maxLength int) (string, error) {
+	h := r.Header[authHeaderName]
+	switch {
+	case len(h) == 0:
+		return "", errMissingToken
+	case len(h) > 1:
+		return "", errMultipleAuthHeaders
+	}
+
+	const b = "bearer "
+	if len(h[0]) < len(b) || !strings.EqualFold(h[0][:len(b)], b) {
+		return "", errInvalidAuthorizationHeader
+	}
+
+	token := strings.TrimLeft(h[0][len(b):], " ")
+	if token == "" || strings.ContainsAny(token, " \t") {
 		return "", errInvalidAuthorizationHeader
 	}
 
-	return h[len(b):], nil
+	if maxLength > 0 && len(token) > maxLength {
+		return "", errTokenTooLong
+	}
+
+	return token, nil
 }
 
-func unauthorized(ctx filters.FilterContext, uname string, reason rejectReason) {
-	ctx.StateBag()[authUserKey] = uname
+func errorResponse(status int, uname string, reason rejectReason) *http.Response {
+	b, err := json.Marshal(&errorDoc{Error: string(reason), User: uname})
+	if err != nil {
+		log.Println(err)
+		return &http.Response{StatusCode: status}
+	}
+
+	return &http.Response{
+		StatusCode:    status,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		ContentLength: int64(len(b)),
+		Body:          ioutil.NopCloser(bytes.NewBuffer(b))}
+}
+
+func reject(ctx filters.FilterContext, status int, uname string, reason rejectReason, jsonErrors bool) {
+	rejectWithHeader(ctx, status, uname, reason, jsonErrors, nil)
+}
+
+func rejectWithHeader(ctx filters.FilterContext, status int, uname string, reason rejectReason, jsonErrors bool, h http.Header) {
+	setAuthUser(ctx, uname)
 	ctx.StateBag()[authRejectReasonKey] = string(reason)
-	ctx.Serve(&http.Response{StatusCode: http.StatusUnauthorized})
+	countReject(reason)
+
+	rsp := &http.Response{StatusCode: status}
+	if jsonErrors {
+		rsp = errorResponse(status, uname, reason)
+	}
+
+	if len(h) > 0 {
+		if rsp.Header == nil {
+			rsp.Header = make(http.Header)
+		}
+
+		for k, v := range h {
+			rsp.Header[k] = v
+		}
+	}
+
+	ctx.Serve(rsp)
+}
+
+func unauthorized(ctx filters.FilterContext, uname string, reason rejectReason, jsonErrors bool) {
+	reject(ctx, http.StatusUnauthorized, uname, reason, jsonErrors)
 }
 
-func authorized(ctx filters.FilterContext, uname string) {
-	ctx.StateBag()["auth-user"] = uname
+func authorized(ctx filters.FilterContext, a *authDoc) {
+	setAuthUser(ctx, a.Uid)
+	ctx.StateBag()[authDocKey] = a
 }
 
 func getStrings(args []interface{}) ([]string, error) {
@@ -232,10 +1241,81 @@ func getStrings(args []interface{}) ([]string, error) {
 	return s, nil
 }
 
-func intersect(left, right []string) bool {
-	for _, l := range left {
-		for _, r := range right {
-			if l == r {
+// matches a value against a pattern, where '*' in the pattern matches
+// any sequence of characters. Patterns without '*' match only the
+// exact value, and patterns with a single trailing '*' are matched as
+// prefixes.
+func match(pattern, value string) bool {
+	i := strings.IndexByte(pattern, '*')
+	switch {
+	case i < 0:
+		return pattern == value
+	case i == len(pattern)-1:
+		return strings.HasPrefix(value, pattern[:i])
+	}
+
+	// iterative matching with backtracking to the last '*'
+	var p, v int
+	star, next := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, v
+			p++
+		case p < len(pattern) && pattern[p] == value[v]:
+			p++
+			v++
+		case star >= 0:
+			p = star + 1
+			next++
+			v = next
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+
+	return p == len(pattern)
+}
+
+// checks if every pattern in required matches at least one of the
+// available values.
+func containsAll(required, available []string) bool {
+	for _, r := range required {
+		found := false
+		for _, a := range available {
+			if match(r, a) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// namedArg splits a filter argument in the form of name=value.
+func namedArg(a string) (string, string, bool) {
+	i := strings.Index(a, "=")
+	if i <= 0 {
+		return "", "", false
+	}
+
+	return a[:i], a[i+1:], true
+}
+
+// checks if any of the patterns matches any of the values.
+func intersect(patterns, values []string) bool {
+	for _, l := range patterns {
+		for _, r := range values {
+			if match(l, r) {
 				return true
 			}
 		}
@@ -244,55 +1324,459 @@ func intersect(left, right []string) bool {
 	return false
 }
 
-func jsonGet(url, auth string, doc interface{}) error {
+func jsonGet(ctx context.Context, url, auth string, doc interface{}) error {
+	_, err := jsonGetHeader(ctx, url, auth, doc)
+	return err
+}
+
+// jsonGetHeader works the same way as jsonGet, and returns the
+// response headers, too. The failed calls are retried, when configured.
+func jsonGetHeader(ctx context.Context, url, auth string, doc interface{}) (http.Header, error) {
+	var h http.Header
+	err := withRetries(ctx, func(ctx context.Context) error {
+		var err error
+		h, err = jsonGetOnce(ctx, url, auth, doc)
+		return err
+	})
+
+	return h, err
+}
+
+func jsonGetOnce(ctx context.Context, url, auth string, doc interface{}) (http.Header, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	req = req.WithContext(ctx)
+
 	if auth != "" {
 		req.Header.Set(authHeaderName, "Bearer "+auth)
 	}
 
-	rsp, err := http.DefaultClient.Do(req)
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= 500 {
+		return nil, errServiceFailure
+	}
+
+	if rsp.StatusCode != 200 {
+		return nil, errInvalidToken
+	}
+
+	// the responses are read into pooled buffers, to avoid allocating
+	// a new decoder buffer for every request
+	b := responseBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if b.Cap() <= maxPooledBufferSize {
+			b.Reset()
+			responseBuffers.Put(b)
+		}
+	}()
+
+	n, err := b.ReadFrom(io.LimitReader(rsp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if n > maxResponseSize {
+		return nil, errResponseTooLarge
+	}
+
+	return rsp.Header, json.Unmarshal(b.Bytes(), doc)
+}
+
+// returns the url of the next page from the Link header, resolved
+// against the url of the current page. A next page with a different
+// scheme or host is not followed, so the pages, and the credentials
+// sent with them, stay on the origin of the first page.
+func nextLink(current string, h http.Header) string {
+	for _, l := range h["Link"] {
+		for _, link := range strings.Split(l, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+
+			for _, p := range parts[1:] {
+				p = strings.Replace(strings.TrimSpace(p), " ", "", -1)
+				if p != `rel="next"` && p != "rel=next" {
+					continue
+				}
+
+				cu, err := url.Parse(current)
+				if err != nil {
+					return ""
+				}
+
+				nu, err := cu.Parse(target[1 : len(target)-1])
+				if err != nil || nu.Scheme != cu.Scheme || nu.Host != cu.Host {
+					return ""
+				}
+
+				return nu.String()
+			}
+		}
+	}
+
+	return ""
+}
+
+// Creates a TeamSource calling the team service set with TeamUrlBase,
+// with the TeamServiceToken, when configured. Every filter
+// specification and the predicates create their own team client from
+// the options, each refreshing a separate service token. To share one
+// service token, create the source once, and set it as TeamSource in
+// the options.
+func NewTeamServiceSource(o Options) TeamSource {
+	o.TeamSource = nil
+	return newTeamClient(o)
+}
+
+func newTeamClient(o Options) TeamSource {
+	if o.TeamSource != nil {
+		return o.TeamSource
+	}
+
+	tc := &teamClient{urlBase: o.TeamUrlBase, idField: o.TeamIdField}
+	if o.TeamServiceToken.TokenUrl != "" {
+		tc.serviceToken = newServiceToken(o.TeamServiceToken, o.TeamServiceTokenScopes)
+	}
+
+	return tc
+}
+
+// the default field names are not stored, to use the default decoding
+// when possible
+func newAuthClient(o Options) *authClient {
+	ac := &authClient{urlBase: o.AuthUrlBase, fallbacks: o.AuthUrlFallbacks, expiryLeeway: o.ExpiryLeeway}
+	if o.UidField != "uid" {
+		ac.uidField = o.UidField
+	}
+
+	if o.RealmField != "realm" {
+		ac.realmField = o.RealmField
+	}
+
+	if o.ScopeField != "scope" {
+		ac.scopeField = o.ScopeField
+	}
+
+	return ac
+}
+
+func (ac *authClient) validateAt(ctx context.Context, url, token string) (*authDoc, error) {
+	var a authDoc
+	if ac.uidField == "" && ac.realmField == "" && ac.scopeField == "" {
+		err := jsonGet(ctx, url, token, &a)
+		return &a, err
+	}
+
+	err := jsonGet(ctx, url, token, &fieldsDoc{
+		doc:        &a,
+		uidField:   ac.uidField,
+		realmField: ac.realmField,
+		scopeField: ac.scopeField})
+	return &a, err
+}
+
+// the fallback urls are tried in order, only when the previous service
+// failed, and not when it rejected the token
+func (ac *authClient) validate(ctx context.Context, token string) (*authDoc, error) {
+	a, err := ac.validateAt(ctx, ac.urlBase, token)
+	for _, u := range ac.fallbacks {
+		if err == nil || err == errInvalidToken {
+			break
+		}
+
+		log.Println("token validation failed, trying fallback:", err)
+		a, err = ac.validateAt(ctx, u, token)
+	}
+
+	if err == nil || err == errInvalidToken {
+		markAuthServiceReached()
+	} else {
+		markAuthServiceFailed()
+	}
+
+	if err == nil && ac.expired(a, time.Now()) {
+		err = errExpiredToken
+	}
+
+	return a, err
+}
+
+// tells whether the token has expired, allowing for the configured
+// clock skew. Tokens without a known expiry never expire here.
+func (ac *authClient) expired(a *authDoc, now time.Time) bool {
+	return !a.expires.IsZero() && now.After(a.expires.Add(ac.expiryLeeway))
+}
+
+// the cache entries and sessions of a token are not kept beyond its
+// expiry
+func (ac *authClient) validUntil(a *authDoc, until time.Time) time.Time {
+	if a.expires.IsZero() {
+		return until
+	}
+
+	if e := a.expires.Add(ac.expiryLeeway); e.Before(until) {
+		return e
+	}
+
+	return until
+}
+
+// fieldsDoc decodes the token info, taking the user id, the realm or
+// the scopes from custom fields. The numbers are decoded as json.Number,
+// to keep the numeric user ids exact, and the tokens without a user id
+// are rejected as invalid.
+type fieldsDoc struct {
+	doc        *authDoc
+	uidField   string
+	realmField string
+	scopeField string
+}
+
+func stringField(v interface{}) string {
+	switch vv := v.(type) {
+	case string:
+		return vv
+	case json.Number:
+		return vv.String()
+	case float64:
+		return strconv.FormatFloat(vv, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// the scopes are accepted both as an array and as a space separated
+// string
+func scopesField(v interface{}) []string {
+	switch vv := v.(type) {
+	case string:
+		return strings.Fields(vv)
+	case []interface{}:
+		var s []string
+		for _, vi := range vv {
+			if si, ok := vi.(string); ok {
+				s = append(s, si)
+			}
+		}
+
+		return s
+	default:
+		return nil
+	}
+}
+
+// the audience is accepted both as a single string and as an array
+func audienceField(v interface{}) []string {
+	if s, ok := v.(string); ok {
+		if s == "" {
+			return nil
+		}
+
+		return []string{s}
+	}
+
+	return scopesField(v)
+}
+
+// the expiry is taken from the exp field as a unix timestamp, or from
+// the expires_in field as seconds from now
+func expiryField(exp, expiresIn interface{}) time.Time {
+	if e, err := strconv.ParseFloat(stringField(exp), 64); err == nil {
+		return time.Unix(int64(e), 0)
+	}
+
+	if e, err := strconv.ParseFloat(stringField(expiresIn), 64); err == nil {
+		return time.Now().Add(time.Duration(e) * time.Second)
+	}
+
+	return time.Time{}
+}
+
+// the providers return the scope either as an array or as a space
+// separated string
+func (a *authDoc) UnmarshalJSON(b []byte) error {
+	var d struct {
+		Uid       string      `json:"uid"`
+		Realm     string      `json:"realm"`
+		Scopes    interface{} `json:"scope"`
+		Audience  interface{} `json:"aud"`
+		ClientId  string      `json:"client_id"`
+		Exp       interface{} `json:"exp"`
+		ExpiresIn interface{} `json:"expires_in"`
+	}
+
+	if err := json.Unmarshal(b, &d); err != nil {
+		return err
+	}
+
+	a.Uid = d.Uid
+	a.Realm = d.Realm
+	a.Scopes = scopesField(d.Scopes)
+	a.Audience = audienceField(d.Audience)
+	a.ClientId = d.ClientId
+	a.expires = expiryField(d.Exp, d.ExpiresIn)
+	return nil
+}
+
+func (d *fieldsDoc) UnmarshalJSON(b []byte) error {
+	var fields map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return err
+	}
+
+	d.doc.Uid = stringField(fields[fieldName(d.uidField, "uid")])
+	if d.doc.Uid == "" {
+		return errInvalidToken
+	}
+
+	d.doc.Realm = stringField(fields[fieldName(d.realmField, "realm")])
+	d.doc.Scopes = scopesField(fields[fieldName(d.scopeField, "scope")])
+	d.doc.Audience = audienceField(fields["aud"])
+	d.doc.ClientId = stringField(fields["client_id"])
+	d.doc.expires = expiryField(fields["exp"], fields["expires_in"])
+	return nil
+}
+
+func fieldName(name, defaultName string) string {
+	if name == "" {
+		return defaultName
+	}
+
+	return name
+}
+
+// when the url contains the {uid} placeholder, it is replaced with the
+// escaped user id, otherwise the user id is appended to the url
+func memberUrl(urlBase, uid string) string {
+	i := strings.Index(urlBase, uidPlaceholder)
+	if i < 0 {
+		return urlBase + uid
+	}
+
+	escaped := url.PathEscape(uid)
+	if q := strings.Index(urlBase, "?"); q >= 0 && q < i {
+		escaped = url.QueryEscape(uid)
+	}
+
+	return strings.Replace(urlBase, uidPlaceholder, escaped, -1)
+}
+
+// jsonGetPages requests a JSON array, and follows the next pages
+// listed in the Link header, up to a limit. The items of the pages are
+// passed to the collect function.
+func jsonGetPages(ctx context.Context, url, auth string, collect func(item map[string]interface{})) error {
+	next := url
+	for page := 0; next != ""; page++ {
+		if page == maxPages {
+			return errTooManyPages
+		}
+
+		var items []map[string]interface{}
+		h, err := jsonGetHeader(ctx, next, auth, &items)
+		if err != nil {
+			return err
+		}
+
+		for _, i := range items {
+			collect(i)
+		}
+
+		next = nextLink(next, h)
+	}
+
+	return nil
+}
+
+func (tc *teamClient) Teams(ctx context.Context, uid, token string) ([]string, error) {
+	if tc.serviceToken != nil {
+		token = tc.serviceToken.get()
+		if token == "" {
+			return nil, errServiceTokenUnavailable
+		}
+	}
+
+	ts := []string{}
+	if err := jsonGetPages(ctx, memberUrl(tc.urlBase, uid), token, func(t map[string]interface{}) {
+		if id := stringField(t[fieldName(tc.idField, "id")]); id != "" {
+			ts = append(ts, id)
+		}
+	}); err != nil {
+		return nil, err
+	}
+
+	return ts, nil
+}
+
+func (gc *groupClient) getGroups(ctx context.Context, uid, token string) ([]string, error) {
+	var g []map[string]interface{}
+	err := jsonGet(ctx, memberUrl(gc.urlBase, uid), token, &g)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	defer rsp.Body.Close()
-	if rsp.StatusCode != 200 {
-		return errInvalidToken
+	var gs []string
+	for _, gi := range g {
+		if id, ok := gi[gc.idField].(string); ok {
+			gs = append(gs, id)
+		}
 	}
 
-	d := json.NewDecoder(rsp.Body)
-	return d.Decode(doc)
+	return gs, nil
 }
 
-func (ac *authClient) validate(token string) (*authDoc, error) {
-	var a authDoc
-	err := jsonGet(ac.urlBase, token, &a)
-	return &a, err
-}
+func newSpec(typ roleCheckType, all bool, o Options) filters.Spec {
+	s := &spec{
+		typ:            typ,
+		all:            all,
+		authClient:     newAuthClient(o),
+		jsonErrors:     o.JSONErrors,
+		reuseDetector:  newReuseDetector(o.TokenReuseIPs, o.TokenReuseWindow, o.TrustedProxies, o.ForwardedDepth),
+		decisionLogger: o.DecisionLogger,
+		tokenCookie:    o.TokenCookie,
+		tokenQuery:     o.TokenQueryParam,
+		userInfoClient: newUserInfoClient(o),
+		dryRun:         o.DryRun,
+		allowPreflight: o.AllowPreflight,
+		sessions:       newAuthSessions(o),
+		authDeadline:   o.AuthDeadline,
+		failurePolicy:  o.ServiceFailurePolicy,
+		retryAfter:     o.RetryAfter,
+		maxTokenLength: o.MaxTokenLength,
+		tokenFormat:    o.TokenFormat}
+	if s.failurePolicy == "" {
+		s.failurePolicy = FailUnavailable
+	}
 
-func (tc *teamClient) getTeams(uid, token string) ([]string, error) {
-	var t []teamDoc
-	err := jsonGet(tc.urlBase+uid, token, &t)
-	if err != nil {
-		return nil, err
+	if s.maxTokenLength <= 0 {
+		s.maxTokenLength = defaultMaxTokenLength
 	}
 
-	ts := make([]string, len(t))
-	for i, ti := range t {
-		ts[i] = ti.Id
+	if s.retryAfter <= 0 {
+		s.retryAfter = defaultRetryAfter
 	}
 
-	return ts, nil
-}
+	switch typ {
+	case checkTeam, checkScopeOrTeam:
+		s.teamClient = newTeamClient(o)
+	case checkGroup:
+		idField := o.GroupIdField
+		if idField == "" {
+			idField = "id"
+		}
 
-func newSpec(typ roleCheckType, authUrlBase, teamUrlBase string) filters.Spec {
-	s := &spec{typ: typ, authClient: &authClient{authUrlBase}}
-	if typ == checkTeam {
-		s.teamClient = &teamClient{teamUrlBase}
+		s.groupClient = &groupClient{urlBase: o.GroupUrlBase, idField: idField}
 	}
 
 	return s
@@ -309,7 +1793,57 @@ func newSpec(typ roleCheckType, authUrlBase, teamUrlBase string) filters.Spec {
 // The token is set as the Authorization Bearer header.
 //
 func NewAuth(authUrlBase string) filters.Spec {
-	return newSpec(checkScope, authUrlBase, "")
+	return NewAuthWithOptions(Options{AuthUrlBase: authUrlBase})
+}
+
+// Creates a new auth filter specification with the provided options.
+// See NewAuth.
+func NewAuthWithOptions(o Options) filters.Spec {
+	return newSpec(checkScope, false, o)
+}
+
+// Creates a new authAll filter specification. It works the same way
+// as the auth filter, but it requires all the configured scopes to be
+// assigned to the user of the token. See NewAuth.
+func NewAuthAll(authUrlBase string) filters.Spec {
+	return NewAuthAllWithOptions(Options{AuthUrlBase: authUrlBase})
+}
+
+// Creates a new authAll filter specification with the provided
+// options. See NewAuthAll.
+func NewAuthAllWithOptions(o Options) filters.Spec {
+	return newSpec(checkScope, true, o)
+}
+
+// Creates a new authOptional filter specification. It works the same
+// way as the auth filter when the request contains a token, but it
+// lets the requests without a token through, without tagging them
+// with the user information. See NewAuth.
+func NewAuthOptional(authUrlBase string) filters.Spec {
+	return NewAuthOptionalWithOptions(Options{AuthUrlBase: authUrlBase})
+}
+
+// Creates a new authOptional filter specification with the provided
+// options. See NewAuthOptional.
+func NewAuthOptionalWithOptions(o Options) filters.Spec {
+	s := newSpec(checkScope, false, o).(*spec)
+	s.optional = true
+	return s
+}
+
+// Creates a new authScopeOrTeam filter specification. The filter
+// accepts the request when either the user of the token has one of the
+// configured scopes, or it is a member of one of the configured teams.
+// The scopes and the teams are separated by a "--" argument. See
+// NewAuth and NewAuthTeam.
+func NewAuthScopeOrTeam(authUrlBase, teamUrlBase string) filters.Spec {
+	return NewAuthScopeOrTeamWithOptions(Options{AuthUrlBase: authUrlBase, TeamUrlBase: teamUrlBase})
+}
+
+// Creates a new authScopeOrTeam filter specification with the provided
+// options. See NewAuthScopeOrTeam.
+func NewAuthScopeOrTeamWithOptions(o Options) filters.Spec {
+	return newSpec(checkScopeOrTeam, false, o)
 }
 
 // Creates a new auth filter specification to validate authorization
@@ -324,17 +1858,79 @@ func NewAuth(authUrlBase string) filters.Spec {
 //
 // teamUrlBase: this service is queried for the team ids, that the
 // user is a member of ('id' field of the returned json document's
-// items). The user id of the user is appended at the end of the url.
+// items). The user id of the user is appended at the end of the url,
+// or, when present, it replaces the {uid} placeholder.
 //
 func NewAuthTeam(authUrlBase, teamUrlBase string) filters.Spec {
-	return newSpec(checkTeam, authUrlBase, teamUrlBase)
+	return NewAuthTeamWithOptions(Options{AuthUrlBase: authUrlBase, TeamUrlBase: teamUrlBase})
+}
+
+// Creates a new authTeam filter specification with the provided
+// options. See NewAuthTeam.
+func NewAuthTeamWithOptions(o Options) filters.Spec {
+	return newSpec(checkTeam, false, o)
+}
+
+// Creates a new authTeamAll filter specification. It works the same
+// way as the authTeam filter, but it requires the user to be a member
+// of all the configured teams. See NewAuthTeam.
+func NewAuthTeamAll(authUrlBase, teamUrlBase string) filters.Spec {
+	return NewAuthTeamAllWithOptions(Options{AuthUrlBase: authUrlBase, TeamUrlBase: teamUrlBase})
+}
+
+// Creates a new authTeamAll filter specification with the provided
+// options. See NewAuthTeamAll.
+func NewAuthTeamAllWithOptions(o Options) filters.Spec {
+	return newSpec(checkTeam, true, o)
+}
+
+// Creates a new auth filter specification to validate authorization
+// tokens, optionally check realms and optionally check groups.
+//
+// authUrlBase: the url of the token validation service. See NewAuth.
+//
+// groupUrlBase: this service is queried for the groups, that the user
+// is a member of. The user id of the user is appended at the end of
+// the url. The service is expected to return a json array of objects.
+//
+// groupIdField: the name of the field of the returned objects
+// containing the group id. When empty, 'id' is used.
+//
+func NewAuthGroup(authUrlBase, groupUrlBase, groupIdField string) filters.Spec {
+	return NewAuthGroupWithOptions(Options{
+		AuthUrlBase:  authUrlBase,
+		GroupUrlBase: groupUrlBase,
+		GroupIdField: groupIdField})
+}
+
+// Creates a new authGroup filter specification with the provided
+// options. See NewAuthGroup.
+func NewAuthGroupWithOptions(o Options) filters.Spec {
+	return newSpec(checkGroup, false, o)
 }
 
 func (s *spec) Name() string {
-	if s.typ == checkScope {
-		return AuthName
-	} else {
+	if s.optional {
+		return AuthOptionalName
+	}
+
+	switch s.typ {
+	case checkTeam:
+		if s.all {
+			return AuthTeamAllName
+		}
+
 		return AuthTeamName
+	case checkGroup:
+		return AuthGroupName
+	case checkScopeOrTeam:
+		return AuthScopeOrTeamName
+	default:
+		if s.all {
+			return AuthAllName
+		}
+
+		return AuthName
 	}
 }
 
@@ -344,15 +1940,174 @@ func (s *spec) CreateFilter(args []interface{}) (filters.Filter, error) {
 		return nil, err
 	}
 
-	f := &filter{typ: s.typ, authClient: s.authClient, teamClient: s.teamClient}
+	f := &filter{
+		name:           s.Name(),
+		typ:            s.typ,
+		all:            s.all,
+		authClient:     s.authClient,
+		teamClient:     s.teamClient,
+		groupClient:    s.groupClient,
+		jsonErrors:     s.jsonErrors,
+		reuseDetector:  s.reuseDetector,
+		decisionLogger: s.decisionLogger,
+		tokenCookie:    s.tokenCookie,
+		tokenQuery:     s.tokenQuery,
+		userInfoClient: s.userInfoClient,
+		dryRun:         s.dryRun,
+		optional:       s.optional,
+		allowPreflight: s.allowPreflight,
+		sessions:       s.sessions,
+		authDeadline:   s.authDeadline,
+		failurePolicy:  s.failurePolicy,
+		retryAfter:     s.retryAfter,
+		maxTokenLength: s.maxTokenLength}
+
+	tokenFormat := s.tokenFormat
+
+	// leading arguments in the form of name=value are filter options
+	for len(sargs) > 0 {
+		name, value, ok := namedArg(sargs[0])
+		if !ok {
+			break
+		}
+
+		switch name {
+		case "tokenCookie":
+			f.tokenCookie = value
+		case "tokenQuery":
+			f.tokenQuery = value
+		case "dryRun":
+			f.dryRun, err = strconv.ParseBool(value)
+			if err != nil {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+		case "serviceFailure":
+			f.failurePolicy = ServiceFailurePolicy(value)
+			if !validFailurePolicy(f.failurePolicy) {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+		case "authUrl":
+			if u, err := url.Parse(value); err != nil || u.Host == "" {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+
+			// the fallbacks of the spec belong to another service. The
+			// sessions, the userinfo claims and the team and group
+			// lookups of the spec are bound to the spec's identity
+			// provider, and the uids of another one cannot be trusted
+			// there.
+			ac := *f.authClient
+			ac.urlBase, ac.fallbacks = value, nil
+			f.authClient = &ac
+			f.sessions, f.userInfoClient = nil, nil
+			f.teamClient, f.groupClient = nil, nil
+		case "audience":
+			f.audience = append(f.audience, value)
+		case "clientId":
+			f.clientIds = append(f.clientIds, value)
+		case "tokenFormat":
+			if _, err := compileTokenFormat(value); err != nil {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+
+			tokenFormat = value
+		default:
+			return nil, filters.ErrInvalidFilterParameters
+		}
+
+		sargs = sargs[1:]
+	}
+
+	if f.failurePolicy == FailOpenReadOnly {
+		reportFailedOpen()
+	}
+
+	if f.tokenFormat, err = compileTokenFormat(tokenFormat); err != nil {
+		return nil, err
+	}
+
 	if len(sargs) > 0 {
 		f.realm, f.args = sargs[0], sargs[1:]
 	}
 
+	// the scopes and the teams are separated by "--"
+	if f.typ == checkScopeOrTeam {
+		for i, a := range f.args {
+			if a == scopeTeamSeparator {
+				f.args, f.teams = f.args[:i], f.args[i+1:]
+				break
+			}
+		}
+	}
+
+	if f.authClient != s.authClient && ((f.typ == checkTeam || f.typ == checkGroup) && len(f.args) > 0 || len(f.teams) > 0) {
+		return nil, errAuthUrlLookup
+	}
+
 	return f, nil
 
 }
 
+// reads the token from the cookie, the query parameter or the
+// Authorization header, in this order, without changing the request.
+// Empty cookie and query names are not checked.
+func readToken(r *http.Request, cookie, query string, maxLength int) (string, error) {
+	var token string
+	if cookie != "" {
+		if c, err := r.Cookie(cookie); err == nil {
+			token = c.Value
+		}
+	}
+
+	if token == "" && query != "" {
+		token = r.URL.Query().Get(query)
+	}
+
+	if token == "" {
+		return parseToken(r, maxLength)
+	}
+
+	if maxLength > 0 && len(token) > maxLength {
+		return "", errTokenTooLong
+	}
+
+	return token, nil
+}
+
+// removes the token parameter from the outgoing request, and masks it in
+// the access log
+func (f *filter) removeQueryToken(r *http.Request) {
+	maskAccessLogQuery(r, f.tokenQuery)
+	q := r.URL.Query()
+	if _, ok := q[f.tokenQuery]; ok {
+		q.Del(f.tokenQuery)
+		r.URL.RawQuery = q.Encode()
+	}
+}
+
+func (f *filter) getToken(r *http.Request) (string, error) {
+	token, err := readToken(r, f.tokenCookie, f.tokenQuery, f.maxTokenLength)
+	if f.tokenQuery != "" {
+		f.removeQueryToken(r)
+	}
+
+	return token, err
+}
+
+// when set for the filter, the token must have been issued for one of
+// the audiences and one of the clients
+func (f *filter) validateAudience(a *authDoc) rejectReason {
+	if len(f.audience) > 0 && !intersect(f.audience, a.Audience) {
+		return invalidAudience
+	}
+
+	if len(f.clientIds) > 0 && !matchAny(f.clientIds, a.ClientId) {
+		return invalidClientId
+	}
+
+	return ""
+}
+
 func (f *filter) validateRealm(a *authDoc) bool {
 	if f.realm == "" {
 		return true
@@ -366,66 +2121,213 @@ func (f *filter) validateScope(a *authDoc) bool {
 		return true
 	}
 
+	if f.all {
+		return containsAll(f.args, a.Scopes)
+	}
+
 	return intersect(f.args, a.Scopes)
 }
 
-func (f *filter) validateTeam(token string, a *authDoc) (bool, error) {
+func (f *filter) validateTeam(rctx context.Context, token string, a *authDoc) ([]string, bool, error) {
+	if len(f.args) == 0 {
+		return nil, true, nil
+	}
+
+	teams, err := f.teamClient.Teams(rctx, a.Uid, token)
+	if f.all {
+		return teams, containsAll(f.args, teams), err
+	}
+
+	return teams, intersect(f.args, teams), err
+}
+
+func (f *filter) validateGroup(rctx context.Context, token string, a *authDoc) ([]string, bool, error) {
 	if len(f.args) == 0 {
-		return true, nil
+		return nil, true, nil
 	}
 
-	teams, err := f.teamClient.getTeams(a.Uid, token)
-	return intersect(f.args, teams), err
+	groups, err := f.groupClient.getGroups(rctx, a.Uid, token)
+	return groups, intersect(f.args, groups), err
 }
 
-func (f *filter) Request(ctx filters.FilterContext) {
-	r := ctx.Request()
+// the scope check is done first, and the team service is queried
+// only when it fails
+func (f *filter) validateScopeOrTeam(ctx filters.FilterContext, rctx context.Context, token string, a *authDoc) {
+	if len(f.args) == 0 && len(f.teams) == 0 || len(f.args) > 0 && intersect(f.args, a.Scopes) {
+		f.allow(ctx, a, a.Scopes)
+		return
+	}
+
+	if len(f.teams) == 0 {
+		f.reject(ctx, a, a.Scopes, invalidScopeOrTeam)
+		return
+	}
 
-	token, err := getToken(r)
+	teams, err := f.teamClient.Teams(rctx, a.Uid, token)
 	if err != nil {
-		unauthorized(ctx, "", missingBearerToken)
+		f.serviceFailure(ctx, a, teamServiceAccess)
+		log.Println(err)
+		return
+	}
+
+	if !intersect(f.teams, teams) {
+		f.reject(ctx, a, teams, invalidScopeOrTeam)
 		return
 	}
 
-	a, err := f.authClient.validate(token)
+	ctx.StateBag()[authTeamsKey] = teams
+	f.allow(ctx, a, teams)
+}
+
+// takes the identity from a valid session, when it belongs to the
+// token of the request, or validates the token, and issues a new
+// session. When it returns false, the request was either rejected, or
+// let through without a token by the authOptional filter.
+func (f *filter) authenticate(ctx filters.FilterContext, rctx context.Context) (string, *authDoc, bool) {
+	token, err := f.getToken(ctx.Request())
+	if err == nil && tokenRevoked(token) {
+		f.reject(ctx, nil, nil, invalidToken)
+		return "", nil, false
+	}
+
+	// the session is used only when the request has the same token, or
+	// has no credentials and a safe method, so that the cross-site
+	// requests changing state are not authenticated by the cookie
+	if a, sessionToken, ok := f.sessions.get(ctx.Request()); ok &&
+		(err == errMissingToken && isSafeMethod(ctx.Request()) || err == nil && token == sessionToken) &&
+		!f.authClient.expired(a, time.Now()) {
+		return sessionToken, a, true
+	}
+
+	// only the absent credentials are responded with 401, or let through
+	// by the authOptional filter, the malformed ones with 400
+	switch err {
+	case nil:
+	case errMissingToken:
+		if !f.optional {
+			f.reject(ctx, nil, nil, missingBearerToken)
+		}
+
+		return "", nil, false
+	case errMultipleAuthHeaders:
+		f.rejectWithStatus(ctx, nil, nil, multipleHeaders, http.StatusBadRequest, nil)
+		return "", nil, false
+	case errTokenTooLong:
+		f.rejectWithStatus(ctx, nil, nil, tokenTooLong, http.StatusBadRequest, nil)
+		return "", nil, false
+	default:
+		f.rejectWithStatus(ctx, nil, nil, malformedHeader, http.StatusBadRequest, nil)
+		return "", nil, false
+	}
+
+	// the obviously malformed tokens are rejected without calling the
+	// token validation service
+	if f.tokenFormat != nil && !f.tokenFormat.MatchString(token) {
+		f.reject(ctx, nil, nil, invalidTokenFormat)
+		return "", nil, false
+	}
+
+	a, err := f.authClient.validate(rctx, token)
 	if err != nil {
-		reason := authServiceAccess
-		if err == errInvalidToken {
-			reason = invalidToken
-		} else {
+		switch err {
+		case errInvalidToken:
+			f.reject(ctx, nil, nil, invalidToken)
+		case errExpiredToken:
+			f.reject(ctx, nil, nil, expiredToken)
+		default:
 			log.Println(err)
+			f.serviceFailure(ctx, nil, authServiceAccess)
 		}
 
-		unauthorized(ctx, "", reason)
+		return "", nil, false
+	}
+
+	f.sessions.issue(ctx, token, a)
+	return token, a, true
+}
+
+func (f *filter) Request(ctx filters.FilterContext) {
+	r := ctx.Request()
+	// the preflight requests are answered here, and never proxied
+	// without authentication. The CORS headers are set by a corsHeaders
+	// filter placed before the auth filter.
+	if f.allowPreflight && isPreflight(r) {
+		ctx.Serve(&http.Response{StatusCode: http.StatusNoContent})
+		return
+	}
+
+	if len(r.Header[authHeaderName]) > 1 {
+		reportAnomaly(ctx, duplicateAuthHeader)
+	}
+
+	// the service calls are cancelled together with the incoming
+	// request, or when the auth deadline is exceeded
+	rctx := r.Context()
+	if f.authDeadline > 0 {
+		var cancel context.CancelFunc
+		rctx, cancel = context.WithTimeout(rctx, f.authDeadline)
+		defer cancel()
+	}
+
+	token, a, ok := f.authenticate(ctx, rctx)
+	if !ok {
+		return
+	}
+
+	ctx.StateBag()[authTokenKey] = token
+	if f.reuseDetector != nil && f.reuseDetector.record(token, f.reuseDetector.clientAddr(r), time.Now()) {
+		reportAnomaly(ctx, tokenReuse)
+	}
+
+	if reason := f.validateAudience(a); reason != "" {
+		f.reject(ctx, a, nil, reason)
 		return
 	}
 
 	if !f.validateRealm(a) {
-		unauthorized(ctx, a.Uid, invalidRealm)
+		f.reject(ctx, a, nil, invalidRealm)
+		return
+	}
+
+	if f.typ == checkScopeOrTeam {
+		f.validateScopeOrTeam(ctx, rctx, token, a)
 		return
 	}
 
 	if f.typ == checkScope {
 		if !f.validateScope(a) {
-			unauthorized(ctx, a.Uid, invalidScope)
+			f.reject(ctx, a, a.Scopes, invalidScope)
 			return
 		}
 
-		authorized(ctx, a.Uid)
+		f.allow(ctx, a, a.Scopes)
 		return
 	}
 
-	if valid, err := f.validateTeam(token, a); err != nil {
-		unauthorized(ctx, a.Uid, teamServiceAccess)
+	validate := f.validateTeam
+	membersKey, accessReason, invalidReason := authTeamsKey, teamServiceAccess, invalidTeam
+	if f.typ == checkGroup {
+		validate = f.validateGroup
+		membersKey, accessReason, invalidReason = authGroupsKey, groupServiceAccess, invalidGroup
+	}
+
+	if members, valid, err := validate(rctx, token, a); err != nil {
+		f.serviceFailure(ctx, a, accessReason)
 		log.Println(err)
 	} else if !valid {
-		unauthorized(ctx, a.Uid, invalidTeam)
+		f.reject(ctx, a, members, invalidReason)
 	} else {
-		authorized(ctx, a.Uid)
+		if members != nil {
+			ctx.StateBag()[membersKey] = members
+		}
+
+		f.allow(ctx, a, members)
 	}
 }
 
-func (f *filter) Response(_ filters.FilterContext) {}
+func (f *filter) Response(ctx filters.FilterContext) {
+	f.sessions.setCookie(ctx)
+}
 
 // Creates basicAuth filter specification.
 func NewBasicAuth() filters.Spec { return basic(BasicAuthName) }
@@ -444,6 +2346,10 @@ func (b basic) CreateFilter(args []interface{}) (filters.Filter, error) {
 		}
 	}
 
+	if len(args) == 1 && isCredentialsRef(uname) {
+		return credentialsFilter(uname)
+	}
+
 	if len(args) > 1 {
 		if pwd, ok = args[1].(string); !ok {
 			return nil, filters.ErrInvalidFilterParameters
@@ -499,50 +2405,305 @@ func (tb *teeBody) Write(b []byte) (int, error) {
 //
 //     spec := NewAuditLog(os.Stderr)
 func NewAuditLog(w io.Writer) filters.Spec {
-	return &auditLog{writer: w}
+	return NewAuditLogWithSink(NewWriterSink(w))
+}
+
+// Creates an auditLog filter specification sending the log entries to
+// an AuditSink. The entries are sent asynchronously, see NewAsyncSink.
+// The sinks sending in the background themselves, like the batching
+// webhook sink, are not wrapped again.
+//
+//     spec := NewAuditLogWithSink(NewWebhookSink("https://siem.example.org/events"))
+func NewAuditLogWithSink(s AuditSink) filters.Spec {
+	return NewAuditLogWithOptions(AuditLogOptions{Sink: s})
+}
+
+// Creates an auditLog filter specification with the provided options.
+func NewAuditLogWithOptions(o AuditLogOptions) filters.Spec {
+	sink := o.Sink
+	if _, async := sink.(auditFlusher); !async {
+		sink = NewAsyncSink(sink, 0)
+	}
+
+	return &auditLog{
+		sink:           sink,
+		maxBodyLog:     o.MaxBody,
+		trustedProxies: o.TrustedProxies,
+		redactPatterns: o.Redact,
+		salt:           o.TokenFingerprintSalt,
+		fields:         o.Fields,
+		sampleRates:    o.MethodSampleRates}
 }
 
 func (al *auditLog) Name() string { return AuditLogName }
 
 func (al *auditLog) CreateFilter(args []interface{}) (filters.Filter, error) {
-	if len(args) == 0 {
-		return al, nil
+	f := &auditLog{
+		sink:           al.sink,
+		maxBodyLog:     al.maxBodyLog,
+		trustedProxies: al.trustedProxies,
+		salt:           al.salt,
+		fields:         al.fields}
+	redact := append([]string(nil), al.redactPatterns...)
+	var (
+		sampleRates map[string]float64
+		err         error
+	)
+
+	for i, a := range args {
+		switch v := a.(type) {
+		case float64:
+			if i != 0 {
+				return nil, filters.ErrInvalidFilterParameters
+			}
+
+			f.maxBodyLog = int(v)
+		case string:
+			name, value, ok := namedArg(v)
+			switch {
+			case ok && name == "category":
+				f.category = value
+			case ok && name == "tls":
+				f.tls, err = strconv.ParseBool(value)
+				if err != nil {
+					return nil, filters.ErrInvalidFilterParameters
+				}
+			case ok && name == "backend":
+				f.backend, err = strconv.ParseBool(value)
+				if err != nil {
+					return nil, filters.ErrInvalidFilterParameters
+				}
+			case ok && name == "query":
+				f.query, err = strconv.ParseBool(value)
+				if err != nil {
+					return nil, filters.ErrInvalidFilterParameters
+				}
+			case ok && name == "redact":
+				redact = append(redact, value)
+			case ok && name == "sample":
+				method, rate, err := parseSampleRate(value)
+				if err != nil {
+					return nil, err
+				}
+
+				if sampleRates == nil {
+					sampleRates = make(map[string]float64)
+				}
+
+				sampleRates[method] = rate
+			case ok:
+				return nil, filters.ErrInvalidFilterParameters
+			case v == "":
+				return nil, filters.ErrInvalidFilterParameters
+			case !credentialHeader(http.CanonicalHeaderKey(v)):
+				// the headers carrying credentials are never captured
+				f.headers = append(f.headers, http.CanonicalHeaderKey(v))
+			}
+		default:
+			return nil, filters.ErrInvalidFilterParameters
+		}
 	}
 
-	if mbl, ok := args[0].(float64); ok {
-		return &auditLog{writer: al.writer, maxBodyLog: int(mbl)}, nil
-	} else {
+	f.sampleRates = al.sampleRates
+	if len(sampleRates) > 0 {
+		f.sampleRates = make(map[string]float64)
+		for m, r := range al.sampleRates {
+			f.sampleRates[m] = r
+		}
+
+		for m, r := range sampleRates {
+			f.sampleRates[m] = r
+		}
+	}
+
+	if f.redactor, err = newRedactor(redact); err != nil {
 		return nil, filters.ErrInvalidFilterParameters
 	}
+
+	return f, nil
 }
 
 func (al *auditLog) Request(ctx filters.FilterContext) {
 	if al.maxBodyLog != 0 {
 		ctx.Request().Body = newTeeBody(ctx.Request().Body, al.maxBodyLog)
 	}
+
+	if al.backend {
+		ctx.StateBag()[backendTraceKey] = traceBackend(ctx.Request())
+	}
+}
+
+// tells whether a canonical header name is known to carry credentials,
+// either by its name, or by containing a word like token or secret
+func credentialHeader(name string) bool {
+	switch name {
+	case authHeaderName, "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key":
+		return true
+	}
+
+	n := strings.ToLower(name)
+	for _, w := range []string{"token", "secret", "password", "api-key", "apikey", "credential", "session"} {
+		if strings.Contains(n, w) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// returns the values of the selected headers, or nil when none of them
+// is present
+func captureHeaders(h http.Header, names []string) map[string]string {
+	var captured map[string]string
+	for _, name := range names {
+		values, ok := h[name]
+		if !ok {
+			continue
+		}
+
+		if captured == nil {
+			captured = make(map[string]string)
+		}
+
+		captured[name] = strings.Join(values, ", ")
+	}
+
+	return captured
+}
+
+// returns the fingerprint of the validated token, or, when the request
+// was rejected before the validation, of the token in the Authorization
+// header
+func (al *auditLog) tokenFingerprint(sb map[string]interface{}, r *http.Request) string {
+	token, ok := sb[authTokenKey].(string)
+	if !ok {
+		var err error
+		if token, err = getToken(r); err != nil {
+			return ""
+		}
+	}
+
+	m := hmac.New(sha256.New, al.salt)
+	m.Write([]byte(token))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// Parses a sample rate of an HTTP method in the form of METHOD:rate,
+// e.g. HEAD:0.01.
+func parseSampleRate(s string) (string, float64, error) {
+	i := strings.LastIndex(s, ":")
+	if i <= 0 {
+		return "", 0, filters.ErrInvalidFilterParameters
+	}
+
+	rate, err := strconv.ParseFloat(s[i+1:], 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return "", 0, filters.ErrInvalidFilterParameters
+	}
+
+	return strings.ToUpper(s[:i]), rate, nil
+}
+
+// Parses a list of sample rates by HTTP method, in the form of
+// METHOD:rate, e.g. HEAD:0.01, to be used as the MethodSampleRates of
+// the audit log options.
+func ParseMethodSampleRates(l []string) (map[string]float64, error) {
+	if len(l) == 0 {
+		return nil, nil
+	}
+
+	rates := make(map[string]float64)
+	for _, s := range l {
+		method, rate, err := parseSampleRate(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample rate: %s", s)
+		}
+
+		rates[method] = rate
+	}
+
+	return rates, nil
 }
 
 func (al *auditLog) Response(ctx filters.FilterContext) {
 	req := ctx.Request()
 
 	oreq := ctx.OriginalRequest()
+	sb := ctx.StateBag()
+	rr, _ := sb[authRejectReasonKey].(string)
+	dr, _ := sb[authDryRunReasonKey].(string)
+	an, _ := sb[authAnomaliesKey].([]string)
+
+	// only the allowed requests are sampled, the rejects, the would-be
+	// rejects of the dry-run mode and the anomalies are always logged
+	rate, sampled := al.sampleRates[oreq.Method]
+	if rr != "" || dr != "" || len(an) > 0 {
+		sampled = false
+	}
+
+	if sampled && (rate == 0 || rand.Float64() >= rate) {
+		return
+	}
+
 	rsp := ctx.Response()
-	doc := auditDoc{
-		Method: oreq.Method,
-		Path:   oreq.URL.Path,
-		Status: rsp.StatusCode}
+	doc := AuditDoc{
+		Method:     oreq.Method,
+		Path:       oreq.URL.Path,
+		Proto:      oreq.Proto,
+		Status:     rsp.StatusCode,
+		Category:   al.category,
+		RemoteAddr: remoteAddr(oreq, al.trustedProxies),
+		UserAgent:  oreq.UserAgent(),
+		Fields:     al.fields}
+	if sampled {
+		doc.SampleRate = rate
+	}
 
-	sb := ctx.StateBag()
+	doc.Owner, _ = sb[routeOwnerKey].(string)
 	au, _ := sb[authUserKey].(string)
-	rr, _ := sb[authRejectReasonKey].(string)
-	if au != "" || rr != "" {
-		doc.AuthStatus = &authStatusDoc{User: au}
+	cl, _ := sb[authClaimsKey].(map[string]string)
+	if au != "" || rr != "" || dr != "" || len(an) > 0 {
+		doc.AuthStatus = &AuthStatusDoc{User: au, DryRunReason: dr, Anomalies: an, Claims: cl}
 		if rr != "" {
 			doc.AuthStatus.Rejected = true
 			doc.AuthStatus.Reason = rr
 		}
 	}
 
+	if al.tls && oreq.TLS != nil {
+		doc.TLS = &TLSDoc{
+			Version:     tls.VersionName(oreq.TLS.Version),
+			CipherSuite: tls.CipherSuiteName(oreq.TLS.CipherSuite),
+			ServerName:  oreq.TLS.ServerName,
+			Resumed:     oreq.TLS.DidResume}
+		if len(oreq.TLS.PeerCertificates) > 0 {
+			doc.TLS.ClientSubject = oreq.TLS.PeerCertificates[0].Subject.String()
+		}
+	}
+
+	if bt, ok := sb[backendTraceKey].(*backendTrace); ok {
+		doc.Backend = bt.doc()
+	}
+
+	if len(al.salt) > 0 {
+		doc.TokenFingerprint = al.tokenFingerprint(sb, oreq)
+	}
+
+	doc.RequestHeaders = captureHeaders(oreq.Header, al.headers)
+	doc.ResponseHeaders = captureHeaders(rsp.Header, al.headers)
+
+	// the query of the forwarded request, where the auth filters have
+	// removed the token parameter
+	if al.query {
+		doc.Query = req.URL.RawQuery
+	}
+
+	if al.redactor != nil {
+		al.redactor.redactHeaders(doc.RequestHeaders)
+		al.redactor.redactHeaders(doc.ResponseHeaders)
+		doc.Query = al.redactor.redactForm(doc.Query)
+	}
+
 	if tb, ok := req.Body.(*teeBody); ok {
 		if tb.maxTee < 0 {
 			io.Copy(tb.buffer, tb.body)
@@ -552,12 +2713,13 @@ func (al *auditLog) Response(ctx filters.FilterContext) {
 
 		if tb.buffer.Len() > 0 {
 			doc.RequestBody = tb.buffer.String()
+			if al.redactor != nil {
+				doc.RequestBody = al.redactor.redact(doc.RequestBody)
+			}
 		}
 	}
 
-	enc := json.NewEncoder(al.writer)
-	err := enc.Encode(&doc)
-	if err != nil {
+	if err := al.sink.Log(&doc); err != nil {
 		log.Println(err)
 	}
 }
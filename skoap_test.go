@@ -1,15 +1,19 @@
 package skoap
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/zalando/skipper/eskip"
 	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/filters/filtertest"
 	"github.com/zalando/skipper/proxy/proxytest"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -47,6 +51,7 @@ func Test(t *testing.T) {
 	for _, ti := range []struct {
 		msg         string
 		typ         roleCheckType
+		all         bool
 		authBaseUrl string
 		teamBaseUrl string
 		args        []interface{}
@@ -163,6 +168,26 @@ func Test(t *testing.T) {
 		hasAuth:     true,
 		auth:        testToken,
 		statusCode:  http.StatusOK,
+	}, {
+		msg:         "valid token, valid realm, not all teams matching",
+		typ:         checkTeam,
+		all:         true,
+		authBaseUrl: testAuthPath + "?access_token=",
+		teamBaseUrl: testTeamPath + "?member=",
+		args:        []interface{}{testRealm, "invalid-team-0", testTeam},
+		hasAuth:     true,
+		auth:        testToken,
+		statusCode:  http.StatusUnauthorized,
+	}, {
+		msg:         "valid token, valid realm, all teams matching",
+		typ:         checkTeam,
+		all:         true,
+		authBaseUrl: testAuthPath + "?access_token=",
+		teamBaseUrl: testTeamPath + "?member=",
+		args:        []interface{}{testRealm, "other-team", testTeam},
+		hasAuth:     true,
+		auth:        testToken,
+		statusCode:  http.StatusOK,
 	}} {
 		backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {}))
 
@@ -178,7 +203,7 @@ func Test(t *testing.T) {
 				return
 			}
 
-			d := testAuthDoc{authDoc{testUid, testRealm, []string{testScope}}, "noise"}
+			d := testAuthDoc{authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}}, "noise"}
 			e := json.NewEncoder(w)
 			err = e.Encode(&d)
 			if err != nil {
@@ -213,6 +238,8 @@ func Test(t *testing.T) {
 		var s filters.Spec
 		if ti.typ == checkScope {
 			s = NewAuth(authServer.URL + ti.authBaseUrl)
+		} else if ti.all {
+			s = NewAuthTeamAll(authServer.URL+ti.authBaseUrl, teamServer.URL+ti.teamBaseUrl)
 		} else {
 			s = NewAuthTeam(authServer.URL+ti.authBaseUrl, teamServer.URL+ti.teamBaseUrl)
 		}
@@ -243,3 +270,1326 @@ func Test(t *testing.T) {
 		}
 	}
 }
+
+func TestJSONErrors(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer authServer.Close()
+
+	for _, ti := range []struct {
+		msg        string
+		jsonErrors bool
+		args       []interface{}
+		hasAuth    bool
+		expected   *errorDoc
+	}{{
+		msg:        "json errors disabled",
+		jsonErrors: false,
+		hasAuth:    false,
+	}, {
+		msg:        "missing token",
+		jsonErrors: true,
+		hasAuth:    false,
+		expected:   &errorDoc{Error: string(missingBearerToken)},
+	}, {
+		msg:        "invalid scope",
+		jsonErrors: true,
+		args:       []interface{}{testRealm, "not-matching-scope"},
+		hasAuth:    true,
+		expected:   &errorDoc{Error: string(invalidScope), User: testUid},
+	}} {
+		s := NewAuthWithOptions(Options{AuthUrlBase: authServer.URL, JSONErrors: ti.jsonErrors})
+		fr := make(filters.Registry)
+		fr.Register(s)
+		r := &eskip.Route{Filters: []*eskip.Filter{{Name: s.Name(), Args: ti.args}}, Backend: backend.URL}
+		proxy := proxytest.New(fr, r)
+
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if ti.hasAuth {
+			req.Header.Set(authHeaderName, "Bearer "+testToken)
+		}
+
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		defer rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusUnauthorized {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode)
+		}
+
+		if ti.expected == nil {
+			if rsp.ContentLength > 0 {
+				t.Error(ti.msg, "unexpected body")
+			}
+
+			continue
+		}
+
+		if rsp.Header.Get("Content-Type") != "application/json" {
+			t.Error(ti.msg, "invalid content type", rsp.Header.Get("Content-Type"))
+		}
+
+		var d errorDoc
+		if err := json.NewDecoder(rsp.Body).Decode(&d); err != nil {
+			t.Error(ti.msg, err)
+			continue
+		}
+
+		if d != *ti.expected {
+			t.Error(ti.msg, "invalid error document", d, *ti.expected)
+		}
+	}
+}
+
+func TestAuthGroup(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer authServer.Close()
+
+	groupServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if lastQueryValue(r.URL.String()) != testUid {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		d := []map[string]string{{"group_name": "admins"}, {"group_name": "users"}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer groupServer.Close()
+
+	for _, ti := range []struct {
+		msg        string
+		args       []interface{}
+		statusCode int
+	}{{
+		msg:        "no group check",
+		args:       []interface{}{testRealm},
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "no matching group",
+		args:       []interface{}{testRealm, "ops"},
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "matching group",
+		args:       []interface{}{testRealm, "ops", "admins"},
+		statusCode: http.StatusOK,
+	}} {
+		s := NewAuthGroup(authServer.URL, groupServer.URL+"?uid=", "group_name")
+		fr := make(filters.Registry)
+		fr.Register(s)
+		r := &eskip.Route{Filters: []*eskip.Filter{{Name: s.Name(), Args: ti.args}}, Backend: backend.URL}
+		proxy := proxytest.New(fr, r)
+
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		if rsp.StatusCode != ti.statusCode {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode, ti.statusCode)
+		}
+	}
+}
+
+func TestAuthScopeOrTeam(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer authServer.Close()
+
+	var teamRequests int
+	teamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		teamRequests++
+		if err := json.NewEncoder(w).Encode([]teamDoc{{testTeam}}); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer teamServer.Close()
+
+	for _, ti := range []struct {
+		msg          string
+		args         []interface{}
+		statusCode   int
+		teamRequests int
+	}{{
+		msg:        "no checks",
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "matching scope",
+		args:       []interface{}{testRealm, "other-scope", testScope, "--", "other-team"},
+		statusCode: http.StatusOK,
+	}, {
+		msg:          "matching team",
+		args:         []interface{}{testRealm, "other-scope", "--", "other-team", testTeam},
+		statusCode:   http.StatusOK,
+		teamRequests: 1,
+	}, {
+		msg:          "only teams",
+		args:         []interface{}{testRealm, "--", testTeam},
+		statusCode:   http.StatusOK,
+		teamRequests: 1,
+	}, {
+		msg:        "only scopes, no match",
+		args:       []interface{}{testRealm, "other-scope"},
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:          "neither matching",
+		args:         []interface{}{testRealm, "other-scope", "--", "other-team"},
+		statusCode:   http.StatusUnauthorized,
+		teamRequests: 1,
+	}} {
+		teamRequests = 0
+		s := NewAuthScopeOrTeam(authServer.URL, teamServer.URL+"?member=")
+		fr := make(filters.Registry)
+		fr.Register(s)
+		r := &eskip.Route{Filters: []*eskip.Filter{{Name: s.Name(), Args: ti.args}}, Backend: backend.URL}
+		proxy := proxytest.New(fr, r)
+
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		proxy.Close()
+		if rsp.StatusCode != ti.statusCode {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode, ti.statusCode)
+		}
+
+		if teamRequests != ti.teamRequests {
+			t.Error(ti.msg, "invalid number of team requests", teamRequests, ti.teamRequests)
+		}
+	}
+}
+
+func TestAuthAll(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope, "other-scope"}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer authServer.Close()
+
+	for _, ti := range []struct {
+		msg        string
+		args       []interface{}
+		statusCode int
+	}{{
+		msg:        "no scope check",
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "one scope missing",
+		args:       []interface{}{testRealm, testScope, "missing-scope"},
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "all scopes assigned",
+		args:       []interface{}{testRealm, testScope, "other-scope"},
+		statusCode: http.StatusOK,
+	}} {
+		s := NewAuthAll(authServer.URL)
+		fr := make(filters.Registry)
+		fr.Register(s)
+		r := &eskip.Route{Filters: []*eskip.Filter{{Name: s.Name(), Args: ti.args}}, Backend: backend.URL}
+		proxy := proxytest.New(fr, r)
+
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		if rsp.StatusCode != ti.statusCode {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode, ti.statusCode)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, ti := range []struct {
+		pattern string
+		value   string
+		match   bool
+	}{
+		{"read-zmon", "read-zmon", true},
+		{"read-zmon", "read-zmon-all", false},
+		{"read-*", "read-zmon", true},
+		{"read-*", "read-", true},
+		{"read-*", "write-zmon", false},
+		{"platform/*", "platform/teapot/ops", true},
+		{"*", "anything", true},
+		{"*-zmon", "read-zmon", true},
+		{"*-zmon", "read-zmon-all", false},
+		{"read-*-all", "read-zmon-all", true},
+		{"read-*-all", "read-zmon-some", false},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXcYb", false},
+		{"a**", "a", true},
+	} {
+		if match(ti.pattern, ti.value) != ti.match {
+			t.Error("failed to match", ti.pattern, ti.value, ti.match)
+		}
+	}
+}
+
+func BenchmarkIntersectWildcard(b *testing.B) {
+	patterns := []string{"write-*", "admin", "read-*-all"}
+	values := []string{"uid", "cn", "read-zmon", "read-kio", "read-stups-all"}
+	for i := 0; i < b.N; i++ {
+		intersect(patterns, values)
+	}
+}
+
+func benchmarkAuth(b *testing.B, authorization string) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"uid": "jdoe", "realm": "/immortals", "scope": ["test-scope"]}`))
+	}))
+	defer authServer.Close()
+
+	f, err := NewAuth(authServer.URL).CreateFilter([]interface{}{testRealm, testScope})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "https://www.example.org", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	if authorization != "" {
+		req.Header.Set(authHeaderName, authorization)
+	}
+
+	ctx := &filtertest.Context{FRequest: req, FOriginalRequest: req, FStateBag: make(map[string]interface{})}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for k := range ctx.FStateBag {
+			delete(ctx.FStateBag, k)
+		}
+
+		f.Request(ctx)
+	}
+}
+
+func BenchmarkAuthMissingToken(b *testing.B) { benchmarkAuth(b, "") }
+
+func BenchmarkAuthValidToken(b *testing.B) { benchmarkAuth(b, "Bearer "+testToken) }
+
+func BenchmarkGetToken(b *testing.B) {
+	req := &http.Request{Header: http.Header{authHeaderName: []string{"Bearer " + testToken}}}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		getToken(req)
+	}
+}
+
+func TestTokenCookie(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, err := getToken(r); err != nil || token != testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer authServer.Close()
+
+	for _, ti := range []struct {
+		msg        string
+		specCookie string
+		args       []interface{}
+		cookie     string
+		header     string
+		statusCode int
+	}{{
+		msg:        "cookie not configured",
+		cookie:     testToken,
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "cookie from spec",
+		specCookie: "oauth2_token",
+		cookie:     testToken,
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "cookie from filter args",
+		args:       []interface{}{"tokenCookie=oauth2_token", testRealm},
+		cookie:     testToken,
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "invalid cookie",
+		specCookie: "oauth2_token",
+		cookie:     "invalid-token",
+		header:     testToken,
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "fallback to header",
+		specCookie: "oauth2_token",
+		header:     testToken,
+		statusCode: http.StatusOK,
+	}} {
+		s := NewAuthWithOptions(Options{AuthUrlBase: authServer.URL, TokenCookie: ti.specCookie})
+		fr := make(filters.Registry)
+		fr.Register(s)
+		r := &eskip.Route{Filters: []*eskip.Filter{{Name: s.Name(), Args: ti.args}}, Backend: backend.URL}
+		proxy := proxytest.New(fr, r)
+
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if ti.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "oauth2_token", Value: ti.cookie})
+		}
+
+		if ti.header != "" {
+			req.Header.Set(authHeaderName, "Bearer "+ti.header)
+		}
+
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		if rsp.StatusCode != ti.statusCode {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode, ti.statusCode)
+		}
+	}
+}
+
+func TestTokenQueryParam(t *testing.T) {
+	var backendQuery string
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		backendQuery = r.URL.RawQuery
+	}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, err := getToken(r); err != nil || token != testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer authServer.Close()
+
+	for _, ti := range []struct {
+		msg        string
+		specParam  string
+		args       []interface{}
+		query      string
+		statusCode int
+		forwarded  string
+	}{{
+		msg:        "query not configured",
+		query:      "access_token=" + testToken,
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:        "query from spec",
+		specParam:  "access_token",
+		query:      "access_token=" + testToken + "&foo=bar",
+		statusCode: http.StatusOK,
+		forwarded:  "foo=bar",
+	}, {
+		msg:        "query from filter args",
+		args:       []interface{}{"tokenQuery=access_token"},
+		query:      "access_token=" + testToken,
+		statusCode: http.StatusOK,
+	}, {
+		msg:        "invalid token in query",
+		specParam:  "access_token",
+		query:      "access_token=invalid-token",
+		statusCode: http.StatusUnauthorized,
+	}} {
+		backendQuery = ""
+		s := NewAuthWithOptions(Options{AuthUrlBase: authServer.URL, TokenQueryParam: ti.specParam})
+		fr := make(filters.Registry)
+		fr.Register(s)
+		r := &eskip.Route{Filters: []*eskip.Filter{{Name: s.Name(), Args: ti.args}}, Backend: backend.URL}
+		proxy := proxytest.New(fr, r)
+
+		rsp, err := http.Get(proxy.URL + "/?" + ti.query)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		rsp.Body.Close()
+		if rsp.StatusCode != ti.statusCode {
+			t.Error(ti.msg, "invalid status code", rsp.StatusCode, ti.statusCode)
+		}
+
+		if backendQuery != ti.forwarded {
+			t.Error(ti.msg, "invalid forwarded query", backendQuery, ti.forwarded)
+		}
+	}
+}
+
+func TestUidField(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"uid": "service", "sub": "jdoe", "managed_id": 42, "large_id": 12345678901234567890, "realm": "/immortals", "scope": ["test-scope"]}`))
+	}))
+	defer authServer.Close()
+
+	for _, ti := range []struct {
+		field string
+		uid   string
+	}{
+		{"", "service"},
+		{"sub", "jdoe"},
+		{"managed_id", "42"},
+		{"large_id", "12345678901234567890"},
+		{"missing", ""},
+	} {
+		ac := &authClient{urlBase: authServer.URL, uidField: ti.field}
+		a, err := ac.validate(context.Background(), testToken)
+		if ti.uid == "" {
+			if err != errInvalidToken {
+				t.Error("failed to reject token without user id", err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a.Uid != ti.uid || a.Realm != testRealm || len(a.Scopes) != 1 {
+			t.Error("invalid auth doc", ti.field, a)
+		}
+	}
+}
+
+func TestAuthDocFields(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"sub": "jdoe", "rlm": "/immortals", "scope": "test-scope other-scope", "scp": ["test-scope"]}`))
+	}))
+	defer authServer.Close()
+
+	for _, ti := range []struct {
+		msg     string
+		options Options
+		uid     string
+		realm   string
+		scopes  []string
+		fail    bool
+	}{{
+		msg:     "custom fields, space separated scopes",
+		options: Options{UidField: "sub", RealmField: "rlm"},
+		uid:     testUid,
+		realm:   testRealm,
+		scopes:  []string{testScope, "other-scope"},
+	}, {
+		msg:     "custom scope field",
+		options: Options{UidField: "sub", ScopeField: "scp"},
+		uid:     testUid,
+		scopes:  []string{testScope},
+	}, {
+		msg:     "missing fields",
+		options: Options{UidField: "sub", RealmField: "realm", ScopeField: "scopes"},
+		uid:     testUid,
+	}, {
+		msg:     "missing user id",
+		options: Options{UidField: "uid", RealmField: "rlm"},
+		fail:    true,
+	}} {
+		ti.options.AuthUrlBase = authServer.URL
+		a, err := newAuthClient(ti.options).validate(context.Background(), testToken)
+		if ti.fail {
+			if err != errInvalidToken {
+				t.Error(ti.msg, "failed to reject token without user id", err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if a.Uid != ti.uid || a.Realm != ti.realm || strings.Join(a.Scopes, " ") != strings.Join(ti.scopes, " ") {
+			t.Error(ti.msg, "invalid auth doc", a)
+		}
+	}
+}
+
+func TestAuthDocScopes(t *testing.T) {
+	for _, ti := range []struct {
+		msg    string
+		doc    string
+		scopes []string
+	}{{
+		msg:    "array",
+		doc:    `{"uid": "jdoe", "scope": ["test-scope", "other-scope"]}`,
+		scopes: []string{testScope, "other-scope"},
+	}, {
+		msg:    "space separated string",
+		doc:    `{"uid": "jdoe", "scope": "test-scope  other-scope"}`,
+		scopes: []string{testScope, "other-scope"},
+	}, {
+		msg: "empty string",
+		doc: `{"uid": "jdoe", "scope": ""}`,
+	}, {
+		msg: "missing",
+		doc: `{"uid": "jdoe"}`,
+	}} {
+		var a authDoc
+		if err := json.Unmarshal([]byte(ti.doc), &a); err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if a.Uid != testUid || strings.Join(a.Scopes, " ") != strings.Join(ti.scopes, " ") {
+			t.Error(ti.msg, "invalid auth doc", a)
+		}
+	}
+}
+
+func TestAuthOptional(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(authHeaderName) != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer authServer.Close()
+
+	s := NewAuthOptional(authServer.URL)
+	if s.Name() != AuthOptionalName {
+		t.Error("invalid filter name", s.Name())
+	}
+
+	for _, ti := range []struct {
+		msg      string
+		args     []interface{}
+		token    string
+		rejected bool
+		user     string
+	}{{
+		msg: "no token",
+	}, {
+		msg:   "valid token",
+		token: testToken,
+		user:  testUid,
+	}, {
+		msg:      "invalid token",
+		token:    "invalid-token",
+		rejected: true,
+	}, {
+		msg:      "invalid scope",
+		args:     []interface{}{testRealm, "other-scope"},
+		token:    testToken,
+		rejected: true,
+		user:     testUid,
+	}, {
+		msg:  "no token, scope not checked",
+		args: []interface{}{testRealm, "other-scope"},
+	}} {
+		f, err := s.CreateFilter(ti.args)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest("GET", "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if ti.token != "" {
+			req.Header.Set(authHeaderName, "Bearer "+ti.token)
+		}
+
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		f.Request(ctx)
+
+		if ctx.FServedWithResponse != ti.rejected {
+			t.Error(ti.msg, "invalid rejection", ctx.FServedWithResponse)
+		}
+
+		if user, _ := ctx.FStateBag[authUserKey].(string); user != ti.user {
+			t.Error(ti.msg, "invalid user", user)
+		}
+	}
+}
+
+func TestParseToken(t *testing.T) {
+	for _, ti := range []struct {
+		msg    string
+		header []string
+		token  string
+		err    error
+	}{{
+		msg: "no header",
+		err: errMissingToken,
+	}, {
+		msg:    "valid token",
+		header: []string{"Bearer " + testToken},
+		token:  testToken,
+	}, {
+		msg:    "case insensitive scheme",
+		header: []string{"bearer " + testToken},
+		token:  testToken,
+	}, {
+		msg:    "multiple headers",
+		header: []string{"Bearer " + testToken, "Bearer " + testToken},
+		err:    errMultipleAuthHeaders,
+	}, {
+		msg:    "empty header",
+		header: []string{""},
+		err:    errInvalidAuthorizationHeader,
+	}, {
+		msg:    "other scheme",
+		header: []string{"Basic dXNlcjpwYXNz"},
+		err:    errInvalidAuthorizationHeader,
+	}, {
+		msg:    "empty token",
+		header: []string{"Bearer "},
+		err:    errInvalidAuthorizationHeader,
+	}, {
+		msg:    "token with space",
+		header: []string{"Bearer foo bar"},
+		err:    errInvalidAuthorizationHeader,
+	}, {
+		msg:    "token too long",
+		header: []string{"Bearer " + strings.Repeat("x", 17)},
+		err:    errTokenTooLong,
+	}} {
+		r := &http.Request{Header: make(http.Header)}
+		if ti.header != nil {
+			r.Header[authHeaderName] = ti.header
+		}
+
+		token, err := parseToken(r, 16)
+		if err != ti.err {
+			t.Error(ti.msg, "invalid error", err)
+		}
+
+		if token != ti.token {
+			t.Error(ti.msg, "invalid token", token)
+		}
+	}
+}
+
+func TestMalformedAuthorizationHeader(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"uid": "jdoe", "realm": "/immortals"}`))
+	}))
+	defer authServer.Close()
+
+	f, err := NewAuthWithOptions(Options{AuthUrlBase: authServer.URL, MaxTokenLength: 16}).CreateFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fo, err := NewAuthOptional(authServer.URL).CreateFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ti := range []struct {
+		msg    string
+		filter filters.Filter
+		header string
+		status int
+		reason rejectReason
+	}{{
+		msg:    "missing",
+		filter: f,
+		status: http.StatusUnauthorized,
+		reason: missingBearerToken,
+	}, {
+		msg:    "malformed",
+		filter: f,
+		header: "Basic dXNlcjpwYXNz",
+		status: http.StatusBadRequest,
+		reason: malformedHeader,
+	}, {
+		msg:    "too long",
+		filter: f,
+		header: "Bearer " + strings.Repeat("x", 17),
+		status: http.StatusBadRequest,
+		reason: tokenTooLong,
+	}, {
+		msg:    "missing, optional",
+		filter: fo,
+	}, {
+		msg:    "malformed, optional",
+		filter: fo,
+		header: "Bearer",
+		status: http.StatusBadRequest,
+		reason: malformedHeader,
+	}} {
+		req, err := http.NewRequest("GET", "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if ti.header != "" {
+			req.Header.Set(authHeaderName, ti.header)
+		}
+
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		ti.filter.Request(ctx)
+
+		if ti.status == 0 {
+			if ctx.FServedWithResponse {
+				t.Error(ti.msg, "unexpected rejection")
+			}
+
+			continue
+		}
+
+		if !ctx.FServedWithResponse || ctx.FResponse.StatusCode != ti.status {
+			t.Error(ti.msg, "invalid response")
+		}
+
+		if reason, _ := ctx.FStateBag[authRejectReasonKey].(string); reason != string(ti.reason) {
+			t.Error(ti.msg, "invalid reject reason", reason)
+		}
+	}
+}
+
+func TestAuthAudience(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"uid": "jdoe", "realm": "/immortals", "scope": ["test-scope"], "aud": "orders-api", "client_id": "shop"}`))
+	}))
+	defer authServer.Close()
+
+	s := NewAuth(authServer.URL)
+	for _, ti := range []struct {
+		msg    string
+		args   []interface{}
+		reason string
+	}{{
+		msg: "no audience check",
+	}, {
+		msg:  "matching audience",
+		args: []interface{}{"audience=other-api", "audience=orders-api"},
+	}, {
+		msg:    "other audience",
+		args:   []interface{}{"audience=other-api"},
+		reason: string(invalidAudience),
+	}, {
+		msg:  "matching client id with wildcard",
+		args: []interface{}{"clientId=sh*", testRealm, testScope},
+	}, {
+		msg:    "other client id",
+		args:   []interface{}{"audience=orders-api", "clientId=other-client"},
+		reason: string(invalidClientId),
+	}} {
+		f, err := s.CreateFilter(ti.args)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest("GET", "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		f.Request(ctx)
+
+		if ctx.FServedWithResponse != (ti.reason != "") {
+			t.Error(ti.msg, "invalid rejection", ctx.FServedWithResponse)
+		}
+
+		if reason, _ := ctx.FStateBag[authRejectReasonKey].(string); reason != ti.reason {
+			t.Error(ti.msg, "invalid reject reason", reason)
+		}
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	var doc string
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(doc))
+	}))
+	defer authServer.Close()
+
+	now := time.Now().Unix()
+	s := NewAuthWithOptions(Options{AuthUrlBase: authServer.URL, ExpiryLeeway: time.Minute})
+	for _, ti := range []struct {
+		msg    string
+		doc    string
+		reason string
+	}{{
+		msg: "no expiry",
+		doc: `{"uid": "jdoe"}`,
+	}, {
+		msg: "not expired",
+		doc: fmt.Sprintf(`{"uid": "jdoe", "exp": %d}`, now+3600),
+	}, {
+		msg: "expired within the leeway",
+		doc: fmt.Sprintf(`{"uid": "jdoe", "exp": %d}`, now-30),
+	}, {
+		msg:    "expired",
+		doc:    fmt.Sprintf(`{"uid": "jdoe", "exp": %d}`, now-120),
+		reason: string(expiredToken),
+	}, {
+		msg: "expires in",
+		doc: `{"uid": "jdoe", "expires_in": 3600}`,
+	}, {
+		msg:    "expired, expires in",
+		doc:    `{"uid": "jdoe", "expires_in": -120}`,
+		reason: string(expiredToken),
+	}} {
+		doc = ti.doc
+		f, err := s.CreateFilter(nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest("GET", "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		f.Request(ctx)
+
+		if reason, _ := ctx.FStateBag[authRejectReasonKey].(string); reason != ti.reason {
+			t.Error(ti.msg, "invalid reject reason", reason)
+		}
+	}
+}
+
+func TestExpiredTokenSession(t *testing.T) {
+	o := Options{SessionKey: []byte("test-secret"), ExpiryLeeway: time.Minute}
+	f, err := NewAuthWithOptions(o).CreateFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := func(expires time.Time) *http.Request {
+		ctx := &filtertest.Context{FStateBag: make(map[string]interface{})}
+		ctx.FRequest, _ = http.NewRequest("GET", "https://www.example.org", nil)
+		newAuthSessions(o).issue(ctx, testToken, &authDoc{Uid: testUid, expires: expires})
+
+		req, err := http.NewRequest("GET", "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Cookie", strings.Split(ctx.FStateBag[authSessionKey].(string), ";")[0])
+		return req
+	}
+
+	ctx := &filtertest.Context{FRequest: issue(time.Now().Add(time.Hour)), FStateBag: make(map[string]interface{})}
+	f.Request(ctx)
+	if ctx.FServedWithResponse {
+		t.Error("failed to accept the session")
+	}
+
+	ctx = &filtertest.Context{FRequest: issue(time.Now().Add(-2 * time.Minute)), FStateBag: make(map[string]interface{})}
+	f.Request(ctx)
+	if !ctx.FServedWithResponse {
+		t.Error("failed to reject the session of an expired token")
+	}
+}
+
+func TestAuthFallbacks(t *testing.T) {
+	var fallbackRequests int
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer rejecting.Close()
+
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fallbackRequests++
+		w.Write([]byte(`{"uid": "jdoe", "realm": "/immortals"}`))
+	}))
+	defer fallback.Close()
+
+	for _, ti := range []struct {
+		msg       string
+		urls      []string
+		reason    string
+		fallbacks int
+	}{{
+		msg:    "no fallback",
+		urls:   []string{failing.URL},
+		reason: string(authServiceAccess),
+	}, {
+		msg:       "fallback after failure",
+		urls:      []string{failing.URL, fallback.URL},
+		fallbacks: 1,
+	}, {
+		msg:       "fallback after unreachable service",
+		urls:      []string{"http://127.0.0.1:0", failing.URL, fallback.URL},
+		fallbacks: 1,
+	}, {
+		msg:    "no fallback after rejection",
+		urls:   []string{rejecting.URL, fallback.URL},
+		reason: string(invalidToken),
+	}} {
+		fallbackRequests = 0
+		s := NewAuthWithOptions(Options{AuthUrlBase: ti.urls[0], AuthUrlFallbacks: ti.urls[1:]})
+		f, err := s.CreateFilter([]interface{}{testRealm})
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest("GET", "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		f.Request(ctx)
+
+		if reason, _ := ctx.FStateBag[authRejectReasonKey].(string); reason != ti.reason {
+			t.Error(ti.msg, "invalid reject reason", reason)
+		}
+
+		if fallbackRequests != ti.fallbacks {
+			t.Error(ti.msg, "invalid number of fallback requests", fallbackRequests)
+		}
+	}
+}
+
+func TestAuthUrlOption(t *testing.T) {
+	specServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"uid": "jdoe", "realm": "/immortals"}`))
+	}))
+	defer specServer.Close()
+
+	routeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"uid": "jdoe", "realm": "/services"}`))
+	}))
+	defer routeServer.Close()
+
+	s := NewAuth(specServer.URL)
+	if _, err := s.CreateFilter([]interface{}{"authUrl=not-a-url"}); err == nil {
+		t.Error("failed to fail on invalid url")
+	}
+
+	ts := NewAuthTeam(specServer.URL, "https://teams.example.org")
+	if _, err := ts.CreateFilter([]interface{}{"authUrl=" + routeServer.URL, testRealm, testTeam}); err != errAuthUrlLookup {
+		t.Error("failed to fail on team check with another identity provider", err)
+	}
+
+	ss := NewAuthWithOptions(Options{AuthUrlBase: specServer.URL, SessionKey: []byte("secret")})
+	if f, err := ss.CreateFilter([]interface{}{"authUrl=" + routeServer.URL}); err != nil || f.(*filter).sessions != nil {
+		t.Error("failed to disable the sessions", err)
+	}
+
+	for _, ti := range []struct {
+		msg    string
+		args   []interface{}
+		reason string
+	}{{
+		msg:  "spec url",
+		args: []interface{}{testRealm},
+	}, {
+		msg:    "route url, other realm",
+		args:   []interface{}{"authUrl=" + routeServer.URL, testRealm},
+		reason: string(invalidRealm),
+	}, {
+		msg:  "route url",
+		args: []interface{}{"authUrl=" + routeServer.URL, "/services"},
+	}} {
+		f, err := s.CreateFilter(ti.args)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest("GET", "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		f.Request(ctx)
+
+		if reason, _ := ctx.FStateBag[authRejectReasonKey].(string); reason != ti.reason {
+			t.Error(ti.msg, "invalid reject reason", reason)
+		}
+	}
+}
+
+func TestMemberUrl(t *testing.T) {
+	for _, ti := range []struct {
+		msg     string
+		urlBase string
+		uid     string
+		url     string
+	}{{
+		msg:     "appended",
+		urlBase: "https://teams.example.org/?uid=",
+		uid:     testUid,
+		url:     "https://teams.example.org/?uid=jdoe",
+	}, {
+		msg:     "path placeholder",
+		urlBase: "https://teams.example.org/api/{uid}/memberships",
+		uid:     "j doe/x",
+		url:     "https://teams.example.org/api/j%20doe%2Fx/memberships",
+	}, {
+		msg:     "query placeholder",
+		urlBase: "https://teams.example.org/api?member={uid}&limit=100",
+		uid:     "j doe&x",
+		url:     "https://teams.example.org/api?member=j+doe%26x&limit=100",
+	}} {
+		if u := memberUrl(ti.urlBase, ti.uid); u != ti.url {
+			t.Error(ti.msg, "invalid url", u)
+		}
+	}
+}
+
+func TestTeamPages(t *testing.T) {
+	teamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", `</teams?member=jdoe&page=2>; rel="next", </teams?member=jdoe&page=3>; rel="last"`)
+			w.Write([]byte(`[{"team_id": "team-1"}, {"team_id": "team-2"}]`))
+		case "2":
+			w.Header().Set("Link", `</teams?member=jdoe&page=3>; rel="next"`)
+			w.Write([]byte(`[{"team_id": "team-3"}]`))
+		default:
+			w.Write([]byte(`[{"team_id": "team-4"}, {"id": "other-field"}]`))
+		}
+	}))
+	defer teamServer.Close()
+
+	tc := newTeamClient(Options{TeamUrlBase: teamServer.URL + "/teams?member=", TeamIdField: "team_id"})
+	teams, err := tc.Teams(context.Background(), testUid, testToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(teams, ",") != "team-1,team-2,team-3,team-4" {
+		t.Error("invalid teams", teams)
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	for _, ti := range []struct {
+		msg  string
+		link []string
+		next string
+	}{{
+		msg: "no link",
+	}, {
+		msg:  "no next",
+		link: []string{`<https://teams.example.org/?page=1>; rel="prev"`},
+	}, {
+		msg:  "absolute",
+		link: []string{`<https://teams.example.org/?page=3>; rel="prev", <https://teams.example.org/?page=5>; rel="next"`},
+		next: "https://teams.example.org/?page=5",
+	}, {
+		msg:  "other host",
+		link: []string{`<https://other.example.org/?page=5>; rel="next"`},
+	}, {
+		msg:  "other scheme",
+		link: []string{`<http://teams.example.org/?page=5>; rel="next"`},
+	}, {
+		msg:  "relative, multiple headers",
+		link: []string{`</?page=3>; rel="prev"`, `</?page=5>;rel=next`},
+		next: "https://teams.example.org/?page=5",
+	}} {
+		if next := nextLink("https://teams.example.org/?page=4", http.Header{"Link": ti.link}); next != ti.next {
+			t.Error(ti.msg, "invalid next link", next)
+		}
+	}
+}
+
+func TestResponseSizeLimit(t *testing.T) {
+	var size int
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"uid": "jdoe", "padding": "` + strings.Repeat("x", size) + `"}`))
+	}))
+	defer service.Close()
+
+	for _, ti := range []struct {
+		msg  string
+		size int
+		err  error
+	}{{
+		msg: "small",
+	}, {
+		msg:  "larger than the pooled buffers",
+		size: 2 * maxPooledBufferSize,
+	}, {
+		msg:  "too large",
+		size: maxResponseSize,
+		err:  errResponseTooLarge,
+	}} {
+		size = ti.size
+		var a authDoc
+		if err := jsonGet(context.Background(), service.URL, testToken, &a); err != ti.err {
+			t.Error(ti.msg, "invalid error", err)
+			continue
+		}
+
+		if ti.err == nil && a.Uid != testUid {
+			t.Error(ti.msg, "invalid auth doc", a)
+		}
+	}
+}
+
+func TestAuthDeadline(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-done:
+		}
+
+		w.Write([]byte(`{"uid": "jdoe"}`))
+	}))
+	defer authServer.Close()
+
+	f, err := NewAuthWithOptions(Options{AuthUrlBase: authServer.URL, AuthDeadline: 30 * time.Millisecond}).CreateFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", "https://www.example.org", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set(authHeaderName, "Bearer "+testToken)
+	ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+	start := time.Now()
+	f.Request(ctx)
+
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("failed to respect the deadline")
+	}
+
+	if reason, _ := ctx.FStateBag[authRejectReasonKey].(string); reason != string(authServiceAccess) {
+		t.Error("invalid reject reason", reason)
+	}
+}
+
+func TestAllowPreflight(t *testing.T) {
+	for _, ti := range []struct {
+		msg            string
+		allowPreflight bool
+		method         string
+		preflight      bool
+		statusCode     int
+	}{{
+		msg:        "preflight not allowed",
+		method:     "OPTIONS",
+		preflight:  true,
+		statusCode: http.StatusUnauthorized,
+	}, {
+		msg:            "preflight allowed",
+		allowPreflight: true,
+		method:         "OPTIONS",
+		preflight:      true,
+		statusCode:     http.StatusNoContent,
+	}, {
+		msg:            "options without preflight",
+		allowPreflight: true,
+		method:         "OPTIONS",
+		statusCode:     http.StatusUnauthorized,
+	}, {
+		msg:            "other method",
+		allowPreflight: true,
+		method:         "GET",
+		preflight:      true,
+		statusCode:     http.StatusUnauthorized,
+	}} {
+		s := NewAuthWithOptions(Options{AuthUrlBase: "https://auth.example.org", AllowPreflight: ti.allowPreflight})
+		f, err := s.CreateFilter(nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		req, err := http.NewRequest(ti.method, "https://www.example.org", nil)
+		if err != nil {
+			t.Fatal(ti.msg, err)
+		}
+
+		if ti.preflight {
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+		}
+
+		// the preflight requests are never proxied
+		ctx := &filtertest.Context{FRequest: req, FStateBag: make(map[string]interface{})}
+		f.Request(ctx)
+		if !ctx.FServedWithResponse || ctx.FResponse.StatusCode != ti.statusCode {
+			t.Error(ti.msg, "invalid response", ctx.FServedWithResponse, ctx.FResponse)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package skoap
+
+import "github.com/zalando/skipper/filters"
+
+// State bag keys set by the auth filters. Custom filters placed after
+// them in the filter chain can read these values. The keys and the
+// types of the values are part of the stable API.
+const (
+	// StateBagUserKey contains the uid of the authenticated user, or of
+	// the rejected user when known, as a string.
+	StateBagUserKey = authUserKey
+
+	// StateBagRejectReasonKey contains the reason of rejecting the
+	// request, as a string.
+	StateBagRejectReasonKey = authRejectReasonKey
+
+	// StateBagDryRunReasonKey contains the reason of the rejection, as a
+	// string, when an auth filter in dry-run mode let the request
+	// through.
+	StateBagDryRunReasonKey = authDryRunReasonKey
+
+	// StateBagAuthContextKey contains the identity of the user allowed
+	// by an auth filter, as *AuthContext, including the teams or groups
+	// fetched for the check, and the userinfo claims, when configured.
+	StateBagAuthContextKey = authContextKey
+)
+
+// Returns the identity of the user allowed by a preceding auth filter,
+// or nil, when the request was not authenticated with a token.
+func GetAuthContext(ctx filters.FilterContext) *AuthContext {
+	if ac, ok := ctx.StateBag()[authContextKey].(*AuthContext); ok {
+		return ac
+	}
+
+	return authContext(ctx)
+}
@@ -0,0 +1,68 @@
+package skoap
+
+import (
+	"encoding/json"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type authContextFilter struct {
+	contexts chan *AuthContext
+}
+
+func (f *authContextFilter) Name() string { return "testAuthContext" }
+
+func (f *authContextFilter) CreateFilter(_ []interface{}) (filters.Filter, error) { return f, nil }
+
+func (f *authContextFilter) Request(ctx filters.FilterContext) { f.contexts <- GetAuthContext(ctx) }
+
+func (f *authContextFilter) Response(_ filters.FilterContext) {}
+
+func TestGetAuthContext(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(&authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}})
+	}))
+	defer authServer.Close()
+
+	teamServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode([]teamDoc{{Id: testTeam}})
+	}))
+	defer teamServer.Close()
+
+	s := NewAuthTeam(authServer.URL, teamServer.URL+"?uid=")
+	f := &authContextFilter{contexts: make(chan *AuthContext, 1)}
+	fr := make(filters.Registry)
+	fr.Register(s)
+	fr.Register(f)
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{
+			{Name: s.Name(), Args: []interface{}{testRealm, testTeam}},
+			{Name: f.Name()}},
+		Backend: backend.URL})
+	defer proxy.Close()
+
+	req, err := http.NewRequest("GET", proxy.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set(authHeaderName, "Bearer "+testToken)
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp.Body.Close()
+	a := <-f.contexts
+	if a == nil || a.User != testUid || a.Realm != testRealm ||
+		len(a.Scopes) != 1 || len(a.Teams) != 1 || a.Teams[0] != testTeam {
+		t.Error("invalid auth context", a)
+	}
+}
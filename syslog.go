@@ -0,0 +1,147 @@
+package skoap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// facility auth (4), severity info (6)
+	syslogPriority = 4*8 + 6
+
+	syslogSDID     = "skoap@32473"
+	syslogMsgID    = "audit"
+	syslogNilValue = "-"
+	localSyslog    = "/dev/log"
+)
+
+type syslogSink struct {
+	mu       sync.Mutex
+	network  string
+	raddr    string
+	tag      string
+	hostname string
+	conn     net.Conn
+}
+
+// Creates an audit sink sending the entries to a syslog server in the
+// RFC5424 format. The message is the entry as JSON, and the auth status
+// fields are set as structured data with the id skoap@32473. The network
+// can be tcp, udp or unix. When it is empty, the entries are sent to the
+// local syslog daemon.
+func NewSyslogSink(network, raddr, tag string) (AuditSink, error) {
+	if network == "" {
+		network, raddr = "unixgram", localSyslog
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = syslogNilValue
+	}
+
+	s := &syslogSink{network: network, raddr: raddr, tag: tag, hostname: hostname}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *syslogSink) connect() error {
+	c, err := net.Dial(s.network, s.raddr)
+	if err != nil {
+		return err
+	}
+
+	s.conn = c
+	return nil
+}
+
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+func structuredData(d *AuditDoc) string {
+	if d.AuthStatus == nil {
+		return syslogNilValue
+	}
+
+	params := []string{
+		fmt.Sprintf(`rejected="%t"`, d.AuthStatus.Rejected),
+	}
+
+	if d.AuthStatus.User != "" {
+		params = append(params, fmt.Sprintf(`user="%s"`, sdEscaper.Replace(d.AuthStatus.User)))
+	}
+
+	if d.AuthStatus.Reason != "" {
+		params = append(params, fmt.Sprintf(`reason="%s"`, sdEscaper.Replace(d.AuthStatus.Reason)))
+	}
+
+	if len(d.AuthStatus.Anomalies) > 0 {
+		params = append(params, fmt.Sprintf(`anomalies="%s"`, sdEscaper.Replace(strings.Join(d.AuthStatus.Anomalies, ","))))
+	}
+
+	return fmt.Sprintf("[%s %s]", syslogSDID, strings.Join(params, " "))
+}
+
+func (s *syslogSink) format(d *AuditDoc, now time.Time) (string, error) {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+
+	tag := s.tag
+	if tag == "" {
+		tag = syslogNilValue
+	}
+
+	return fmt.Sprintf(
+		"<%d>1 %s %s %s %d %s %s %s",
+		syslogPriority,
+		now.Format(time.RFC3339Nano),
+		s.hostname,
+		tag,
+		os.Getpid(),
+		syslogMsgID,
+		structuredData(d),
+		b,
+	), nil
+}
+
+// writes a message, using octet counting framing on stream connections
+// (RFC6587)
+func (s *syslogSink) write(m string) error {
+	if s.network == "tcp" || s.network == "tcp4" || s.network == "tcp6" || s.network == "unix" {
+		m = strconv.Itoa(len(m)) + " " + m
+	}
+
+	_, err := s.conn.Write([]byte(m))
+	return err
+}
+
+func (s *syslogSink) Log(d *AuditDoc) error {
+	m, err := s.format(d, time.Now())
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write(m); err == nil {
+		return nil
+	}
+
+	// reconnect once, e.g. after the syslog server was restarted
+	s.conn.Close()
+	if err := s.connect(); err != nil {
+		return err
+	}
+
+	return s.write(m)
+}
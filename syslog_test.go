@@ -0,0 +1,96 @@
+package skoap
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStructuredData(t *testing.T) {
+	for _, ti := range []struct {
+		msg      string
+		doc      *AuditDoc
+		expected string
+	}{{
+		msg:      "no auth status",
+		doc:      &AuditDoc{},
+		expected: "-",
+	}, {
+		msg:      "allowed",
+		doc:      &AuditDoc{AuthStatus: &AuthStatusDoc{User: "jdoe"}},
+		expected: `[skoap@32473 rejected="false" user="jdoe"]`,
+	}, {
+		msg: "rejected with escaping",
+		doc: &AuditDoc{AuthStatus: &AuthStatusDoc{
+			User:      `j"doe]`,
+			Rejected:  true,
+			Reason:    "invalid-scope",
+			Anomalies: []string{"token-reuse", "duplicate-auth-header"}}},
+		expected: `[skoap@32473 rejected="true" user="j\"doe\]" reason="invalid-scope" anomalies="token-reuse,duplicate-auth-header"]`,
+	}} {
+		if sd := structuredData(ti.doc); sd != ti.expected {
+			t.Error(ti.msg, "invalid structured data", sd, ti.expected)
+		}
+	}
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	messages := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		defer c.Close()
+		r := bufio.NewReader(c)
+		length, err := r.ReadString(' ')
+		if err != nil {
+			return
+		}
+
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			return
+		}
+
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return
+		}
+
+		messages <- string(b)
+	}()
+
+	s, err := NewSyslogSink("tcp", l.Addr().String(), "skoap")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Log(&AuditDoc{Method: "GET", Path: "/foo", Status: 401, AuthStatus: &AuthStatusDoc{Rejected: true, Reason: "invalid-token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case m := <-messages:
+		if !strings.HasPrefix(m, "<38>1 ") ||
+			!strings.Contains(m, ` skoap `) ||
+			!strings.Contains(m, ` audit [skoap@32473 rejected="true" reason="invalid-token"] {"method":"GET"`) {
+			t.Error("invalid message", m)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout")
+	}
+}
@@ -0,0 +1,29 @@
+package skoap
+
+import "regexp"
+
+// The predefined token formats of the TokenFormat option.
+const (
+	TokenFormatJWT  = "jwt"
+	TokenFormatUUID = "uuid"
+)
+
+const invalidTokenFormat rejectReason = "invalid-token-format"
+
+var predefinedTokenFormats = map[string]string{
+	TokenFormatJWT:  `[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`,
+	TokenFormatUUID: `[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`}
+
+// compiles either a predefined format, or a regular expression that
+// needs to match the whole token. The empty format means no check.
+func compileTokenFormat(format string) (*regexp.Regexp, error) {
+	if format == "" {
+		return nil, nil
+	}
+
+	if p, ok := predefinedTokenFormats[format]; ok {
+		format = p
+	}
+
+	return regexp.Compile("^(?:" + format + ")$")
+}
@@ -0,0 +1,87 @@
+package skoap
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+type (
+	// backendTrace records the details of the backend connections of a
+	// proxied request, with the client trace of the outgoing request.
+	backendTrace struct {
+		mu        sync.Mutex
+		attempts  int
+		address   string
+		reused    bool
+		tlsError  string
+		tlsDone   bool
+		connError string
+	}
+)
+
+// attaches a client trace to the request. It relies on the proxy
+// creating the outgoing request with the context of the incoming one.
+func traceBackend(r *http.Request) *backendTrace {
+	bt := &backendTrace{}
+	ct := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			bt.mu.Lock()
+			bt.attempts++
+			bt.mu.Unlock()
+		},
+		GotConn: func(ci httptrace.GotConnInfo) {
+			bt.mu.Lock()
+			bt.reused = ci.Reused
+			if ci.Conn != nil {
+				bt.address = ci.Conn.RemoteAddr().String()
+			}
+
+			bt.mu.Unlock()
+		},
+		ConnectDone: func(_, addr string, err error) {
+			bt.mu.Lock()
+			bt.address = addr
+			if err != nil {
+				bt.connError = err.Error()
+			}
+
+			bt.mu.Unlock()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			bt.mu.Lock()
+			bt.tlsDone = true
+			if err != nil {
+				bt.tlsError = err.Error()
+			}
+
+			bt.mu.Unlock()
+		},
+	}
+
+	*r = *r.WithContext(httptrace.WithClientTrace(r.Context(), ct))
+	return bt
+}
+
+func (bt *backendTrace) doc() *BackendDoc {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+
+	if bt.attempts == 0 {
+		return nil
+	}
+
+	d := &BackendDoc{
+		Address:  bt.address,
+		Reused:   bt.reused,
+		TLSError: bt.tlsError,
+		Retries:  bt.attempts - 1,
+		Error:    bt.connError}
+	if bt.tlsDone {
+		verified := bt.tlsError == ""
+		d.TLSVerified = &verified
+	}
+
+	return d
+}
@@ -0,0 +1,43 @@
+package skoap
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBackendTrace(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer backend.Close()
+
+	for i, reused := range []bool{false, true} {
+		req, err := http.NewRequest("GET", backend.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bt := traceBackend(req)
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp.Body.Close()
+
+		d := bt.doc()
+		if d == nil {
+			t.Fatal("missing backend details")
+		}
+
+		if !strings.HasSuffix(backend.URL, d.Address) || d.Reused != reused || d.Retries != 0 || d.TLSVerified != nil {
+			t.Error(i, "invalid backend details", d)
+		}
+	}
+}
+
+func TestBackendTraceNoConnection(t *testing.T) {
+	if d := (&backendTrace{}).doc(); d != nil {
+		t.Error("unexpected backend details", d)
+	}
+}
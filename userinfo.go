@@ -0,0 +1,64 @@
+package skoap
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultUserInfoCacheTTL = 5 * time.Minute
+	claimHeaderPrefix       = "X-Auth-Claim-"
+)
+
+var defaultUserInfoClaims = []string{"email", "name"}
+
+// userInfoClient fetches the selected claims of the users from an OIDC
+// userinfo endpoint, and caches them by token.
+type userInfoClient struct {
+	url    string
+	claims []string
+	cache  *ttlCache
+}
+
+func newUserInfoClient(o Options) *userInfoClient {
+	if o.UserInfoUrl == "" {
+		return nil
+	}
+
+	claims := o.UserInfoClaims
+	if len(claims) == 0 {
+		claims = defaultUserInfoClaims
+	}
+
+	ttl := o.UserInfoCacheTTL
+	if ttl <= 0 {
+		ttl = defaultUserInfoCacheTTL
+	}
+
+	c := &userInfoClient{url: o.UserInfoUrl, claims: claims, cache: newTTLCache("userinfo", ttl, 0)}
+	registerTokenCache(c.cache)
+	return c
+}
+
+func (uc *userInfoClient) getClaims(ctx context.Context, token string) (map[string]string, error) {
+	now := time.Now()
+	if c, ok := uc.cache.get(token, now); ok {
+		return c.(map[string]string), nil
+	}
+
+	var doc map[string]interface{}
+	if err := jsonGet(ctx, uc.url, token, &doc); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]string)
+	for _, name := range uc.claims {
+		if v, ok := doc[name]; ok && v != nil {
+			claims[name] = fmt.Sprint(v)
+		}
+	}
+
+	uc.cache.set(token, claims, now)
+	return claims, nil
+}
@@ -0,0 +1,97 @@
+package skoap
+
+import (
+	"encoding/json"
+	"github.com/zalando/skipper/eskip"
+	"github.com/zalando/skipper/filters"
+	"github.com/zalando/skipper/proxy/proxytest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUserInfo(t *testing.T) {
+	var headers http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+	}))
+	defer backend.Close()
+
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		d := authDoc{Uid: testUid, Realm: testRealm, Scopes: []string{testScope}}
+		if err := json.NewEncoder(w).Encode(&d); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer authServer.Close()
+
+	var userInfoRequests int
+	userInfoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(authHeaderName) != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		userInfoRequests++
+		w.Write([]byte(`{"sub": "jdoe", "email": "jdoe@example.org", "name": "John Doe", "phone": "123"}`))
+	}))
+	defer userInfoServer.Close()
+
+	fr := make(filters.Registry)
+	fr.Register(NewAuthWithOptions(Options{AuthUrlBase: authServer.URL, UserInfoUrl: userInfoServer.URL}))
+	fr.Register(NewForwardAuth())
+	proxy := proxytest.New(fr, &eskip.Route{
+		Filters: []*eskip.Filter{{Name: AuthName}, {Name: ForwardAuthName}},
+		Backend: backend.URL})
+	defer proxy.Close()
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", proxy.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set(authHeaderName, "Bearer "+testToken)
+		req.Header.Set(claimHeaderPrefix+"Email", "fake@example.org")
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rsp.Body.Close()
+		if rsp.StatusCode != http.StatusOK {
+			t.Error("invalid status code", rsp.StatusCode)
+		}
+
+		if headers.Get(claimHeaderPrefix+"Email") != "jdoe@example.org" ||
+			headers.Get(claimHeaderPrefix+"Name") != "John Doe" ||
+			headers.Get(claimHeaderPrefix+"Phone") != "" {
+			t.Error("invalid claim headers", headers)
+		}
+	}
+
+	if userInfoRequests != 1 {
+		t.Error("failed to cache the claims", userInfoRequests)
+	}
+}
+
+func TestTTLCache(t *testing.T) {
+	now := time.Now()
+	c := newTTLCache("test", time.Minute, 2)
+	c.set("foo", 1, now)
+	c.set("bar", 2, now.Add(-2*time.Minute))
+
+	if v, ok := c.get("foo", now); !ok || v != 1 {
+		t.Error("failed to get value", v)
+	}
+
+	if _, ok := c.get("bar", now); ok {
+		t.Error("failed to expire value")
+	}
+
+	c.set("baz", 3, now)
+	if n := c.memoryStats().Entries; n != 2 {
+		t.Error("failed to drop expired entries", n)
+	}
+}